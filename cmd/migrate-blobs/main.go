@@ -0,0 +1,164 @@
+// Command migrate-blobs moves report files and Excel templates stored in the database
+// into the configured blob store. It can be run again after an interruption: rows that
+// already have a storage key are skipped.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"gobi/config"
+	"gobi/internal/models"
+	"gobi/pkg/blob"
+	"gobi/pkg/database"
+	"gobi/pkg/utils"
+	"os"
+
+	"github.com/joho/godotenv"
+	"gorm.io/gorm"
+)
+
+// blobColumn is a table column whose contents move to the blob store
+type blobColumn struct {
+	model  interface{}
+	table  string
+	column string
+	kind   string // first segment of the blob keys
+	ext    func(format string) string
+}
+
+// legacyRow is a row that still holds its contents in the database
+type legacyRow struct {
+	ID      uint
+	UserID  uint
+	Format  string
+	Content []byte
+}
+
+var columns = []blobColumn{
+	{model: &models.Report{}, table: "reports", column: "content", kind: "reports", ext: func(format string) string {
+		if format == "" {
+			return "xlsx"
+		}
+		return format
+	}},
+	{model: &models.ExcelTemplate{}, table: "excel_templates", column: "template", kind: "templates", ext: func(string) string { return "xlsx" }},
+}
+
+func main() {
+	batchSize := flag.Int("batch", 50, "rows moved per batch")
+	dryRun := flag.Bool("dry-run", false, "count the rows to move without moving them")
+	dropColumns := flag.Bool("drop-columns", false, "drop the old columns once every row has been moved")
+	flag.Parse()
+
+	_ = godotenv.Load()
+	if err := config.LoadConfig(); err != nil {
+		utils.Logger.WithError(err).Fatal("Failed to load config")
+	}
+	cfg := config.GetConfig()
+	utils.InitSecretProviders(cfg)
+	if err := database.InitDB(cfg); err != nil {
+		utils.Logger.WithError(err).Fatal("Failed to initialize database")
+	}
+	if err := blob.Init(cfg); err != nil {
+		utils.Logger.WithError(err).Fatal("Failed to initialize blob store")
+	}
+
+	db := database.GetDB()
+	failed := false
+	for _, col := range columns {
+		if !db.Migrator().HasColumn(col.model, col.column) {
+			utils.Logger.Infof("%s.%s does not exist, nothing to move", col.table, col.column)
+			continue
+		}
+		remaining, err := migrate(db, blob.Default(), col, *batchSize, *dryRun)
+		if err != nil {
+			utils.Logger.WithError(err).Errorf("Failed to move %s.%s", col.table, col.column)
+			failed = true
+			continue
+		}
+		if *dropColumns && !*dryRun && remaining == 0 {
+			if err := db.Migrator().DropColumn(col.model, col.column); err != nil {
+				utils.Logger.WithError(err).Errorf("Failed to drop %s.%s", col.table, col.column)
+				failed = true
+				continue
+			}
+			utils.Logger.Infof("Dropped %s.%s", col.table, col.column)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// migrate moves the contents of a column to the store in batches and returns the number of
+// rows left in the database. Each blob is read back and compared before the row points to
+// it and the column is cleared; empty contents are only cleared.
+func migrate(db *gorm.DB, store blob.Store, col blobColumn, batchSize int, dryRun bool) (int64, error) {
+	pending := func() *gorm.DB {
+		return db.Table(col.table).
+			Where(fmt.Sprintf("%s IS NOT NULL AND COALESCE(storage_key, '') = ''", col.column))
+	}
+	var total int64
+	if err := pending().Count(&total).Error; err != nil {
+		return 0, err
+	}
+	utils.Logger.Infof("%s: %d rows to move", col.table, total)
+	if dryRun || total == 0 {
+		return total, nil
+	}
+
+	formatColumn := "'' AS format"
+	if col.table == "reports" {
+		formatColumn = "format"
+	}
+	var moved int64
+	var lastID uint
+	for {
+		var rows []legacyRow
+		err := pending().
+			Select(fmt.Sprintf("id, user_id, %s, %s AS content", formatColumn, col.column)).
+			Where("id > ?", lastID).Order("id").Limit(batchSize).
+			Scan(&rows).Error
+		if err != nil {
+			return total - moved, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			lastID = row.ID
+			if len(row.Content) == 0 {
+				if err := db.Table(col.table).Where("id = ?", row.ID).Update(col.column, nil).Error; err != nil {
+					return total - moved, fmt.Errorf("row %d: %w", row.ID, err)
+				}
+				moved++
+				continue
+			}
+			key := blob.NewKey(col.kind, row.UserID, col.ext(row.Format))
+			checksum := blob.Checksum(row.Content)
+			if err := store.Put(context.Background(), key, row.Content); err != nil {
+				return total - moved, fmt.Errorf("row %d: %w", row.ID, err)
+			}
+			stored, err := blob.ReadAll(context.Background(), store, key)
+			if err != nil || !bytes.Equal(stored, row.Content) {
+				store.Delete(context.Background(), key)
+				return total - moved, fmt.Errorf("row %d: blob %s could not be verified: %v", row.ID, key, err)
+			}
+			err = db.Table(col.table).Where("id = ?", row.ID).Updates(map[string]interface{}{
+				"storage_key": key,
+				"size":        len(row.Content),
+				"checksum":    checksum,
+				col.column:    nil,
+			}).Error
+			if err != nil {
+				store.Delete(context.Background(), key)
+				return total - moved, fmt.Errorf("row %d: %w", row.ID, err)
+			}
+			moved++
+		}
+		utils.Logger.Infof("%s: moved %d of %d rows", col.table, moved, total)
+	}
+	return total - moved, nil
+}
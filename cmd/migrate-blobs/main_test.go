@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"gobi/internal/models"
+	"gobi/pkg/blob"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openLegacyDB opens a database laid out as before the blob store: reports and templates
+// keep their contents in a column
+func openLegacyDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gobi.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Report{}, &models.ExcelTemplate{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"ALTER TABLE reports ADD COLUMN content BLOB",
+		"ALTER TABLE excel_templates ADD COLUMN template BLOB",
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// storedRow is a row after the migration
+type storedRow struct {
+	ID         uint
+	StorageKey string
+	Size       int64
+	Checksum   string
+	Content    []byte
+}
+
+func TestMigrateMovesContentsToTheStore(t *testing.T) {
+	db := openLegacyDB(t)
+	store, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := map[uint][]byte{
+		1: []byte("a,b\n1,2\n"),
+		2: []byte("PK\x03\x04 workbook"),
+		3: []byte("%PDF-1.4 report"),
+		4: {},
+	}
+	formats := map[uint]string{1: "csv", 2: "", 3: "pdf", 4: "csv"}
+	for id := uint(1); id <= 4; id++ {
+		db.Exec("INSERT INTO reports (id, user_id, name, format, content) VALUES (?, 7, 'Weekly', ?, ?)", id, formats[id], legacy[id])
+	}
+	db.Exec("INSERT INTO reports (id, user_id, name, format) VALUES (5, 7, 'Never generated', 'csv')")
+	db.Exec("INSERT INTO excel_templates (id, user_id, name, template) VALUES (1, 7, 'Sales', ?)", []byte("PK\x03\x04 template"))
+
+	remaining, err := migrate(db, store, columns[0], 1, true)
+	if err != nil || remaining != 4 {
+		t.Fatalf("dry run = %d, %v, want 4 rows to move", remaining, err)
+	}
+	var keys int64
+	db.Table("reports").Where("storage_key <> ''").Count(&keys)
+	if keys != 0 {
+		t.Fatalf("dry run moved %d rows", keys)
+	}
+
+	// A batch smaller than the table makes the migration page through it
+	for _, col := range columns {
+		remaining, err := migrate(db, store, col, 2, false)
+		if err != nil || remaining != 0 {
+			t.Fatalf("migrate %s = %d, %v, want every row moved", col.table, remaining, err)
+		}
+	}
+
+	var reports []storedRow
+	db.Table("reports").Select("id, storage_key, size, checksum, content").Order("id").Scan(&reports)
+	for _, row := range reports {
+		if row.Content != nil {
+			t.Errorf("report %d still has its content in the database", row.ID)
+		}
+		data, ok := legacy[row.ID]
+		if !ok || len(data) == 0 {
+			if row.StorageKey != "" {
+				t.Errorf("report %d without content was given blob %s", row.ID, row.StorageKey)
+			}
+			continue
+		}
+		ext := formats[row.ID]
+		if ext == "" {
+			ext = "xlsx"
+		}
+		if !strings.HasPrefix(row.StorageKey, "reports/7/") || !strings.HasSuffix(row.StorageKey, "."+ext) {
+			t.Errorf("report %d has key %q, want reports/7/*.%s", row.ID, row.StorageKey, ext)
+		}
+		if row.Size != int64(len(data)) || row.Checksum != blob.Checksum(data) {
+			t.Errorf("report %d has size %d and checksum %s, want those of its content", row.ID, row.Size, row.Checksum)
+		}
+		stored, err := blob.ReadAll(context.Background(), store, row.StorageKey)
+		if err != nil || !bytes.Equal(stored, data) {
+			t.Errorf("blob of report %d = %q, %v, want %q", row.ID, stored, err, data)
+		}
+	}
+
+	var template storedRow
+	db.Table("excel_templates").Select("id, storage_key, size, checksum, template AS content").Where("id = 1").Scan(&template)
+	stored, err := blob.ReadAll(context.Background(), store, template.StorageKey)
+	if template.Content != nil || !strings.HasPrefix(template.StorageKey, "templates/7/") || err != nil ||
+		string(stored) != "PK\x03\x04 template" {
+		t.Errorf("template = %+v, blob %q, %v, want it moved", template, stored, err)
+	}
+
+	// Running again finds nothing left and keeps the keys
+	remaining, err = migrate(db, store, columns[0], 2, false)
+	if err != nil || remaining != 0 {
+		t.Fatalf("second run = %d, %v, want nothing to move", remaining, err)
+	}
+	var again []storedRow
+	db.Table("reports").Select("id, storage_key").Order("id").Scan(&again)
+	for i := range again {
+		if again[i].StorageKey != reports[i].StorageKey {
+			t.Errorf("second run changed the key of report %d", again[i].ID)
+		}
+	}
+}
+
+// corruptingStore stores blobs that read back differently and records deletions
+type corruptingStore struct {
+	blob.Store
+	deleted []string
+}
+
+func (s *corruptingStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("corrupted")), nil
+}
+
+func (s *corruptingStore) Delete(ctx context.Context, key string) error {
+	s.deleted = append(s.deleted, key)
+	return s.Store.Delete(ctx, key)
+}
+
+func TestMigrateKeepsRowsWhoseBlobCannotBeVerified(t *testing.T) {
+	db := openLegacyDB(t)
+	files, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &corruptingStore{Store: files}
+	content := []byte("a,b\n1,2\n")
+	db.Exec("INSERT INTO reports (id, user_id, name, format, content) VALUES (1, 7, 'Weekly', 'csv', ?)", content)
+
+	remaining, err := migrate(db, store, columns[0], 10, false)
+	if err == nil || remaining != 1 {
+		t.Fatalf("migrate = %d, %v, want an error and the row left", remaining, err)
+	}
+	var row storedRow
+	db.Table("reports").Select("id, storage_key, content").Where("id = 1").Scan(&row)
+	if row.StorageKey != "" || !bytes.Equal(row.Content, content) {
+		t.Errorf("row = %+v, want it untouched", row)
+	}
+	if len(store.deleted) != 1 {
+		t.Fatalf("deleted %q, want the unverified blob removed", store.deleted)
+	}
+	if _, err := files.Get(context.Background(), store.deleted[0]); err != blob.ErrNotFound {
+		t.Errorf("unverified blob is still stored: %v", err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"gobi/internal/repositories"
 	"gobi/internal/services"
 	"gobi/internal/services/infrastructure"
+	"gobi/pkg/blob"
 	"gobi/pkg/database"
 	"gobi/pkg/errors"
 	"gobi/pkg/utils"
@@ -16,6 +17,7 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // schedule time zones must not depend on the zoneinfo of the host
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -39,8 +41,8 @@ func (c *ConsoleAlertChannel) SendAlert(alert *errors.Alert) error {
 	return nil
 }
 
-// setupServer 设置和配置服务器
-func setupServer(cfg *config.Config) (*http.Server, error) {
+// setupServer 设置和配置服务器，并返回已启动的报表调度器
+func setupServer(cfg *config.Config) (*http.Server, *services.ReportScheduler, error) {
 	// 初始化错误监控
 	errorMonitor := errors.GetGlobalMonitor()
 	defer errorMonitor.Stop()
@@ -49,9 +51,22 @@ func setupServer(cfg *config.Config) (*http.Server, error) {
 	consoleAlertChannel := &ConsoleAlertChannel{}
 	errorMonitor.AddAlertChannel(consoleAlertChannel)
 
+	// 初始化加密密钥环
+	if err := utils.InitKeyring(cfg); err != nil {
+		return nil, nil, errors.WrapError(err, "Failed to initialize encryption keyring")
+	}
+
+	// 初始化外部密钥提供者
+	utils.InitSecretProviders(cfg)
+
+	// 初始化报表与模板文件存储
+	if err := blob.Init(cfg); err != nil {
+		return nil, nil, errors.WrapError(err, "Failed to initialize blob store")
+	}
+
 	// 初始化数据库
 	if err := database.InitDB(cfg); err != nil {
-		return nil, errors.WrapError(err, "Failed to initialize database")
+		return nil, nil, errors.WrapError(err, "Failed to initialize database")
 	}
 	db := database.GetDB()
 
@@ -61,8 +76,6 @@ func setupServer(cfg *config.Config) (*http.Server, error) {
 
 	// 初始化智能缓存
 	utils.InitQueryCache(cfg)
-	utils.InitReportGenerator()
-	defer utils.StopReportGenerator()
 
 	// Create infrastructure services
 	cacheService := infrastructure.NewCacheService(cfg)
@@ -70,9 +83,10 @@ func setupServer(cfg *config.Config) (*http.Server, error) {
 	encryptionService := infrastructure.NewEncryptionService()
 	authService := infrastructure.NewAuthService()
 
-	// Create user repository for permission service
+	// Create repositories for permission service
 	userRepo := repositories.NewUserRepository(db)
-	permissionService := infrastructure.NewPermissionService(userRepo)
+	dashboardRepo := repositories.NewDashboardRepository(db)
+	permissionService := infrastructure.NewPermissionService(userRepo, dashboardRepo)
 
 	sqlExecutionService := infrastructure.NewSQLExecutionService()
 	reportGeneratorService := infrastructure.NewReportGeneratorService()
@@ -94,9 +108,16 @@ func setupServer(cfg *config.Config) (*http.Server, error) {
 		apiKeyRepo,
 	)
 
+	// 启动报表调度器
+	scheduler := serviceFactory.CreateReportScheduler()
+	scheduler.Start()
+
 	h := handlers.NewHandler(db)
 	reportHandler := handlers.NewReportHandler(db, serviceFactory)
 	webhookHandler := handlers.NewWebhookHandler(db, serviceFactory)
+	dashboardHandler := handlers.NewDashboardHandler(db, serviceFactory)
+	embedHandler := handlers.NewEmbedHandler(db, serviceFactory)
+	boundaryHandler := handlers.NewBoundaryHandler(db, serviceFactory)
 
 	r := gin.New()
 
@@ -135,6 +156,13 @@ func setupServer(cfg *config.Config) (*http.Server, error) {
 	r.POST("/api/auth/login", h.Login)
 	r.POST("/api/auth/register", h.CreateUser)
 
+	// Public embed links, authorized by the signed token in the path
+	r.GET("/embed/:token", embedHandler.ServeEmbed)
+	r.POST("/embed/:token", embedHandler.ServeEmbed)
+
+	// Public report download links of report emails, authorized by the signed token in the path
+	r.GET("/reports/download/:token", reportHandler.DownloadReportLink)
+
 	// Protected routes
 	authorized := r.Group("/api")
 	authorized.Use(middleware.AuthMiddleware(cfg, h.UserService))
@@ -171,13 +199,47 @@ func setupServer(cfg *config.Config) (*http.Server, error) {
 		authorized.PUT("/datasources/:id", h.UpdateDataSource)
 		authorized.DELETE("/datasources/:id", h.DeleteDataSource)
 		authorized.POST("/datasources/test", h.TestDatabaseConnection)
+		authorized.GET("/datasources/:id/schema", h.GetDataSourceSchema)
+		authorized.POST("/datasources/:id/profile", h.ProfileDataSource)
+		authorized.GET("/datasources/:id/profiles/:profileId", h.GetDataSourceProfile)
 
 		// Chart routes
+		authorized.GET("/chart-types", h.ListChartTypes)
 		authorized.POST("/charts", h.CreateChart)
 		authorized.GET("/charts", h.ListCharts)
 		authorized.GET("/charts/:id", h.GetChart)
 		authorized.PUT("/charts/:id", h.UpdateChart)
 		authorized.DELETE("/charts/:id", h.DeleteChart)
+		authorized.GET("/charts/:id/data", h.GetChartData)
+		authorized.POST("/charts/:id/refresh", h.RefreshChartData)
+		authorized.GET("/charts/:id/render", h.RenderChart)
+		authorized.GET("/charts/:id/echarts", h.GetChartEChartsOption)
+		authorized.POST("/charts/:id/drill", h.DrillChart)
+
+		// Dashboard routes
+		authorized.POST("/dashboards", dashboardHandler.CreateDashboard)
+		authorized.GET("/dashboards", dashboardHandler.ListDashboards)
+		authorized.GET("/dashboards/:id", dashboardHandler.GetDashboard)
+		authorized.PUT("/dashboards/:id", dashboardHandler.UpdateDashboard)
+		authorized.DELETE("/dashboards/:id", dashboardHandler.DeleteDashboard)
+		authorized.GET("/dashboards/:id/data", dashboardHandler.GetDashboardData)
+		authorized.POST("/dashboards/:id/data", dashboardHandler.GetDashboardData)
+		authorized.GET("/dashboards/:id/filters", dashboardHandler.GetDashboardFilters)
+		authorized.GET("/dashboards/:id/events", dashboardHandler.StreamDashboardEvents)
+
+		// Embedding routes
+		authorized.GET("/embed/secret", embedHandler.GetEmbedSecret)
+		authorized.POST("/embed/secret/rotate", embedHandler.RotateEmbedSecret)
+		authorized.POST("/embed/tokens", embedHandler.CreateEmbedToken)
+		authorized.GET("/embed/usage", embedHandler.ListEmbedUsage)
+
+		// Map boundary routes
+		authorized.POST("/boundaries", boundaryHandler.UploadBoundarySet)
+		authorized.GET("/boundaries", boundaryHandler.ListBoundarySets)
+		authorized.GET("/boundaries/:id", boundaryHandler.GetBoundarySet)
+		authorized.PUT("/boundaries/:id", boundaryHandler.UpdateBoundarySet)
+		authorized.DELETE("/boundaries/:id", boundaryHandler.DeleteBoundarySet)
+		authorized.GET("/maps/:name", boundaryHandler.GetMapGeoJSON)
 
 		// Excel template routes
 		authorized.POST("/templates", h.UploadTemplate)
@@ -203,6 +265,9 @@ func setupServer(cfg *config.Config) (*http.Server, error) {
 		authorized.DELETE("/users/:id", h.DeleteUser)
 		authorized.POST("/users/:id/reset-password", h.ResetPassword)
 
+		// Encryption key rotation (admin only)
+		authorized.POST("/admin/encryption/rotate", h.RotateEncryptionKeys)
+
 		// Report routes
 		authorized.POST("/reports", reportHandler.CreateReport)
 		authorized.GET("/reports", reportHandler.ListReports)
@@ -211,25 +276,56 @@ func setupServer(cfg *config.Config) (*http.Server, error) {
 		authorized.DELETE("/reports/:id", reportHandler.DeleteReport)
 		authorized.POST("/reports/:id/generate", reportHandler.GenerateReport)
 		authorized.GET("/reports/:id/status", reportHandler.GetReportStatus)
+		authorized.POST("/reports/:id/pin", reportHandler.PinReport)
+		authorized.DELETE("/reports/:id/pin", reportHandler.UnpinReport)
+
+		// Report retention routes
+		authorized.GET("/reports/retention", reportHandler.ListRetentionPolicies)
+		authorized.PUT("/reports/retention", reportHandler.SetRetentionPolicy)
+		authorized.DELETE("/reports/retention", reportHandler.DeleteRetentionPolicy)
+		authorized.GET("/reports/retention/preview", reportHandler.PreviewRetention)
 
 		// Report schedule routes
 		authorized.POST("/reports/schedules", reportHandler.CreateReportSchedule)
 		authorized.GET("/reports/schedules", reportHandler.ListReportSchedules)
+		authorized.POST("/reports/schedules/preview", reportHandler.PreviewReportSchedule)
 		authorized.GET("/reports/schedules/:id", reportHandler.GetReportSchedule)
 		authorized.PUT("/reports/schedules/:id", reportHandler.UpdateReportSchedule)
 		authorized.DELETE("/reports/schedules/:id", reportHandler.DeleteReportSchedule)
+		authorized.PUT("/reports/schedules/:id/retention", reportHandler.SetScheduleRetentionPolicy)
+		authorized.DELETE("/reports/schedules/:id/retention", reportHandler.DeleteScheduleRetentionPolicy)
+		authorized.POST("/reports/schedules/:id/run", reportHandler.RunReportSchedule)
+		authorized.POST("/reports/schedules/:id/backfill", reportHandler.BackfillReportSchedule)
+		authorized.GET("/reports/schedules/:id/runs", reportHandler.ListScheduleRuns)
 
 		// Legacy report routes (for backward compatibility)
 		authorized.POST("/reports/generate/excel", reportHandler.GenerateExcelReport)
+		authorized.POST("/reports/generate/pdf", reportHandler.GeneratePDFReport)
 		authorized.GET("/reports/:id/download", reportHandler.DownloadReport)
+		authorized.GET("/reports/:id/deliveries", reportHandler.ListReportDeliveries)
 	}
 
 	srv := &http.Server{
 		Addr:    ":" + cfg.Server.Port,
 		Handler: r,
 	}
+	// Close live event streams so they do not hold up graceful shutdown
+	srv.RegisterOnShutdown(dashboardHandler.Hub.Close)
+
+	// 将服务重启前未完成的数据剖析任务标记为失败
+	serviceFactory.CreateDataProfileService().RecoverStaleProfiles()
 
-	return srv, nil
+	// 启动过期报表清理任务
+	retention := serviceFactory.CreateReportRetentionService()
+	retention.Start()
+	srv.RegisterOnShutdown(retention.Stop)
+
+	// 启动报表邮件重试任务，重试记录在数据库中，重启后继续
+	deliveries := serviceFactory.CreateReportDeliveryService()
+	deliveries.Start()
+	srv.RegisterOnShutdown(deliveries.Stop)
+
+	return srv, scheduler, nil
 }
 
 // gracefulShutdown 优雅关闭服务器
@@ -259,7 +355,7 @@ func main() {
 	cfg := config.GetConfig()
 
 	// 设置服务器
-	srv, err := setupServer(cfg)
+	srv, scheduler, err := setupServer(cfg)
 	if err != nil {
 		utils.Logger.WithError(err).Fatal("Failed to setup server")
 		os.Exit(1)
@@ -280,7 +376,9 @@ func main() {
 	<-quit
 
 	// 优雅关闭
-	if err := gracefulShutdown(srv, 5*time.Second); err != nil {
+	err = gracefulShutdown(srv, 5*time.Second)
+	scheduler.Stop()
+	if err != nil {
 		utils.Logger.WithError(err).Error("Failed to shutdown gracefully")
 		os.Exit(1)
 	}
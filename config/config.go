@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,15 +17,25 @@ import (
 
 // Config 应用配置结构
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	JWT      JWTConfig      `mapstructure:"jwt"`
-	Database DatabaseConfig `mapstructure:"database"`
-	Security SecurityConfig `mapstructure:"security"`
-	Logging  LoggingConfig  `mapstructure:"logging"`
-	Cache    CacheConfig    `mapstructure:"cache"`
-	Webhook  WebhookConfig  `mapstructure:"webhook"`
-	Monitor  MonitorConfig  `mapstructure:"monitor"`
-	API      APIConfig      `mapstructure:"api"`
+	Server     ServerConfig     `mapstructure:"server"`
+	JWT        JWTConfig        `mapstructure:"jwt"`
+	Database   DatabaseConfig   `mapstructure:"database"`
+	Security   SecurityConfig   `mapstructure:"security"`
+	Logging    LoggingConfig    `mapstructure:"logging"`
+	Cache      CacheConfig      `mapstructure:"cache"`
+	Webhook    WebhookConfig    `mapstructure:"webhook"`
+	Monitor    MonitorConfig    `mapstructure:"monitor"`
+	API        APIConfig        `mapstructure:"api"`
+	Encryption EncryptionConfig `mapstructure:"encryption"`
+	Secrets    SecretsConfig    `mapstructure:"secrets"`
+	Realtime   RealtimeConfig   `mapstructure:"realtime"`
+	Embed      EmbedConfig      `mapstructure:"embed"`
+	Geo        GeoConfig        `mapstructure:"geo"`
+	PDF        PDFConfig        `mapstructure:"pdf"`
+	Email      EmailConfig      `mapstructure:"email"`
+	Storage    StorageConfig    `mapstructure:"storage"`
+	Retention  RetentionConfig  `mapstructure:"retention"`
+	Scheduler  SchedulerConfig  `mapstructure:"scheduler"`
 }
 
 // ServerConfig 服务器配置
@@ -192,6 +203,118 @@ type APIConfig struct {
 	EnableProfiling bool   `mapstructure:"enable_profiling"`
 }
 
+// EncryptionConfig 加密密钥配置
+type EncryptionConfig struct {
+	ActiveKeyID string            `mapstructure:"active_key_id"`
+	KeyFile     string            `mapstructure:"key_file"`
+	Keys        map[string]string `mapstructure:"keys"` // key ID -> base64 encoded 32-byte key
+}
+
+// SecretsConfig 外部密钥引用配置
+type SecretsConfig struct {
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+	Vault    VaultConfig   `mapstructure:"vault"`
+	// 数据源密码可使用的密钥引用白名单，仅管理员可使用，均为空时数据源不能使用密钥引用
+	AllowedEnvPrefixes []string `mapstructure:"allowed_env_prefixes"` // env: 引用的环境变量名前缀
+	AllowedFileDirs    []string `mapstructure:"allowed_file_dirs"`    // file: 引用的文件所在目录，须为绝对路径
+	AllowedVaultPaths  []string `mapstructure:"allowed_vault_paths"`  // vault: 引用的路径前缀
+}
+
+// RealtimeConfig 实时推送(SSE)配置
+type RealtimeConfig struct {
+	MaxConnections        int           `mapstructure:"max_connections"`          // SSE连接总数上限
+	MaxConnectionsPerUser int           `mapstructure:"max_connections_per_user"` // 每个用户的SSE连接上限
+	HeartbeatInterval     time.Duration `mapstructure:"heartbeat_interval"`       // 心跳间隔
+	MinRefreshInterval    time.Duration `mapstructure:"min_refresh_interval"`     // 图块刷新的最小间隔
+}
+
+// EmbedConfig 嵌入链接配置
+type EmbedConfig struct {
+	DefaultTokenTTL time.Duration `mapstructure:"default_token_ttl"` // 签发嵌入令牌的默认有效期
+	MaxTokenTTL     time.Duration `mapstructure:"max_token_ttl"`     // 嵌入令牌有效期上限
+}
+
+// GeoConfig 地图边界配置
+type GeoConfig struct {
+	MaxUploadSize int64         `mapstructure:"max_upload_size"` // 边界文件上传大小上限（字节）
+	CacheMaxAge   time.Duration `mapstructure:"cache_max_age"`   // 客户端缓存边界数据的时长
+}
+
+// PDFConfig PDF报表配置
+type PDFConfig struct {
+	FontPath     string `mapstructure:"font_path"`      // 正文字体文件（TrueType .ttf/.ttc），中文报表必须配置含中文字形的字体；为空时使用 Helvetica，仅支持 ASCII
+	BoldFontPath string `mapstructure:"bold_font_path"` // 粗体字体文件，为空时以正文字体描边加粗
+	FontIndex    int    `mapstructure:"font_index"`     // .ttc 字体集合中使用的字体序号
+	MaxTableRows int    `mapstructure:"max_table_rows"` // 每个表格写入PDF的最大行数，超出部分截断并注明
+}
+
+// EmailConfig 报表邮件投递配置
+type EmailConfig struct {
+	Host              string        `mapstructure:"host"`                // SMTP服务器地址，为空时不投递邮件
+	Port              int           `mapstructure:"port"`                // SMTP端口
+	Username          string        `mapstructure:"username"`            // SMTP用户名，为空时不认证
+	Password          string        `mapstructure:"password"`            // SMTP密码，支持 env:/file:/vault: 密钥引用
+	From              string        `mapstructure:"from"`                // 发件人地址
+	StartTLS          bool          `mapstructure:"starttls"`            // 要求STARTTLS加密，服务器不支持时投递失败
+	Timeout           time.Duration `mapstructure:"timeout"`             // 单次SMTP会话超时
+	MaxAttachmentSize int64         `mapstructure:"max_attachment_size"` // 附件大小上限（字节），超出时改为发送签名下载链接
+	MaxAttempts       int           `mapstructure:"max_attempts"`        // 每个收件人的最大投递次数
+	RetryDelay        time.Duration `mapstructure:"retry_delay"`         // 首次重试前的等待时间，之后每次翻倍
+	LinkBaseURL       string        `mapstructure:"link_base_url"`       // 下载链接的对外访问地址，如 https://bi.example.com
+	LinkTTL           time.Duration `mapstructure:"link_ttl"`            // 下载链接有效期
+}
+
+// StorageConfig 报表与模板文件存储配置
+type StorageConfig struct {
+	Driver string             `mapstructure:"driver"` // local 或 s3
+	Local  LocalStorageConfig `mapstructure:"local"`
+	S3     S3StorageConfig    `mapstructure:"s3"`
+}
+
+// LocalStorageConfig 本地文件存储配置
+type LocalStorageConfig struct {
+	Path string `mapstructure:"path"` // 存储根目录
+}
+
+// S3StorageConfig S3兼容对象存储配置
+type S3StorageConfig struct {
+	Endpoint        string        `mapstructure:"endpoint"`          // 服务地址，如 https://s3.eu-west-1.amazonaws.com 或 http://localhost:9000
+	Region          string        `mapstructure:"region"`            // 区域
+	Bucket          string        `mapstructure:"bucket"`            // 存储桶
+	AccessKeyID     string        `mapstructure:"access_key_id"`     // 访问密钥ID
+	SecretAccessKey string        `mapstructure:"secret_access_key"` // 访问密钥，支持 env:/file:/vault: 密钥引用
+	PathStyle       bool          `mapstructure:"path_style"`        // 使用路径风格地址，MinIO 等S3兼容服务通常需要开启
+	Prefix          string        `mapstructure:"prefix"`            // 对象键前缀
+	Timeout         time.Duration `mapstructure:"timeout"`           // 连接及等待响应头的超时，不限制下载传输时间
+}
+
+// RetentionConfig 报表保留策略配置，用户和计划未设置保留策略时使用
+type RetentionConfig struct {
+	Enabled        bool          `mapstructure:"enabled"`          // 是否运行后台清理任务
+	Interval       time.Duration `mapstructure:"interval"`         // 清理间隔
+	BatchSize      int           `mapstructure:"batch_size"`       // 每次清理处理的最大报表数
+	KeepLast       int           `mapstructure:"keep_last"`        // 每个计划保留的最近报表数，0 表示不限
+	KeepDays       int           `mapstructure:"keep_days"`        // 报表保留天数，0 表示不限
+	KeepFailedDays int           `mapstructure:"keep_failed_days"` // 失败报表保留天数，0 表示与 keep_days 相同
+	Action         string        `mapstructure:"action"`           // 过期报表的处理方式，delete 或 archive
+}
+
+// SchedulerConfig 报表计划调度配置。多个实例通过数据库行租约认领到期的计划，每次运行只由一个实例执行
+type SchedulerConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`          // 是否在本实例运行调度器
+	Workers         int           `mapstructure:"workers"`          // 同时生成报表的最大数量
+	PollInterval    time.Duration `mapstructure:"poll_interval"`    // 检查到期计划的间隔
+	LeaseDuration   time.Duration `mapstructure:"lease_duration"`   // 计划租约时长，运行中定期续约，实例退出后租约过期由其他实例接管
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"` // 关闭时等待运行中报表完成的最长时间
+}
+
+// VaultConfig Vault配置
+type VaultConfig struct {
+	Address string        `mapstructure:"address"`
+	Token   string        `mapstructure:"token"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
 // ConfigManager 配置管理器
 type ConfigManager struct {
 	config     *Config
@@ -326,6 +449,28 @@ func (cm *ConfigManager) bindEnvironmentVariables() {
 	// 安全配置
 	cm.viper.BindEnv("security.bcrypt_cost", "GOBI_SECURITY_BCRYPT_COST")
 	cm.viper.BindEnv("security.rate_limit", "GOBI_SECURITY_RATE_LIMIT")
+
+	// 加密配置
+	cm.viper.BindEnv("encryption.active_key_id", "GOBI_ENCRYPTION_ACTIVE_KEY_ID")
+	cm.viper.BindEnv("encryption.key_file", "GOBI_ENCRYPTION_KEY_FILE")
+
+	// 外部密钥配置
+	cm.viper.BindEnv("secrets.vault.address", "GOBI_SECRETS_VAULT_ADDRESS")
+	cm.viper.BindEnv("secrets.vault.token", "GOBI_SECRETS_VAULT_TOKEN")
+
+	// 邮件投递配置
+	cm.viper.BindEnv("email.host", "GOBI_EMAIL_HOST")
+	cm.viper.BindEnv("email.username", "GOBI_EMAIL_USERNAME")
+	cm.viper.BindEnv("email.password", "GOBI_EMAIL_PASSWORD")
+
+	// 文件存储配置
+	cm.viper.BindEnv("storage.driver", "GOBI_STORAGE_DRIVER")
+	cm.viper.BindEnv("storage.s3.access_key_id", "GOBI_STORAGE_S3_ACCESS_KEY_ID")
+	cm.viper.BindEnv("storage.s3.secret_access_key", "GOBI_STORAGE_S3_SECRET_ACCESS_KEY")
+
+	// 报表调度配置
+	cm.viper.BindEnv("scheduler.enabled", "GOBI_SCHEDULER_ENABLED")
+	cm.viper.BindEnv("scheduler.workers", "GOBI_SCHEDULER_WORKERS")
 }
 
 // setDefaults 设置默认值
@@ -450,6 +595,108 @@ func (cm *ConfigManager) setDefaults(config *Config) {
 	if config.Webhook.MaxPayload == 0 {
 		config.Webhook.MaxPayload = 1024 * 1024 // 1MB
 	}
+
+	// 外部密钥默认值
+	if config.Secrets.CacheTTL == 0 {
+		config.Secrets.CacheTTL = 5 * time.Minute
+	}
+	if config.Secrets.Vault.Timeout == 0 {
+		config.Secrets.Vault.Timeout = 10 * time.Second
+	}
+
+	// 实时推送默认值
+	if config.Realtime.MaxConnections == 0 {
+		config.Realtime.MaxConnections = 500
+	}
+	if config.Realtime.MaxConnectionsPerUser == 0 {
+		config.Realtime.MaxConnectionsPerUser = 10
+	}
+	if config.Realtime.HeartbeatInterval == 0 {
+		config.Realtime.HeartbeatInterval = 15 * time.Second
+	}
+	if config.Realtime.MinRefreshInterval == 0 {
+		config.Realtime.MinRefreshInterval = 5 * time.Second
+	}
+
+	// 嵌入链接默认值
+	if config.Embed.DefaultTokenTTL == 0 {
+		config.Embed.DefaultTokenTTL = 10 * time.Minute
+	}
+	if config.Embed.MaxTokenTTL == 0 {
+		config.Embed.MaxTokenTTL = 24 * time.Hour
+	}
+
+	// 地图边界默认值
+	if config.Geo.MaxUploadSize == 0 {
+		config.Geo.MaxUploadSize = 20 << 20
+	}
+	if config.Geo.CacheMaxAge == 0 {
+		config.Geo.CacheMaxAge = time.Hour
+	}
+
+	// PDF报表默认值
+	if config.PDF.MaxTableRows == 0 {
+		config.PDF.MaxTableRows = 1000
+	}
+
+	// 邮件投递默认值
+	if config.Email.Port == 0 {
+		config.Email.Port = 587
+	}
+	if config.Email.Timeout == 0 {
+		config.Email.Timeout = 30 * time.Second
+	}
+	if config.Email.MaxAttachmentSize == 0 {
+		config.Email.MaxAttachmentSize = 10 << 20
+	}
+	if config.Email.MaxAttempts == 0 {
+		config.Email.MaxAttempts = 3
+	}
+	if config.Email.RetryDelay == 0 {
+		config.Email.RetryDelay = 30 * time.Second
+	}
+	if config.Email.LinkTTL == 0 {
+		config.Email.LinkTTL = 7 * 24 * time.Hour
+	}
+
+	// 文件存储默认值
+	if config.Storage.Driver == "" {
+		config.Storage.Driver = "local"
+	}
+	if config.Storage.Local.Path == "" {
+		config.Storage.Local.Path = "./data/blobs"
+	}
+	if config.Storage.S3.Region == "" {
+		config.Storage.S3.Region = "us-east-1"
+	}
+	if config.Storage.S3.Timeout == 0 {
+		config.Storage.S3.Timeout = 60 * time.Second
+	}
+
+	// 报表保留默认值
+	if config.Retention.Interval == 0 {
+		config.Retention.Interval = time.Hour
+	}
+	if config.Retention.BatchSize == 0 {
+		config.Retention.BatchSize = 500
+	}
+	if config.Retention.Action == "" {
+		config.Retention.Action = "delete"
+	}
+
+	// 报表调度默认值
+	if config.Scheduler.Workers == 0 {
+		config.Scheduler.Workers = 4
+	}
+	if config.Scheduler.PollInterval == 0 {
+		config.Scheduler.PollInterval = 15 * time.Second
+	}
+	if config.Scheduler.LeaseDuration == 0 {
+		config.Scheduler.LeaseDuration = 5 * time.Minute
+	}
+	if config.Scheduler.ShutdownTimeout == 0 {
+		config.Scheduler.ShutdownTimeout = 30 * time.Second
+	}
 }
 
 // validateConfig 验证配置
@@ -510,6 +757,48 @@ func (cm *ConfigManager) validateConfig(config *Config) error {
 		errors = append(errors, "webhook.max_payload must be at least 1KB")
 	}
 
+	// 验证外部密钥配置
+	for _, dir := range config.Secrets.AllowedFileDirs {
+		if !filepath.IsAbs(dir) {
+			errors = append(errors, "secrets.allowed_file_dirs must be absolute paths")
+			break
+		}
+	}
+	for _, list := range [][]string{config.Secrets.AllowedEnvPrefixes, config.Secrets.AllowedVaultPaths} {
+		for _, prefix := range list {
+			if strings.TrimSpace(prefix) == "" {
+				errors = append(errors, "secrets.allowed_env_prefixes and allowed_vault_paths must not contain empty entries")
+				break
+			}
+		}
+	}
+
+	// 验证报表保留配置
+	if config.Retention.KeepLast < 0 || config.Retention.KeepDays < 0 || config.Retention.KeepFailedDays < 0 {
+		errors = append(errors, "retention.keep_last, keep_days and keep_failed_days must be non-negative")
+	}
+	if config.Retention.Action != "delete" && config.Retention.Action != "archive" {
+		errors = append(errors, "retention.action must be one of: delete, archive")
+	}
+	if config.Retention.BatchSize < 1 {
+		errors = append(errors, "retention.batch_size must be positive")
+	}
+
+	// 验证报表调度配置
+	if config.Scheduler.Workers < 1 {
+		errors = append(errors, "scheduler.workers must be positive")
+	}
+
+	// 验证加密配置
+	if len(config.Encryption.Keys) > 0 && config.Encryption.ActiveKeyID == "" {
+		errors = append(errors, "encryption.active_key_id is required when encryption.keys is set")
+	}
+	if config.Encryption.ActiveKeyID != "" && config.Encryption.KeyFile == "" {
+		if _, ok := config.Encryption.Keys[config.Encryption.ActiveKeyID]; !ok {
+			errors = append(errors, "encryption.active_key_id must reference a key in encryption.keys or encryption.key_file")
+		}
+	}
+
 	if len(errors) > 0 {
 		return fmt.Errorf("configuration validation failed: %s", strings.Join(errors, "; "))
 	}
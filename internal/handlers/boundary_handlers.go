@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"fmt"
+	"gobi/internal/models"
+	"gobi/internal/services"
+	"gobi/pkg/errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// BoundaryHandler handles the boundary sets of map and choropleth charts
+type BoundaryHandler struct {
+	DB              *gorm.DB
+	BoundaryService *services.BoundaryService
+}
+
+// NewBoundaryHandler creates a new BoundaryHandler instance
+func NewBoundaryHandler(db *gorm.DB, serviceFactory *services.ServiceFactory) *BoundaryHandler {
+	return &BoundaryHandler{
+		DB:              db,
+		BoundaryService: serviceFactory.CreateBoundaryService(),
+	}
+}
+
+// UploadBoundarySet stores a GeoJSON or TopoJSON file uploaded as multipart form field "file"
+func (h *BoundaryHandler) UploadBoundarySet(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Boundary file is required", err))
+		return
+	}
+	if limit := h.BoundaryService.MaxUploadSize(); file.Size > limit {
+		c.Error(errors.NewBadRequestError(fmt.Sprintf("Boundary file must not exceed %d bytes", limit), nil))
+		return
+	}
+	isPublic := false
+	if v := c.PostForm("is_public"); v != "" {
+		if isPublic, err = strconv.ParseBool(v); err != nil {
+			c.Error(errors.NewBadRequestError("Invalid is_public", err))
+			return
+		}
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.Error(errors.WrapError(err, "Could not open boundary file"))
+		return
+	}
+	defer src.Close()
+	data, err := io.ReadAll(src)
+	if err != nil {
+		c.Error(errors.WrapError(err, "Could not read boundary file"))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	upload := &services.BoundaryUpload{
+		Name:        c.PostForm("name"),
+		Description: c.PostForm("description"),
+		KeyProperty: c.PostForm("key_property"),
+		Matching:    c.PostForm("matching"),
+		Aliases:     c.PostForm("aliases"),
+		Object:      c.PostForm("object"),
+		IsPublic:    isPublic,
+		Data:        data,
+	}
+	set, err := h.BoundaryService.UploadBoundarySet(upload, userID.(uint))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, set)
+}
+
+// ListBoundarySets lists the boundary sets the caller owns and the public ones
+func (h *BoundaryHandler) ListBoundarySets(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	sets, err := h.BoundaryService.ListBoundarySets(userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sets)
+}
+
+// GetBoundarySet returns a boundary set and its zoom levels
+func (h *BoundaryHandler) GetBoundarySet(c *gin.Context) {
+	id := c.Param("id")
+	setID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid boundary set ID", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	set, err := h.BoundaryService.GetBoundarySet(uint(setID), userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, set)
+}
+
+// UpdateBoundarySet changes the description, matching, aliases and visibility of a boundary set
+func (h *BoundaryHandler) UpdateBoundarySet(c *gin.Context) {
+	id := c.Param("id")
+	setID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid boundary set ID", err))
+		return
+	}
+
+	var req struct {
+		Description string `json:"description"`
+		Matching    string `json:"matching"`
+		Aliases     string `json:"aliases"`
+		IsPublic    bool   `json:"is_public"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(errors.NewBadRequestError("Invalid boundary set data", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	updates := &models.BoundarySet{
+		Description: req.Description,
+		Matching:    req.Matching,
+		Aliases:     req.Aliases,
+		IsPublic:    req.IsPublic,
+	}
+	set, err := h.BoundaryService.UpdateBoundarySet(uint(setID), updates, userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, set)
+}
+
+// DeleteBoundarySet deletes a boundary set
+func (h *BoundaryHandler) DeleteBoundarySet(c *gin.Context) {
+	id := c.Param("id")
+	setID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid boundary set ID", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	if err := h.BoundaryService.DeleteBoundarySet(uint(setID), userID.(uint), isAdmin); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Boundary set deleted successfully"})
+}
+
+// GetMapGeoJSON serves the GeoJSON of a boundary set by map name for clients to register.
+// It is revalidated by ETag and may be cached for the configured max age.
+func (h *BoundaryHandler) GetMapGeoJSON(c *gin.Context) {
+	zoom := -1
+	if z := c.Query("zoom"); z != "" {
+		var err error
+		if zoom, err = strconv.Atoi(z); err != nil || zoom < 0 {
+			c.Error(errors.NewBadRequestError("Invalid zoom", err))
+			return
+		}
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	file, err := h.BoundaryService.GetBoundaryGeoJSON(c.Param("name"), zoom, c.GetHeader("If-None-Match"), userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("ETag", file.ETag)
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(h.BoundaryService.CacheMaxAge()/time.Second)))
+	if file.NotModified {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/geo+json", file.Content)
+}
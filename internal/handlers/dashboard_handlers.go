@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"gobi/internal/models"
+	"gobi/internal/services"
+	"gobi/pkg/errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DashboardHandler handles dashboard-related HTTP requests
+type DashboardHandler struct {
+	DB               *gorm.DB
+	DashboardService *services.DashboardService
+	Hub              *services.DashboardHub
+}
+
+// NewDashboardHandler creates a new DashboardHandler instance
+func NewDashboardHandler(db *gorm.DB, serviceFactory *services.ServiceFactory) *DashboardHandler {
+	return &DashboardHandler{
+		DB:               db,
+		DashboardService: serviceFactory.CreateDashboardService(),
+		Hub:              serviceFactory.CreateDashboardHub(),
+	}
+}
+
+// CreateDashboard handles dashboard creation
+func (h *DashboardHandler) CreateDashboard(c *gin.Context) {
+	var dashboard models.Dashboard
+	if err := c.ShouldBindJSON(&dashboard); err != nil {
+		c.Error(errors.NewBadRequestError("Invalid dashboard data", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	if err := h.DashboardService.CreateDashboard(&dashboard, userID.(uint), isAdmin); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, dashboard)
+}
+
+// ListDashboards handles dashboard listing
+func (h *DashboardHandler) ListDashboards(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	dashboards, err := h.DashboardService.ListDashboards(userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dashboards)
+}
+
+// GetDashboard handles getting a specific dashboard
+func (h *DashboardHandler) GetDashboard(c *gin.Context) {
+	id := c.Param("id")
+	dashboardID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid dashboard ID", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	dashboard, err := h.DashboardService.GetDashboard(uint(dashboardID), userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dashboard)
+}
+
+// UpdateDashboard handles dashboard updates
+func (h *DashboardHandler) UpdateDashboard(c *gin.Context) {
+	id := c.Param("id")
+	dashboardID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid dashboard ID", err))
+		return
+	}
+
+	var updates models.Dashboard
+	if err := c.ShouldBindJSON(&updates); err != nil {
+		c.Error(errors.NewBadRequestError("Invalid dashboard data", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	dashboard, err := h.DashboardService.UpdateDashboard(uint(dashboardID), &updates, userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dashboard)
+}
+
+// DeleteDashboard handles dashboard deletion
+func (h *DashboardHandler) DeleteDashboard(c *gin.Context) {
+	id := c.Param("id")
+	dashboardID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid dashboard ID", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	if err := h.DashboardService.DeleteDashboard(uint(dashboardID), userID.(uint), isAdmin); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Dashboard deleted successfully"})
+}
+
+// GetDashboardData returns the data of every tile of a dashboard. GET applies the filter
+// defaults; POST takes filter values as {"filters": {"region": "EU"}}.
+func (h *DashboardHandler) GetDashboardData(c *gin.Context) {
+	id := c.Param("id")
+	dashboardID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid dashboard ID", err))
+		return
+	}
+
+	var req struct {
+		Filters map[string]interface{} `json:"filters"`
+	}
+	if c.Request.Method == http.MethodPost && c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(errors.NewBadRequestError("Invalid filter values", err))
+			return
+		}
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	data, err := h.DashboardService.GetDashboardData(uint(dashboardID), userID.(uint), isAdmin, req.Filters)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
+}
+
+// GetDashboardFilters returns the filters of a dashboard with their options
+func (h *DashboardHandler) GetDashboardFilters(c *gin.Context) {
+	id := c.Param("id")
+	dashboardID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid dashboard ID", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	filters, err := h.DashboardService.GetDashboardFilters(uint(dashboardID), userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, filters)
+}
+
+// StreamDashboardEvents streams live tile updates of a dashboard as Server-Sent Events.
+// A snapshot event is sent first, then a tiles event whenever tile data is refreshed.
+func (h *DashboardHandler) StreamDashboardEvents(c *gin.Context) {
+	id := c.Param("id")
+	dashboardID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid dashboard ID", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	events, unsubscribe, err := h.Hub.Subscribe(uint(dashboardID), userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(h.Hub.HeartbeatInterval())
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event.Data)
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		}
+	})
+}
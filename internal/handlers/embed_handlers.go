@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"gobi/internal/services"
+	"gobi/pkg/errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// EmbedHandler handles embed secrets, embed tokens and the public embed links
+type EmbedHandler struct {
+	DB           *gorm.DB
+	EmbedService *services.EmbedService
+}
+
+// NewEmbedHandler creates a new EmbedHandler instance
+func NewEmbedHandler(db *gorm.DB, serviceFactory *services.ServiceFactory) *EmbedHandler {
+	return &EmbedHandler{
+		DB:           db,
+		EmbedService: serviceFactory.CreateEmbedService(),
+	}
+}
+
+// GetEmbedSecret returns the key ID of the caller's embed secret
+func (h *EmbedHandler) GetEmbedSecret(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	secret, err := h.EmbedService.GetSecret(userID.(uint))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, secret)
+}
+
+// RotateEmbedSecret replaces the caller's embed secret and returns the new one
+func (h *EmbedHandler) RotateEmbedSecret(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	secret, err := h.EmbedService.RotateSecret(userID.(uint))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, secret)
+}
+
+// CreateEmbedToken signs an embed token for a chart or dashboard
+func (h *EmbedHandler) CreateEmbedToken(c *gin.Context) {
+	var req services.EmbedTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(errors.NewBadRequestError("Invalid embed token request", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	token, err := h.EmbedService.CreateToken(&req, userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, token)
+}
+
+// ListEmbedUsage lists the latest requests to the caller's embed links
+func (h *EmbedHandler) ListEmbedUsage(c *gin.Context) {
+	limit := 0
+	if l := c.Query("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil {
+			c.Error(errors.NewBadRequestError("Invalid limit", err))
+			return
+		}
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	usage, err := h.EmbedService.ListUsage(userID.(uint), isAdmin, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// ServeEmbed serves the chart or dashboard named by the embed token in the path. It is
+// public; POST takes the values of the dashboard filters that are not locked.
+func (h *EmbedHandler) ServeEmbed(c *gin.Context) {
+	var req struct {
+		Filters map[string]interface{} `json:"filters"`
+	}
+	if c.Request.Method == http.MethodPost && c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(errors.NewBadRequestError("Invalid filter values", err))
+			return
+		}
+	}
+
+	client := services.EmbedClient{IPAddress: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	view, err := h.EmbedService.Serve(c.Param("token"), req.Filters, client)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, view)
+}
@@ -29,8 +29,11 @@ type Handler struct {
 	DataSourceService *services.DataSourceService
 	QueryService      *services.QueryService
 	ChartService      *services.ChartService
+	DrillService      *services.DrillService
 	ReportService     *services.ReportService
 	TemplateService   *services.TemplateService
+	KeyRotation       *services.KeyRotationService
+	DataProfile       *services.DataProfileService
 }
 
 // NewHandler creates a new Handler instance
@@ -41,9 +44,10 @@ func NewHandler(db *gorm.DB) *Handler {
 	encryptionService := infrastructure.NewEncryptionService()
 	authService := infrastructure.NewAuthService()
 
-	// Create user repository for permission service
+	// Create repositories for permission service
 	userRepo := repositories.NewUserRepository(db)
-	permissionService := infrastructure.NewPermissionService(userRepo)
+	dashboardRepo := repositories.NewDashboardRepository(db)
+	permissionService := infrastructure.NewPermissionService(userRepo, dashboardRepo)
 
 	sqlExecutionService := infrastructure.NewSQLExecutionService()
 	reportGeneratorService := infrastructure.NewReportGeneratorService()
@@ -72,8 +76,11 @@ func NewHandler(db *gorm.DB) *Handler {
 		DataSourceService: serviceFactory.CreateDataSourceService(),
 		QueryService:      serviceFactory.CreateQueryService(),
 		ChartService:      serviceFactory.CreateChartService(),
+		DrillService:      serviceFactory.CreateDrillService(),
 		ReportService:     serviceFactory.CreateReportService(),
 		TemplateService:   serviceFactory.CreateTemplateService(),
+		KeyRotation:       serviceFactory.CreateKeyRotationService(),
+		DataProfile:       serviceFactory.CreateDataProfileService(),
 	}
 }
 
@@ -151,7 +158,8 @@ func (h *Handler) CreateDataSource(c *gin.Context) {
 	}
 
 	userID, _ := c.Get("userID")
-	if err := h.DataSourceService.CreateDataSource(&ds, userID.(uint)); err != nil {
+	role, _ := c.Get("role")
+	if err := h.DataSourceService.CreateDataSource(&ds, userID.(uint), role.(string) == "admin"); err != nil {
 		// 记录数据源创建错误
 		if customErr, ok := err.(*errors.CustomError); ok {
 			errors.RecordError(customErr)
@@ -200,6 +208,85 @@ func (h *Handler) GetDataSource(c *gin.Context) {
 	c.JSON(http.StatusOK, ds)
 }
 
+// GetDataSourceSchema returns the tables and columns of a data source with their latest profiles
+func (h *Handler) GetDataSourceSchema(c *gin.Context) {
+	id := c.Param("id")
+	dsID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid data source ID", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	tables, err := h.DataProfile.GetSchema(uint(dsID), userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tables": tables})
+}
+
+// ProfileDataSource starts a background profile of a data source table
+func (h *Handler) ProfileDataSource(c *gin.Context) {
+	id := c.Param("id")
+	dsID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid data source ID", err))
+		return
+	}
+
+	var req struct {
+		Table      string `json:"table"`
+		SampleSize int    `json:"sample_size"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(errors.NewBadRequestError("Invalid profile request", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	profile, err := h.DataProfile.StartProfile(uint(dsID), req.Table, req.SampleSize, userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, profile)
+}
+
+// GetDataSourceProfile returns a profile job and, once completed, its column statistics
+func (h *Handler) GetDataSourceProfile(c *gin.Context) {
+	dsID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid data source ID", err))
+		return
+	}
+	profileID, err := strconv.ParseUint(c.Param("profileId"), 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid profile ID", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	profile, err := h.DataProfile.GetProfile(uint(dsID), uint(profileID), userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
 func (h *Handler) UpdateDataSource(c *gin.Context) {
 	id := c.Param("id")
 	dsID, err := strconv.ParseUint(id, 10, 32)
@@ -405,12 +492,14 @@ func (h *Handler) DeleteQuery(c *gin.Context) {
 // Chart handlers
 func (h *Handler) CreateChart(c *gin.Context) {
 	var req struct {
-		Name        string `json:"name"`
-		Type        string `json:"type"`
-		QueryID     uint   `json:"queryId"`
-		Config      string `json:"config"`
-		Data        string `json:"data"`
-		Description string `json:"description"`
+		Name            string `json:"name"`
+		Type            string `json:"type"`
+		QueryID         uint   `json:"queryId"`
+		Config          string `json:"config"`
+		Data            string `json:"data"`
+		Description     string `json:"description"`
+		FieldMappings   string `json:"field_mappings"`
+		RefreshInterval int    `json:"refresh_interval"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		var syntaxErr *json.SyntaxError
@@ -425,53 +514,17 @@ func (h *Handler) CreateChart(c *gin.Context) {
 		return
 	}
 
-	// 验证图表类型
-	validChartTypes := map[string]bool{
-		"bar":               true,
-		"line":              true,
-		"pie":               true,
-		"scatter":           true,
-		"radar":             true,
-		"heatmap":           true,
-		"gauge":             true,
-		"funnel":            true,
-		"area":              true, // 面积图 - 显示数据趋势和变化量
-		"3d-bar":            true,
-		"3d-scatter":        true,
-		"3d-surface":        true,
-		"3d-bubble":         true,
-		"treemap":           true,
-		"sunburst":          true,
-		"tree":              true,
-		"boxplot":           true,
-		"candlestick":       true,
-		"wordcloud":         true,
-		"graph":             true,
-		"waterfall":         true,
-		"polar":             true,
-		"gantt":             true,
-		"rose":              true,
-		"geo":               true,
-		"map":               true,
-		"choropleth":        true,
-		"progress":          true,
-		"circular-progress": true,
-	}
-
-	if !validChartTypes[req.Type] {
-		c.Error(errors.NewBadRequestError("Invalid chart type", nil))
-		return
-	}
-
 	userID, _ := c.Get("userID")
 	chart := models.Chart{
-		Name:        req.Name,
-		Type:        req.Type,
-		QueryID:     req.QueryID,
-		Config:      req.Config,
-		Data:        req.Data,
-		Description: req.Description,
-		UserID:      userID.(uint),
+		Name:            req.Name,
+		Type:            req.Type,
+		QueryID:         req.QueryID,
+		Config:          req.Config,
+		Data:            req.Data,
+		Description:     req.Description,
+		FieldMappings:   req.FieldMappings,
+		RefreshInterval: req.RefreshInterval,
+		UserID:          userID.(uint),
 	}
 
 	if err := h.ChartService.CreateChart(&chart, userID.(uint)); err != nil {
@@ -482,6 +535,11 @@ func (h *Handler) CreateChart(c *gin.Context) {
 	c.JSON(http.StatusCreated, chart)
 }
 
+// ListChartTypes returns the chart type registry with config schemas and data shapes
+func (h *Handler) ListChartTypes(c *gin.Context) {
+	c.JSON(http.StatusOK, h.ChartService.ListChartTypes())
+}
+
 func (h *Handler) ListCharts(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	role, _ := c.Get("role")
@@ -525,7 +583,7 @@ func (h *Handler) UpdateChart(c *gin.Context) {
 		return
 	}
 
-	var req models.Chart
+	var req services.ChartUpdate
 	if err := c.ShouldBindJSON(&req); err != nil {
 		var syntaxErr *json.SyntaxError
 		var typeErr *json.UnmarshalTypeError
@@ -552,6 +610,143 @@ func (h *Handler) UpdateChart(c *gin.Context) {
 	c.JSON(http.StatusOK, chart)
 }
 
+// GetChartData returns the chart's data from its bound query, mapped to the chart type
+func (h *Handler) GetChartData(c *gin.Context) {
+	id := c.Param("id")
+	chartID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid chart ID", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	data, err := h.ChartService.GetChartData(uint(chartID), userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
+}
+
+// RefreshChartData re-executes the chart's query and returns fresh data
+func (h *Handler) RefreshChartData(c *gin.Context) {
+	id := c.Param("id")
+	chartID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid chart ID", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	data, err := h.ChartService.RefreshChartData(uint(chartID), userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
+}
+
+// GetChartEChartsOption returns a ready-to-use ECharts option for a chart
+func (h *Handler) GetChartEChartsOption(c *gin.Context) {
+	id := c.Param("id")
+	chartID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid chart ID", err))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	option, err := h.ChartService.GetEChartsOption(uint(chartID), userID.(uint), isAdmin)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, option)
+}
+
+// DrillChart returns the drill level or drill-through target of a clicked chart datum
+func (h *Handler) DrillChart(c *gin.Context) {
+	id := c.Param("id")
+	chartID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid chart ID", err))
+		return
+	}
+
+	var req services.DrillRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(errors.NewBadRequestError("Invalid drill request", err))
+			return
+		}
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	result, err := h.DrillService.Drill(uint(chartID), userID.(uint), isAdmin, &req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// RenderChart draws a chart as an SVG or PNG image
+func (h *Handler) RenderChart(c *gin.Context) {
+	id := c.Param("id")
+	chartID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid chart ID", err))
+		return
+	}
+	width, height := 0, 0
+	if w := c.Query("width"); w != "" {
+		if width, err = strconv.Atoi(w); err != nil {
+			c.Error(errors.NewBadRequestError("Invalid width", err))
+			return
+		}
+	}
+	if hgt := c.Query("height"); hgt != "" {
+		if height, err = strconv.Atoi(hgt); err != nil {
+			c.Error(errors.NewBadRequestError("Invalid height", err))
+			return
+		}
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	isAdmin := role.(string) == "admin"
+
+	image, err := h.ChartService.RenderChart(uint(chartID), userID.(uint), isAdmin, c.Query("format"), width, height)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("ETag", image.ETag)
+	c.Header("Cache-Control", "private, no-cache")
+	if c.GetHeader("If-None-Match") == image.ETag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, image.ContentType, image.Content)
+}
+
 func (h *Handler) DeleteChart(c *gin.Context) {
 	id := c.Param("id")
 	chartID, err := strconv.ParseUint(id, 10, 32)
@@ -611,7 +806,7 @@ func (h *Handler) UploadTemplate(c *gin.Context) {
 	}
 
 	if err := h.TemplateService.CreateTemplate(&template, userID.(uint)); err != nil {
-		c.Error(errors.WrapError(err, "Could not save template"))
+		c.Error(err)
 		return
 	}
 
@@ -700,19 +895,17 @@ func (h *Handler) DownloadTemplate(c *gin.Context) {
 	role, _ := c.Get("role")
 	isAdmin := role.(string) == "admin"
 
-	template, err := h.TemplateService.DownloadTemplate(uint(templateID), userID.(uint), isAdmin)
+	template, content, err := h.TemplateService.DownloadTemplate(uint(templateID), userID.(uint), isAdmin)
 	if err != nil {
 		c.Error(err)
 		return
 	}
+	defer content.Close()
 
-	// 设置响应头，告诉浏览器这是一个文件下载
-	c.Header("Content-Disposition", "attachment; filename="+template.Name)
-	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	c.Header("Content-Length", strconv.Itoa(len(template.Template)))
-
-	// 写入文件内容
-	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", template.Template)
+	// 设置响应头，告诉浏览器这是一个文件下载，文件内容从存储中流式写出
+	c.DataFromReader(http.StatusOK, template.Size, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", content, map[string]string{
+		"Content-Disposition": "attachment; filename=" + template.Name,
+	})
 }
 
 func (h *Handler) DeleteTemplate(c *gin.Context) {
@@ -823,6 +1016,23 @@ func (h *Handler) ClearCache(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Cache '%s' cleared", req.Type)})
 }
 
+// RotateEncryptionKeys re-encrypts stored secrets with the active key (admin only)
+func (h *Handler) RotateEncryptionKeys(c *gin.Context) {
+	role, _ := c.Get("role")
+	if role.(string) != "admin" {
+		c.Error(errors.ErrForbidden)
+		return
+	}
+
+	result, err := h.KeyRotation.RotateAll()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
 func (h *Handler) TestDatabaseConnection(c *gin.Context) {
 	var ds models.DataSource
 	if err := c.ShouldBindJSON(&ds); err != nil {
@@ -830,7 +1040,8 @@ func (h *Handler) TestDatabaseConnection(c *gin.Context) {
 		return
 	}
 
-	if err := h.DataSourceService.TestConnection(&ds); err != nil {
+	role, _ := c.Get("role")
+	if err := h.DataSourceService.TestConnection(&ds, role.(string) == "admin"); err != nil {
 		c.Error(err)
 		return
 	}
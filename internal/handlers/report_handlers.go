@@ -6,6 +6,7 @@ import (
 	"gobi/internal/services"
 	"gobi/pkg/errors"
 	"gobi/pkg/utils"
+	"mime"
 	"net/http"
 	"strconv"
 	"time"
@@ -21,6 +22,8 @@ type ReportHandler struct {
 	ReportService           *services.ReportService
 	ReportScheduleService   *services.ReportScheduleService
 	ReportGenerationService *services.ReportGenerationService
+	ReportDeliveryService   *services.ReportDeliveryService
+	ReportRetentionService  *services.ReportRetentionService
 }
 
 // NewReportHandler creates a new ReportHandler.
@@ -30,6 +33,8 @@ func NewReportHandler(db *gorm.DB, serviceFactory *services.ServiceFactory) *Rep
 		ReportService:           serviceFactory.CreateReportService(),
 		ReportScheduleService:   services.NewReportScheduleService(db),
 		ReportGenerationService: serviceFactory.CreateReportGenerationService(),
+		ReportDeliveryService:   serviceFactory.CreateReportDeliveryService(),
+		ReportRetentionService:  serviceFactory.CreateReportRetentionService(),
 	}
 }
 
@@ -204,7 +209,7 @@ func (h *ReportHandler) DeleteReport(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Report deleted successfully"})
 }
 
-// GenerateReport generates a report based on the report configuration
+// GenerateReport queues a run that generates a report again from its schedule
 func (h *ReportHandler) GenerateReport(c *gin.Context) {
 	id := c.Param("id")
 	reportID, err := strconv.ParseUint(id, 10, 32)
@@ -233,10 +238,10 @@ func (h *ReportHandler) GenerateReport(c *gin.Context) {
 		"action":   "generate_report",
 		"userID":   userID,
 		"reportID": reportID,
-		"status":   result.Status,
-	}).Info("Report generation completed")
+		"runID":    result.ID,
+	}).Info("Report generation queued")
 
-	c.JSON(http.StatusOK, result)
+	c.JSON(http.StatusAccepted, result)
 }
 
 // GetReportStatus gets the current status of a report
@@ -270,12 +275,20 @@ func (h *ReportHandler) GetReportStatus(c *gin.Context) {
 // CreateReportSchedule creates a new report schedule
 func (h *ReportHandler) CreateReportSchedule(c *gin.Context) {
 	var req struct {
-		Name        string `json:"name" binding:"required"`
-		Type        string `json:"type" binding:"required,oneof=daily weekly monthly"`
-		QueryIDs    []uint `json:"query_ids"`
-		ChartIDs    []uint `json:"chart_ids"`
-		TemplateIDs []uint `json:"template_ids"`
-		CronPattern string `json:"cron_pattern" binding:"required"`
+		Name             string                 `json:"name" binding:"required"`
+		Type             string                 `json:"type" binding:"required,oneof=daily weekly monthly"`
+		QueryIDs         []uint                 `json:"query_ids"`
+		ChartIDs         []uint                 `json:"chart_ids"`
+		TemplateIDs      []uint                 `json:"template_ids"`
+		Parameters       map[string]interface{} `json:"parameters"`
+		Format           string                 `json:"format" binding:"omitempty,oneof=xlsx pdf"`
+		Layout           map[string]interface{} `json:"layout"`
+		CronPattern      string                 `json:"cron_pattern" binding:"required"`
+		TimeZone         string                 `json:"time_zone"`
+		Recipients       []string               `json:"recipients"`
+		Subject          string                 `json:"subject"`
+		Body             string                 `json:"body"`
+		AttachmentFormat string                 `json:"attachment_format" binding:"omitempty,oneof=xlsx pdf csv"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -306,20 +319,38 @@ func (h *ReportHandler) CreateReportSchedule(c *gin.Context) {
 	queryIDs, _ := json.Marshal(req.QueryIDs)
 	chartIDs, _ := json.Marshal(req.ChartIDs)
 	templateIDs, _ := json.Marshal(req.TemplateIDs)
+	var parameters, layout, recipients []byte
+	if req.Parameters != nil {
+		parameters, _ = json.Marshal(req.Parameters)
+	}
+	if req.Layout != nil {
+		layout, _ = json.Marshal(req.Layout)
+	}
+	if req.Recipients != nil {
+		recipients, _ = json.Marshal(req.Recipients)
+	}
 
-	// 使用cron表达式计算下次运行时间
-	nextRun := calculateNextRunFromCron(req.CronPattern)
+	// 使用cron表达式在计划时区计算下次运行时间
+	nextRun := calculateNextRunFromCron(req.CronPattern, req.TimeZone)
 
 	schedule := models.ReportSchedule{
-		UserID:      userID,
-		Name:        req.Name,
-		Type:        req.Type,
-		Queries:     string(queryIDs),
-		Charts:      string(chartIDs),
-		Templates:   string(templateIDs),
-		CronPattern: req.CronPattern,
-		Active:      true,
-		NextRun:     nextRun,
+		UserID:           userID,
+		Name:             req.Name,
+		Type:             req.Type,
+		Queries:          string(queryIDs),
+		Charts:           string(chartIDs),
+		Templates:        string(templateIDs),
+		Parameters:       string(parameters),
+		Format:           req.Format,
+		Layout:           string(layout),
+		CronPattern:      req.CronPattern,
+		TimeZone:         req.TimeZone,
+		Active:           true,
+		NextRun:          nextRun,
+		Recipients:       string(recipients),
+		Subject:          req.Subject,
+		Body:             req.Body,
+		AttachmentFormat: req.AttachmentFormat,
 	}
 
 	if err := h.ReportScheduleService.CreateReportSchedule(&schedule, userID); err != nil {
@@ -398,13 +429,21 @@ func (h *ReportHandler) UpdateReportSchedule(c *gin.Context) {
 	isAdmin := role == "admin"
 
 	var req struct {
-		Name        string `json:"name"`
-		Type        string `json:"type" binding:"omitempty,oneof=daily weekly monthly"`
-		QueryIDs    []uint `json:"query_ids"`
-		ChartIDs    []uint `json:"chart_ids"`
-		TemplateIDs []uint `json:"template_ids"`
-		CronPattern string `json:"cron_pattern"`
-		Active      *bool  `json:"active"`
+		Name             string                 `json:"name"`
+		Type             string                 `json:"type" binding:"omitempty,oneof=daily weekly monthly"`
+		QueryIDs         []uint                 `json:"query_ids"`
+		ChartIDs         []uint                 `json:"chart_ids"`
+		TemplateIDs      []uint                 `json:"template_ids"`
+		Parameters       map[string]interface{} `json:"parameters"`
+		Format           string                 `json:"format" binding:"omitempty,oneof=xlsx pdf"`
+		Layout           map[string]interface{} `json:"layout"`
+		CronPattern      string                 `json:"cron_pattern"`
+		TimeZone         string                 `json:"time_zone"`
+		Active           *bool                  `json:"active"`
+		Recipients       []string               `json:"recipients"`
+		Subject          string                 `json:"subject"`
+		Body             string                 `json:"body"`
+		AttachmentFormat string                 `json:"attachment_format" binding:"omitempty,oneof=xlsx pdf csv"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.Error(errors.NewBadRequestError("Invalid report schedule data", err))
@@ -412,7 +451,7 @@ func (h *ReportHandler) UpdateReportSchedule(c *gin.Context) {
 	}
 
 	// Convert arrays to JSON strings if provided
-	var queryIDs, chartIDs, templateIDs string
+	var queryIDs, chartIDs, templateIDs, parameters, layout, recipients string
 	if req.QueryIDs != nil {
 		queryIDsBytes, _ := json.Marshal(req.QueryIDs)
 		queryIDs = string(queryIDsBytes)
@@ -425,14 +464,34 @@ func (h *ReportHandler) UpdateReportSchedule(c *gin.Context) {
 		templateIDsBytes, _ := json.Marshal(req.TemplateIDs)
 		templateIDs = string(templateIDsBytes)
 	}
+	if req.Parameters != nil {
+		parametersBytes, _ := json.Marshal(req.Parameters)
+		parameters = string(parametersBytes)
+	}
+	if req.Layout != nil {
+		layoutBytes, _ := json.Marshal(req.Layout)
+		layout = string(layoutBytes)
+	}
+	if req.Recipients != nil {
+		recipientsBytes, _ := json.Marshal(req.Recipients)
+		recipients = string(recipientsBytes)
+	}
 
 	updates := &models.ReportSchedule{
-		Name:        req.Name,
-		Type:        req.Type,
-		Queries:     queryIDs,
-		Charts:      chartIDs,
-		Templates:   templateIDs,
-		CronPattern: req.CronPattern,
+		Name:             req.Name,
+		Type:             req.Type,
+		Queries:          queryIDs,
+		Charts:           chartIDs,
+		Templates:        templateIDs,
+		Parameters:       parameters,
+		Format:           req.Format,
+		Layout:           layout,
+		CronPattern:      req.CronPattern,
+		TimeZone:         req.TimeZone,
+		Recipients:       recipients,
+		Subject:          req.Subject,
+		Body:             req.Body,
+		AttachmentFormat: req.AttachmentFormat,
 	}
 	if req.Active != nil {
 		updates.Active = *req.Active
@@ -447,6 +506,109 @@ func (h *ReportHandler) UpdateReportSchedule(c *gin.Context) {
 	c.JSON(http.StatusOK, schedule)
 }
 
+// PreviewReportSchedule lists the next run times of a cron pattern in a time zone, so that
+// a schedule can be checked before it is saved
+func (h *ReportHandler) PreviewReportSchedule(c *gin.Context) {
+	var req struct {
+		CronPattern string `json:"cron_pattern" binding:"required"`
+		TimeZone    string `json:"time_zone"`
+		Count       int    `json:"count"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(errors.NewBadRequestError("Invalid schedule preview request", err))
+		return
+	}
+
+	preview, err := h.ReportScheduleService.PreviewNextRuns(req.CronPattern, req.TimeZone, req.Count)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, preview)
+}
+
+// RunReportSchedule queues a run of a report schedule now
+func (h *ReportHandler) RunReportSchedule(c *gin.Context) {
+	scheduleID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid schedule ID", err))
+		return
+	}
+
+	userID := c.GetUint("userID")
+	run, err := h.ReportScheduleService.RunSchedule(uint(scheduleID), userID, c.GetString("role") == "admin")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	utils.Logger.WithFields(map[string]interface{}{
+		"action":     "run_report_schedule",
+		"userID":     userID,
+		"scheduleID": scheduleID,
+		"runID":      run.ID,
+	}).Info("Report schedule run queued")
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// BackfillReportSchedule queues runs of a report schedule for its run times in a past
+// date range
+func (h *ReportHandler) BackfillReportSchedule(c *gin.Context) {
+	scheduleID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid schedule ID", err))
+		return
+	}
+	var req struct {
+		From string `json:"from" binding:"required"`
+		To   string `json:"to" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(errors.NewBadRequestError("Invalid backfill request", err))
+		return
+	}
+
+	userID := c.GetUint("userID")
+	runs, err := h.ReportScheduleService.BackfillSchedule(uint(scheduleID), req.From, req.To, userID, c.GetString("role") == "admin")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	utils.Logger.WithFields(map[string]interface{}{
+		"action":     "backfill_report_schedule",
+		"userID":     userID,
+		"scheduleID": scheduleID,
+		"runs":       len(runs),
+	}).Info("Report schedule backfill queued")
+
+	c.JSON(http.StatusAccepted, runs)
+}
+
+// ListScheduleRuns lists the latest runs of a report schedule
+func (h *ReportHandler) ListScheduleRuns(c *gin.Context) {
+	scheduleID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid schedule ID", err))
+		return
+	}
+	limit := 0
+	if l := c.Query("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			c.Error(errors.NewBadRequestError("Invalid limit", err))
+			return
+		}
+	}
+
+	runs, err := h.ReportScheduleService.ListScheduleRuns(uint(scheduleID), c.Query("trigger"), c.Query("status"), limit, c.GetUint("userID"), c.GetString("role") == "admin")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
 // DeleteReportSchedule deletes a report schedule
 func (h *ReportHandler) DeleteReportSchedule(c *gin.Context) {
 	id := c.Param("id")
@@ -482,7 +644,8 @@ func (h *ReportHandler) DeleteReportSchedule(c *gin.Context) {
 // GeneratePDFReport generates a PDF report from a chart
 func (h *ReportHandler) GeneratePDFReport(c *gin.Context) {
 	var req struct {
-		ChartID uint `json:"chart_id"`
+		ChartID uint                   `json:"chart_id"`
+		Layout  map[string]interface{} `json:"layout"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.Error(errors.NewBadRequestError("Invalid request", err))
@@ -493,7 +656,11 @@ func (h *ReportHandler) GeneratePDFReport(c *gin.Context) {
 	role := c.GetString("role")
 	isAdmin := role == "admin"
 
-	pdfBytes, err := h.ReportGenerationService.GeneratePDFReport(req.ChartID, userID, isAdmin)
+	var layout []byte
+	if req.Layout != nil {
+		layout, _ = json.Marshal(req.Layout)
+	}
+	pdfBytes, err := h.ReportGenerationService.GeneratePDFReport(req.ChartID, userID, isAdmin, layout)
 	if err != nil {
 		c.Error(err)
 		return
@@ -539,30 +706,177 @@ func (h *ReportHandler) DownloadReport(c *gin.Context) {
 	role := c.GetString("role")
 	isAdmin := role == "admin"
 
-	report, err := h.ReportGenerationService.DownloadReport(uint(reportID), userID, isAdmin)
+	report, content, err := h.ReportGenerationService.DownloadReport(uint(reportID), userID, isAdmin)
 	if err != nil {
 		c.Error(err)
 		return
 	}
+	defer content.Close()
+
+	fileName := services.ReportFileName(report) + "." + services.ReportFormat(report.Format)
+	c.DataFromReader(http.StatusOK, report.Size, services.ReportContentType(report.Format), content, map[string]string{
+		"Content-Disposition": "attachment; filename=" + fileName,
+	})
+}
 
-	// Generate filename
-	fileName := report.Name
-	if report.Type == "daily" {
-		fileName += "_" + report.GeneratedAt.Format("2006-01-02")
-	} else if report.Type == "weekly" {
-		fileName += "_week_" + report.GeneratedAt.Format("2006-01-02")
-	} else if report.Type == "monthly" {
-		fileName += "_" + report.GeneratedAt.Format("2006-01")
+// ListReportDeliveries lists the email deliveries of a report, one per recipient
+func (h *ReportHandler) ListReportDeliveries(c *gin.Context) {
+	reportID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid report ID", err))
+		return
 	}
-	fileName += ".xlsx"
 
-	c.Header("Content-Disposition", "attachment; filename="+fileName)
-	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	c.Header("Content-Length", strconv.Itoa(len(report.Content)))
-	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", report.Content)
+	deliveries, err := h.ReportDeliveryService.ListDeliveries(uint(reportID), c.GetUint("userID"), c.GetString("role") == "admin")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// DownloadReportLink downloads a report through the signed link of a report email
+func (h *ReportHandler) DownloadReportLink(c *gin.Context) {
+	download, err := h.ReportDeliveryService.OpenDownload(c.Param("token"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	defer download.Content.Close()
+
+	c.DataFromReader(http.StatusOK, download.Size, download.ContentType, download.Content, map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": download.FileName}),
+		"Cache-Control":       "private, no-store",
+	})
+}
+
+// PinReport pins a report so that retention policies never remove it
+func (h *ReportHandler) PinReport(c *gin.Context) {
+	h.setReportPinned(c, true)
+}
+
+// UnpinReport unpins a report
+func (h *ReportHandler) UnpinReport(c *gin.Context) {
+	h.setReportPinned(c, false)
+}
+
+// setReportPinned pins or unpins the report of the request
+func (h *ReportHandler) setReportPinned(c *gin.Context, pinned bool) {
+	reportID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid report ID", err))
+		return
+	}
+
+	report, err := h.ReportService.PinReport(uint(reportID), pinned, c.GetUint("userID"), c.GetString("role") == "admin")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// retentionPolicyRequest is the body of a retention policy
+type retentionPolicyRequest struct {
+	KeepLast       int    `json:"keep_last" binding:"min=0"`
+	KeepDays       int    `json:"keep_days" binding:"min=0"`
+	KeepFailedDays int    `json:"keep_failed_days" binding:"min=0"`
+	Action         string `json:"action" binding:"omitempty,oneof=delete archive"`
+}
+
+// ListRetentionPolicies lists the retention policies of the user, or all policies for admins
+func (h *ReportHandler) ListRetentionPolicies(c *gin.Context) {
+	policies, err := h.ReportRetentionService.ListPolicies(c.GetUint("userID"), c.GetString("role") == "admin")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, policies)
+}
+
+// SetRetentionPolicy sets the default retention policy of the user's reports
+func (h *ReportHandler) SetRetentionPolicy(c *gin.Context) {
+	h.setRetentionPolicy(c, 0)
+}
+
+// DeleteRetentionPolicy removes the default retention policy of the user's reports
+func (h *ReportHandler) DeleteRetentionPolicy(c *gin.Context) {
+	if err := h.ReportRetentionService.DeletePolicy(0, c.GetUint("userID"), c.GetString("role") == "admin"); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Retention policy deleted successfully"})
+}
+
+// SetScheduleRetentionPolicy sets the retention policy of the reports of a schedule
+func (h *ReportHandler) SetScheduleRetentionPolicy(c *gin.Context) {
+	scheduleID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid schedule ID", err))
+		return
+	}
+	h.setRetentionPolicy(c, uint(scheduleID))
+}
+
+// DeleteScheduleRetentionPolicy removes the retention policy of a schedule, whose reports
+// then follow the default policy of its owner
+func (h *ReportHandler) DeleteScheduleRetentionPolicy(c *gin.Context) {
+	scheduleID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.Error(errors.NewBadRequestError("Invalid schedule ID", err))
+		return
+	}
+
+	if err := h.ReportRetentionService.DeletePolicy(uint(scheduleID), c.GetUint("userID"), c.GetString("role") == "admin"); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Retention policy deleted successfully"})
+}
+
+// setRetentionPolicy saves the retention policy of the request for a schedule, or as the
+// user default when scheduleID is 0
+func (h *ReportHandler) setRetentionPolicy(c *gin.Context, scheduleID uint) {
+	var req retentionPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(errors.NewBadRequestError("Invalid retention policy", err))
+		return
+	}
+
+	userID := c.GetUint("userID")
+	policy := &models.ReportRetentionPolicy{
+		ScheduleID:     scheduleID,
+		KeepLast:       req.KeepLast,
+		KeepDays:       req.KeepDays,
+		KeepFailedDays: req.KeepFailedDays,
+		Action:         req.Action,
+	}
+	if err := h.ReportRetentionService.SetPolicy(policy, userID, c.GetString("role") == "admin"); err != nil {
+		c.Error(err)
+		return
+	}
+
+	utils.Logger.WithFields(map[string]interface{}{
+		"action":     "set_retention_policy",
+		"userID":     userID,
+		"scheduleID": scheduleID,
+	}).Info("Retention policy saved successfully")
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// PreviewRetention lists the reports the janitor would delete or archive now, without
+// changing anything
+func (h *ReportHandler) PreviewRetention(c *gin.Context) {
+	preview, err := h.ReportRetentionService.Preview(c.GetUint("userID"), c.GetString("role") == "admin")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, preview)
 }
 
-// calculateNextRunFromCron calculates the next run time based on cron pattern
-func calculateNextRunFromCron(cronPattern string) time.Time {
-	return utils.CalculateNextRunFromCron(cronPattern, time.Time{})
+// calculateNextRunFromCron calculates the next run time based on cron pattern in a time zone
+func calculateNextRunFromCron(cronPattern, timeZone string) time.Time {
+	return utils.CalculateNextRunInZone(cronPattern, timeZone, time.Time{})
 }
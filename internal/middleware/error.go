@@ -265,7 +265,7 @@ func getHTTPStatus(code errors.ErrorCode) int {
 	switch code {
 	case errors.ErrCodeSuccess:
 		return http.StatusOK
-	case errors.ErrCodeInvalidRequest, errors.ErrCodeInvalidChartType, errors.ErrCodeInvalidChartConfig, errors.ErrCodeInvalidChartData, errors.ErrCodeInvalidSQL:
+	case errors.ErrCodeInvalidRequest, errors.ErrCodeInvalidChartType, errors.ErrCodeInvalidChartConfig, errors.ErrCodeInvalidChartData, errors.ErrCodeInvalidSQL, errors.ErrCodeDataSourceInvalid:
 		return http.StatusBadRequest
 	case errors.ErrCodeUnauthorized, errors.ErrCodeInvalidToken, errors.ErrCodeTokenExpired, errors.ErrCodeTokenNotValidYet, errors.ErrCodeTokenMissingClaims, errors.ErrCodeInvalidCredentials, errors.ErrCodeInvalidAPIKey, errors.ErrCodeAPIKeyExpired:
 		return http.StatusUnauthorized
@@ -26,8 +26,19 @@ type DataSource struct {
 	Database    string
 	Username    string
 	Password    string
+	PasswordRef string `gorm:"-" json:"password_ref,omitempty"` // env:, file: or vault: reference used instead of Password, admins only
 	Description string
 	IsPublic    bool
+	Replicas    string `gorm:"type:text" json:"replicas"` // JSON array of read replicas, see DataSourceReplica
+}
+
+// DataSourceReplica is a read replica endpoint of a data source.
+// Replicas share the primary's database name and credentials unless overridden.
+type DataSourceReplica struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Weight   int    `json:"weight"`
+	Username string `json:"username,omitempty"`
 }
 
 type Query struct {
@@ -54,6 +65,13 @@ type Chart struct {
 	Config      string // JSON configuration
 	Data        string // JSON data
 	Description string `json:"description"`
+	// FieldMappings maps chart roles (x, y, series, size...) to query result columns as JSON
+	FieldMappings string `json:"field_mappings" gorm:"type:text"`
+	// RefreshInterval is the maximum age in seconds of live data; 0 relies on the query cache
+	RefreshInterval int `json:"refresh_interval"`
+	// Drill declares the drill-down levels and drill-through target of the chart as JSON,
+	// e.g. {"levels": ["region", "city"], "through": {"query_id": 4, "parameters": {"city": "city"}}}
+	Drill string `json:"drill" gorm:"type:text"`
 }
 
 type ExcelTemplate struct {
@@ -61,8 +79,25 @@ type ExcelTemplate struct {
 	UserID      uint
 	User        User
 	Name        string
-	Template    []byte
-	Description string `json:"description"`
+	Template    []byte            `gorm:"-" json:"-"` // workbook while it is uploaded or read, kept in the blob store
+	StorageKey  string            `gorm:"type:varchar(255)" json:"-"`
+	Size        int64             `json:"size"`
+	Checksum    string            `gorm:"type:varchar(64)" json:"checksum"` // hex SHA-256 of the workbook
+	Description string            `json:"description"`
+	Bindings    string            `gorm:"type:text" json:"-"` // JSON array of TemplateBinding
+	BindingList []TemplateBinding `gorm:"-" json:"bindings"`
+}
+
+// TemplateBinding is a placeholder, table anchor or defined name of an Excel template
+// that a report fills
+type TemplateBinding struct {
+	Kind    string   `json:"kind"`             // value, table or range
+	Source  string   `json:"source"`           // query, param or report
+	Name    string   `json:"name"`             // result, parameter or report field
+	Column  string   `json:"column,omitempty"` // column of a query value
+	Columns []string `json:"columns,omitempty"`
+	Sheet   string   `json:"sheet"`
+	Cell    string   `json:"cell"` // cell, or range of a defined name
 }
 
 type Report struct {
@@ -70,26 +105,48 @@ type Report struct {
 	UserID      uint
 	User        User
 	Name        string
-	Type        string    // daily, weekly, monthly
-	Content     []byte    // report content in PDF or Excel format
-	GeneratedAt time.Time // when the report was generated
-	Status      string    // pending, success, failed
-	Error       string    // error message if generation failed
+	Type        string     // daily, weekly, monthly
+	Content     []byte     `gorm:"-" json:"-"` // report file while it is generated or read, kept in the blob store
+	StorageKey  string     `gorm:"type:varchar(255)" json:"-"`
+	Size        int64      // size of the report file in bytes
+	Checksum    string     `gorm:"type:varchar(64)"` // hex SHA-256 of the report file
+	GeneratedAt time.Time  // when the report was generated
+	Status      string     // generating, success, partial or failed
+	Error       string     // error message if generation failed
+	ScheduleID  uint       `gorm:"index"` // schedule the report was generated from
+	Sections    string     // JSON array of the outcome of every section
+	Format      string     // xlsx or pdf; empty is xlsx
+	TimeZone    string     // IANA time zone of the schedule when generated; names and dates use it
+	Pinned      bool       `gorm:"default:false"` // pinned reports are never removed by retention
+	ArchivedAt  *time.Time // when retention moved the report file to the archive
+	LogicalDate *time.Time // schedule time the report was generated for, bound to {{logical_date}}
+	// RetentionRetryAt is when retention tries again after it failed to delete or archive the report
+	RetentionRetryAt *time.Time
 }
 
 type ReportSchedule struct {
 	gorm.Model
-	UserID      uint
-	User        User
-	Name        string
-	Type        string    // daily, weekly, monthly
-	Queries     string    // JSON array of query IDs to include
-	Charts      string    // JSON array of chart IDs to include
-	Templates   string    // JSON array of template IDs to use
-	LastRun     time.Time // last time the report was generated
-	NextRun     time.Time // next scheduled run time
-	Active      bool      // whether the schedule is active
-	CronPattern string    // cron pattern for scheduling
+	UserID           uint
+	User             User
+	Name             string
+	Type             string     // daily, weekly, monthly
+	Queries          string     // JSON array of query IDs to include
+	Charts           string     // JSON array of chart IDs to include
+	Templates        string     // JSON array of template IDs to use
+	LastRun          time.Time  // last time the report was generated
+	NextRun          time.Time  // next scheduled run time
+	Active           bool       // whether the schedule is active
+	CronPattern      string     // cron pattern for scheduling
+	TimeZone         string     // IANA time zone the cron pattern runs in, e.g. Asia/Shanghai; empty is the server's
+	Parameters       string     // JSON object of the values bound to {{parameters}} of the queries
+	Format           string     // output format, xlsx or pdf; empty is xlsx
+	Layout           string     // JSON layout template of PDF output
+	Recipients       string     // JSON array of email addresses the reports are sent to
+	Subject          string     // subject template of the report emails
+	Body             string     // body template of the report emails
+	AttachmentFormat string     // format of the emailed report, xlsx, pdf or csv; empty is Format
+	LeaseOwner       string     `gorm:"type:varchar(128)" json:"-"` // scheduler instance running the schedule
+	LeaseExpiresAt   *time.Time `gorm:"index" json:"-"`             // other instances may claim the schedule after this
 }
 
 // APIKey represents an API key for service-to-service authentication
@@ -120,7 +177,7 @@ type Webhook struct {
 	URL       string    `gorm:"type:varchar(512)" json:"url"`
 	Events    string    `gorm:"type:text" json:"events"`    // JSON array of event types
 	Headers   string    `gorm:"type:text" json:"headers"`   // JSON object of custom headers
-	Secret    string    `gorm:"type:varchar(128)" json:"-"` // for signature verification
+	Secret    string    `gorm:"type:varchar(512)" json:"-"` // for signature verification
 	Active    bool      `gorm:"default:true" json:"active"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -139,3 +196,224 @@ type WebhookDelivery struct {
 	CreatedAt time.Time  `json:"created_at"`
 	SentAt    *time.Time `json:"sent_at"`
 }
+
+// ReportDelivery is the delivery of a report to one email recipient. Reports larger than
+// the attachment limit are sent as a signed download link.
+type ReportDelivery struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	ReportID   uint   `gorm:"index" json:"report_id"`
+	ScheduleID uint   `gorm:"index" json:"schedule_id"`
+	Recipient  string `gorm:"type:varchar(255)" json:"recipient"`
+	Method     string `gorm:"type:varchar(32)" json:"method"` // attachment or link
+	Format     string `gorm:"type:varchar(16)" json:"format"` // xlsx, pdf or csv
+	Status     string `gorm:"type:varchar(32)" json:"status"` // pending, sent or failed
+	Attempts   int    `gorm:"default:0" json:"attempts"`
+	Error      string `gorm:"type:text" json:"error,omitempty"`
+	// NextAttemptAt is when a pending delivery is sent next; a sender claims it by moving
+	// it past the time the attempt may take
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at"`
+}
+
+// ScheduleRun is one run of a report schedule: by the scheduler when it is due, or queued
+// from the API to run it now or to backfill past run times
+type ScheduleRun struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	ScheduleID     uint       `gorm:"index" json:"schedule_id"`
+	ReportID       uint       `json:"report_id"`                                          // report produced, or regenerated by a manual run; 0 if none
+	Trigger        string     `gorm:"type:varchar(16);default:cron;index" json:"trigger"` // cron, manual or backfill
+	LogicalDate    *time.Time `json:"logical_date"`                                       // schedule time the run stands for
+	TriggeredBy    uint       `json:"triggered_by,omitempty"`                             // user who queued a manual or backfill run
+	Status         string     `gorm:"type:varchar(16);index" json:"status"`               // queued, running, success, partial or failed
+	Error          string     `gorm:"type:text" json:"error,omitempty"`
+	Worker         string     `gorm:"type:varchar(128)" json:"worker"` // scheduler instance that ran it
+	LeaseExpiresAt *time.Time `json:"-"`                               // a running run past this was abandoned
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at"`
+}
+
+// ReportRetentionPolicy decides how long generated reports are kept. A policy with a
+// ScheduleID applies to the reports of that schedule; a policy without one is the default
+// of its user. Zero limits mean no limit.
+type ReportRetentionPolicy struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	UserID         uint      `gorm:"uniqueIndex:idx_retention_scope" json:"user_id"`
+	ScheduleID     uint      `gorm:"uniqueIndex:idx_retention_scope" json:"schedule_id"`
+	KeepLast       int       `json:"keep_last"`                      // successful reports kept per schedule
+	KeepDays       int       `json:"keep_days"`                      // days reports are kept
+	KeepFailedDays int       `json:"keep_failed_days"`               // days failed reports are kept; 0 is KeepDays
+	Action         string    `gorm:"type:varchar(16)" json:"action"` // delete or archive
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// DataProfile is a persisted column profile of a data source table.
+// Profiles are computed by background jobs; Result holds the column profiles as JSON.
+type DataProfile struct {
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	DataSourceID uint            `gorm:"index" json:"data_source_id"`
+	DataSource   DataSource      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	UserID       uint            `gorm:"index" json:"user_id"`
+	Table        string          `gorm:"column:table_name;type:varchar(255)" json:"table"`
+	SampleSize   int             `json:"sample_size"`                    // 0 means the whole table
+	Status       string          `gorm:"type:varchar(32)" json:"status"` // pending, running, completed, failed
+	Result       string          `gorm:"type:text" json:"-"`             // JSON array of ColumnProfile
+	Error        string          `gorm:"type:text" json:"error,omitempty"`
+	Columns      []ColumnProfile `gorm:"-" json:"columns,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	StartedAt    *time.Time      `json:"started_at"`
+	CompletedAt  *time.Time      `json:"completed_at"`
+}
+
+// ColumnProfile holds the statistics of a single profiled column
+type ColumnProfile struct {
+	Column        string            `json:"column"`
+	DataType      string            `json:"data_type"`
+	Kind          string            `json:"kind"` // numeric, temporal, text, other
+	RowCount      int64             `json:"row_count"`
+	NullCount     int64             `json:"null_count"`
+	NullRate      float64           `json:"null_rate"`
+	DistinctCount int64             `json:"distinct_count"`
+	Min           interface{}       `json:"min"`
+	Max           interface{}       `json:"max"`
+	TopValues     []ValueCount      `json:"top_values"`
+	Histogram     []HistogramBucket `json:"histogram,omitempty"`
+	Error         string            `json:"error,omitempty"`
+}
+
+// ValueCount is a value and the number of rows holding it
+type ValueCount struct {
+	Value interface{} `json:"value"`
+	Count int64       `json:"count"`
+}
+
+// HistogramBucket is one bucket of a numeric or date histogram
+type HistogramBucket struct {
+	Label string      `json:"label"`
+	Lower interface{} `json:"lower,omitempty"`
+	Upper interface{} `json:"upper,omitempty"`
+	Count int64       `json:"count"`
+}
+
+// Dashboard arranges chart, text and KPI tiles on a 12 column grid.
+// Owners can share a dashboard with everyone (IsPublic) or with individual users.
+type Dashboard struct {
+	ID          uint              `gorm:"primaryKey" json:"id"`
+	UserID      uint              `gorm:"index" json:"user_id"`
+	User        User              `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Name        string            `gorm:"type:varchar(128)" json:"name"`
+	Description string            `gorm:"type:text" json:"description"`
+	IsPublic    bool              `json:"is_public"` // every user may view
+	Tiles       []DashboardTile   `gorm:"constraint:OnDelete:CASCADE" json:"tiles"`
+	Filters     []DashboardFilter `gorm:"constraint:OnDelete:CASCADE" json:"filters"`
+	Shares      []DashboardShare  `gorm:"constraint:OnDelete:CASCADE" json:"shares"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// DashboardTile is a tile of a dashboard and its position on the grid
+type DashboardTile struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	DashboardID uint   `gorm:"index" json:"dashboard_id"`
+	Type        string `gorm:"type:varchar(16)" json:"type"` // chart, text, kpi
+	Title       string `gorm:"type:varchar(128)" json:"title"`
+	ChartID     uint   `json:"chart_id,omitempty"`                 // chart tiles
+	QueryID     uint   `json:"query_id,omitempty"`                 // KPI tiles
+	ValueField  string `json:"value_field,omitempty"`              // KPI tiles: column holding the value
+	Content     string `gorm:"type:text" json:"content,omitempty"` // text tiles: markdown
+	// FilterMappings maps dashboard filter names to the query parameter or result column they
+	// filter as JSON, e.g. {"region": {"column": "region"}, "period": {"parameter": "period"}}
+	FilterMappings string `gorm:"type:text" json:"filter_mappings,omitempty"`
+	// CrossFilter names the filter set by clicking a data point of a chart tile; the chart
+	// field whose value is used defaults to the first data field of the chart type
+	CrossFilter      string `gorm:"type:varchar(64)" json:"cross_filter,omitempty"`
+	CrossFilterField string `gorm:"type:varchar(64)" json:"cross_filter_field,omitempty"`
+	// RefreshInterval is the number of seconds between live updates of the tile; chart tiles
+	// default to the chart's refresh interval
+	RefreshInterval int `json:"refresh_interval,omitempty"`
+	X               int `json:"x"`
+	Y               int `json:"y"`
+	Width           int `json:"width"`
+	Height          int `json:"height"`
+}
+
+// DashboardFilter is a dashboard-wide filter applied to the tiles mapped to it
+type DashboardFilter struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	DashboardID uint   `gorm:"index" json:"dashboard_id"`
+	Name        string `gorm:"type:varchar(64)" json:"name"` // key of the filter value and tile mappings
+	Label       string `gorm:"type:varchar(128)" json:"label"`
+	Type        string `gorm:"type:varchar(16)" json:"type"` // date_range, select, multi_select, text, number
+	Default     string `gorm:"type:text" json:"default"`     // JSON value used when none is given
+	Options     string `gorm:"type:text" json:"options"`     // JSON array of static options
+	// OptionsQueryID sources the options from the distinct values of OptionsField in a query result
+	OptionsQueryID uint   `json:"options_query_id,omitempty"`
+	OptionsField   string `gorm:"type:varchar(128)" json:"options_field,omitempty"`
+}
+
+// DashboardShare grants a user view or edit access to a dashboard
+type DashboardShare struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	DashboardID uint   `gorm:"uniqueIndex:idx_dashboard_share" json:"dashboard_id"`
+	UserID      uint   `gorm:"uniqueIndex:idx_dashboard_share" json:"user_id"`
+	Permission  string `gorm:"type:varchar(16)" json:"permission"` // view, edit
+}
+
+// EmbedSecret is the secret a user signs embed tokens with. Rotating it replaces KeyID and
+// Secret, which revokes every token signed before.
+type EmbedSecret struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	UserID    uint      `gorm:"uniqueIndex" json:"user_id"`
+	User      User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	KeyID     string    `gorm:"type:varchar(32);uniqueIndex" json:"key_id"` // kid header of the tokens
+	Secret    string    `gorm:"type:varchar(512)" json:"-"`                 // stored encrypted
+	RotatedAt time.Time `json:"rotated_at"`
+}
+
+// EmbedUsage records a request to a public embed link
+type EmbedUsage struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	UserID     uint      `gorm:"index" json:"user_id"` // signer of the token, 0 when unknown
+	KeyID      string    `gorm:"type:varchar(32)" json:"key_id"`
+	Resource   string    `gorm:"type:varchar(16)" json:"resource"` // chart, dashboard
+	ResourceID uint      `json:"resource_id"`
+	TokenID    string    `gorm:"type:varchar(64)" json:"token_id,omitempty"` // jti claim
+	Status     string    `gorm:"type:varchar(16)" json:"status"`             // served, denied, failed
+	Error      string    `gorm:"type:text" json:"error,omitempty"`
+	IPAddress  string    `gorm:"type:varchar(64)" json:"ip_address"`
+	UserAgent  string    `gorm:"type:varchar(255)" json:"user_agent"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+// BoundarySet is an uploaded set of map boundaries. Map and choropleth charts use it by
+// naming it in their mapName config; region keys of their data are matched to the value
+// of KeyProperty of its features.
+type BoundarySet struct {
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	UserID       uint            `gorm:"index" json:"user_id"`
+	User         User            `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Name         string          `gorm:"type:varchar(64);uniqueIndex" json:"name"` // map name clients register
+	Description  string          `gorm:"type:text" json:"description"`
+	Format       string          `gorm:"type:varchar(16)" json:"format"` // uploaded as geojson or topojson
+	KeyProperty  string          `gorm:"type:varchar(64)" json:"key_property"`
+	Matching     string          `gorm:"type:varchar(32)" json:"matching"` // exact, case-insensitive, alias
+	Aliases      string          `gorm:"type:text" json:"aliases"`         // JSON object of alias to feature key, e.g. {"USA": "United States"}
+	Keys         string          `gorm:"type:text" json:"-"`               // JSON array of the feature keys
+	FeatureCount int             `json:"feature_count"`
+	IsPublic     bool            `json:"is_public"` // every user may use
+	Levels       []BoundaryLevel `gorm:"constraint:OnDelete:CASCADE" json:"levels"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+// BoundaryLevel is the GeoJSON of a boundary set simplified for a zoom level
+type BoundaryLevel struct {
+	ID            uint   `gorm:"primaryKey" json:"-"`
+	BoundarySetID uint   `gorm:"uniqueIndex:idx_boundary_level" json:"-"`
+	Zoom          int    `gorm:"uniqueIndex:idx_boundary_level" json:"zoom"`
+	Size          int    `json:"size"` // bytes of GeoJSON
+	Checksum      string `gorm:"type:varchar(64)" json:"checksum"`
+	Data          []byte `json:"-"`
+}
@@ -0,0 +1,104 @@
+package repositories
+
+import (
+	"gobi/internal/models"
+	"gobi/pkg/errors"
+
+	"gorm.io/gorm"
+)
+
+// BoundaryRepositoryImpl implements BoundaryRepository interface
+type BoundaryRepositoryImpl struct {
+	db *gorm.DB
+}
+
+// NewBoundaryRepository creates a new BoundaryRepository instance
+func NewBoundaryRepository(db *gorm.DB) BoundaryRepository {
+	return &BoundaryRepositoryImpl{db: db}
+}
+
+// preloadLevels loads the zoom levels of boundary sets without their GeoJSON
+func preloadLevels(db *gorm.DB) *gorm.DB {
+	return db.Preload("Levels", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "boundary_set_id", "zoom", "size", "checksum").Order("zoom")
+	})
+}
+
+// Create creates a boundary set with its zoom levels
+func (r *BoundaryRepositoryImpl) Create(set *models.BoundarySet) error {
+	if err := r.db.Create(set).Error; err != nil {
+		return errors.WrapError(err, "Could not create boundary set")
+	}
+	return nil
+}
+
+// FindByID finds a boundary set by ID
+func (r *BoundaryRepositoryImpl) FindByID(id uint) (*models.BoundarySet, error) {
+	var set models.BoundarySet
+	if err := preloadLevels(r.db).First(&set, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.WrapError(err, "Could not find boundary set")
+	}
+	return &set, nil
+}
+
+// FindByName finds a boundary set by its map name
+func (r *BoundaryRepositoryImpl) FindByName(name string) (*models.BoundarySet, error) {
+	var set models.BoundarySet
+	if err := preloadLevels(r.db).Where("name = ?", name).First(&set).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.WrapError(err, "Could not find boundary set")
+	}
+	return &set, nil
+}
+
+// FindByUser finds the boundary sets a user owns and the public ones
+func (r *BoundaryRepositoryImpl) FindByUser(userID uint, isAdmin bool) ([]models.BoundarySet, error) {
+	var sets []models.BoundarySet
+	query := preloadLevels(r.db)
+	if !isAdmin {
+		query = query.Where("user_id = ? OR is_public = ?", userID, true)
+	}
+	if err := query.Order("name").Find(&sets).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not find boundary sets")
+	}
+	return sets, nil
+}
+
+// FindLevel finds a zoom level of a boundary set with its GeoJSON
+func (r *BoundaryRepositoryImpl) FindLevel(setID uint, zoom int) (*models.BoundaryLevel, error) {
+	var level models.BoundaryLevel
+	if err := r.db.Where("boundary_set_id = ? AND zoom = ?", setID, zoom).First(&level).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.WrapError(err, "Could not find boundary level")
+	}
+	return &level, nil
+}
+
+// Update saves the settings of a boundary set; its zoom levels are not changed
+func (r *BoundaryRepositoryImpl) Update(set *models.BoundarySet) error {
+	if err := r.db.Omit("Levels").Save(set).Error; err != nil {
+		return errors.WrapError(err, "Could not update boundary set")
+	}
+	return nil
+}
+
+// Delete deletes a boundary set and its zoom levels
+func (r *BoundaryRepositoryImpl) Delete(id uint) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("boundary_set_id = ?", id).Delete(&models.BoundaryLevel{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.BoundarySet{}, id).Error
+	})
+	if err != nil {
+		return errors.WrapError(err, "Could not delete boundary set")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package repositories
+
+import (
+	"gobi/internal/models"
+	"gobi/pkg/errors"
+
+	"gorm.io/gorm"
+)
+
+// DashboardRepositoryImpl implements DashboardRepository interface
+type DashboardRepositoryImpl struct {
+	db *gorm.DB
+}
+
+// NewDashboardRepository creates a new DashboardRepository instance
+func NewDashboardRepository(db *gorm.DB) DashboardRepository {
+	return &DashboardRepositoryImpl{db: db}
+}
+
+// preloadLayout loads tiles in grid order along with the filters and shares
+func preloadLayout(db *gorm.DB) *gorm.DB {
+	return db.Preload("Tiles", func(db *gorm.DB) *gorm.DB {
+		return db.Order("y, x, id")
+	}).Preload("Filters", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Shares")
+}
+
+// Create creates a dashboard with its tiles, filters and shares
+func (r *DashboardRepositoryImpl) Create(dashboard *models.Dashboard) error {
+	if err := r.db.Create(dashboard).Error; err != nil {
+		return errors.WrapError(err, "Could not create dashboard")
+	}
+	return nil
+}
+
+// FindByID finds a dashboard by ID
+func (r *DashboardRepositoryImpl) FindByID(id uint) (*models.Dashboard, error) {
+	var dashboard models.Dashboard
+	if err := preloadLayout(r.db).First(&dashboard, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.WrapError(err, "Could not find dashboard")
+	}
+	return &dashboard, nil
+}
+
+// FindByUser finds the dashboards a user owns, was shared or can see publicly
+func (r *DashboardRepositoryImpl) FindByUser(userID uint, isAdmin bool) ([]models.Dashboard, error) {
+	var dashboards []models.Dashboard
+	query := preloadLayout(r.db).Model(&models.Dashboard{})
+	if !isAdmin {
+		shared := r.db.Model(&models.DashboardShare{}).Select("dashboard_id").Where("user_id = ?", userID)
+		query = query.Where("user_id = ? OR is_public = ? OR id IN (?)", userID, true, shared)
+	}
+	if err := query.Order("id").Find(&dashboards).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not find dashboards")
+	}
+	return dashboards, nil
+}
+
+// Update saves a dashboard and replaces its layout. Tiles keep their IDs when they are
+// resubmitted with them; tiles missing from the dashboard are deleted. Filters and shares
+// are replaced.
+func (r *DashboardRepositoryImpl) Update(dashboard *models.Dashboard) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Tiles", "Filters", "Shares").Save(dashboard).Error; err != nil {
+			return err
+		}
+
+		keep := []uint{0}
+		for i := range dashboard.Tiles {
+			tile := &dashboard.Tiles[i]
+			tile.DashboardID = dashboard.ID
+			if err := tx.Save(tile).Error; err != nil {
+				return err
+			}
+			keep = append(keep, tile.ID)
+		}
+		if err := tx.Where("dashboard_id = ? AND id NOT IN ?", dashboard.ID, keep).Delete(&models.DashboardTile{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("dashboard_id = ?", dashboard.ID).Delete(&models.DashboardFilter{}).Error; err != nil {
+			return err
+		}
+		for i := range dashboard.Filters {
+			filter := &dashboard.Filters[i]
+			filter.ID = 0
+			filter.DashboardID = dashboard.ID
+			if err := tx.Create(filter).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("dashboard_id = ?", dashboard.ID).Delete(&models.DashboardShare{}).Error; err != nil {
+			return err
+		}
+		for i := range dashboard.Shares {
+			share := &dashboard.Shares[i]
+			share.ID = 0
+			share.DashboardID = dashboard.ID
+			if err := tx.Create(share).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.WrapError(err, "Could not update dashboard")
+	}
+	return nil
+}
+
+// Delete deletes a dashboard with its tiles, filters and shares
+func (r *DashboardRepositoryImpl) Delete(id uint) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("dashboard_id = ?", id).Delete(&models.DashboardTile{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("dashboard_id = ?", id).Delete(&models.DashboardFilter{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("dashboard_id = ?", id).Delete(&models.DashboardShare{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Dashboard{}, id).Error
+	})
+	if err != nil {
+		return errors.WrapError(err, "Could not delete dashboard")
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"gobi/internal/models"
+	"gobi/pkg/errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DataProfileRepositoryImpl implements DataProfileRepository interface
+type DataProfileRepositoryImpl struct {
+	db *gorm.DB
+}
+
+// NewDataProfileRepository creates a new DataProfileRepository instance
+func NewDataProfileRepository(db *gorm.DB) DataProfileRepository {
+	return &DataProfileRepositoryImpl{db: db}
+}
+
+// Create creates a new data profile
+func (r *DataProfileRepositoryImpl) Create(profile *models.DataProfile) error {
+	if err := r.db.Create(profile).Error; err != nil {
+		return errors.WrapError(err, "Could not create data profile")
+	}
+	return nil
+}
+
+// FindByID finds a data profile by ID
+func (r *DataProfileRepositoryImpl) FindByID(id uint) (*models.DataProfile, error) {
+	var profile models.DataProfile
+	if err := r.db.First(&profile, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.WrapError(err, "Could not find data profile")
+	}
+	return &profile, nil
+}
+
+// FindByDataSource finds the profiles of a data source, newest first
+func (r *DataProfileRepositoryImpl) FindByDataSource(dataSourceID uint) ([]models.DataProfile, error) {
+	var profiles []models.DataProfile
+	if err := r.db.Where("data_source_id = ?", dataSourceID).Order("created_at DESC").Find(&profiles).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not find data profiles")
+	}
+	return profiles, nil
+}
+
+// Update updates a data profile
+func (r *DataProfileRepositoryImpl) Update(profile *models.DataProfile) error {
+	if err := r.db.Save(profile).Error; err != nil {
+		return errors.WrapError(err, "Could not update data profile")
+	}
+	return nil
+}
+
+// MarkRunning moves a pending profile to running. It reports false when the profile is no
+// longer pending, e.g. because it was failed as stale.
+func (r *DataProfileRepositoryImpl) MarkRunning(profile *models.DataProfile) (bool, error) {
+	result := r.db.Model(&models.DataProfile{}).
+		Where("id = ? AND status = ?", profile.ID, "pending").
+		Updates(map[string]interface{}{"status": "running", "started_at": profile.StartedAt})
+	if result.Error != nil {
+		return false, errors.WrapError(result.Error, "Could not update data profile")
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	profile.Status = "running"
+	return true, nil
+}
+
+// FailStale fails the profiles running since before runningBefore and those pending since
+// before pendingBefore, which no server is working on any more
+func (r *DataProfileRepositoryImpl) FailStale(runningBefore, pendingBefore time.Time, message string) (int64, error) {
+	result := r.db.Model(&models.DataProfile{}).
+		Where("(status = ? AND started_at < ?) OR (status = ? AND created_at < ?)", "running", runningBefore, "pending", pendingBefore).
+		Updates(map[string]interface{}{"status": "failed", "error": message, "completed_at": time.Now()})
+	if result.Error != nil {
+		return 0, errors.WrapError(result.Error, "Could not fail stale data profiles")
+	}
+	return result.RowsAffected, nil
+}
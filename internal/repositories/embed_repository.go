@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"gobi/internal/models"
+	"gobi/pkg/errors"
+
+	"gorm.io/gorm"
+)
+
+// EmbedRepositoryImpl implements EmbedRepository interface
+type EmbedRepositoryImpl struct {
+	db *gorm.DB
+}
+
+// NewEmbedRepository creates a new EmbedRepository instance
+func NewEmbedRepository(db *gorm.DB) EmbedRepository {
+	return &EmbedRepositoryImpl{db: db}
+}
+
+// FindSecretByUser finds the embed secret of a user
+func (r *EmbedRepositoryImpl) FindSecretByUser(userID uint) (*models.EmbedSecret, error) {
+	var secret models.EmbedSecret
+	if err := r.db.Where("user_id = ?", userID).First(&secret).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.WrapError(err, "Could not find embed secret")
+	}
+	return &secret, nil
+}
+
+// FindSecretByKeyID finds an embed secret by the key ID tokens name it with
+func (r *EmbedRepositoryImpl) FindSecretByKeyID(keyID string) (*models.EmbedSecret, error) {
+	var secret models.EmbedSecret
+	if err := r.db.Where("key_id = ?", keyID).First(&secret).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.WrapError(err, "Could not find embed secret")
+	}
+	return &secret, nil
+}
+
+// FindAllSecrets finds every embed secret
+func (r *EmbedRepositoryImpl) FindAllSecrets() ([]models.EmbedSecret, error) {
+	var secrets []models.EmbedSecret
+	if err := r.db.Order("id").Find(&secrets).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not find embed secrets")
+	}
+	return secrets, nil
+}
+
+// SaveSecret creates or updates an embed secret
+func (r *EmbedRepositoryImpl) SaveSecret(secret *models.EmbedSecret) error {
+	if err := r.db.Save(secret).Error; err != nil {
+		return errors.WrapError(err, "Could not save embed secret")
+	}
+	return nil
+}
+
+// CreateUsage records an embed request
+func (r *EmbedRepositoryImpl) CreateUsage(usage *models.EmbedUsage) error {
+	if err := r.db.Create(usage).Error; err != nil {
+		return errors.WrapError(err, "Could not record embed usage")
+	}
+	return nil
+}
+
+// FindUsage finds the most recent embed requests to a user's tokens
+func (r *EmbedRepositoryImpl) FindUsage(userID uint, isAdmin bool, limit int) ([]models.EmbedUsage, error) {
+	var usage []models.EmbedUsage
+	query := r.db
+	if !isAdmin {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&usage).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not list embed usage")
+	}
+	return usage, nil
+}
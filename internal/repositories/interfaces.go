@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"gobi/internal/models"
+	"time"
 )
 
 // QueryRepository defines the interface for query data access
@@ -87,3 +88,43 @@ type WebhookRepository interface {
 	UpdateDelivery(delivery *models.WebhookDelivery) error
 	ListDeliveries(webhookID uint) ([]models.WebhookDelivery, error)
 }
+
+// DataProfileRepository defines the interface for data profile access
+type DataProfileRepository interface {
+	Create(profile *models.DataProfile) error
+	FindByID(id uint) (*models.DataProfile, error)
+	FindByDataSource(dataSourceID uint) ([]models.DataProfile, error)
+	Update(profile *models.DataProfile) error
+	MarkRunning(profile *models.DataProfile) (bool, error)
+	FailStale(runningBefore, pendingBefore time.Time, message string) (int64, error)
+}
+
+// DashboardRepository defines the interface for dashboard data access
+type DashboardRepository interface {
+	Create(dashboard *models.Dashboard) error
+	FindByID(id uint) (*models.Dashboard, error)
+	FindByUser(userID uint, isAdmin bool) ([]models.Dashboard, error)
+	Update(dashboard *models.Dashboard) error
+	Delete(id uint) error
+}
+
+// EmbedRepository defines the interface for embed secret and usage data access
+type EmbedRepository interface {
+	FindSecretByUser(userID uint) (*models.EmbedSecret, error)
+	FindSecretByKeyID(keyID string) (*models.EmbedSecret, error)
+	FindAllSecrets() ([]models.EmbedSecret, error)
+	SaveSecret(secret *models.EmbedSecret) error
+	CreateUsage(usage *models.EmbedUsage) error
+	FindUsage(userID uint, isAdmin bool, limit int) ([]models.EmbedUsage, error)
+}
+
+// BoundaryRepository defines the interface for boundary set data access
+type BoundaryRepository interface {
+	Create(set *models.BoundarySet) error
+	FindByID(id uint) (*models.BoundarySet, error)
+	FindByName(name string) (*models.BoundarySet, error)
+	FindByUser(userID uint, isAdmin bool) ([]models.BoundarySet, error)
+	FindLevel(setID uint, zoom int) (*models.BoundaryLevel, error)
+	Update(set *models.BoundarySet) error
+	Delete(id uint) error
+}
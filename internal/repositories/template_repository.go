@@ -72,12 +72,14 @@ func (r *TemplateRepositoryImpl) GetStats() (map[string]interface{}, error) {
 	var totalQueries int64
 	var totalCharts int64
 	var totalUsers int64
+	var totalDashboards int64
 	var todayQueries int64
 
 	today := time.Now().Format("2006-01-02")
 	r.db.Model(&models.Query{}).Count(&totalQueries)
 	r.db.Model(&models.Chart{}).Count(&totalCharts)
 	r.db.Model(&models.User{}).Count(&totalUsers)
+	r.db.Model(&models.Dashboard{}).Count(&totalDashboards)
 	r.db.Model(&models.Query{}).Where("DATE(created_at) = ?", today).Count(&todayQueries)
 
 	// 查询趋势（最近7天每天的查询数）
@@ -98,11 +100,12 @@ func (r *TemplateRepositoryImpl) GetStats() (map[string]interface{}, error) {
 	r.db.Table("queries").Select("name, exec_count as count").Order("exec_count desc").Limit(5).Scan(&hotQueries)
 
 	return map[string]interface{}{
-		"totalQueries": totalQueries,
-		"totalCharts":  totalCharts,
-		"totalUsers":   totalUsers,
-		"todayQueries": todayQueries,
-		"queryTrends":  queryTrends,
-		"hotQueries":   hotQueries,
+		"totalQueries":    totalQueries,
+		"totalCharts":     totalCharts,
+		"totalUsers":      totalUsers,
+		"totalDashboards": totalDashboards,
+		"todayQueries":    todayQueries,
+		"queryTrends":     queryTrends,
+		"hotQueries":      hotQueries,
 	}, nil
 }
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	errs "errors"
+	"fmt"
+	"gobi/pkg/blob"
+	"gobi/pkg/errors"
+	"gobi/pkg/utils"
+	"io"
+)
+
+// Kinds of stored files, the first segment of their blob keys
+const (
+	blobKindReport   = "reports"
+	blobKindTemplate = "templates"
+)
+
+// putBlob stores data under a new key of a kind and returns the key and checksum
+func putBlob(store BlobStore, kind string, ownerID uint, ext string, data []byte) (string, string, error) {
+	key := blob.NewKey(kind, ownerID, ext)
+	if err := store.Put(context.Background(), key, data); err != nil {
+		return "", "", errors.WrapError(err, "Could not store file")
+	}
+	return key, blob.Checksum(data), nil
+}
+
+// openBlob opens a stored file for streaming. Files stored in the database before the
+// blob store existed have no key until migrate-blobs moves them.
+func openBlob(store BlobStore, key string) (io.ReadCloser, error) {
+	if key == "" {
+		return nil, errors.NewError(errors.ErrCodeInternalServer, "File has not been moved to the blob store; run migrate-blobs", nil)
+	}
+	r, err := store.Get(context.Background(), key)
+	if errs.Is(err, blob.ErrNotFound) {
+		return nil, errors.NewError(errors.ErrCodeInternalServer, "Stored file is missing", err)
+	}
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not read stored file")
+	}
+	return r, nil
+}
+
+// readBlob reads a whole stored file and checks it against its checksum
+func readBlob(store BlobStore, key, checksum string) ([]byte, error) {
+	r, err := openBlob(store, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not read stored file")
+	}
+	if checksum != "" && blob.Checksum(data) != checksum {
+		return nil, errors.NewError(errors.ErrCodeInternalServer, fmt.Sprintf("Stored file %s does not match its checksum", key), nil)
+	}
+	return data, nil
+}
+
+// deleteBlob removes a stored file. Failures only leave an orphaned file and are logged.
+func deleteBlob(store BlobStore, key string) {
+	if key == "" {
+		return
+	}
+	if err := store.Delete(context.Background(), key); err != nil {
+		utils.Logger.Errorf("Failed to delete stored file %s: %v", key, err)
+	}
+}
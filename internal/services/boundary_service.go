@@ -0,0 +1,428 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gobi/config"
+	"gobi/internal/models"
+	"gobi/internal/repositories"
+	"gobi/pkg/charts"
+	"gobi/pkg/errors"
+	"gobi/pkg/geo"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// boundaryNamePattern restricts boundary set names to what clients can register a map as
+var boundaryNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// regionChartTypes are the chart types whose name field holds region keys
+var regionChartTypes = map[string]bool{"map": true, "choropleth": true}
+
+// BoundaryUpload is an uploaded GeoJSON or TopoJSON boundary file and its settings
+type BoundaryUpload struct {
+	Name        string
+	Description string
+	KeyProperty string // feature property holding the region key; defaults to "name"
+	Matching    string // exact, case-insensitive or alias; defaults to exact
+	Aliases     string // JSON object of alias to feature key
+	Object      string // TopoJSON object to convert; empty converts every object
+	IsPublic    bool
+	Data        []byte
+}
+
+// BoundaryFile is the GeoJSON of a boundary set at a zoom level. Content is nil when the
+// client's copy is current.
+type BoundaryFile struct {
+	Content     []byte
+	ETag        string
+	Zoom        int
+	NotModified bool
+}
+
+// RegionMatch reports how the region keys of chart data matched a boundary set
+type RegionMatch struct {
+	Boundary  string   `json:"boundary"`
+	Matching  string   `json:"matching"`
+	Matched   int      `json:"matched"`
+	Unmatched []string `json:"unmatched"` // distinct keys no feature matched
+}
+
+// boundaryMatcher is a boundary set prepared for matching region keys
+type boundaryMatcher struct {
+	set     *models.BoundarySet
+	keys    []string
+	aliases map[string]string
+}
+
+// BoundaryService stores boundary sets for map charts and matches chart data to them
+type BoundaryService struct {
+	boundaryRepo repositories.BoundaryRepository
+	cacheService CacheService
+	cfg          config.GeoConfig
+}
+
+// NewBoundaryService creates a new BoundaryService instance
+func NewBoundaryService(boundaryRepo repositories.BoundaryRepository, cacheService CacheService, cfg config.GeoConfig) *BoundaryService {
+	return &BoundaryService{
+		boundaryRepo: boundaryRepo,
+		cacheService: cacheService,
+		cfg:          cfg,
+	}
+}
+
+// MaxUploadSize returns the size limit of boundary files in bytes
+func (s *BoundaryService) MaxUploadSize() int64 {
+	return s.cfg.MaxUploadSize
+}
+
+// CacheMaxAge returns how long clients may cache boundary GeoJSON
+func (s *BoundaryService) CacheMaxAge() time.Duration {
+	return s.cfg.CacheMaxAge
+}
+
+// UploadBoundarySet parses a boundary file, simplifies it for every zoom level and stores it
+func (s *BoundaryService) UploadBoundarySet(upload *BoundaryUpload, userID uint) (*models.BoundarySet, error) {
+	if int64(len(upload.Data)) > s.cfg.MaxUploadSize {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("Boundary file must not exceed %d bytes", s.cfg.MaxUploadSize), nil)
+	}
+	if upload.KeyProperty == "" {
+		upload.KeyProperty = "name"
+	}
+	if upload.Matching == "" {
+		upload.Matching = geo.MatchExact
+	}
+	var fieldErrors []charts.FieldError
+	if !boundaryNamePattern.MatchString(upload.Name) {
+		fieldErrors = append(fieldErrors, charts.FieldError{Field: "name", Message: "must be 1 to 64 letters, digits, '_', '.' or '-'"})
+	}
+	if len(upload.KeyProperty) > 64 {
+		fieldErrors = append(fieldErrors, charts.FieldError{Field: "key_property", Message: "must be at most 64 characters"})
+	}
+	if len(fieldErrors) > 0 {
+		return nil, boundaryValidationError(fieldErrors)
+	}
+	if _, err := s.boundaryRepo.FindByName(upload.Name); err == nil {
+		return nil, errors.NewConflictError(fmt.Sprintf("A boundary set named %q already exists", upload.Name), nil)
+	} else if err != errors.ErrNotFound {
+		return nil, err
+	}
+
+	fc, format, err := geo.Parse(upload.Data, upload.Object)
+	if err != nil {
+		return nil, errors.NewBadRequestError("Invalid boundary file: "+err.Error(), nil)
+	}
+	keys, missing := fc.Keys(upload.KeyProperty)
+	if len(missing) > 0 {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("%d of %d features have no %q property, e.g. feature %d", len(missing), len(fc.Features), upload.KeyProperty, missing[0]), nil)
+	}
+
+	set := &models.BoundarySet{
+		UserID:       userID,
+		Name:         upload.Name,
+		Description:  upload.Description,
+		Format:       format,
+		KeyProperty:  upload.KeyProperty,
+		Matching:     upload.Matching,
+		Aliases:      upload.Aliases,
+		FeatureCount: len(fc.Features),
+		IsPublic:     upload.IsPublic,
+	}
+	if fieldErrors := validateBoundaryMatching(set, keys); len(fieldErrors) > 0 {
+		return nil, boundaryValidationError(fieldErrors)
+	}
+	encodedKeys, _ := json.Marshal(keys)
+	set.Keys = string(encodedKeys)
+
+	for _, level := range geo.Levels {
+		simplified, err := fc.Simplify(level)
+		if err != nil {
+			return nil, errors.NewBadRequestError("Invalid boundary file: "+err.Error(), nil)
+		}
+		data, err := json.Marshal(simplified)
+		if err != nil {
+			return nil, errors.WrapError(err, "Could not encode boundary set")
+		}
+		sum := sha256.Sum256(data)
+		set.Levels = append(set.Levels, models.BoundaryLevel{
+			Zoom:     level.Zoom,
+			Size:     len(data),
+			Checksum: hex.EncodeToString(sum[:]),
+			Data:     data,
+		})
+	}
+
+	if err := s.boundaryRepo.Create(set); err != nil {
+		return nil, err
+	}
+	s.cacheService.Delete(boundaryMatcherCacheKey(set.Name))
+	return s.boundaryRepo.FindByID(set.ID)
+}
+
+// ListBoundarySets lists the boundary sets a user owns and the public ones
+func (s *BoundaryService) ListBoundarySets(userID uint, isAdmin bool) ([]models.BoundarySet, error) {
+	return s.boundaryRepo.FindByUser(userID, isAdmin)
+}
+
+// GetBoundarySet returns a boundary set the user owns or that is public
+func (s *BoundaryService) GetBoundarySet(setID uint, userID uint, isAdmin bool) (*models.BoundarySet, error) {
+	set, err := s.boundaryRepo.FindByID(setID)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin && set.UserID != userID && !set.IsPublic {
+		return nil, errors.ErrForbidden
+	}
+	return set, nil
+}
+
+// UpdateBoundarySet changes the description, matching, aliases and visibility of a
+// boundary set; only its owner or an admin may
+func (s *BoundaryService) UpdateBoundarySet(setID uint, updates *models.BoundarySet, userID uint, isAdmin bool) (*models.BoundarySet, error) {
+	set, err := s.ownedBoundarySet(setID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	set.Description = updates.Description
+	if updates.Matching != "" {
+		set.Matching = updates.Matching
+	}
+	set.Aliases = updates.Aliases
+	set.IsPublic = updates.IsPublic
+
+	var keys []string
+	json.Unmarshal([]byte(set.Keys), &keys)
+	if fieldErrors := validateBoundaryMatching(set, keys); len(fieldErrors) > 0 {
+		return nil, boundaryValidationError(fieldErrors)
+	}
+	if err := s.boundaryRepo.Update(set); err != nil {
+		return nil, err
+	}
+	s.cacheService.Delete(boundaryMatcherCacheKey(set.Name))
+	return s.boundaryRepo.FindByID(setID)
+}
+
+// DeleteBoundarySet deletes a boundary set; only its owner or an admin may
+func (s *BoundaryService) DeleteBoundarySet(setID uint, userID uint, isAdmin bool) error {
+	set, err := s.ownedBoundarySet(setID, userID, isAdmin)
+	if err != nil {
+		return err
+	}
+	if err := s.boundaryRepo.Delete(set.ID); err != nil {
+		return err
+	}
+	s.cacheService.Delete(boundaryMatcherCacheKey(set.Name))
+	for _, level := range set.Levels {
+		s.cacheService.Delete(boundaryFileCacheKey(level.Checksum))
+	}
+	return nil
+}
+
+// GetBoundaryGeoJSON returns the GeoJSON of a boundary set at a zoom level; a negative zoom
+// returns the uploaded detail. When ifNoneMatch is the ETag of the level the content is
+// not loaded.
+func (s *BoundaryService) GetBoundaryGeoJSON(name string, zoom int, ifNoneMatch string, userID uint, isAdmin bool) (*BoundaryFile, error) {
+	set, err := s.boundaryRepo.FindByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin && set.UserID != userID && !set.IsPublic {
+		return nil, errors.ErrForbidden
+	}
+	if len(set.Levels) == 0 {
+		return nil, errors.ErrNotFound
+	}
+	level := set.Levels[len(set.Levels)-1]
+	if zoom >= 0 {
+		found := false
+		for _, l := range set.Levels {
+			if l.Zoom == zoom {
+				level, found = l, true
+			}
+		}
+		if !found {
+			return nil, errors.NewBadRequestError(fmt.Sprintf("zoom must be between %d and %d", set.Levels[0].Zoom, set.Levels[len(set.Levels)-1].Zoom), nil)
+		}
+	}
+
+	file := &BoundaryFile{ETag: fmt.Sprintf(`"%s-z%d"`, level.Checksum[:16], level.Zoom), Zoom: level.Zoom}
+	if etagMatches(ifNoneMatch, file.ETag) {
+		file.NotModified = true
+		return file, nil
+	}
+	cacheKey := boundaryFileCacheKey(level.Checksum)
+	if cached, found := s.cacheService.Get(cacheKey); found {
+		if content, ok := cached.([]byte); ok {
+			file.Content = content
+			return file, nil
+		}
+	}
+	stored, err := s.boundaryRepo.FindLevel(set.ID, level.Zoom)
+	if err != nil {
+		return nil, err
+	}
+	file.Content = stored.Data
+	s.cacheService.Set(cacheKey, stored.Data, s.cfg.CacheMaxAge)
+	return file, nil
+}
+
+// MatchRegions joins the region keys of map and choropleth chart data to the boundary
+// set named by the chart's mapName, rewriting matched keys to their feature key; rows
+// matching the same feature are summed. Charts naming a map no boundary set provides are
+// left to the client.
+func (s *BoundaryService) MatchRegions(chart *models.Chart, data *ChartData) {
+	if s == nil || data == nil || !regionChartTypes[chart.Type] {
+		return
+	}
+	cfg, _ := charts.ParseConfig(chart.Config)
+	name, _ := cfg["mapName"].(string)
+	if name == "" {
+		name = "world"
+	}
+	matcher := s.boundaryMatcher(name)
+	if matcher == nil {
+		return
+	}
+	mode := matcher.set.Matching
+	if m, ok := cfg["matching"].(string); ok && geo.ValidMatching(m) {
+		mode = m
+	}
+
+	match := geo.NewMatcher(matcher.keys, mode, matcher.aliases)
+	result := &RegionMatch{Boundary: matcher.set.Name, Matching: mode, Unmatched: []string{}}
+	seen := map[string]bool{}
+	features := map[string]map[string]interface{}{}
+	rows := make([]map[string]interface{}, 0, len(data.Rows))
+	for _, row := range data.Rows {
+		key := geo.KeyString(row["name"])
+		feature, ok := match.Match(key)
+		if !ok {
+			if key != "" && !seen[key] {
+				seen[key] = true
+				result.Unmatched = append(result.Unmatched, key)
+			}
+			rows = append(rows, row)
+			continue
+		}
+		result.Matched++
+		if first, ok := features[feature]; ok {
+			a, aok := first["value"].(float64)
+			b, bok := row["value"].(float64)
+			if aok && bok {
+				first["value"] = a + b
+				continue
+			}
+		}
+		row["name"] = feature
+		features[feature] = row
+		rows = append(rows, row)
+	}
+	data.Rows = rows
+	data.RowCount = len(rows)
+	data.Regions = result
+}
+
+// NameProperty returns the feature property a chart's regions are named by, or "" when it
+// is the ECharts default
+func (s *BoundaryService) NameProperty(chart *models.Chart) string {
+	if s == nil || !regionChartTypes[chart.Type] {
+		return ""
+	}
+	cfg, _ := charts.ParseConfig(chart.Config)
+	name, _ := cfg["mapName"].(string)
+	if name == "" {
+		name = "world"
+	}
+	if matcher := s.boundaryMatcher(name); matcher != nil && matcher.set.KeyProperty != "name" {
+		return matcher.set.KeyProperty
+	}
+	return ""
+}
+
+// boundaryMatcher loads the keys and aliases of a boundary set, or nil when there is none
+func (s *BoundaryService) boundaryMatcher(name string) *boundaryMatcher {
+	cacheKey := boundaryMatcherCacheKey(name)
+	if cached, found := s.cacheService.Get(cacheKey); found {
+		if matcher, ok := cached.(*boundaryMatcher); ok {
+			return matcher
+		}
+		return nil
+	}
+	set, err := s.boundaryRepo.FindByName(name)
+	if err != nil {
+		if err == errors.ErrNotFound {
+			s.cacheService.Set(cacheKey, false, time.Minute)
+		}
+		return nil
+	}
+	matcher := &boundaryMatcher{set: set}
+	json.Unmarshal([]byte(set.Keys), &matcher.keys)
+	if strings.TrimSpace(set.Aliases) != "" {
+		json.Unmarshal([]byte(set.Aliases), &matcher.aliases)
+	}
+	s.cacheService.Set(cacheKey, matcher, 5*time.Minute)
+	return matcher
+}
+
+// ownedBoundarySet returns a boundary set its owner or an admin may change
+func (s *BoundaryService) ownedBoundarySet(setID uint, userID uint, isAdmin bool) (*models.BoundarySet, error) {
+	set, err := s.GetBoundarySet(setID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin && set.UserID != userID {
+		return nil, errors.ErrForbidden
+	}
+	return set, nil
+}
+
+// validateBoundaryMatching checks the matching mode and alias table of a boundary set
+func validateBoundaryMatching(set *models.BoundarySet, keys []string) []charts.FieldError {
+	var fieldErrors []charts.FieldError
+	if !geo.ValidMatching(set.Matching) {
+		fieldErrors = append(fieldErrors, charts.FieldError{Field: "matching", Message: "must be exact, case-insensitive or alias"})
+	}
+	if strings.TrimSpace(set.Aliases) == "" {
+		return fieldErrors
+	}
+	var aliases map[string]string
+	if err := json.Unmarshal([]byte(set.Aliases), &aliases); err != nil {
+		return append(fieldErrors, charts.FieldError{Field: "aliases", Message: "must be a JSON object of alias to feature key"})
+	}
+	known := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		known[key] = true
+	}
+	for _, alias := range sortedStringKeys(aliases) {
+		if !known[aliases[alias]] {
+			fieldErrors = append(fieldErrors, charts.FieldError{Field: "aliases." + alias, Message: fmt.Sprintf("%q is not a feature key", aliases[alias])})
+		}
+	}
+	return fieldErrors
+}
+
+func boundaryValidationError(fieldErrors []charts.FieldError) error {
+	return chartValidationError(errors.ErrCodeInvalidRequest, "Invalid boundary set", fieldErrors)
+}
+
+// etagMatches reports whether an If-None-Match header names etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func boundaryMatcherCacheKey(name string) string {
+	return "boundary_matcher_" + name
+}
+
+func boundaryFileCacheKey(checksum string) string {
+	return "boundary_file_" + checksum
+}
@@ -0,0 +1,521 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gobi/internal/models"
+	"gobi/internal/services/infrastructure"
+	"gobi/pkg/charts"
+	"gobi/pkg/database"
+	"gobi/pkg/errors"
+	"sort"
+	"strings"
+)
+
+// drillAggregates are the aggregate functions a drill-down measure may use
+var drillAggregates = map[string]string{"sum": "SUM", "avg": "AVG", "min": "MIN", "max": "MAX", "count": "COUNT"}
+
+// drillDefinition is the drill configuration of a chart
+type drillDefinition struct {
+	// Levels are the dimension columns of the chart query, from the coarsest to the finest
+	Levels []string `json:"levels"`
+	// Field is the chart field showing the level; defaults to the chart type's first data field
+	Field string `json:"field,omitempty"`
+	// Measures maps the columns aggregated at each level to sum, avg, min, max or count;
+	// defaults to the sum of the columns of the chart's number fields
+	Measures map[string]string `json:"measures,omitempty"`
+	// Through is opened by clicking a datum of the last level
+	Through *drillThrough `json:"through,omitempty"`
+}
+
+// drillThrough is the chart or query a drill-through opens
+type drillThrough struct {
+	ChartID uint `json:"chart_id,omitempty"`
+	QueryID uint `json:"query_id,omitempty"`
+	// Parameters maps {{parameters}} of the target SQL to the column whose clicked value fills them
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// DrillStep is a level value chosen on the way down
+type DrillStep struct {
+	Column string      `json:"column"`
+	Value  interface{} `json:"value"`
+}
+
+// DrillRequest is a click on a drillable chart. Level and Path describe the data shown, as
+// returned by the previous drill; without a Datum the data of that level is returned, which
+// is how clients navigate back up.
+type DrillRequest struct {
+	Level   int                    `json:"level"`
+	Path    []DrillStep            `json:"path"`
+	Datum   map[string]interface{} `json:"datum"`   // clicked data point, keyed by chart field or column
+	Through bool                   `json:"through"` // open the drill-through target above the last level
+}
+
+// DrillBreadcrumb is a level on the way down; requesting its Level and Path returns to it
+type DrillBreadcrumb struct {
+	Level int         `json:"level"`
+	Label string      `json:"label"`
+	Path  []DrillStep `json:"path"`
+}
+
+// DrillTarget identifies the chart or query a drill-through opened
+type DrillTarget struct {
+	Type string `json:"type"` // chart, query
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// DrillResult is the data of a drill level or drill-through target
+type DrillResult struct {
+	ChartID         uint                     `json:"chart_id"`
+	Level           int                      `json:"level"`
+	Column          string                   `json:"column,omitempty"` // dimension column of the level
+	Path            []DrillStep              `json:"path"`
+	Breadcrumbs     []DrillBreadcrumb        `json:"breadcrumbs"`
+	CanDrillDown    bool                     `json:"can_drill_down"`
+	CanDrillThrough bool                     `json:"can_drill_through"`
+	Target          *DrillTarget             `json:"target,omitempty"`
+	Data            *ChartData               `json:"data,omitempty"`
+	Rows            []map[string]interface{} `json:"rows,omitempty"` // query targets
+}
+
+// DrillService navigates the drill paths of charts. Drill queries run through the dashboard
+// statement runner, so they are validated, bound and cached like dashboard tiles.
+type DrillService struct {
+	chartService     *ChartService
+	dashboardService *DashboardService
+}
+
+// NewDrillService creates a new DrillService instance
+func NewDrillService(chartService *ChartService, dashboardService *DashboardService) *DrillService {
+	return &DrillService{
+		chartService:     chartService,
+		dashboardService: dashboardService,
+	}
+}
+
+// Drill returns the level below a clicked datum, the drill-through target of a datum of
+// the last level, or, without a datum, the level of the request
+func (s *DrillService) Drill(chartID uint, userID uint, isAdmin bool, req *DrillRequest) (*DrillResult, error) {
+	chart, err := s.chartService.GetChart(chartID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseDrillDefinition(chart.Drill)
+	if err != nil {
+		return nil, errors.NewBadRequestError("Invalid chart drill paths", err)
+	}
+	if len(def.Levels) == 0 && def.Through == nil {
+		return nil, errors.NewBadRequestError("Chart has no drill paths", nil)
+	}
+	t, ok := charts.Lookup(chart.Type)
+	if !ok {
+		return nil, errors.NewBadRequestError("Invalid chart type", nil)
+	}
+	cfg, _ := charts.ParseConfig(chart.Config)
+	mappings, _ := charts.ParseFieldMappings(chart.FieldMappings)
+	columns := t.Columns(mappings, cfg)
+
+	path, err := drillPath(def, req)
+	if err != nil {
+		return nil, err
+	}
+	level := req.Level
+	if req.Datum != nil {
+		column := ""
+		if level < len(def.Levels) {
+			column = def.Levels[level]
+			columns = drillLevelColumns(columns, drillField(def, t), column)
+		}
+		if column != "" {
+			value, err := drillDatumValue(req.Datum, column, columns)
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, DrillStep{Column: column, Value: value})
+		}
+		if req.Through || level+1 >= len(def.Levels) {
+			if def.Through == nil {
+				return nil, errors.NewBadRequestError("Chart has no deeper drill level", nil)
+			}
+			return s.drillThrough(chart, def, level, path, req.Datum, columns, userID, isAdmin)
+		}
+		level++
+	}
+	if len(def.Levels) == 0 {
+		return nil, errors.NewBadRequestError("Drill-through needs the clicked datum", nil)
+	}
+	return s.drillDown(chart, t, def, columns, level, path)
+}
+
+// drillDown loads a level: the chart query grouped by the level's column and filtered by
+// the values chosen above it
+func (s *DrillService) drillDown(chart *models.Chart, t *charts.ChartType, def *drillDefinition, columns map[string]string, level int, path []DrillStep) (*DrillResult, error) {
+	plan := s.dashboardService.newDashboardPlan(nil)
+	query, err := plan.loadQuery(chart.QueryID)
+	if err != nil {
+		return nil, err
+	}
+	dialect, err := database.GetDriver(query.DataSource.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	field := drillField(def, t)
+	column := def.Levels[level]
+	measures := drillMeasures(def, t, columns, field)
+	sqlText, args := buildDrillQuery(dialect, query.SQL, column, measures, path)
+
+	outcome := s.run(plan, query, sqlText, args)
+	if outcome.err != nil {
+		return nil, outcome.err
+	}
+	shaped := *chart
+	encoded, _ := json.Marshal(drillLevelColumns(columns, field, column))
+	shaped.FieldMappings = string(encoded)
+	data, err := shapeChartData(&shaped, outcome.rows, outcome.source)
+	if err != nil {
+		return nil, err
+	}
+	s.chartService.boundaryService.MatchRegions(&shaped, data)
+
+	return &DrillResult{
+		ChartID:         chart.ID,
+		Level:           level,
+		Column:          column,
+		Path:            path,
+		Breadcrumbs:     drillBreadcrumbs(chart, def, path),
+		CanDrillDown:    level+1 < len(def.Levels),
+		CanDrillThrough: def.Through != nil,
+		Data:            data,
+	}, nil
+}
+
+// drillThrough opens the target chart or query with its parameters bound to the values
+// of the path and the clicked datum
+func (s *DrillService) drillThrough(chart *models.Chart, def *drillDefinition, level int, path []DrillStep, datum map[string]interface{}, columns map[string]string, userID uint, isAdmin bool) (*DrillResult, error) {
+	values := map[string]interface{}{}
+	for parameter, column := range def.Through.Parameters {
+		value, err := drillDatumValue(datum, column, columns)
+		if err != nil {
+			for _, step := range path {
+				if step.Column == column {
+					value, err = step.Value, nil
+				}
+			}
+		}
+		if err != nil {
+			return nil, errors.NewBadRequestError(fmt.Sprintf("No value for drill-through parameter %q from column %q", parameter, column), nil)
+		}
+		values[parameter] = value
+	}
+
+	result := &DrillResult{
+		ChartID:     chart.ID,
+		Level:       level,
+		Path:        path,
+		Breadcrumbs: drillBreadcrumbs(chart, def, path),
+	}
+	plan := s.dashboardService.newDashboardPlan(values)
+	queryID := def.Through.QueryID
+	var target *models.Chart
+	if def.Through.ChartID != 0 {
+		var err error
+		if target, err = s.chartService.GetChart(def.Through.ChartID, userID, isAdmin); err != nil {
+			return nil, err
+		}
+		result.Target = &DrillTarget{Type: "chart", ID: target.ID, Name: target.Name}
+		queryID = target.QueryID
+	}
+
+	var rows []map[string]interface{}
+	source := "static"
+	if queryID != 0 {
+		query, err := plan.loadQuery(queryID)
+		if err != nil {
+			return nil, err
+		}
+		if result.Target == nil {
+			if !isAdmin && query.UserID != userID && !query.IsPublic {
+				return nil, errors.ErrForbidden
+			}
+			result.Target = &DrillTarget{Type: "query", ID: query.ID, Name: query.Name}
+		}
+		dialect, err := database.GetDriver(query.DataSource.Type)
+		if err != nil {
+			return nil, err
+		}
+		mappings := make(map[string]filterTarget, len(values))
+		for parameter := range def.Through.Parameters {
+			mappings[parameter] = filterTarget{Parameter: parameter}
+		}
+		sqlText, args := bindTileFilters(dialect, query.SQL, mappings, values, "")
+		outcome := s.run(plan, query, sqlText, args)
+		if outcome.err != nil {
+			return nil, outcome.err
+		}
+		rows, source = outcome.rows, outcome.source
+	} else if target != nil {
+		var err error
+		if rows, err = staticChartRows(target); err != nil {
+			return nil, err
+		}
+	}
+
+	if target == nil {
+		result.Rows = rows
+		if result.Rows == nil {
+			result.Rows = []map[string]interface{}{}
+		}
+		return result, nil
+	}
+	data, err := shapeChartData(target, rows, source)
+	if err != nil {
+		return nil, err
+	}
+	s.chartService.boundaryService.MatchRegions(target, data)
+	result.Data = data
+	return result, nil
+}
+
+// run executes a single drill statement
+func (s *DrillService) run(plan *dashboardPlan, query *models.Query, sqlText string, args []interface{}) queryOutcome {
+	statements := map[string]*dashboardStatement{
+		"drill": {query: query, bound: infrastructure.BoundQuery{SQL: sqlText, Args: args}},
+	}
+	return s.dashboardService.runStatements(context.Background(), statements)["drill"]
+}
+
+// parseDrillDefinition decodes the drill configuration of a chart
+func parseDrillDefinition(drill string) (*drillDefinition, error) {
+	def := &drillDefinition{}
+	if strings.TrimSpace(drill) == "" {
+		return def, nil
+	}
+	if err := json.Unmarshal([]byte(drill), def); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// validateDrill checks the drill configuration of a chart
+func validateDrill(chart *models.Chart, t *charts.ChartType) []charts.FieldError {
+	var fieldErrors []charts.FieldError
+	add := func(field, message string) {
+		fieldErrors = append(fieldErrors, charts.FieldError{Field: field, Message: message})
+	}
+	def, err := parseDrillDefinition(chart.Drill)
+	if err != nil {
+		add("drill", "must be a JSON object")
+		return fieldErrors
+	}
+	if len(def.Levels) > 0 && chart.QueryID == 0 {
+		add("drill.levels", "need a chart bound to a query")
+	}
+	seen := map[string]bool{}
+	for i, level := range def.Levels {
+		path := fmt.Sprintf("drill.levels[%d]", i)
+		if !filterNamePattern.MatchString(level) {
+			add(path, "must be a column name")
+		} else if seen[level] {
+			add(path, "is listed twice")
+		}
+		seen[level] = true
+	}
+	if def.Field != "" && !chartHasField(t, def.Field) {
+		add("drill.field", fmt.Sprintf("is not a field of %s charts", t.Name))
+	}
+	for _, column := range sortedStringKeys(def.Measures) {
+		path := "drill.measures." + column
+		if !filterNamePattern.MatchString(column) {
+			add(path, "must be a column name")
+		} else if _, ok := drillAggregates[def.Measures[column]]; !ok {
+			add(path, "must be sum, avg, min, max or count")
+		}
+	}
+	if len(def.Levels) > 0 && len(def.Measures) == 0 {
+		cfg, _ := charts.ParseConfig(chart.Config)
+		mappings, _ := charts.ParseFieldMappings(chart.FieldMappings)
+		if len(drillMeasures(def, t, t.Columns(mappings, cfg), drillField(def, t))) == 0 {
+			add("drill.measures", fmt.Sprintf("are required as %s charts have no number fields", t.Name))
+		}
+	}
+	if through := def.Through; through != nil {
+		if (through.ChartID == 0) == (through.QueryID == 0) {
+			add("drill.through", "must name either a chart_id or a query_id")
+		}
+		for _, parameter := range sortedStringKeys(through.Parameters) {
+			if !filterNamePattern.MatchString(parameter) || !filterNamePattern.MatchString(through.Parameters[parameter]) {
+				add("drill.through.parameters."+parameter, "must map a parameter name to a column name")
+			}
+		}
+	}
+	return fieldErrors
+}
+
+// drillPath checks that the path of a request leads to its level
+func drillPath(def *drillDefinition, req *DrillRequest) ([]DrillStep, error) {
+	maxLevel := len(def.Levels) - 1
+	if maxLevel < 0 {
+		maxLevel = 0
+	}
+	if req.Level < 0 || req.Level > maxLevel {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("level must be between 0 and %d", maxLevel), nil)
+	}
+	if len(req.Path) != req.Level {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("path must have one step per level above level %d", req.Level), nil)
+	}
+	path := make([]DrillStep, 0, len(req.Path)+1)
+	for i, step := range req.Path {
+		if step.Column != "" && step.Column != def.Levels[i] {
+			return nil, errors.NewBadRequestError(fmt.Sprintf("path step %d must be on column %q", i, def.Levels[i]), nil)
+		}
+		value, err := drillValue(step.Value)
+		if err != nil {
+			return nil, errors.NewBadRequestError(fmt.Sprintf("path step %d: %s", i, err.Error()), nil)
+		}
+		path = append(path, DrillStep{Column: def.Levels[i], Value: value})
+	}
+	return path, nil
+}
+
+// drillDatumValue reads a column of the clicked datum, which clients send either as a
+// result row keyed by column or as a chart row keyed by field
+func drillDatumValue(datum map[string]interface{}, column string, columns map[string]string) (interface{}, error) {
+	raw, ok := datum[column]
+	if !ok {
+		fields := make([]string, 0, len(columns))
+		for field := range columns {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			if columns[field] == column {
+				if raw, ok = datum[field]; ok {
+					break
+				}
+			}
+		}
+	}
+	if !ok {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("datum has no value for column %q", column), nil)
+	}
+	value, err := drillValue(raw)
+	if err != nil {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("datum %s %s", column, err.Error()), nil)
+	}
+	return value, nil
+}
+
+// drillLevelColumns maps the chart fields of a level, the drill field showing its column
+func drillLevelColumns(columns map[string]string, field, column string) map[string]string {
+	levelColumns := make(map[string]string, len(columns)+1)
+	for name, c := range columns {
+		levelColumns[name] = c
+	}
+	if field != "" {
+		levelColumns[field] = column
+	}
+	return levelColumns
+}
+
+// drillValue accepts the scalar values of a level; null selects the NULL group
+func drillValue(raw interface{}) (interface{}, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	return scalarFilterValue(raw)
+}
+
+// drillField returns the chart field showing the drill level
+func drillField(def *drillDefinition, t *charts.ChartType) string {
+	if def.Field != "" || len(t.DataFields) == 0 {
+		return def.Field
+	}
+	return t.DataFields[0].Name
+}
+
+// drillMeasures returns the aggregate of every measure column
+func drillMeasures(def *drillDefinition, t *charts.ChartType, columns map[string]string, field string) map[string]string {
+	if len(def.Measures) > 0 {
+		return def.Measures
+	}
+	measures := map[string]string{}
+	for _, f := range t.DataFields {
+		if f.Type == "number" && f.Name != field && filterNamePattern.MatchString(columns[f.Name]) {
+			measures[columns[f.Name]] = "sum"
+		}
+	}
+	return measures
+}
+
+// buildDrillQuery wraps a query to group it by a level column, aggregating the measures,
+// for the rows matching the values chosen on the path. Values are bound, never spliced.
+func buildDrillQuery(dialect database.Dialect, sqlText, column string, measures map[string]string, path []DrillStep) (string, []interface{}) {
+	sqlText, args := bindTileFilters(dialect, sqlText, nil, nil, "")
+	bind := func(value interface{}) string {
+		args = append(args, value)
+		return dialect.Placeholder(len(args))
+	}
+
+	level := dialect.QuoteIdentifier(column)
+	selects := []string{level}
+	for _, measure := range sortedStringKeys(measures) {
+		quoted := dialect.QuoteIdentifier(measure)
+		selects = append(selects, fmt.Sprintf("%s(%s) AS %s", drillAggregates[measures[measure]], quoted, quoted))
+	}
+	var predicates []string
+	for _, step := range path {
+		quoted := dialect.QuoteIdentifier(step.Column)
+		if step.Value == nil {
+			predicates = append(predicates, quoted+" IS NULL")
+		} else {
+			predicates = append(predicates, quoted+" = "+bind(step.Value))
+		}
+	}
+
+	inner := strings.TrimRight(strings.TrimSpace(sqlText), ";")
+	query := fmt.Sprintf("SELECT %s FROM (%s) AS gobi_drill", strings.Join(selects, ", "), inner)
+	if len(predicates) > 0 {
+		query += " WHERE " + strings.Join(predicates, " AND ")
+	}
+	return query + fmt.Sprintf(" GROUP BY %s ORDER BY %s", level, level), args
+}
+
+// drillBreadcrumbs lists the chart's top level and a crumb per chosen value that opened a
+// level; the value opening a drill-through has no level to return to
+func drillBreadcrumbs(chart *models.Chart, def *drillDefinition, path []DrillStep) []DrillBreadcrumb {
+	crumbs := make([]DrillBreadcrumb, 0, len(path)+1)
+	crumbs = append(crumbs, DrillBreadcrumb{Level: 0, Label: chart.Name, Path: []DrillStep{}})
+	for i, step := range path {
+		if i+1 >= len(def.Levels) {
+			break
+		}
+		label := "(empty)"
+		if step.Value != nil {
+			label = fmt.Sprint(step.Value)
+		}
+		crumbs = append(crumbs, DrillBreadcrumb{Level: i + 1, Label: label, Path: path[:i+1]})
+	}
+	return crumbs
+}
+
+func chartHasField(t *charts.ChartType, name string) bool {
+	for _, f := range t.DataFields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedStringKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,17 +1,37 @@
 package services
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"gobi/internal/models"
 	"gobi/internal/repositories"
+	"gobi/pkg/charts"
 	"gobi/pkg/errors"
+	"gobi/pkg/render"
+	"time"
 )
 
+// ChartData is the data of a chart with rows keyed by the fields of its chart type
+type ChartData struct {
+	ChartID         uint                     `json:"chart_id"`
+	Type            string                   `json:"type"`
+	Fields          map[string]string        `json:"fields"` // field name to source column
+	Rows            []map[string]interface{} `json:"rows"`
+	RowCount        int                      `json:"row_count"`
+	Source          string                   `json:"source"` // static, cache or database
+	RefreshInterval int                      `json:"refresh_interval"`
+	RefreshedAt     time.Time                `json:"refreshed_at"`
+	Regions         *RegionMatch             `json:"regions,omitempty"` // map and choropleth charts drawn on a stored boundary set
+}
+
 // ChartService handles chart-related business logic
 type ChartService struct {
-	chartRepo    repositories.ChartRepository
-	queryService QueryService
-	cacheService CacheService
+	chartRepo       repositories.ChartRepository
+	queryService    QueryService
+	cacheService    CacheService
+	boundaryService *BoundaryService
 }
 
 // NewChartService creates a new ChartService instance
@@ -19,11 +39,13 @@ func NewChartService(
 	chartRepo repositories.ChartRepository,
 	queryService QueryService,
 	cacheService CacheService,
+	boundaryService *BoundaryService,
 ) *ChartService {
 	return &ChartService{
-		chartRepo:    chartRepo,
-		queryService: queryService,
-		cacheService: cacheService,
+		chartRepo:       chartRepo,
+		queryService:    queryService,
+		cacheService:    cacheService,
+		boundaryService: boundaryService,
 	}
 }
 
@@ -31,8 +53,8 @@ func NewChartService(
 func (s *ChartService) CreateChart(chart *models.Chart, userID uint) error {
 	chart.UserID = userID
 
-	// Validate chart type
-	if err := s.validateChartType(chart.Type); err != nil {
+	// Validate chart type, configuration and data
+	if err := s.validateChart(chart); err != nil {
 		return err
 	}
 
@@ -63,8 +85,24 @@ func (s *ChartService) GetChart(chartID uint, userID uint, isAdmin bool) (*model
 	return chart, nil
 }
 
+// ChartUpdate holds the fields of a chart update. Empty Name, Type, Config and Data and a
+// zero QueryID leave them unchanged; the other fields are changed when present, so that
+// auto-refresh can be turned off with a refresh_interval of 0 and field mappings, drill
+// paths and the description can be cleared.
+type ChartUpdate struct {
+	Name            string  `json:"Name"`
+	Type            string  `json:"Type"`
+	QueryID         uint    `json:"QueryID"`
+	Config          string  `json:"Config"`
+	Data            string  `json:"Data"`
+	Description     *string `json:"description"`
+	FieldMappings   *string `json:"field_mappings"`
+	RefreshInterval *int    `json:"refresh_interval"`
+	Drill           *string `json:"drill"`
+}
+
 // UpdateChart updates a chart
-func (s *ChartService) UpdateChart(chartID uint, updates *models.Chart, userID uint, isAdmin bool) (*models.Chart, error) {
+func (s *ChartService) UpdateChart(chartID uint, updates *ChartUpdate, userID uint, isAdmin bool) (*models.Chart, error) {
 	chart, err := s.chartRepo.FindByID(chartID)
 	if err != nil {
 		return nil, errors.ErrNotFound
@@ -73,10 +111,6 @@ func (s *ChartService) UpdateChart(chartID uint, updates *models.Chart, userID u
 		return nil, errors.ErrForbidden
 	}
 	if updates.Type != "" {
-		// Validate chart type
-		if err := s.validateChartType(updates.Type); err != nil {
-			return nil, err
-		}
 		chart.Type = updates.Type
 	}
 	if updates.Name != "" {
@@ -91,12 +125,25 @@ func (s *ChartService) UpdateChart(chartID uint, updates *models.Chart, userID u
 	if updates.Data != "" {
 		chart.Data = updates.Data
 	}
-	if updates.Description != "" {
-		chart.Description = updates.Description
+	if updates.Description != nil {
+		chart.Description = *updates.Description
+	}
+	if updates.FieldMappings != nil {
+		chart.FieldMappings = *updates.FieldMappings
+	}
+	if updates.RefreshInterval != nil {
+		chart.RefreshInterval = *updates.RefreshInterval
+	}
+	if updates.Drill != nil {
+		chart.Drill = *updates.Drill
+	}
+	if err := s.validateChart(chart); err != nil {
+		return nil, err
 	}
 	if err := s.chartRepo.Update(chart); err != nil {
 		return nil, errors.WrapError(err, "Could not update chart")
 	}
+	s.cacheService.Delete(chartDataCacheKey(chart.ID))
 	return chart, nil
 }
 
@@ -112,74 +159,306 @@ func (s *ChartService) DeleteChart(chartID uint, userID uint, isAdmin bool) erro
 	if err := s.chartRepo.Delete(chartID); err != nil {
 		return errors.WrapError(err, "Could not delete chart")
 	}
+	s.cacheService.Delete(chartDataCacheKey(chartID))
 	return nil
 }
 
-// validateChartType validates if the chart type is supported
-func (s *ChartService) validateChartType(chartType string) error {
-	validChartTypes := map[string]bool{
-		"bar":               true,
-		"line":              true,
-		"pie":               true,
-		"scatter":           true,
-		"radar":             true,
-		"heatmap":           true,
-		"gauge":             true,
-		"funnel":            true,
-		"area":              true,
-		"3d-bar":            true,
-		"3d-scatter":        true,
-		"3d-surface":        true,
-		"3d-bubble":         true,
-		"treemap":           true,
-		"sunburst":          true,
-		"tree":              true,
-		"boxplot":           true,
-		"candlestick":       true,
-		"wordcloud":         true,
-		"graph":             true,
-		"waterfall":         true,
-		"polar":             true,
-		"gantt":             true,
-		"rose":              true,
-		"geo":               true,
-		"map":               true,
-		"choropleth":        true,
-		"progress":          true,
-		"circular-progress": true,
-	}
-
-	if !validChartTypes[chartType] {
-		return errors.NewBadRequestError("Invalid chart type", nil)
+// GetChartData returns the chart's data mapped to its chart type. Charts bound to a query
+// read through the query cache; with a refresh interval, data older than the interval is
+// re-executed against the database.
+func (s *ChartService) GetChartData(chartID uint, userID uint, isAdmin bool) (*ChartData, error) {
+	chart, err := s.GetChart(chartID, userID, isAdmin)
+	if err != nil {
+		return nil, err
 	}
+	return s.chartData(chart, userID, isAdmin)
+}
 
-	return nil
+// chartData returns cached data while it is within the chart's refresh interval and
+// loads it otherwise
+func (s *ChartService) chartData(chart *models.Chart, userID uint, isAdmin bool) (*ChartData, error) {
+	if chart.QueryID != 0 && chart.RefreshInterval > 0 {
+		if cached, found := s.cacheService.Get(chartDataCacheKey(chart.ID)); found {
+			if data, ok := cached.(*ChartData); ok && time.Since(data.RefreshedAt) < time.Duration(chart.RefreshInterval)*time.Second {
+				result := *data
+				result.Source = "cache"
+				return &result, nil
+			}
+		}
+	}
+
+	return s.loadChartData(chart, userID, isAdmin, chart.RefreshInterval > 0)
 }
 
-// ValidateChartConfig validates chart configuration JSON
-func (s *ChartService) ValidateChartConfig(config string) error {
-	if config == "" {
-		return nil // Empty config is valid
+// RefreshChartData re-executes the chart's query, bypassing every cache
+func (s *ChartService) RefreshChartData(chartID uint, userID uint, isAdmin bool) (*ChartData, error) {
+	chart, err := s.GetChart(chartID, userID, isAdmin)
+	if err != nil {
+		return nil, err
 	}
+	if chart.QueryID == 0 {
+		return nil, errors.NewBadRequestError("Chart is not bound to a query", nil)
+	}
+	s.cacheService.Delete(chartDataCacheKey(chart.ID))
+	return s.loadChartData(chart, userID, isAdmin, true)
+}
 
-	var configMap map[string]interface{}
-	if err := json.Unmarshal([]byte(config), &configMap); err != nil {
-		return errors.NewBadRequestError("Invalid chart configuration JSON", err)
+// loadChartData reads the chart's rows from its query, or its static data, and shapes them
+func (s *ChartService) loadChartData(chart *models.Chart, userID uint, isAdmin bool, fresh bool) (*ChartData, error) {
+	var rows []map[string]interface{}
+	var err error
+	source := "static"
+	if chart.QueryID == 0 {
+		if rows, err = staticChartRows(chart); err != nil {
+			return nil, err
+		}
+	} else {
+		var result *ExecuteQueryResult
+		if fresh {
+			result, err = s.queryService.RefreshQuery(chart.QueryID, userID, isAdmin)
+		} else {
+			result, err = s.queryService.ExecuteQuery(chart.QueryID, userID, isAdmin)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = result.Data
+		source = result.Source
 	}
 
-	return nil
+	data, err := shapeChartData(chart, rows, source)
+	if err != nil {
+		return nil, err
+	}
+	s.boundaryService.MatchRegions(chart, data)
+	if chart.QueryID != 0 && chart.RefreshInterval > 0 {
+		s.cacheService.Set(chartDataCacheKey(chart.ID), data, time.Duration(chart.RefreshInterval)*time.Second)
+	}
+	return data, nil
 }
 
-// ValidateChartData validates chart data JSON
-func (s *ChartService) ValidateChartData(data string) error {
-	if data == "" {
-		return nil // Empty data is valid
+// staticChartRows decodes the inline data of a chart that is not bound to a query
+func staticChartRows(chart *models.Chart) ([]map[string]interface{}, error) {
+	var rows []map[string]interface{}
+	if chart.Data != "" {
+		if err := json.Unmarshal([]byte(chart.Data), &rows); err != nil {
+			return nil, errors.NewErrorWithSeverity(errors.ErrCodeInvalidChartData, "Invalid chart data", err, errors.SeverityLow, errors.CategoryValidation)
+		}
+	}
+	return rows, nil
+}
+
+// shapeChartData maps result rows to the fields of the chart's type
+func shapeChartData(chart *models.Chart, rows []map[string]interface{}, source string) (*ChartData, error) {
+	t, ok := charts.Lookup(chart.Type)
+	if !ok {
+		return nil, errors.NewBadRequestError("Invalid chart type", nil)
+	}
+	cfg, err := charts.ParseConfig(chart.Config)
+	if err != nil {
+		return nil, errors.NewErrorWithSeverity(errors.ErrCodeInvalidChartConfig, "Invalid chart configuration", err, errors.SeverityLow, errors.CategoryValidation)
+	}
+	mappings, err := charts.ParseFieldMappings(chart.FieldMappings)
+	if err != nil {
+		return nil, errors.NewErrorWithSeverity(errors.ErrCodeInvalidChartConfig, "Invalid chart field mappings", err, errors.SeverityLow, errors.CategoryValidation)
 	}
+	columns := t.Columns(mappings, cfg)
 
-	var dataArray []map[string]interface{}
-	if err := json.Unmarshal([]byte(data), &dataArray); err != nil {
-		return errors.NewBadRequestError("Invalid chart data JSON", err)
+	shaped, fieldErrors := t.Shape(columns, rows)
+	if len(fieldErrors) > 0 {
+		return nil, chartValidationError(errors.ErrCodeInvalidChartData, "Chart field mappings do not match the query result", fieldErrors)
 	}
+	return &ChartData{
+		ChartID:         chart.ID,
+		Type:            chart.Type,
+		Fields:          columns,
+		Rows:            shaped,
+		RowCount:        len(shaped),
+		Source:          source,
+		RefreshInterval: chart.RefreshInterval,
+		RefreshedAt:     time.Now(),
+	}, nil
+}
+
+// chartDataCacheKey returns the cache key of a chart's shaped data
+func chartDataCacheKey(chartID uint) string {
+	return fmt.Sprintf("chart_data_%d", chartID)
+}
+
+// GetEChartsOption builds a complete ECharts option from the chart's definition and its
+// current data
+func (s *ChartService) GetEChartsOption(chartID uint, userID uint, isAdmin bool) (charts.Option, error) {
+	chart, err := s.GetChart(chartID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := charts.Lookup(chart.Type)
+	if !ok {
+		return nil, errors.NewBadRequestError("Invalid chart type", nil)
+	}
+	cfg, err := charts.ParseConfig(chart.Config)
+	if err != nil {
+		return nil, errors.NewErrorWithSeverity(errors.ErrCodeInvalidChartConfig, "Invalid chart configuration", err, errors.SeverityLow, errors.CategoryValidation)
+	}
+	if _, ok := cfg["title"]; !ok {
+		cfg["title"] = chart.Name
+	}
+	if _, ok := cfg["nameProperty"]; !ok {
+		if property := s.boundaryService.NameProperty(chart); property != "" {
+			cfg["nameProperty"] = property
+		}
+	}
+	data, err := s.chartData(chart, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	return t.EChartsOption(cfg, data.Rows), nil
+}
+
+// RenderedChart is a chart drawn as an image
+type RenderedChart struct {
+	Content     []byte
+	ContentType string
+	ETag        string
+}
 
+// renderCacheEntry keeps the last image rendered for a chart, format and size
+type renderCacheEntry struct {
+	fingerprint string
+	image       *RenderedChart
+}
+
+// RenderChart draws a chart with its current data as SVG or PNG. A zero width or height
+// uses the default size. The image is cached until the chart or its data changes.
+func (s *ChartService) RenderChart(chartID uint, userID uint, isAdmin bool, format string, width, height int) (*RenderedChart, error) {
+	imageFormat, err := render.ParseFormat(format)
+	if err != nil {
+		return nil, errors.NewBadRequestError("Unsupported image format", err)
+	}
+	if width == 0 {
+		width = render.DefaultWidth
+	}
+	if height == 0 {
+		height = render.DefaultHeight
+	}
+	if !render.ValidSize(width, height) {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("Image size must be between %d and %d pixels and at most %d pixels in total",
+			render.MinSize, render.MaxSize, render.MaxPixels), nil)
+	}
+
+	chart, err := s.GetChart(chartID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if !render.Supported(chart.Type) {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("Rendering is not supported for %s charts", chart.Type), nil)
+	}
+	data, err := s.chartData(chart, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := renderFingerprint(chart, data.Rows)
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not render chart")
+	}
+	cacheKey := fmt.Sprintf("chart_render_%d_%s_%dx%d", chart.ID, imageFormat, width, height)
+	if cached, found := s.cacheService.Get(cacheKey); found {
+		if entry, ok := cached.(*renderCacheEntry); ok && entry.fingerprint == fingerprint {
+			return entry.image, nil
+		}
+	}
+
+	spec, err := render.NewSpec(chart, data.Rows, width, height)
+	if err != nil {
+		return nil, errors.NewErrorWithSeverity(errors.ErrCodeInvalidChartConfig, "Could not render chart", err, errors.SeverityLow, errors.CategoryValidation)
+	}
+	content, err := render.Render(spec, imageFormat)
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not render chart")
+	}
+	image := &RenderedChart{
+		Content:     content,
+		ContentType: render.ContentType(imageFormat),
+		ETag:        fmt.Sprintf(`"%s-%s-%dx%d"`, fingerprint[:16], imageFormat, width, height),
+	}
+	s.cacheService.Set(cacheKey, &renderCacheEntry{fingerprint: fingerprint, image: image}, time.Hour)
+	return image, nil
+}
+
+// renderFingerprint hashes what a rendered image depends on
+func renderFingerprint(chart *models.Chart, rows []map[string]interface{}) (string, error) {
+	encoded, err := json.Marshal(rows)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00", chart.UpdatedAt.UTC().Format(time.RFC3339Nano), chart.Name, chart.Type, chart.Config)
+	h.Write(encoded)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ListChartTypes returns the registered chart types
+func (s *ChartService) ListChartTypes() []*charts.ChartType {
+	return charts.Types()
+}
+
+// validateChart checks the type, configuration, field mappings, inline data and drill
+// paths of a chart
+func (s *ChartService) validateChart(chart *models.Chart) error {
+	t, ok := charts.Lookup(chart.Type)
+	if !ok {
+		return errors.NewBadRequestError("Invalid chart type", nil)
+	}
+	if chart.RefreshInterval < 0 {
+		return errors.NewBadRequestError("Refresh interval must not be negative", nil)
+	}
+	if fieldErrors := t.ValidateConfig(chart.Config); len(fieldErrors) > 0 {
+		return chartValidationError(errors.ErrCodeInvalidChartConfig, "Invalid chart configuration", fieldErrors)
+	}
+	if fieldErrors := t.ValidateFieldMappings(chart.FieldMappings); len(fieldErrors) > 0 {
+		return chartValidationError(errors.ErrCodeInvalidChartConfig, "Invalid chart field mappings", fieldErrors)
+	}
+	if fieldErrors := t.ValidateData(chart.FieldMappings, chart.Config, chart.Data); len(fieldErrors) > 0 {
+		return chartValidationError(errors.ErrCodeInvalidChartData, "Invalid chart data", fieldErrors)
+	}
+	if fieldErrors := validateDrill(chart, t); len(fieldErrors) > 0 {
+		return chartValidationError(errors.ErrCodeInvalidChartConfig, "Invalid chart drill paths", fieldErrors)
+	}
+	return nil
+}
+
+// ValidateChartConfig validates a chart configuration against the schema of its type
+func (s *ChartService) ValidateChartConfig(chartType, config string) error {
+	t, ok := charts.Lookup(chartType)
+	if !ok {
+		return errors.NewBadRequestError("Invalid chart type", nil)
+	}
+	if fieldErrors := t.ValidateConfig(config); len(fieldErrors) > 0 {
+		return chartValidationError(errors.ErrCodeInvalidChartConfig, "Invalid chart configuration", fieldErrors)
+	}
 	return nil
 }
+
+// ValidateChartData validates that inline chart data has the shape its type requires
+func (s *ChartService) ValidateChartData(chartType, config, data string) error {
+	t, ok := charts.Lookup(chartType)
+	if !ok {
+		return errors.NewBadRequestError("Invalid chart type", nil)
+	}
+	if fieldErrors := t.ValidateData("", config, data); len(fieldErrors) > 0 {
+		return chartValidationError(errors.ErrCodeInvalidChartData, "Invalid chart data", fieldErrors)
+	}
+	return nil
+}
+
+// chartValidationError builds a validation error listing the offending fields
+func chartValidationError(code errors.ErrorCode, message string, fieldErrors []charts.FieldError) error {
+	return errors.NewErrorWithSeverity(
+		code,
+		message,
+		nil,
+		errors.SeverityLow,
+		errors.CategoryValidation,
+	).WithDetails(map[string]interface{}{"fields": fieldErrors})
+}
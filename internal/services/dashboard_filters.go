@@ -0,0 +1,410 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"gobi/internal/models"
+	"gobi/pkg/charts"
+	"gobi/pkg/database"
+	"gobi/pkg/errors"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// dashboardFilterTypes lists the supported filter types
+	dashboardFilterTypes = map[string]bool{"date_range": true, "select": true, "multi_select": true, "text": true, "number": true}
+	// filterNamePattern restricts filter names, query parameters and filtered columns
+	filterNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// sqlParameterPattern matches {{name}}, {{name.from}} and {{name.to}} in query SQL
+	sqlParameterPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)(?:\.(from|to))?\s*\}\}`)
+	// filterDateLayouts are the accepted formats of date_range bounds
+	filterDateLayouts = []string{"2006-01-02", "2006-01-02 15:04:05", time.RFC3339}
+)
+
+// filterTarget is what a dashboard filter applies to in a tile's query: a {{parameter}} of
+// the SQL, or a column of its result
+type filterTarget struct {
+	Parameter string `json:"parameter,omitempty"`
+	Column    string `json:"column,omitempty"`
+}
+
+// dateRange is the value of a date_range filter; either bound may be left open
+type dateRange struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// parseFilterMappings decodes the filter mappings of a tile
+func parseFilterMappings(mappings string) (map[string]filterTarget, error) {
+	result := map[string]filterTarget{}
+	if strings.TrimSpace(mappings) == "" {
+		return result, nil
+	}
+	if err := json.Unmarshal([]byte(mappings), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parseFilterValue checks a decoded JSON value against a filter type and returns it in
+// canonical form. A nil result leaves the filter unset.
+func parseFilterValue(filterType string, raw interface{}) (interface{}, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	switch filterType {
+	case "select", "text":
+		value, err := scalarFilterValue(raw)
+		if value == "" {
+			return nil, err
+		}
+		return value, err
+	case "number":
+		switch v := raw.(type) {
+		case float64:
+			return bindableNumber(v), nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("must be a number")
+			}
+			return bindableNumber(f), nil
+		}
+		return nil, fmt.Errorf("must be a number")
+	case "multi_select":
+		items, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("must be an array")
+		}
+		if len(items) == 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			value, err := scalarFilterValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case "date_range":
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("must be an object with from and to dates")
+		}
+		var r dateRange
+		for _, bound := range []struct {
+			key   string
+			value *string
+		}{{"from", &r.From}, {"to", &r.To}} {
+			value, ok := object[bound.key]
+			if !ok || value == nil {
+				continue
+			}
+			text, ok := value.(string)
+			if !ok || !isFilterDate(text) {
+				return nil, fmt.Errorf("%s must be a date such as 2006-01-02", bound.key)
+			}
+			*bound.value = text
+		}
+		if r.From == "" && r.To == "" {
+			return nil, nil
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unsupported filter type %q", filterType)
+}
+
+// scalarFilterValue accepts strings and numbers; booleans are bound as strings
+func scalarFilterValue(raw interface{}) (interface{}, error) {
+	switch v := raw.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return bindableNumber(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return nil, fmt.Errorf("must be a string or number")
+}
+
+// bindableNumber binds whole numbers as integers so they compare exactly with integer columns
+func bindableNumber(f float64) interface{} {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f)
+	}
+	return f
+}
+
+func isFilterDate(text string) bool {
+	for _, layout := range filterDateLayouts {
+		if _, err := time.Parse(layout, text); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveFilterValues merges the requested filter values over the filters' defaults. A
+// filter given as null is cleared even when it has a default.
+func resolveFilterValues(filters []models.DashboardFilter, requested map[string]interface{}) (map[string]interface{}, error) {
+	var fieldErrors []charts.FieldError
+	known := make(map[string]bool, len(filters))
+	values := make(map[string]interface{}, len(filters))
+	for _, filter := range filters {
+		known[filter.Name] = true
+		raw, ok := requested[filter.Name]
+		if !ok {
+			if value, err := filterDefault(filter); err == nil && value != nil {
+				values[filter.Name] = value
+			}
+			continue
+		}
+		value, err := parseFilterValue(filter.Type, raw)
+		if err != nil {
+			fieldErrors = append(fieldErrors, charts.FieldError{Field: "filters." + filter.Name, Message: err.Error()})
+		} else if value != nil {
+			values[filter.Name] = value
+		}
+	}
+
+	names := make([]string, 0, len(requested))
+	for name := range requested {
+		if !known[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fieldErrors = append(fieldErrors, charts.FieldError{Field: "filters." + name, Message: "is not a filter of this dashboard"})
+	}
+
+	if len(fieldErrors) > 0 {
+		return nil, errors.NewBadRequestError("Invalid filter values", nil).WithDetails(map[string]interface{}{"fields": fieldErrors})
+	}
+	return values, nil
+}
+
+// filterDefault decodes the default value of a filter
+func filterDefault(filter models.DashboardFilter) (interface{}, error) {
+	if strings.TrimSpace(filter.Default) == "" {
+		return nil, nil
+	}
+	var raw interface{}
+	if err := json.Unmarshal([]byte(filter.Default), &raw); err != nil {
+		return nil, fmt.Errorf("must be a JSON value")
+	}
+	return parseFilterValue(filter.Type, raw)
+}
+
+// filterStaticOptions decodes the static options of a filter
+func filterStaticOptions(filter models.DashboardFilter) ([]interface{}, error) {
+	options := []interface{}{}
+	if strings.TrimSpace(filter.Options) == "" {
+		return options, nil
+	}
+	if err := json.Unmarshal([]byte(filter.Options), &options); err != nil {
+		return nil, fmt.Errorf("must be a JSON array")
+	}
+	return options, nil
+}
+
+// filterApplies reports whether a mapping restricts the result of a query: a column
+// filter always does, a parameter only when the SQL uses it
+func filterApplies(sqlText string, target filterTarget) bool {
+	if target.Column != "" {
+		return true
+	}
+	if target.Parameter == "" {
+		return false
+	}
+	for _, match := range sqlParameterPattern.FindAllStringSubmatch(sqlText, -1) {
+		if match[1] == target.Parameter {
+			return true
+		}
+	}
+	return false
+}
+
+// bindTileFilters applies the filter values to a tile's query. Mapped {{parameters}} are
+// replaced by bind placeholders, with NULL bound for filters without a value, and column
+// filters wrap the query in a WHERE clause. An empty list binds a single NULL, so IN (...)
+// stays valid SQL and matches nothing. Values are always bound, never spliced into
+// the SQL. The filter named by skip is left unapplied, so a chart is not narrowed by the
+// cross-filter it sets itself.
+func bindTileFilters(dialect database.Dialect, sqlText string, mappings map[string]filterTarget, values map[string]interface{}, skip string) (string, []interface{}) {
+	var args []interface{}
+	bind := func(value interface{}) string {
+		args = append(args, value)
+		return dialect.Placeholder(len(args))
+	}
+	bindList := func(values []interface{}) string {
+		if len(values) == 0 {
+			return bind(nil)
+		}
+		placeholders := make([]string, len(values))
+		for i, item := range values {
+			placeholders[i] = bind(item)
+		}
+		return strings.Join(placeholders, ", ")
+	}
+
+	parameters := map[string]interface{}{}
+	var columns []string
+	columnFilters := map[string]string{}
+	for name, target := range mappings {
+		if name == skip {
+			continue
+		}
+		if target.Parameter != "" {
+			parameters[target.Parameter] = values[name]
+		} else if target.Column != "" && values[name] != nil {
+			columns = append(columns, name)
+			columnFilters[name] = target.Column
+		}
+	}
+
+	sqlText = sqlParameterPattern.ReplaceAllStringFunc(sqlText, func(match string) string {
+		parts := sqlParameterPattern.FindStringSubmatch(match)
+		switch value := parameters[parts[1]].(type) {
+		case dateRange:
+			if parts[2] == "from" && value.From != "" {
+				return bind(value.From)
+			}
+			if parts[2] == "to" && value.To != "" {
+				return bind(value.To)
+			}
+		case []interface{}:
+			if parts[2] == "" {
+				return bindList(value)
+			}
+		case nil:
+		default:
+			if parts[2] == "" {
+				return bind(value)
+			}
+		}
+		return bind(nil)
+	})
+
+	if len(columns) == 0 {
+		return sqlText, args
+	}
+	sort.Strings(columns)
+	var predicates []string
+	for _, name := range columns {
+		column := dialect.QuoteIdentifier(columnFilters[name])
+		switch value := values[name].(type) {
+		case dateRange:
+			if value.From != "" {
+				predicates = append(predicates, column+" >= "+bind(value.From))
+			}
+			if value.To != "" {
+				predicates = append(predicates, column+" <= "+bind(value.To))
+			}
+		case []interface{}:
+			predicates = append(predicates, column+" IN ("+bindList(value)+")")
+		default:
+			predicates = append(predicates, column+" = "+bind(value))
+		}
+	}
+	inner := strings.TrimRight(strings.TrimSpace(sqlText), ";")
+	return fmt.Sprintf("SELECT * FROM (%s) AS gobi_filtered WHERE %s", inner, strings.Join(predicates, " AND ")), args
+}
+
+// validateFilters checks the filter definitions of a dashboard
+func (s *DashboardService) validateFilters(dashboard *models.Dashboard, userID uint, isAdmin bool, add func(field, message string)) map[string]models.DashboardFilter {
+	filters := make(map[string]models.DashboardFilter, len(dashboard.Filters))
+	for i, filter := range dashboard.Filters {
+		path := fmt.Sprintf("filters[%d]", i)
+		if !filterNamePattern.MatchString(filter.Name) {
+			add(path+".name", "must be a letter or underscore followed by letters, digits or underscores")
+		} else if _, ok := filters[filter.Name]; ok {
+			add(path+".name", "is used by another filter")
+		}
+		filters[filter.Name] = filter
+
+		if !dashboardFilterTypes[filter.Type] {
+			add(path+".type", "must be one of date_range, select, multi_select, text, number")
+			continue
+		}
+		if _, err := filterDefault(filter); err != nil {
+			add(path+".default", err.Error())
+		}
+		if _, err := filterStaticOptions(filter); err != nil {
+			add(path+".options", err.Error())
+		}
+		if filter.OptionsQueryID != 0 {
+			if msg := s.checkQueryAccess(filter.OptionsQueryID, dashboard, userID, isAdmin); msg != "" {
+				add(path+".options_query_id", msg)
+			}
+			if filter.OptionsField == "" {
+				add(path+".options_field", "is required when options come from a query")
+			}
+		}
+	}
+	return filters
+}
+
+// validateTileFilters checks the filter mappings and cross-filter of a tile
+func validateTileFilters(tile *models.DashboardTile, chartType *charts.ChartType, filters map[string]models.DashboardFilter, path string, add func(field, message string)) {
+	mappings, err := parseFilterMappings(tile.FilterMappings)
+	if err != nil {
+		add(path+".filter_mappings", "must be a JSON object of filter names to {parameter} or {column} targets")
+	} else if len(mappings) > 0 && tile.Type == "text" {
+		add(path+".filter_mappings", "cannot be set on text tiles")
+	} else {
+		names := make([]string, 0, len(mappings))
+		for name := range mappings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			target := mappings[name]
+			mappingPath := path + ".filter_mappings." + name
+			switch {
+			case filters[name].Name == "":
+				add(mappingPath, "is not a filter of this dashboard")
+			case (target.Parameter == "") == (target.Column == ""):
+				add(mappingPath, "must set exactly one of parameter or column")
+			case target.Parameter != "" && !filterNamePattern.MatchString(target.Parameter):
+				add(mappingPath+".parameter", "must be a letter or underscore followed by letters, digits or underscores")
+			case target.Column != "" && !filterNamePattern.MatchString(target.Column):
+				add(mappingPath+".column", "must be a letter or underscore followed by letters, digits or underscores")
+			}
+		}
+	}
+
+	if tile.CrossFilter == "" {
+		return
+	}
+	filter := filters[tile.CrossFilter]
+	switch {
+	case tile.Type != "chart":
+		add(path+".cross_filter", "can only be set on chart tiles")
+	case filter.Name == "":
+		add(path+".cross_filter", "is not a filter of this dashboard")
+	case filter.Type == "date_range":
+		add(path+".cross_filter", "cannot set a date_range filter")
+	case chartType == nil:
+	case tile.CrossFilterField == "":
+		// Default to the field that identifies a data point: x for bars, name for pies...
+		if len(chartType.DataFields) > 0 {
+			tile.CrossFilterField = chartType.DataFields[0].Name
+		}
+	default:
+		found := false
+		for _, field := range chartType.DataFields {
+			found = found || field.Name == tile.CrossFilterField
+		}
+		if !found {
+			add(path+".cross_filter_field", fmt.Sprintf("is not a field of %s charts", chartType.Name))
+		}
+	}
+}
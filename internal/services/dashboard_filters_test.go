@@ -0,0 +1,64 @@
+package services
+
+import (
+	"gobi/pkg/database"
+	"strings"
+	"testing"
+)
+
+func TestBindTileFiltersEmptyListMatchesNothing(t *testing.T) {
+	db := newTestDB(t)
+	for _, stmt := range []string{
+		"CREATE TABLE sales (region TEXT, amount INTEGER)",
+		"INSERT INTO sales VALUES ('north', 10), ('south', 20)",
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	dialect := &database.SQLiteDriver{}
+
+	tests := []struct {
+		name     string
+		sql      string
+		mappings map[string]filterTarget
+		values   map[string]interface{}
+		want     int
+	}{
+		{
+			name:     "column filter",
+			sql:      "SELECT region, amount FROM sales",
+			mappings: map[string]filterTarget{"region": {Column: "region"}},
+			values:   map[string]interface{}{"region": []interface{}{}},
+		},
+		{
+			name:     "parameter",
+			sql:      "SELECT region, amount FROM sales WHERE region IN ({{region}})",
+			mappings: map[string]filterTarget{"region": {Parameter: "region"}},
+			values:   map[string]interface{}{"region": []interface{}{}},
+		},
+		{
+			name:     "non-empty list",
+			sql:      "SELECT region, amount FROM sales WHERE region IN ({{region}})",
+			mappings: map[string]filterTarget{"region": {Parameter: "region"}},
+			values:   map[string]interface{}{"region": []interface{}{"north", "east"}},
+			want:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sqlText, args := bindTileFilters(dialect, tt.sql, tt.mappings, tt.values, "")
+			// SQLite accepts IN (), MySQL and PostgreSQL do not
+			if strings.Contains(sqlText, "()") {
+				t.Errorf("%s has an empty list", sqlText)
+			}
+			var rows []map[string]interface{}
+			if err := db.Raw(sqlText, args...).Scan(&rows).Error; err != nil {
+				t.Fatalf("%s %v is not valid SQL: %v", sqlText, args, err)
+			}
+			if len(rows) != tt.want {
+				t.Errorf("%s %v returned %d rows, want %d", sqlText, args, len(rows), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,663 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	errs "errors"
+	"fmt"
+	"gobi/internal/models"
+	"gobi/internal/repositories"
+	"gobi/internal/services/infrastructure"
+	"gobi/pkg/charts"
+	"gobi/pkg/database"
+	"gobi/pkg/errors"
+	"sync"
+	"time"
+)
+
+const (
+	// dashboardGridColumns is the width of the dashboard grid
+	dashboardGridColumns = 12
+	// dashboardBatchParallelism bounds how many data sources a dashboard queries at once;
+	// ExecuteBatch bounds the queries running against each of them
+	dashboardBatchParallelism = 4
+	// dashboardDataTimeout bounds the time spent loading a dashboard's data
+	dashboardDataTimeout = 60 * time.Second
+)
+
+// TileData is the data of a single dashboard tile. Tiles fail independently: a failing
+// query sets Error on its tiles without failing the dashboard.
+type TileData struct {
+	TileID  uint        `json:"tile_id"`
+	Type    string      `json:"type"`
+	Chart   *ChartData  `json:"chart,omitempty"`
+	Value   interface{} `json:"value,omitempty"`
+	Content string      `json:"content,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// DashboardData is the data of every tile of a dashboard and the filter values applied
+type DashboardData struct {
+	DashboardID uint                   `json:"dashboard_id"`
+	Filters     map[string]interface{} `json:"filters"`
+	Tiles       []TileData             `json:"tiles"`
+	RefreshedAt time.Time              `json:"refreshed_at"`
+}
+
+// DashboardFilterOptions is a dashboard filter with its default value and the options to
+// choose from. Options failing to load set Error; the filter stays usable.
+type DashboardFilterOptions struct {
+	Name    string        `json:"name"`
+	Label   string        `json:"label"`
+	Type    string        `json:"type"`
+	Default interface{}   `json:"default"`
+	Options []interface{} `json:"options"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// DashboardService handles dashboard-related business logic
+type DashboardService struct {
+	dashboardRepo     repositories.DashboardRepository
+	chartRepo         repositories.ChartRepository
+	queryRepo         repositories.QueryRepository
+	userRepo          repositories.UserRepository
+	permissionService PermissionService
+	validationService ValidationService
+	encryptionService EncryptionService
+	executor          *infrastructure.OptimizedSQLExecutionService
+	boundaryService   *BoundaryService
+}
+
+// NewDashboardService creates a new DashboardService instance
+func NewDashboardService(
+	dashboardRepo repositories.DashboardRepository,
+	chartRepo repositories.ChartRepository,
+	queryRepo repositories.QueryRepository,
+	userRepo repositories.UserRepository,
+	permissionService PermissionService,
+	validationService ValidationService,
+	encryptionService EncryptionService,
+	executor *infrastructure.OptimizedSQLExecutionService,
+	boundaryService *BoundaryService,
+) *DashboardService {
+	return &DashboardService{
+		dashboardRepo:     dashboardRepo,
+		chartRepo:         chartRepo,
+		queryRepo:         queryRepo,
+		userRepo:          userRepo,
+		permissionService: permissionService,
+		validationService: validationService,
+		encryptionService: encryptionService,
+		executor:          executor,
+		boundaryService:   boundaryService,
+	}
+}
+
+// CreateDashboard creates a new dashboard owned by the user
+func (s *DashboardService) CreateDashboard(dashboard *models.Dashboard, userID uint, isAdmin bool) error {
+	dashboard.ID = 0
+	dashboard.UserID = userID
+	for i := range dashboard.Tiles {
+		dashboard.Tiles[i].ID = 0
+	}
+	for i := range dashboard.Filters {
+		dashboard.Filters[i].ID = 0
+	}
+	for i := range dashboard.Shares {
+		dashboard.Shares[i].ID = 0
+	}
+	if err := s.validateDashboard(dashboard, userID, isAdmin); err != nil {
+		return err
+	}
+	if err := s.dashboardRepo.Create(dashboard); err != nil {
+		return errors.WrapError(err, "Could not create dashboard")
+	}
+	return nil
+}
+
+// ListDashboards returns the dashboards the user owns, was shared or can see publicly
+func (s *DashboardService) ListDashboards(userID uint, isAdmin bool) ([]models.Dashboard, error) {
+	dashboards, err := s.dashboardRepo.FindByUser(userID, isAdmin)
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not fetch dashboards")
+	}
+	return dashboards, nil
+}
+
+// GetDashboard returns a dashboard the user may view
+func (s *DashboardService) GetDashboard(dashboardID uint, userID uint, isAdmin bool) (*models.Dashboard, error) {
+	dashboard, err := s.dashboardRepo.FindByID(dashboardID)
+	if err != nil {
+		if errs.Is(err, errors.ErrNotFound) {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.WrapError(err, "Could not fetch dashboard")
+	}
+	if !s.permissionService.CanAccess(userID, dashboardID, "dashboard", isAdmin) {
+		return nil, errors.ErrForbidden
+	}
+	return dashboard, nil
+}
+
+// UpdateDashboard updates a dashboard. Users the dashboard is shared with for editing may
+// change its name, description, tiles and filters; only the owner changes who it is shared with.
+func (s *DashboardService) UpdateDashboard(dashboardID uint, updates *models.Dashboard, userID uint, isAdmin bool) (*models.Dashboard, error) {
+	dashboard, err := s.GetDashboard(dashboardID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	isOwner := isAdmin || dashboard.UserID == userID
+	if !isOwner && !canEditDashboard(dashboard, userID) {
+		return nil, errors.ErrForbidden
+	}
+
+	if updates.Name != "" {
+		dashboard.Name = updates.Name
+	}
+	dashboard.Description = updates.Description
+	if updates.Tiles != nil {
+		// Tiles keep their IDs only when they already belong to this dashboard
+		existing := make(map[uint]bool, len(dashboard.Tiles))
+		for _, tile := range dashboard.Tiles {
+			existing[tile.ID] = true
+		}
+		for i := range updates.Tiles {
+			if !existing[updates.Tiles[i].ID] {
+				updates.Tiles[i].ID = 0
+			}
+		}
+		dashboard.Tiles = updates.Tiles
+	}
+	if updates.Filters != nil {
+		dashboard.Filters = updates.Filters
+	}
+	if isOwner {
+		dashboard.IsPublic = updates.IsPublic
+		if updates.Shares != nil {
+			dashboard.Shares = updates.Shares
+		}
+	}
+
+	if err := s.validateDashboard(dashboard, userID, isAdmin); err != nil {
+		return nil, err
+	}
+	if err := s.dashboardRepo.Update(dashboard); err != nil {
+		return nil, errors.WrapError(err, "Could not update dashboard")
+	}
+	return s.dashboardRepo.FindByID(dashboardID)
+}
+
+// DeleteDashboard deletes a dashboard; only its owner or an admin may
+func (s *DashboardService) DeleteDashboard(dashboardID uint, userID uint, isAdmin bool) error {
+	if _, err := s.GetDashboard(dashboardID, userID, isAdmin); err != nil {
+		return err
+	}
+	if !isAdmin && !s.permissionService.CheckOwnership(userID, dashboardID, "dashboard") {
+		return errors.ErrForbidden
+	}
+	if err := s.dashboardRepo.Delete(dashboardID); err != nil {
+		return errors.WrapError(err, "Could not delete dashboard")
+	}
+	return nil
+}
+
+// canEditDashboard reports whether the dashboard is shared with the user for editing
+func canEditDashboard(dashboard *models.Dashboard, userID uint) bool {
+	for _, share := range dashboard.Shares {
+		if share.UserID == userID && share.Permission == "edit" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDashboard checks the tile layout, the filters and their mappings, that the editing
+// user may use every chart and query the dashboard references, and the share list. Tiles
+// setting a cross-filter get the default cross-filter field filled in.
+func (s *DashboardService) validateDashboard(dashboard *models.Dashboard, userID uint, isAdmin bool) error {
+	var fieldErrors []charts.FieldError
+	add := func(field, message string) {
+		fieldErrors = append(fieldErrors, charts.FieldError{Field: field, Message: message})
+	}
+
+	if dashboard.Name == "" {
+		add("name", "is required")
+	}
+	filters := s.validateFilters(dashboard, userID, isAdmin, add)
+	for i := range dashboard.Tiles {
+		tile := &dashboard.Tiles[i]
+		path := fmt.Sprintf("tiles[%d]", i)
+		if tile.X < 0 || tile.Y < 0 || tile.Width < 1 || tile.Height < 1 || tile.X+tile.Width > dashboardGridColumns {
+			add(path, fmt.Sprintf("must lie within the %d column grid with a positive width and height", dashboardGridColumns))
+		}
+		if tile.RefreshInterval < 0 {
+			add(path+".refresh_interval", "must not be negative")
+		}
+		for j := 0; j < i; j++ {
+			if tilesOverlap(*tile, dashboard.Tiles[j]) {
+				add(path, fmt.Sprintf("overlaps tiles[%d]", j))
+			}
+		}
+
+		var chartType *charts.ChartType
+		switch tile.Type {
+		case "chart":
+			chart, err := s.chartRepo.FindByID(tile.ChartID)
+			if err != nil {
+				add(path+".chart_id", "must reference an existing chart")
+			} else if !isAdmin && chart.UserID != userID && chart.UserID != dashboard.UserID {
+				add(path+".chart_id", "references a chart you cannot access")
+			} else {
+				chartType, _ = charts.Lookup(chart.Type)
+			}
+		case "kpi":
+			if msg := s.checkQueryAccess(tile.QueryID, dashboard, userID, isAdmin); msg != "" {
+				add(path+".query_id", msg)
+			}
+			if tile.ValueField == "" {
+				add(path+".value_field", "is required for KPI tiles")
+			}
+		case "text":
+		default:
+			add(path+".type", "must be one of chart, text, kpi")
+		}
+		validateTileFilters(tile, chartType, filters, path, add)
+	}
+
+	seen := map[uint]bool{}
+	for i, share := range dashboard.Shares {
+		path := fmt.Sprintf("shares[%d]", i)
+		if share.Permission != "view" && share.Permission != "edit" {
+			add(path+".permission", "must be view or edit")
+		}
+		switch {
+		case share.UserID == dashboard.UserID:
+			add(path+".user_id", "is the dashboard owner")
+		case seen[share.UserID]:
+			add(path+".user_id", "is shared more than once")
+		default:
+			if _, err := s.userRepo.FindByID(share.UserID); err != nil {
+				add(path+".user_id", "must reference an existing user")
+			}
+		}
+		seen[share.UserID] = true
+	}
+
+	if len(fieldErrors) > 0 {
+		return errors.NewBadRequestError("Invalid dashboard", nil).WithDetails(map[string]interface{}{"fields": fieldErrors})
+	}
+	return nil
+}
+
+// checkQueryAccess returns why the user cannot reference a query on the dashboard, or ""
+func (s *DashboardService) checkQueryAccess(queryID uint, dashboard *models.Dashboard, userID uint, isAdmin bool) string {
+	query, err := s.queryRepo.FindByID(queryID)
+	if err != nil {
+		return "must reference an existing query"
+	}
+	if !isAdmin && query.UserID != userID && query.UserID != dashboard.UserID && !query.IsPublic {
+		return "references a query you cannot access"
+	}
+	return ""
+}
+
+func tilesOverlap(a, b models.DashboardTile) bool {
+	return a.X < b.X+b.Width && b.X < a.X+a.Width && a.Y < b.Y+b.Height && b.Y < a.Y+a.Height
+}
+
+// dashboardStatement is a tile query with the dashboard filters bound to it
+type dashboardStatement struct {
+	query *models.Query
+	bound infrastructure.BoundQuery
+}
+
+// queryOutcome is the result of one statement of a dashboard
+type queryOutcome struct {
+	rows   []map[string]interface{}
+	source string
+	err    error
+}
+
+// dashboardPlan collects the distinct statements the tiles of a dashboard need
+type dashboardPlan struct {
+	service    *DashboardService
+	values     map[string]interface{}
+	locked     map[string]bool // filters every statement must apply, see add
+	queries    map[uint]*models.Query
+	queryErrs  map[uint]error
+	statements map[string]*dashboardStatement
+}
+
+func (s *DashboardService) newDashboardPlan(values map[string]interface{}) *dashboardPlan {
+	return &dashboardPlan{
+		service:    s,
+		values:     values,
+		queries:    map[uint]*models.Query{},
+		queryErrs:  map[uint]error{},
+		statements: map[string]*dashboardStatement{},
+	}
+}
+
+// loadQuery fetches and validates a query once per plan
+func (p *dashboardPlan) loadQuery(queryID uint) (*models.Query, error) {
+	if query, ok := p.queries[queryID]; ok {
+		return query, nil
+	}
+	if err, ok := p.queryErrs[queryID]; ok {
+		return nil, err
+	}
+	query, err := p.service.queryRepo.FindByID(queryID)
+	if err == nil {
+		if validationErr := p.service.validationService.ValidateSQL(query.SQL); validationErr != nil {
+			err = errors.NewErrorWithSeverity(errors.ErrCodeInvalidSQL, "Invalid SQL query", validationErr, errors.SeverityMedium, errors.CategoryValidation)
+		}
+	}
+	if err != nil {
+		p.queryErrs[queryID] = err
+		return nil, err
+	}
+	p.queries[queryID] = query
+	return query, nil
+}
+
+// add binds the filters mapped by a tile to a query and returns the key of the statement.
+// Tiles binding the same values to the same query share a statement.
+// Locked filters must be applied by the tile and are applied even when the tile sets them
+// as its cross-filter.
+func (p *dashboardPlan) add(queryID uint, tile models.DashboardTile) (string, error) {
+	query, err := p.loadQuery(queryID)
+	if err != nil {
+		return "", err
+	}
+	dialect, err := database.GetDriver(query.DataSource.Type)
+	if err != nil {
+		return "", err
+	}
+	mappings, err := parseFilterMappings(tile.FilterMappings)
+	if err != nil {
+		return "", errors.NewBadRequestError("Invalid filter mappings", err)
+	}
+	skip := tile.CrossFilter
+	for name := range p.locked {
+		if !filterApplies(query.SQL, mappings[name]) {
+			return "", errors.NewError(errors.ErrCodeForbidden, fmt.Sprintf("Tile is not restricted by locked filter %q", name), nil)
+		}
+		if name == skip {
+			skip = ""
+		}
+	}
+	sqlText, args := bindTileFilters(dialect, query.SQL, mappings, p.values, skip)
+
+	encodedArgs, _ := json.Marshal(args)
+	key := fmt.Sprintf("%d\x00%s\x00%s", query.ID, sqlText, encodedArgs)
+	if _, ok := p.statements[key]; !ok {
+		p.statements[key] = &dashboardStatement{query: query, bound: infrastructure.BoundQuery{SQL: sqlText, Args: args}}
+	}
+	return key, nil
+}
+
+// tilePlan is the resolved data plan of a dashboard's tiles
+type tilePlan struct {
+	*dashboardPlan
+	tileCharts     map[uint]*models.Chart
+	tileStatements map[uint]string // tile ID -> statement key
+	tileErrors     map[uint]error
+}
+
+// planTiles resolves the charts of a dashboard's tiles and the statements they need. Tiles
+// not applying every locked filter fail instead of running unrestricted.
+func (s *DashboardService) planTiles(dashboard *models.Dashboard, values map[string]interface{}, locked map[string]bool) *tilePlan {
+	plan := &tilePlan{
+		dashboardPlan:  s.newDashboardPlan(values),
+		tileCharts:     map[uint]*models.Chart{},
+		tileStatements: map[uint]string{},
+		tileErrors:     map[uint]error{},
+	}
+	plan.locked = locked
+	for _, tile := range dashboard.Tiles {
+		queryID := tile.QueryID
+		switch tile.Type {
+		case "chart":
+			chart, err := s.chartRepo.FindByID(tile.ChartID)
+			if err != nil {
+				plan.tileErrors[tile.ID] = err
+				continue
+			}
+			plan.tileCharts[tile.ID] = chart
+			queryID = chart.QueryID
+		case "kpi":
+		default:
+			continue
+		}
+		if queryID == 0 {
+			// Static chart data cannot be filtered, so it would be shown unrestricted
+			if name := firstLocked(locked); name != "" {
+				plan.tileErrors[tile.ID] = errors.NewError(errors.ErrCodeForbidden, fmt.Sprintf("Static chart data is not restricted by locked filter %q", name), nil)
+			}
+			continue
+		}
+		if key, err := plan.add(queryID, tile); err != nil {
+			plan.tileErrors[tile.ID] = err
+		} else {
+			plan.tileStatements[tile.ID] = key
+		}
+	}
+	return plan
+}
+
+// firstLocked returns the alphabetically first locked filter name, or "" when none are locked
+func firstLocked(locked map[string]bool) string {
+	first := ""
+	for name, ok := range locked {
+		if ok && (first == "" || name < first) {
+			first = name
+		}
+	}
+	return first
+}
+
+// tileData builds the data of a tile from the outcome of its statement
+func (p *tilePlan) tileData(tile models.DashboardTile, outcome queryOutcome) TileData {
+	td := TileData{TileID: tile.ID, Type: tile.Type}
+	if err := p.tileErrors[tile.ID]; err != nil {
+		td.Error = tileErrorMessage(err)
+		return td
+	}
+	switch tile.Type {
+	case "text":
+		td.Content = tile.Content
+	case "chart":
+		chart := p.tileCharts[tile.ID]
+		rows, source, err := outcome.rows, outcome.source, outcome.err
+		if chart.QueryID == 0 {
+			rows, err = staticChartRows(chart)
+			source = "static"
+		}
+		if err == nil {
+			td.Chart, err = shapeChartData(chart, rows, source)
+			p.service.boundaryService.MatchRegions(chart, td.Chart)
+		}
+		if err != nil {
+			td.Error = tileErrorMessage(err)
+		}
+	case "kpi":
+		switch {
+		case outcome.err != nil:
+			td.Error = tileErrorMessage(outcome.err)
+		case len(outcome.rows) == 0:
+			td.Error = "Query returned no rows"
+		default:
+			value, ok := outcome.rows[0][tile.ValueField]
+			if !ok {
+				td.Error = fmt.Sprintf("Column %q is not in the query result", tile.ValueField)
+			} else if b, isBytes := value.([]byte); isBytes {
+				td.Value = string(b)
+			} else {
+				td.Value = value
+			}
+		}
+	}
+	return td
+}
+
+// GetDashboardData loads the data of every tile with the filter values applied over the
+// filters' defaults. Each distinct statement runs once however many tiles use it; the
+// statements of a data source run through a single bounded batch and data sources are
+// queried concurrently.
+func (s *DashboardService) GetDashboardData(dashboardID uint, userID uint, isAdmin bool, filterValues map[string]interface{}) (*DashboardData, error) {
+	dashboard, err := s.GetDashboard(dashboardID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	values, err := resolveFilterValues(dashboard.Filters, filterValues)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := s.loadTiles(dashboard, values, nil)
+	return data, nil
+}
+
+// loadTiles runs the statements of a dashboard's tiles and builds their data
+func (s *DashboardService) loadTiles(dashboard *models.Dashboard, values map[string]interface{}, locked map[string]bool) (*DashboardData, *tilePlan) {
+	plan := s.planTiles(dashboard, values, locked)
+	outcomes := s.runStatements(context.Background(), plan.statements)
+
+	data := &DashboardData{DashboardID: dashboard.ID, Filters: values, Tiles: make([]TileData, 0, len(dashboard.Tiles)), RefreshedAt: time.Now()}
+	for _, tile := range dashboard.Tiles {
+		data.Tiles = append(data.Tiles, plan.tileData(tile, outcomes[plan.tileStatements[tile.ID]]))
+	}
+	return data, plan
+}
+
+// GetDashboardFilters returns the filters of a dashboard with their options. Options from
+// queries are the distinct values of the options field in result order.
+func (s *DashboardService) GetDashboardFilters(dashboardID uint, userID uint, isAdmin bool) ([]DashboardFilterOptions, error) {
+	dashboard, err := s.GetDashboard(dashboardID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := s.newDashboardPlan(nil)
+	keys := make([]string, len(dashboard.Filters))
+	result := make([]DashboardFilterOptions, len(dashboard.Filters))
+	for i, filter := range dashboard.Filters {
+		result[i] = DashboardFilterOptions{Name: filter.Name, Label: filter.Label, Type: filter.Type}
+		result[i].Default, _ = filterDefault(filter)
+		if result[i].Options, err = filterStaticOptions(filter); err != nil {
+			result[i].Error = err.Error()
+		}
+		if filter.OptionsQueryID == 0 {
+			continue
+		}
+		if keys[i], err = plan.add(filter.OptionsQueryID, models.DashboardTile{}); err != nil {
+			result[i].Error = tileErrorMessage(err)
+		}
+	}
+
+	outcomes := s.runStatements(context.Background(), plan.statements)
+	for i, filter := range dashboard.Filters {
+		if keys[i] == "" {
+			continue
+		}
+		outcome := outcomes[keys[i]]
+		if outcome.err != nil {
+			result[i].Error = tileErrorMessage(outcome.err)
+			continue
+		}
+		seen := map[string]bool{}
+		for _, row := range outcome.rows {
+			value, ok := row[filter.OptionsField]
+			if !ok || value == nil {
+				continue
+			}
+			if b, isBytes := value.([]byte); isBytes {
+				value = string(b)
+			}
+			id := fmt.Sprint(value)
+			if !seen[id] {
+				seen[id] = true
+				result[i].Options = append(result[i].Options, value)
+			}
+		}
+	}
+	return result, nil
+}
+
+// runStatements executes the statements grouped by data source
+func (s *DashboardService) runStatements(ctx context.Context, statements map[string]*dashboardStatement) map[string]queryOutcome {
+	type batch struct {
+		dataSource models.DataSource
+		keys       []string
+		queries    []infrastructure.BoundQuery
+	}
+
+	outcomes := make(map[string]queryOutcome, len(statements))
+	batches := map[uint]*batch{}
+	for key, statement := range statements {
+		b, ok := batches[statement.query.DataSourceID]
+		if !ok {
+			ds := statement.query.DataSource
+			if ds.Password != "" {
+				password, err := s.encryptionService.Decrypt(ds.Password)
+				if err != nil {
+					outcomes[key] = queryOutcome{err: errors.NewErrorWithSeverity(errors.ErrCodeInternalServer, "Could not decrypt password", err, errors.SeverityHigh, errors.CategorySecurity)}
+					continue
+				}
+				ds.Password = password
+			}
+			b = &batch{dataSource: ds}
+			batches[statement.query.DataSourceID] = b
+		}
+		b.keys = append(b.keys, key)
+		b.queries = append(b.queries, statement.bound)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dashboardDataTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, dashboardBatchParallelism)
+	for _, b := range batches {
+		wg.Add(1)
+		go func(b *batch) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			results, err := s.executor.ExecuteBoundBatch(ctx, b.dataSource, b.queries)
+			mu.Lock()
+			defer mu.Unlock()
+			for i, key := range b.keys {
+				switch {
+				case err != nil:
+					outcomes[key] = queryOutcome{err: err}
+				case results[i] == nil:
+					outcomes[key] = queryOutcome{err: fmt.Errorf("query was not executed")}
+				case results[i].Error != nil:
+					outcomes[key] = queryOutcome{err: results[i].Error}
+				default:
+					source := "database"
+					if results[i].CacheHit {
+						source = "cache"
+					} else if err := s.queryRepo.IncrementExecCount(statements[key].query.ID); err != nil {
+						errors.RecordError(errors.NewDatabaseError("Failed to increment execution count", err))
+					}
+					outcomes[key] = queryOutcome{rows: results[i].Data, source: source}
+				}
+			}
+		}(b)
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// tileErrorMessage returns the message shown for a failed tile
+func tileErrorMessage(err error) string {
+	var customErr *errors.CustomError
+	if errs.As(err, &customErr) {
+		return customErr.Message
+	}
+	return err.Error()
+}
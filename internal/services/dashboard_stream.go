@@ -0,0 +1,460 @@
+package services
+
+import (
+	"context"
+	errs "errors"
+	"gobi/config"
+	"gobi/internal/models"
+	"gobi/pkg/errors"
+	"gobi/pkg/utils"
+	"sync"
+	"time"
+)
+
+const (
+	// dashboardStreamTick is how often a stream checks for due tile refreshes
+	dashboardStreamTick = time.Second
+	// dashboardStreamReload is how often a stream reloads the dashboard to pick up edits
+	dashboardStreamReload = 30 * time.Second
+	// dashboardSubscriberBuffer is the number of events a slow subscriber may fall behind
+	// before it is disconnected
+	dashboardSubscriberBuffer = 16
+)
+
+// DashboardEvent is an event pushed to live dashboard subscribers: a "snapshot" of every
+// tile when subscribing, then "tiles" with the tiles whose data changed, or "error"
+type DashboardEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// DashboardHub runs one live stream per watched dashboard. Each stream refreshes every
+// distinct statement once per refresh interval however many clients are subscribed, and
+// pushes tiles whenever the query cache stores a fresh result for one of its statements.
+type DashboardHub struct {
+	service *DashboardService
+	cfg     config.RealtimeConfig
+
+	mu              sync.Mutex
+	streams         map[uint]*dashboardStream
+	connections     int
+	userConnections map[uint]int
+	closed          bool
+}
+
+// NewDashboardHub creates a new DashboardHub instance
+func NewDashboardHub(service *DashboardService, cfg config.RealtimeConfig) *DashboardHub {
+	return &DashboardHub{
+		service:         service,
+		cfg:             cfg,
+		streams:         make(map[uint]*dashboardStream),
+		userConnections: make(map[uint]int),
+	}
+}
+
+// HeartbeatInterval returns how often idle event streams should send a keep-alive
+func (h *DashboardHub) HeartbeatInterval() time.Duration {
+	return h.cfg.HeartbeatInterval
+}
+
+// Subscribe starts watching a dashboard the user may view. The returned channel receives a
+// snapshot first and is closed when the stream ends or the user loses access to the
+// dashboard; unsubscribe must be called when the client goes away.
+func (h *DashboardHub) Subscribe(dashboardID uint, userID uint, isAdmin bool) (<-chan DashboardEvent, func(), error) {
+	h.mu.Lock()
+	err := h.admitLocked(userID)
+	h.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshot, err := h.service.GetDashboardData(dashboardID, userID, isAdmin, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// Check again: other clients may have connected while the snapshot loaded
+	if err := h.admitLocked(userID); err != nil {
+		return nil, nil, err
+	}
+
+	stream, ok := h.streams[dashboardID]
+	if !ok {
+		stream = newDashboardStream(h, dashboardID)
+		h.streams[dashboardID] = stream
+		go stream.run()
+	}
+	events := make(chan DashboardEvent, dashboardSubscriberBuffer)
+	events <- DashboardEvent{Type: "snapshot", Data: snapshot}
+	stream.subscribers[events] = dashboardSubscriber{userID: userID, isAdmin: isAdmin}
+	h.connections++
+	h.userConnections[userID]++
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.removeLocked(stream, events)
+		})
+	}
+	return events, unsubscribe, nil
+}
+
+// admitLocked checks the connection limits for a new subscriber; h.mu must be held
+func (h *DashboardHub) admitLocked(userID uint) error {
+	switch {
+	case h.closed:
+		return errors.NewError(errors.ErrCodeServiceUnavailable, "Server is shutting down", nil)
+	case h.cfg.MaxConnections > 0 && h.connections >= h.cfg.MaxConnections:
+		return errors.NewError(errors.ErrCodeRateLimit, "Too many live connections", nil)
+	case h.cfg.MaxConnectionsPerUser > 0 && h.userConnections[userID] >= h.cfg.MaxConnectionsPerUser:
+		return errors.NewError(errors.ErrCodeRateLimit, "Too many live connections for this user", nil)
+	}
+	return nil
+}
+
+// Close ends every stream and disconnects all subscribers; it is meant for server shutdown
+func (h *DashboardHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, stream := range h.streams {
+		for events := range stream.subscribers {
+			h.removeLocked(stream, events)
+		}
+	}
+}
+
+// Stats returns the number of watched dashboards and connected subscribers
+func (h *DashboardHub) Stats() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return map[string]interface{}{
+		"dashboards":  len(h.streams),
+		"connections": h.connections,
+	}
+}
+
+// removeLocked disconnects a subscriber and stops the stream once nobody watches it; h.mu
+// must be held
+func (h *DashboardHub) removeLocked(stream *dashboardStream, events chan DashboardEvent) {
+	subscriber, ok := stream.subscribers[events]
+	if !ok {
+		return
+	}
+	delete(stream.subscribers, events)
+	close(events)
+	h.connections--
+	if h.userConnections[subscriber.userID]--; h.userConnections[subscriber.userID] <= 0 {
+		delete(h.userConnections, subscriber.userID)
+	}
+	if len(stream.subscribers) == 0 {
+		stream.cancel()
+		delete(h.streams, stream.dashboardID)
+	}
+}
+
+// broadcast sends an event to every subscriber of a stream. Subscribers too slow to keep up
+// are disconnected; clients reconnect and start over from a snapshot.
+func (h *DashboardHub) broadcast(stream *dashboardStream, event DashboardEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for events := range stream.subscribers {
+		select {
+		case events <- event:
+		default:
+			h.removeLocked(stream, events)
+		}
+	}
+}
+
+// revokeLostAccess disconnects the subscribers of a stream who may no longer view its
+// dashboard, for example because it was unshared from them. Permissions are checked
+// without holding h.mu since they read the database.
+func (h *DashboardHub) revokeLostAccess(stream *dashboardStream) {
+	h.mu.Lock()
+	subscribers := make(map[dashboardSubscriber]bool, len(stream.subscribers))
+	for _, subscriber := range stream.subscribers {
+		subscribers[subscriber] = true
+	}
+	h.mu.Unlock()
+
+	revoked := make(map[dashboardSubscriber]bool)
+	for subscriber := range subscribers {
+		if !h.service.permissionService.CanAccess(subscriber.userID, stream.dashboardID, "dashboard", subscriber.isAdmin) {
+			revoked[subscriber] = true
+		}
+	}
+	if len(revoked) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	event := DashboardEvent{Type: "error", Data: map[string]string{"message": "Access to the dashboard was revoked"}}
+	for events, subscriber := range stream.subscribers {
+		if !revoked[subscriber] {
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+		}
+		h.removeLocked(stream, events)
+	}
+}
+
+// endStream disconnects every subscriber of a stream
+func (h *DashboardHub) endStream(stream *dashboardStream, event DashboardEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for events := range stream.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+		h.removeLocked(stream, events)
+	}
+}
+
+// TilesUpdate is the data of a "tiles" event
+type TilesUpdate struct {
+	DashboardID uint       `json:"dashboard_id"`
+	Tiles       []TileData `json:"tiles"`
+	RefreshedAt time.Time  `json:"refreshed_at"`
+}
+
+// cacheEvent is a fresh query result stored in the cache
+type cacheEvent struct {
+	key   string
+	value interface{}
+}
+
+// streamStatement is a statement of a live dashboard and the tiles showing its result
+type streamStatement struct {
+	statement *dashboardStatement
+	cacheKey  string
+	interval  time.Duration
+	next      time.Time
+	tiles     []models.DashboardTile
+}
+
+// dashboardSubscriber is the user a live dashboard is streamed to
+type dashboardSubscriber struct {
+	userID  uint
+	isAdmin bool
+}
+
+// dashboardStream is the live state of one watched dashboard
+type dashboardStream struct {
+	hub         *DashboardHub
+	dashboardID uint
+	subscribers map[chan DashboardEvent]dashboardSubscriber // guarded by hub.mu
+	ctx         context.Context
+	cancel      context.CancelFunc
+	cacheEvents chan cacheEvent
+
+	mu         sync.RWMutex
+	byKey      map[string]*streamStatement // by cache keys; written by run, read by the listener
+	plan       *tilePlan
+	statements map[string]*streamStatement // by statement key
+	loadedAt   time.Time
+}
+
+func newDashboardStream(hub *DashboardHub, dashboardID uint) *dashboardStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &dashboardStream{
+		hub:         hub,
+		dashboardID: dashboardID,
+		subscribers: make(map[chan DashboardEvent]dashboardSubscriber),
+		ctx:         ctx,
+		cancel:      cancel,
+		cacheEvents: make(chan cacheEvent, 256),
+		byKey:       make(map[string]*streamStatement),
+		statements:  make(map[string]*streamStatement),
+	}
+}
+
+// run refreshes due statements and turns cache updates into tile events until cancelled
+func (st *dashboardStream) run() {
+	removeListener := utils.AddQueryCacheListener(func(key string, value interface{}) {
+		st.mu.RLock()
+		_, watched := st.byKey[key]
+		st.mu.RUnlock()
+		if !watched {
+			return
+		}
+		select {
+		case st.cacheEvents <- cacheEvent{key: key, value: value}:
+		default:
+		}
+	})
+	defer removeListener()
+
+	if !st.reload() {
+		return
+	}
+	ticker := time.NewTicker(dashboardStreamTick)
+	defer ticker.Stop()
+	refreshed := make(chan map[string]queryOutcome, 1)
+	refreshing := false
+	for {
+		select {
+		case <-st.ctx.Done():
+			return
+		case event := <-st.cacheEvents:
+			st.pushCached(event)
+		case outcomes := <-refreshed:
+			refreshing = false
+			st.pushErrors(outcomes)
+		case now := <-ticker.C:
+			if now.Sub(st.loadedAt) >= dashboardStreamReload && !st.reload() {
+				return
+			}
+			if refreshing {
+				continue
+			}
+			if due := st.due(now); len(due) > 0 {
+				refreshing = true
+				go func() {
+					refreshed <- st.refresh(due)
+				}()
+			}
+		}
+	}
+}
+
+// reload loads the dashboard, disconnects subscribers who lost access to it and rebuilds
+// the statements to watch. It reports false and ends the stream when the dashboard is gone
+// or nobody may watch it anymore.
+func (st *dashboardStream) reload() bool {
+	service := st.hub.service
+	dashboard, err := service.dashboardRepo.FindByID(st.dashboardID)
+	if err == nil {
+		st.hub.revokeLostAccess(st)
+		if st.ctx.Err() != nil {
+			return false
+		}
+		var values map[string]interface{}
+		if values, err = resolveFilterValues(dashboard.Filters, nil); err == nil {
+			st.rebuild(dashboard, service.planTiles(dashboard, values, nil))
+			return true
+		}
+	}
+	if errs.Is(err, errors.ErrNotFound) {
+		st.hub.endStream(st, DashboardEvent{Type: "error", Data: map[string]string{"message": "Dashboard was deleted"}})
+		return false
+	}
+	// Keep streaming the previous layout through transient failures
+	st.loadedAt = time.Now()
+	return true
+}
+
+// rebuild replaces the watched statements, keeping the refresh schedule of statements
+// that are still in use
+func (st *dashboardStream) rebuild(dashboard *models.Dashboard, plan *tilePlan) {
+	minInterval := st.hub.cfg.MinRefreshInterval
+	statements := make(map[string]*streamStatement)
+	byKey := make(map[string]*streamStatement)
+	now := time.Now()
+	for _, tile := range dashboard.Tiles {
+		key, ok := plan.tileStatements[tile.ID]
+		if !ok {
+			continue
+		}
+		ss, ok := statements[key]
+		if !ok {
+			statement := plan.statements[key]
+			ss = &streamStatement{
+				statement: statement,
+				cacheKey:  st.hub.service.executor.CacheKey(statement.query.DataSourceID, statement.bound.SQL, statement.bound.Args...),
+			}
+			statements[key] = ss
+			byKey[ss.cacheKey] = ss
+			if len(statement.bound.Args) == 0 && statement.bound.SQL == statement.query.SQL {
+				// Unfiltered tiles show the same rows as the query itself
+				byKey[queryResultCacheKey(statement.query.ID)] = ss
+			}
+		}
+		ss.tiles = append(ss.tiles, tile)
+
+		seconds := tile.RefreshInterval
+		if chart := plan.tileCharts[tile.ID]; seconds == 0 && chart != nil {
+			seconds = chart.RefreshInterval
+		}
+		if seconds > 0 {
+			interval := time.Duration(seconds) * time.Second
+			if interval < minInterval {
+				interval = minInterval
+			}
+			if ss.interval == 0 || interval < ss.interval {
+				ss.interval = interval
+			}
+		}
+	}
+	for key, ss := range statements {
+		if previous, ok := st.statements[key]; ok && !previous.next.IsZero() {
+			ss.next = previous.next
+		} else if ss.interval > 0 {
+			ss.next = now.Add(ss.interval)
+		}
+	}
+
+	st.mu.Lock()
+	st.byKey = byKey
+	st.mu.Unlock()
+	st.plan = plan
+	st.statements = statements
+	st.loadedAt = now
+}
+
+// due returns the statements whose refresh interval has elapsed and schedules their next run
+func (st *dashboardStream) due(now time.Time) map[string]*dashboardStatement {
+	due := make(map[string]*dashboardStatement)
+	for key, ss := range st.statements {
+		if ss.interval > 0 && !now.Before(ss.next) {
+			due[key] = ss.statement
+			ss.next = now.Add(ss.interval)
+		}
+	}
+	return due
+}
+
+// refresh re-executes statements past their cache entries. Successful results reach the
+// subscribers through the cache listener, like results refreshed anywhere else.
+func (st *dashboardStream) refresh(due map[string]*dashboardStatement) map[string]queryOutcome {
+	executor := st.hub.service.executor
+	for _, statement := range due {
+		executor.InvalidateCache(statement.query.DataSourceID, statement.bound.SQL, statement.bound.Args...)
+	}
+	return st.hub.service.runStatements(st.ctx, due)
+}
+
+// pushCached sends the tiles showing a freshly cached result
+func (st *dashboardStream) pushCached(event cacheEvent) {
+	ss, ok := st.byKey[event.key]
+	rows, isRows := event.value.([]map[string]interface{})
+	if !ok || !isRows {
+		return
+	}
+	st.push(ss, queryOutcome{rows: rows, source: "database"})
+}
+
+// pushErrors sends the tiles of statements that failed to refresh
+func (st *dashboardStream) pushErrors(outcomes map[string]queryOutcome) {
+	for key, outcome := range outcomes {
+		if ss, ok := st.statements[key]; ok && outcome.err != nil {
+			st.push(ss, outcome)
+		}
+	}
+}
+
+func (st *dashboardStream) push(ss *streamStatement, outcome queryOutcome) {
+	update := TilesUpdate{DashboardID: st.dashboardID, Tiles: make([]TileData, 0, len(ss.tiles)), RefreshedAt: time.Now()}
+	for _, tile := range ss.tiles {
+		update.Tiles = append(update.Tiles, st.plan.tileData(tile, outcome))
+	}
+	st.hub.broadcast(st, DashboardEvent{Type: "tiles", Data: update})
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"gobi/internal/models"
+	"gobi/internal/repositories"
+	"gobi/pkg/database"
+	"gobi/pkg/errors"
+	"gobi/pkg/utils"
+	"time"
+)
+
+const (
+	// maxProfileSampleSize caps the number of rows a single profile may scan
+	maxProfileSampleSize = 1000000
+	// profileJobTimeout bounds how long a background profile may run
+	profileJobTimeout = 10 * time.Minute
+	// profilePendingTimeout bounds how long a profile may wait for a free job slot
+	profilePendingTimeout = 30 * time.Minute
+	// profileStaleGrace is how long after profileJobTimeout a running profile is failed as stale
+	profileStaleGrace = time.Minute
+	// profileStaleError is the error of profiles whose server stopped before they finished
+	profileStaleError = "profile abandoned: the server running it stopped before it finished"
+)
+
+// profileJobSlots limits the number of profiles running at the same time
+var profileJobSlots = make(chan struct{}, 2)
+
+// DataProfileService handles table profiling and schema introspection
+type DataProfileService struct {
+	dsRepo            repositories.DataSourceRepository
+	profileRepo       repositories.DataProfileRepository
+	encryptionService EncryptionService
+}
+
+// TableSchemaWithProfile is a table from schema introspection with its latest profile
+type TableSchemaWithProfile struct {
+	database.TableSchema
+	Profile *models.DataProfile `json:"profile,omitempty"`
+}
+
+// NewDataProfileService creates a new DataProfileService instance
+func NewDataProfileService(
+	dsRepo repositories.DataSourceRepository,
+	profileRepo repositories.DataProfileRepository,
+	encryptionService EncryptionService,
+) *DataProfileService {
+	return &DataProfileService{
+		dsRepo:            dsRepo,
+		profileRepo:       profileRepo,
+		encryptionService: encryptionService,
+	}
+}
+
+// StartProfile records a pending profile for a table and runs it in the background
+func (s *DataProfileService) StartProfile(dsID uint, table string, sampleSize int, userID uint, isAdmin bool) (*models.DataProfile, error) {
+	if table == "" {
+		return nil, errors.NewBadRequestError("Table is required", nil)
+	}
+	if sampleSize < 0 || sampleSize > maxProfileSampleSize {
+		return nil, errors.NewBadRequestError("Sample size must be between 0 and 1000000", nil)
+	}
+
+	ds, err := s.getDataSource(dsID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &models.DataProfile{
+		DataSourceID: ds.ID,
+		UserID:       userID,
+		Table:        table,
+		SampleSize:   sampleSize,
+		Status:       "pending",
+	}
+	if err := s.profileRepo.Create(profile); err != nil {
+		return nil, err
+	}
+
+	// The job works on its own copy so the caller can serialize the pending record safely
+	job := *profile
+	go s.runProfile(&job, ds)
+
+	return profile, nil
+}
+
+// GetProfile retrieves a profile of a data source
+func (s *DataProfileService) GetProfile(dsID, profileID uint, userID uint, isAdmin bool) (*models.DataProfile, error) {
+	if _, err := s.getDataSource(dsID, userID, isAdmin); err != nil {
+		return nil, err
+	}
+	profile, err := s.profileRepo.FindByID(profileID)
+	if err != nil {
+		return nil, err
+	}
+	if profile.DataSourceID != dsID {
+		return nil, errors.ErrNotFound
+	}
+	if isStaleProfile(profile, time.Now()) {
+		s.RecoverStaleProfiles()
+		if profile, err = s.profileRepo.FindByID(profileID); err != nil {
+			return nil, err
+		}
+	}
+	decodeProfileColumns(profile)
+	return profile, nil
+}
+
+// GetSchema introspects a data source and attaches the latest completed profile of each table
+func (s *DataProfileService) GetSchema(dsID uint, userID uint, isAdmin bool) ([]TableSchemaWithProfile, error) {
+	ds, err := s.getDataSource(dsID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := database.IntrospectSchema(context.Background(), ds)
+	if err != nil {
+		return nil, errors.NewErrorWithSeverity(
+			errors.ErrCodeDataSourceConnection,
+			"Could not introspect data source schema",
+			err,
+			errors.SeverityMedium,
+			errors.CategoryDatabase,
+		)
+	}
+
+	profiles, err := s.profileRepo.FindByDataSource(ds.ID)
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]*models.DataProfile)
+	for i := range profiles {
+		p := &profiles[i]
+		if p.Status != "completed" {
+			continue
+		}
+		if _, seen := latest[p.Table]; !seen {
+			decodeProfileColumns(p)
+			latest[p.Table] = p
+		}
+	}
+
+	result := make([]TableSchemaWithProfile, 0, len(tables))
+	for _, table := range tables {
+		result = append(result, TableSchemaWithProfile{TableSchema: table, Profile: latest[table.Name]})
+	}
+	return result, nil
+}
+
+// runProfile executes a profile job and persists its outcome
+func (s *DataProfileService) runProfile(profile *models.DataProfile, ds *models.DataSource) {
+	profileJobSlots <- struct{}{}
+	defer func() { <-profileJobSlots }()
+
+	started := time.Now()
+	profile.StartedAt = &started
+	running, err := s.profileRepo.MarkRunning(profile)
+	if err != nil {
+		utils.Logger.WithError(err).Error("Could not start data profile")
+		profile.Status = "failed"
+		profile.Error = err.Error()
+		profile.CompletedAt = &started
+		s.profileRepo.Update(profile)
+		return
+	}
+	if !running {
+		// Waited past profilePendingTimeout and was failed as stale
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), profileJobTimeout)
+	defer cancel()
+
+	columns, err := database.ProfileTable(ctx, ds, profile.Table, profile.SampleSize)
+	if err == nil {
+		var result []byte
+		if result, err = json.Marshal(columns); err == nil {
+			profile.Result = string(result)
+		}
+	}
+
+	completed := time.Now()
+	profile.CompletedAt = &completed
+	profile.Status = "completed"
+	if err != nil {
+		profile.Status = "failed"
+		profile.Error = err.Error()
+	}
+	if updateErr := s.profileRepo.Update(profile); updateErr != nil {
+		utils.Logger.WithError(updateErr).Error("Could not save data profile")
+	}
+
+	utils.Logger.WithFields(map[string]interface{}{
+		"action":         "profile_table",
+		"profile_id":     profile.ID,
+		"data_source_id": profile.DataSourceID,
+		"table":          profile.Table,
+		"status":         profile.Status,
+		"duration":       completed.Sub(started).String(),
+	}).Info("Data profile finished")
+}
+
+// RecoverStaleProfiles fails the profiles no server is working on any more: running past
+// the job timeout, or pending past profilePendingTimeout. It runs on startup, as profiles run
+// inside the server that started them and are lost when it stops.
+func (s *DataProfileService) RecoverStaleProfiles() {
+	now := time.Now()
+	failed, err := s.profileRepo.FailStale(now.Add(-profileJobTimeout-profileStaleGrace), now.Add(-profilePendingTimeout), profileStaleError)
+	if err != nil {
+		utils.Logger.WithError(err).Error("Could not fail stale data profiles")
+		return
+	}
+	if failed > 0 {
+		utils.Logger.Warnf("Failed %d stale data profiles", failed)
+	}
+}
+
+// isStaleProfile reports whether no server can still be working on a profile
+func isStaleProfile(profile *models.DataProfile, now time.Time) bool {
+	switch profile.Status {
+	case "running":
+		return profile.StartedAt != nil && profile.StartedAt.Before(now.Add(-profileJobTimeout-profileStaleGrace))
+	case "pending":
+		return profile.CreatedAt.Before(now.Add(-profilePendingTimeout))
+	}
+	return false
+}
+
+// getDataSource loads a data source the user may read and decrypts its password
+func (s *DataProfileService) getDataSource(dsID uint, userID uint, isAdmin bool) (*models.DataSource, error) {
+	ds, err := s.dsRepo.FindByID(dsID)
+	if err != nil {
+		return nil, errors.ErrNotFound
+	}
+	if !isAdmin && ds.UserID != userID && !ds.IsPublic {
+		return nil, errors.ErrForbidden
+	}
+	if ds.Password != "" {
+		password, err := s.encryptionService.Decrypt(ds.Password)
+		if err != nil {
+			return nil, errors.NewErrorWithSeverity(
+				errors.ErrCodeInternalServer,
+				"Could not decrypt password",
+				err,
+				errors.SeverityHigh,
+				errors.CategorySecurity,
+			)
+		}
+		ds.Password = password
+	}
+	return ds, nil
+}
+
+// decodeProfileColumns fills Columns from the stored JSON result
+func decodeProfileColumns(profile *models.DataProfile) {
+	if profile.Result == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(profile.Result), &profile.Columns); err != nil {
+		utils.Logger.WithError(err).Warn("Could not decode data profile result")
+	}
+}
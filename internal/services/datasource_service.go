@@ -1,13 +1,18 @@
 package services
 
 import (
+	"context"
 	"gobi/internal/models"
 	"gobi/internal/repositories"
 	"gobi/pkg/database"
 	"gobi/pkg/errors"
 	"gobi/pkg/utils"
+	"time"
 )
 
+// connectionTestTimeout bounds a data source connection test
+const connectionTestTimeout = 10 * time.Second
+
 // DataSourceService handles data source-related business logic
 type DataSourceService struct {
 	dsRepo            repositories.DataSourceRepository
@@ -28,8 +33,9 @@ func NewDataSourceService(
 	}
 }
 
-// CreateDataSource creates a new data source with encrypted password
-func (s *DataSourceService) CreateDataSource(ds *models.DataSource, userID uint) error {
+// CreateDataSource creates a new data source with encrypted password, or with a secret
+// reference when created by an admin
+func (s *DataSourceService) CreateDataSource(ds *models.DataSource, userID uint, isAdmin bool) error {
 	ds.UserID = userID
 
 	// 验证必填字段
@@ -51,54 +57,82 @@ func (s *DataSourceService) CreateDataSource(ds *models.DataSource, userID uint)
 			errors.CategoryValidation,
 		)
 	}
-	if ds.Host == "" {
-		return errors.NewErrorWithSeverity(
-			errors.ErrCodeDataSourceHostRequired,
-			"DataSource host is required",
-			nil,
-			errors.SeverityMedium,
-			errors.CategoryValidation,
-		)
-	}
-	if ds.Port == 0 {
-		return errors.NewErrorWithSeverity(
-			errors.ErrCodeDataSourcePortRequired,
-			"DataSource port is required",
-			nil,
-			errors.SeverityMedium,
-			errors.CategoryValidation,
-		)
+	if err := validateDriverFields(ds); err != nil {
+		return err
 	}
-	if ds.Database == "" {
+
+	if _, err := database.ParseReplicas(ds); err != nil {
 		return errors.NewErrorWithSeverity(
-			errors.ErrCodeDataSourceDatabaseRequired,
-			"DataSource database is required",
-			nil,
+			errors.ErrCodeDataSourceInvalid,
+			"Invalid data source replicas",
+			err,
 			errors.SeverityMedium,
 			errors.CategoryValidation,
 		)
 	}
 
-	if ds.Password != "" {
-		encryptedPassword, err := s.encryptionService.Encrypt(ds.Password)
-		if err != nil {
-			return errors.NewErrorWithSeverity(
-				errors.ErrCodeInternalServer,
-				"Failed to encrypt password",
-				err,
-				errors.SeverityHigh,
-				errors.CategorySecurity,
-			)
-		}
-		ds.Password = encryptedPassword
+	if err := s.storePassword(ds, ds.Password, ds.PasswordRef, isAdmin); err != nil {
+		return err
 	}
 
 	if err := s.dsRepo.Create(ds); err != nil {
 		return errors.NewDatabaseError("Could not create data source", err)
 	}
+	hidePassword(ds)
+	return nil
+}
+
+// storePassword sets the stored password of a data source: a secret reference as-is, or a
+// literal password encrypted. References are resolved on the server, so only admins may use
+// them, and only within the secrets.allowed_* allowlist.
+func (s *DataSourceService) storePassword(ds *models.DataSource, password, ref string, isAdmin bool) error {
+	if ref != "" {
+		if err := checkPasswordRef(ref, isAdmin); err != nil {
+			return err
+		}
+		ds.Password = ref
+		return nil
+	}
+	if password == "" {
+		return nil
+	}
+	encryptedPassword, err := s.encryptionService.Encrypt(password)
+	if err != nil {
+		return errors.NewErrorWithSeverity(
+			errors.ErrCodeInternalServer,
+			"Failed to encrypt password",
+			err,
+			errors.SeverityHigh,
+			errors.CategorySecurity,
+		)
+	}
+	ds.Password = encryptedPassword
 	return nil
 }
 
+// checkPasswordRef checks that a user may have a secret reference resolved as a password
+func checkPasswordRef(ref string, isAdmin bool) error {
+	if !isAdmin {
+		return errors.NewError(errors.ErrCodeForbidden, "Only administrators can use secret references as passwords", nil)
+	}
+	if !utils.IsSecretReference(ref) {
+		return errors.NewBadRequestError("password_ref must be an env:, file: or vault: secret reference", nil)
+	}
+	if err := utils.CheckSecretReference(ref); err != nil {
+		return errors.NewBadRequestError("Secret reference is not allowed", err)
+	}
+	return nil
+}
+
+// hidePassword clears the password of a data source for responses, keeping the secret
+// reference it uses, which is not secret
+func hidePassword(ds *models.DataSource) {
+	if utils.IsSecretReference(ds.Password) {
+		ds.PasswordRef = ds.Password
+	}
+	ds.Password = ""
+}
+
 // ListDataSources retrieves data sources based on user permissions
 func (s *DataSourceService) ListDataSources(userID uint, isAdmin bool) ([]models.DataSource, error) {
 	dataSources, err := s.dsRepo.FindByUser(userID, isAdmin)
@@ -106,7 +140,7 @@ func (s *DataSourceService) ListDataSources(userID uint, isAdmin bool) ([]models
 		return nil, errors.WrapError(err, "Could not fetch data sources")
 	}
 	for i := range dataSources {
-		dataSources[i].Password = ""
+		hidePassword(&dataSources[i])
 	}
 	return dataSources, nil
 }
@@ -120,7 +154,7 @@ func (s *DataSourceService) GetDataSource(dsID uint, userID uint, isAdmin bool)
 	if !isAdmin && ds.UserID != userID && !ds.IsPublic {
 		return nil, errors.ErrForbidden
 	}
-	ds.Password = ""
+	hidePassword(ds)
 	return ds, nil
 }
 
@@ -155,17 +189,28 @@ func (s *DataSourceService) UpdateDataSource(dsID uint, updates *models.DataSour
 		ds.Description = updates.Description
 	}
 	ds.IsPublic = updates.IsPublic
-	if updates.Password != "" {
-		encryptedPassword, err := s.encryptionService.Encrypt(updates.Password)
-		if err != nil {
-			return nil, errors.WrapError(err, "Failed to encrypt password")
+	if err := validateDriverFields(ds); err != nil {
+		return nil, err
+	}
+	if updates.Replicas != "" {
+		ds.Replicas = updates.Replicas
+		if _, err := database.ParseReplicas(ds); err != nil {
+			return nil, errors.NewErrorWithSeverity(
+				errors.ErrCodeDataSourceInvalid,
+				"Invalid data source replicas",
+				err,
+				errors.SeverityMedium,
+				errors.CategoryValidation,
+			)
 		}
-		ds.Password = encryptedPassword
+	}
+	if err := s.storePassword(ds, updates.Password, updates.PasswordRef, isAdmin); err != nil {
+		return nil, err
 	}
 	if err := s.dsRepo.Update(ds); err != nil {
 		return nil, errors.WrapError(err, "Could not update data source")
 	}
-	ds.Password = ""
+	hidePassword(ds)
 	return ds, nil
 }
 
@@ -184,21 +229,24 @@ func (s *DataSourceService) DeleteDataSource(dsID uint, userID uint, isAdmin boo
 	return nil
 }
 
-// TestConnection tests the connection to a data source
-func (s *DataSourceService) TestConnection(ds *models.DataSource) error {
-	// Decrypt password
-	if ds.Password != "" {
-		decryptedPassword, err := utils.DecryptAES(ds.Password)
+// TestConnection tests the connection to a data source given in a request. Its password is
+// literal; a secret reference is resolved for admins within the allowlist only.
+func (s *DataSourceService) TestConnection(ds *models.DataSource, isAdmin bool) error {
+	if ds.PasswordRef != "" {
+		if err := checkPasswordRef(ds.PasswordRef, isAdmin); err != nil {
+			return err
+		}
+		resolved, err := utils.ResolveAllowedSecret(ds.PasswordRef)
 		if err != nil {
 			return errors.NewErrorWithSeverity(
 				errors.ErrCodeInternalServer,
-				"Could not decrypt password",
+				"Could not resolve secret reference",
 				err,
 				errors.SeverityHigh,
 				errors.CategorySecurity,
 			)
 		}
-		ds.Password = decryptedPassword
+		ds.Password = resolved
 	}
 
 	// Use the connection manager to test the connection
@@ -213,7 +261,20 @@ func (s *DataSourceService) TestConnection(ds *models.DataSource) error {
 		)
 	}
 
-	if err := db.Ping(); err != nil {
+	driver, err := database.GetDriver(ds.Type)
+	if err != nil {
+		return errors.NewErrorWithSeverity(
+			errors.ErrCodeDataSourceInvalid,
+			"Unsupported data source type",
+			err,
+			errors.SeverityMedium,
+			errors.CategoryValidation,
+		)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectionTestTimeout)
+	defer cancel()
+	if err := driver.TestConnection(ctx, db); err != nil {
 		return errors.NewErrorWithSeverity(
 			errors.ErrCodeDataSourceConnection,
 			"Database connection test failed",
@@ -225,3 +286,18 @@ func (s *DataSourceService) TestConnection(ds *models.DataSource) error {
 
 	return nil
 }
+
+// validateDriverFields checks the type-specific fields of a data source with its registered driver
+func validateDriverFields(ds *models.DataSource) error {
+	driver, err := database.GetDriver(ds.Type)
+	if err != nil {
+		return errors.NewErrorWithSeverity(
+			errors.ErrCodeDataSourceInvalid,
+			"Unsupported data source type",
+			err,
+			errors.SeverityMedium,
+			errors.CategoryValidation,
+		)
+	}
+	return driver.Validate(ds)
+}
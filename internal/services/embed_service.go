@@ -0,0 +1,540 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	errs "errors"
+	"fmt"
+	"gobi/config"
+	"gobi/internal/models"
+	"gobi/internal/repositories"
+	"gobi/pkg/charts"
+	"gobi/pkg/errors"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// embedKeyIDPrefix marks the key ID naming an embed secret in the kid token header
+	embedKeyIDPrefix = "ek_"
+	// embedSecretPrefix marks a plaintext embed secret
+	embedSecretPrefix = "emsec_"
+	// embedUsageLimit is the default number of usage records listed, up to maxEmbedUsageLimit
+	embedUsageLimit    = 100
+	maxEmbedUsageLimit = 1000
+)
+
+// EmbedTokenRequest asks for a token embedding a chart or dashboard. Filters are locked:
+// the embedding page cannot change them.
+type EmbedTokenRequest struct {
+	Resource   string                 `json:"resource" binding:"required"` // chart, dashboard
+	ResourceID uint                   `json:"resource_id" binding:"required"`
+	Filters    map[string]interface{} `json:"filters"`
+	TTL        int                    `json:"ttl"` // seconds; defaults to embed.default_token_ttl
+}
+
+// EmbedToken is a signed embed token and the public path serving it
+type EmbedToken struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// EmbedSecretInfo describes a user's embed secret. Secret is only set when it was just rotated.
+type EmbedSecretInfo struct {
+	KeyID     string    `json:"key_id"`
+	Secret    string    `json:"secret,omitempty"`
+	RotatedAt time.Time `json:"rotated_at"`
+}
+
+// EmbedClient identifies the client of a public embed request in the usage log
+type EmbedClient struct {
+	IPAddress string
+	UserAgent string
+}
+
+// EmbedChart is the public view of a chart; the query and data source stay private
+type EmbedChart struct {
+	ID          uint       `json:"id"`
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Description string     `json:"description"`
+	Config      string     `json:"config"`
+	Data        *ChartData `json:"data,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// EmbedTile is the public layout of a dashboard tile
+type EmbedTile struct {
+	ID               uint        `json:"id"`
+	Type             string      `json:"type"`
+	Title            string      `json:"title"`
+	Chart            *EmbedChart `json:"chart,omitempty"`
+	CrossFilter      string      `json:"cross_filter,omitempty"`
+	CrossFilterField string      `json:"cross_filter_field,omitempty"`
+	X                int         `json:"x"`
+	Y                int         `json:"y"`
+	Width            int         `json:"width"`
+	Height           int         `json:"height"`
+}
+
+// EmbedDashboard is the public view of a dashboard. Filters lists the filters the viewer
+// may change, with static options only.
+type EmbedDashboard struct {
+	ID          uint                     `json:"id"`
+	Name        string                   `json:"name"`
+	Description string                   `json:"description"`
+	Tiles       []EmbedTile              `json:"tiles"`
+	Filters     []DashboardFilterOptions `json:"filters"`
+	Data        *DashboardData           `json:"data"`
+}
+
+// EmbedView is what a public embed link serves
+type EmbedView struct {
+	Resource      string                 `json:"resource"`
+	Chart         *EmbedChart            `json:"chart,omitempty"`
+	Dashboard     *EmbedDashboard        `json:"dashboard,omitempty"`
+	LockedFilters map[string]interface{} `json:"locked_filters"`
+	ExpiresAt     time.Time              `json:"expires_at"`
+}
+
+// embedClaims are the claims of an embed token
+type embedClaims struct {
+	Resource   string                 `json:"resource"`
+	ResourceID uint                   `json:"resource_id"`
+	Filters    map[string]interface{} `json:"filters,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// EmbedService signs embed tokens and serves the charts and dashboards they name to
+// anonymous viewers, with the data the signer may see narrowed by the locked filters
+type EmbedService struct {
+	embedRepo         repositories.EmbedRepository
+	userRepo          repositories.UserRepository
+	chartRepo         repositories.ChartRepository
+	dashboardService  *DashboardService
+	encryptionService EncryptionService
+	cfg               config.EmbedConfig
+}
+
+// NewEmbedService creates a new EmbedService instance
+func NewEmbedService(
+	embedRepo repositories.EmbedRepository,
+	userRepo repositories.UserRepository,
+	chartRepo repositories.ChartRepository,
+	dashboardService *DashboardService,
+	encryptionService EncryptionService,
+	cfg config.EmbedConfig,
+) *EmbedService {
+	return &EmbedService{
+		embedRepo:         embedRepo,
+		userRepo:          userRepo,
+		chartRepo:         chartRepo,
+		dashboardService:  dashboardService,
+		encryptionService: encryptionService,
+		cfg:               cfg,
+	}
+}
+
+// GetSecret returns the key ID of a user's embed secret
+func (s *EmbedService) GetSecret(userID uint) (*EmbedSecretInfo, error) {
+	secret, err := s.embedRepo.FindSecretByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &EmbedSecretInfo{KeyID: secret.KeyID, RotatedAt: secret.RotatedAt}, nil
+}
+
+// RotateSecret replaces a user's embed secret, creating it on first use, and returns the
+// new secret. Every token signed with the previous secret stops working.
+func (s *EmbedService) RotateSecret(userID uint) (*EmbedSecretInfo, error) {
+	secret, err := s.embedRepo.FindSecretByUser(userID)
+	if errs.Is(err, errors.ErrNotFound) {
+		secret, err = &models.EmbedSecret{UserID: userID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keyBytes := make([]byte, 8)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, errors.WrapError(err, "Failed to generate embed secret")
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, errors.WrapError(err, "Failed to generate embed secret")
+	}
+	plain := embedSecretPrefix + base64.RawURLEncoding.EncodeToString(secretBytes)
+	encrypted, err := s.encryptionService.Encrypt(plain)
+	if err != nil {
+		return nil, errors.NewErrorWithSeverity(errors.ErrCodeInternalServer, "Could not encrypt embed secret", err, errors.SeverityHigh, errors.CategorySecurity)
+	}
+
+	secret.KeyID = embedKeyIDPrefix + hex.EncodeToString(keyBytes)
+	secret.Secret = encrypted
+	secret.RotatedAt = time.Now()
+	if err := s.embedRepo.SaveSecret(secret); err != nil {
+		return nil, err
+	}
+	return &EmbedSecretInfo{KeyID: secret.KeyID, Secret: plain, RotatedAt: secret.RotatedAt}, nil
+}
+
+// CreateToken signs an embed token with the user's embed secret, creating the secret if
+// the user has none yet. The user must be able to view the resource.
+func (s *EmbedService) CreateToken(req *EmbedTokenRequest, userID uint, isAdmin bool) (*EmbedToken, error) {
+	ttl := s.cfg.DefaultTokenTTL
+	if req.TTL != 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+	if ttl <= 0 || ttl > s.cfg.MaxTokenTTL {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("ttl must be between 1 and %d seconds", int(s.cfg.MaxTokenTTL/time.Second)), nil)
+	}
+	if err := s.checkResource(req.Resource, req.ResourceID, req.Filters, userID, isAdmin); err != nil {
+		return nil, err
+	}
+
+	secret, err := s.embedRepo.FindSecretByUser(userID)
+	if errs.Is(err, errors.ErrNotFound) {
+		if _, err = s.RotateSecret(userID); err == nil {
+			secret, err = s.embedRepo.FindSecretByUser(userID)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.encryptionService.Decrypt(secret.Secret)
+	if err != nil {
+		return nil, errors.NewErrorWithSeverity(errors.ErrCodeInternalServer, "Could not decrypt embed secret", err, errors.SeverityHigh, errors.CategorySecurity)
+	}
+
+	jti := make([]byte, 12)
+	if _, err := rand.Read(jti); err != nil {
+		return nil, errors.WrapError(err, "Failed to generate embed token")
+	}
+	now := time.Now()
+	claims := embedClaims{
+		Resource:   req.Resource,
+		ResourceID: req.ResourceID,
+		Filters:    req.Filters,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = secret.KeyID
+	signed, err := token.SignedString([]byte(key))
+	if err != nil {
+		return nil, errors.WrapError(err, "Failed to sign embed token")
+	}
+	return &EmbedToken{Token: signed, URL: "/embed/" + signed, ExpiresAt: claims.ExpiresAt.Time}, nil
+}
+
+// Serve validates an embed token and returns the chart or dashboard it names. The signer's
+// current permissions apply, and locked filters override the filter values requested.
+// Every request is recorded in the usage log.
+func (s *EmbedService) Serve(tokenString string, filterValues map[string]interface{}, client EmbedClient) (*EmbedView, error) {
+	usage := &models.EmbedUsage{IPAddress: client.IPAddress, UserAgent: truncate(client.UserAgent, 255), Status: "served"}
+	view, err := s.serve(tokenString, filterValues, usage)
+	if err != nil {
+		usage.Status = "failed"
+		var customErr *errors.CustomError
+		if errs.As(err, &customErr) && (customErr.Category == errors.CategoryAuth || customErr.Code == errors.ErrCodeForbidden || customErr.Code == errors.ErrCodeNotFound) {
+			usage.Status = "denied"
+		}
+		usage.Error = tileErrorMessage(err)
+	}
+	if recordErr := s.embedRepo.CreateUsage(usage); recordErr != nil {
+		errors.RecordError(errors.NewDatabaseError("Failed to record embed usage", recordErr))
+	}
+	return view, err
+}
+
+func (s *EmbedService) serve(tokenString string, filterValues map[string]interface{}, usage *models.EmbedUsage) (*EmbedView, error) {
+	claims, secret, err := s.parseToken(tokenString)
+	if secret != nil {
+		usage.UserID = secret.UserID
+		usage.KeyID = secret.KeyID
+	}
+	if err != nil {
+		return nil, err
+	}
+	usage.Resource = claims.Resource
+	usage.ResourceID = claims.ResourceID
+	usage.TokenID = claims.ID
+
+	user, err := s.userRepo.FindByID(secret.UserID)
+	if err != nil {
+		return nil, errors.ErrForbidden
+	}
+	isAdmin := user.Role == "admin"
+
+	view := &EmbedView{Resource: claims.Resource, LockedFilters: claims.Filters, ExpiresAt: claims.ExpiresAt.Time}
+	if view.LockedFilters == nil {
+		view.LockedFilters = map[string]interface{}{}
+	}
+	switch claims.Resource {
+	case "chart":
+		view.Chart, err = s.embedChart(claims.ResourceID, claims.Filters, user.ID, isAdmin)
+	case "dashboard":
+		view.Dashboard, err = s.embedDashboard(claims.ResourceID, claims.Filters, filterValues, user.ID, isAdmin)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// ListUsage returns the most recent requests to embed links signed by a user
+func (s *EmbedService) ListUsage(userID uint, isAdmin bool, limit int) ([]models.EmbedUsage, error) {
+	if limit <= 0 {
+		limit = embedUsageLimit
+	}
+	if limit > maxEmbedUsageLimit {
+		limit = maxEmbedUsageLimit
+	}
+	return s.embedRepo.FindUsage(userID, isAdmin, limit)
+}
+
+// parseToken verifies an embed token against the secret its kid header names. The secret
+// is returned whenever it was found, so that rejected tokens are attributed in the usage log.
+func (s *EmbedService) parseToken(tokenString string) (*embedClaims, *models.EmbedSecret, error) {
+	var secret *models.EmbedSecret
+	claims := &embedClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		found, err := s.embedRepo.FindSecretByKeyID(keyID)
+		if err != nil {
+			return nil, fmt.Errorf("unknown embed key %q", keyID)
+		}
+		secret = found
+		key, err := s.encryptionService.Decrypt(found.Secret)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(key), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		if errs.Is(err, jwt.ErrTokenExpired) {
+			return nil, secret, errors.ErrTokenExpired
+		}
+		return nil, secret, errors.NewErrorWithSeverity(errors.ErrCodeInvalidToken, "Invalid embed token", err, errors.SeverityMedium, errors.CategoryAuth)
+	}
+
+	lifetime := time.Until(claims.ExpiresAt.Time)
+	if claims.IssuedAt != nil {
+		lifetime = claims.ExpiresAt.Time.Sub(claims.IssuedAt.Time)
+	}
+	if lifetime > s.cfg.MaxTokenTTL {
+		return nil, secret, errors.NewErrorWithSeverity(errors.ErrCodeInvalidToken, fmt.Sprintf("Embed token must expire within %s", s.cfg.MaxTokenTTL), nil, errors.SeverityMedium, errors.CategoryAuth)
+	}
+	if (claims.Resource != "chart" && claims.Resource != "dashboard") || claims.ResourceID == 0 {
+		return nil, secret, errors.NewErrorWithSeverity(errors.ErrCodeInvalidToken, "Embed token must name a chart or dashboard", nil, errors.SeverityMedium, errors.CategoryAuth)
+	}
+	return claims, secret, nil
+}
+
+// checkResource verifies that a user may embed a resource with the given locked filters
+func (s *EmbedService) checkResource(resource string, resourceID uint, locked map[string]interface{}, userID uint, isAdmin bool) error {
+	switch resource {
+	case "chart":
+		chart, err := s.viewChart(resourceID, userID, isAdmin)
+		if err != nil {
+			return err
+		}
+		_, _, err = chartLockedFilters(chart, locked)
+		return err
+	case "dashboard":
+		dashboard, err := s.dashboardService.GetDashboard(resourceID, userID, isAdmin)
+		if err != nil {
+			return err
+		}
+		_, _, err = dashboardLockedFilters(dashboard.Filters, locked)
+		return err
+	}
+	return errors.NewBadRequestError("resource must be chart or dashboard", nil)
+}
+
+// viewChart returns a chart the user may view
+func (s *EmbedService) viewChart(chartID uint, userID uint, isAdmin bool) (*models.Chart, error) {
+	chart, err := s.chartRepo.FindByID(chartID)
+	if err != nil {
+		return nil, errors.ErrNotFound
+	}
+	if !isAdmin && chart.UserID != userID {
+		return nil, errors.ErrForbidden
+	}
+	return chart, nil
+}
+
+// embedChart loads a chart's data through a single-tile dashboard plan, so that locked
+// filters are bound exactly as dashboard filters are
+func (s *EmbedService) embedChart(chartID uint, locked map[string]interface{}, userID uint, isAdmin bool) (*EmbedChart, error) {
+	chart, err := s.viewChart(chartID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	values, mappings, err := chartLockedFilters(chart, locked)
+	if err != nil {
+		return nil, err
+	}
+	encoded, _ := json.Marshal(mappings)
+	dashboard := &models.Dashboard{Tiles: []models.DashboardTile{{Type: "chart", ChartID: chart.ID, FilterMappings: string(encoded)}}}
+	data, _ := s.dashboardService.loadTiles(dashboard, values, lockedNames(values))
+
+	result := publicChart(chart)
+	result.Data, result.Error = data.Tiles[0].Chart, data.Tiles[0].Error
+	return result, nil
+}
+
+// embedDashboard loads a dashboard's data with the locked filters applied over the
+// requested values
+func (s *EmbedService) embedDashboard(dashboardID uint, locked, requested map[string]interface{}, userID uint, isAdmin bool) (*EmbedDashboard, error) {
+	dashboard, err := s.dashboardService.GetDashboard(dashboardID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	_, names, err := dashboardLockedFilters(dashboard.Filters, locked)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]interface{}, len(requested)+len(locked))
+	for name, value := range requested {
+		merged[name] = value
+	}
+	for name, value := range locked {
+		merged[name] = value
+	}
+	values, err := resolveFilterValues(dashboard.Filters, merged)
+	if err != nil {
+		return nil, err
+	}
+
+	data, plan := s.dashboardService.loadTiles(dashboard, values, names)
+	result := &EmbedDashboard{
+		ID:          dashboard.ID,
+		Name:        dashboard.Name,
+		Description: dashboard.Description,
+		Tiles:       make([]EmbedTile, 0, len(dashboard.Tiles)),
+		Filters:     []DashboardFilterOptions{},
+		Data:        data,
+	}
+	for _, tile := range dashboard.Tiles {
+		et := EmbedTile{ID: tile.ID, Type: tile.Type, Title: tile.Title, X: tile.X, Y: tile.Y, Width: tile.Width, Height: tile.Height}
+		if tile.CrossFilter != "" && !names[tile.CrossFilter] {
+			et.CrossFilter, et.CrossFilterField = tile.CrossFilter, tile.CrossFilterField
+		}
+		if chart := plan.tileCharts[tile.ID]; chart != nil {
+			et.Chart = publicChart(chart)
+		}
+		result.Tiles = append(result.Tiles, et)
+	}
+	for _, filter := range dashboard.Filters {
+		if names[filter.Name] {
+			continue
+		}
+		options := DashboardFilterOptions{Name: filter.Name, Label: filter.Label, Type: filter.Type}
+		options.Default, _ = filterDefault(filter)
+		if options.Options, err = filterStaticOptions(filter); err != nil {
+			options.Error = err.Error()
+		}
+		result.Filters = append(result.Filters, options)
+	}
+	return result, nil
+}
+
+// dashboardLockedFilters validates locked values against a dashboard's filters. A locked
+// filter must have a value, or it would leave the tiles unrestricted.
+func dashboardLockedFilters(filters []models.DashboardFilter, locked map[string]interface{}) (map[string]interface{}, map[string]bool, error) {
+	values, err := resolveFilterValues(filters, locked)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fieldErrors []charts.FieldError
+	for _, name := range sortedKeys(locked) {
+		if values[name] == nil {
+			fieldErrors = append(fieldErrors, charts.FieldError{Field: "filters." + name, Message: "locked filters must have a value"})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return nil, nil, errors.NewBadRequestError("Invalid filter values", nil).WithDetails(map[string]interface{}{"fields": fieldErrors})
+	}
+	return values, lockedNames(locked), nil
+}
+
+// chartLockedFilters parses the locked values of a chart and maps each to the {{parameter}}
+// of the same name when the chart's SQL has one, and otherwise to the result column of that
+// name. Objects are date ranges, arrays match any of their items.
+func chartLockedFilters(chart *models.Chart, locked map[string]interface{}) (map[string]interface{}, map[string]filterTarget, error) {
+	if chart.QueryID == 0 && len(locked) > 0 {
+		return nil, nil, errors.NewBadRequestError("Charts with static data cannot be embedded with locked filters", nil)
+	}
+	values := make(map[string]interface{}, len(locked))
+	mappings := make(map[string]filterTarget, len(locked))
+	var fieldErrors []charts.FieldError
+	for _, name := range sortedKeys(locked) {
+		if !filterNamePattern.MatchString(name) {
+			fieldErrors = append(fieldErrors, charts.FieldError{Field: "filters." + name, Message: "must be a column or parameter name"})
+			continue
+		}
+		filterType := "select"
+		switch locked[name].(type) {
+		case map[string]interface{}:
+			filterType = "date_range"
+		case []interface{}:
+			filterType = "multi_select"
+		}
+		value, err := parseFilterValue(filterType, locked[name])
+		if err == nil && value == nil {
+			err = fmt.Errorf("locked filters must have a value")
+		}
+		if err != nil {
+			fieldErrors = append(fieldErrors, charts.FieldError{Field: "filters." + name, Message: err.Error()})
+			continue
+		}
+		values[name] = value
+		if filterApplies(chart.Query.SQL, filterTarget{Parameter: name}) {
+			mappings[name] = filterTarget{Parameter: name}
+		} else {
+			mappings[name] = filterTarget{Column: name}
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return nil, nil, errors.NewBadRequestError("Invalid filter values", nil).WithDetails(map[string]interface{}{"fields": fieldErrors})
+	}
+	return values, mappings, nil
+}
+
+// publicChart returns the public view of a chart without its data
+func publicChart(chart *models.Chart) *EmbedChart {
+	return &EmbedChart{ID: chart.ID, Name: chart.Name, Type: chart.Type, Description: chart.Description, Config: chart.Config}
+}
+
+func lockedNames(locked map[string]interface{}) map[string]bool {
+	names := make(map[string]bool, len(locked))
+	for name := range locked {
+		names[name] = true
+	}
+	return names
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func truncate(text string, max int) string {
+	if len(text) > max {
+		return text[:max]
+	}
+	return text
+}
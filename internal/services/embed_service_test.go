@@ -0,0 +1,69 @@
+package services
+
+import (
+	"gobi/config"
+	"gobi/internal/models"
+	"gobi/internal/repositories"
+	"gobi/internal/services/infrastructure"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// newEmbedTestService wires an EmbedService over a throwaway database
+func newEmbedTestService(t *testing.T) (*gorm.DB, *EmbedService) {
+	t.Helper()
+	db := newTestDB(t, &models.User{}, &models.DataSource{}, &models.Query{}, &models.Chart{},
+		&models.Dashboard{}, &models.DashboardTile{}, &models.DashboardFilter{}, &models.DashboardShare{})
+	userRepo := repositories.NewUserRepository(db)
+	dashboardRepo := repositories.NewDashboardRepository(db)
+	chartRepo := repositories.NewChartRepository(db)
+	dashboardService := NewDashboardService(dashboardRepo, chartRepo, repositories.NewQueryRepository(db), userRepo,
+		infrastructure.NewPermissionService(userRepo, dashboardRepo), nil, nil, nil, nil)
+	return db, NewEmbedService(nil, userRepo, chartRepo, dashboardService, nil, config.EmbedConfig{})
+}
+
+func TestEmbedDoesNotLeakStaticChartRowsPastLockedFilters(t *testing.T) {
+	db, service := newEmbedTestService(t)
+	owner := &models.User{Username: "owner", Email: "owner@example.com", Role: "user"}
+	db.Create(owner)
+	// Static rows of every tenant: no query can restrict them to the locked one
+	chart := &models.Chart{UserID: owner.ID, Name: "Revenue", Type: "bar",
+		Data: `[{"x": "tenant-42", "y": 10}, {"x": "tenant-7", "y": 99}]`}
+	db.Create(chart)
+	dashboard := &models.Dashboard{UserID: owner.ID, Name: "Tenants",
+		Filters: []models.DashboardFilter{{Name: "tenant", Label: "Tenant", Type: "select"}},
+		Tiles: []models.DashboardTile{{Type: "chart", ChartID: chart.ID, Width: 6, Height: 4,
+			FilterMappings: `{"tenant": {"column": "tenant"}}`}},
+	}
+	db.Create(dashboard)
+	locked := map[string]interface{}{"tenant": float64(42)}
+
+	// Without locked filters the static rows are shown as usual
+	open, err := service.embedDashboard(dashboard.ID, nil, nil, owner.ID, false)
+	if err != nil {
+		t.Fatalf("embedDashboard: %v", err)
+	}
+	if tile := open.Data.Tiles[0]; tile.Chart == nil || tile.Chart.RowCount != 2 {
+		t.Fatalf("unlocked tile = %+v, want the 2 static rows", tile)
+	}
+
+	embedded, err := service.embedDashboard(dashboard.ID, locked, nil, owner.ID, false)
+	if err != nil {
+		t.Fatalf("embedDashboard: %v", err)
+	}
+	tile := embedded.Data.Tiles[0]
+	if tile.Chart != nil {
+		t.Errorf("locked dashboard served %d static rows: %+v", tile.Chart.RowCount, tile.Chart.Rows)
+	}
+	if tile.Error == "" {
+		t.Error("locked dashboard tile has no error, want the static tile refused")
+	}
+
+	if _, err := service.embedChart(chart.ID, locked, owner.ID, false); err == nil {
+		t.Error("embedChart of a static chart with locked filters succeeded, want an error")
+	}
+	if err := service.checkResource("chart", chart.ID, locked, owner.ID, false); err == nil {
+		t.Error("signing a locked token for a static chart succeeded, want an error")
+	}
+}
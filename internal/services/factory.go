@@ -1,7 +1,10 @@
 package services
 
 import (
+	"gobi/config"
 	"gobi/internal/repositories"
+	"gobi/internal/services/infrastructure"
+	"gobi/pkg/blob"
 
 	"gorm.io/gorm"
 )
@@ -90,6 +93,24 @@ func (f *ServiceFactory) CreateChartService() *ChartService {
 		chartRepo,
 		*queryService,
 		f.cacheService,
+		f.CreateBoundaryService(),
+	)
+}
+
+// CreateBoundaryService creates a BoundaryService with all dependencies
+func (f *ServiceFactory) CreateBoundaryService() *BoundaryService {
+	return NewBoundaryService(
+		repositories.NewBoundaryRepository(f.db),
+		f.cacheService,
+		config.AppConfig.Geo,
+	)
+}
+
+// CreateDrillService creates a DrillService with all dependencies
+func (f *ServiceFactory) CreateDrillService() *DrillService {
+	return NewDrillService(
+		f.CreateChartService(),
+		f.CreateDashboardService(),
 	)
 }
 
@@ -98,8 +119,9 @@ func (f *ServiceFactory) CreateReportService() *ReportService {
 	reportRepo := repositories.NewReportRepository(f.db)
 	return NewReportService(
 		reportRepo,
-		f.reportGenerator,
+		f.CreateReportGenerationService(),
 		f.permissionService,
+		f.blobStore(),
 	)
 }
 
@@ -109,6 +131,7 @@ func (f *ServiceFactory) CreateTemplateService() *TemplateService {
 	return NewTemplateService(
 		templateRepo,
 		f.permissionService,
+		f.blobStore(),
 	)
 }
 
@@ -119,6 +142,7 @@ func (f *ServiceFactory) CreateWebhookService() *WebhookService {
 		webhookRepo,
 		f.webhookTrigger,
 		f.permissionService,
+		f.encryptionService,
 	)
 }
 
@@ -129,7 +153,102 @@ func (f *ServiceFactory) CreateReportGenerationService() *ReportGenerationServic
 		f.db,
 		webhookRepo,
 		f.webhookTrigger,
+		f.CreateDashboardService(),
+		f.CreateChartService(),
+		f.CreateReportDeliveryService(),
+		f.blobStore(),
+		config.AppConfig.PDF,
 	)
 }
 
+// CreateReportDeliveryService creates a ReportDeliveryService with all dependencies
+func (f *ServiceFactory) CreateReportDeliveryService() *ReportDeliveryService {
+	cfg := config.AppConfig.Email
+	var sender MailSender
+	if cfg.Host != "" {
+		sender = NewSMTPMailSender(cfg)
+	}
+	return NewReportDeliveryService(f.db, sender, f.blobStore(), cfg, config.AppConfig.JWT.Secret)
+}
+
+// CreateReportScheduler creates a ReportScheduler with all dependencies
+func (f *ServiceFactory) CreateReportScheduler() *ReportScheduler {
+	return NewReportScheduler(f.db, f.CreateReportGenerationService(), config.AppConfig.Scheduler)
+}
+
+// CreateReportRetentionService creates a ReportRetentionService with all dependencies
+func (f *ServiceFactory) CreateReportRetentionService() *ReportRetentionService {
+	return NewReportRetentionService(f.db, f.blobStore(), config.AppConfig.Retention)
+}
+
+// CreateKeyRotationService creates a KeyRotationService with all dependencies
+func (f *ServiceFactory) CreateKeyRotationService() *KeyRotationService {
+	return NewKeyRotationService(
+		repositories.NewDataSourceRepository(f.db),
+		repositories.NewWebhookRepository(f.db),
+		repositories.NewEmbedRepository(f.db),
+		f.encryptionService,
+	)
+}
+
+// CreateDataProfileService creates a DataProfileService with all dependencies
+func (f *ServiceFactory) CreateDataProfileService() *DataProfileService {
+	return NewDataProfileService(
+		repositories.NewDataSourceRepository(f.db),
+		repositories.NewDataProfileRepository(f.db),
+		f.encryptionService,
+	)
+}
+
+// CreateDashboardService creates a DashboardService with all dependencies
+func (f *ServiceFactory) CreateDashboardService() *DashboardService {
+	return NewDashboardService(
+		repositories.NewDashboardRepository(f.db),
+		repositories.NewChartRepository(f.db),
+		repositories.NewQueryRepository(f.db),
+		repositories.NewUserRepository(f.db),
+		f.permissionService,
+		f.validationService,
+		f.encryptionService,
+		f.optimizedExecutor(),
+		f.CreateBoundaryService(),
+	)
+}
+
+// CreateDashboardHub creates the hub streaming live dashboard updates. The server needs a
+// single hub so that every client of a dashboard shares its refreshes.
+func (f *ServiceFactory) CreateDashboardHub() *DashboardHub {
+	return NewDashboardHub(f.CreateDashboardService(), config.AppConfig.Realtime)
+}
+
+// CreateEmbedService creates an EmbedService with all dependencies
+func (f *ServiceFactory) CreateEmbedService() *EmbedService {
+	return NewEmbedService(
+		repositories.NewEmbedRepository(f.db),
+		repositories.NewUserRepository(f.db),
+		repositories.NewChartRepository(f.db),
+		f.CreateDashboardService(),
+		f.encryptionService,
+		config.AppConfig.Embed,
+	)
+}
+
+// blobStore returns the store of report and template files opened at startup
+func (f *ServiceFactory) blobStore() BlobStore {
+	return blob.Default()
+}
+
+// optimizedExecutor returns the configured SQL execution service when it is the optimized
+// one, and otherwise an optimized executor sharing the factory's cache
+func (f *ServiceFactory) optimizedExecutor() *infrastructure.OptimizedSQLExecutionService {
+	if optimized, ok := f.sqlExecutionService.(*infrastructure.OptimizedSQLExecutionService); ok {
+		return optimized
+	}
+	cache, ok := f.cacheService.(*infrastructure.CacheService)
+	if !ok {
+		cache = infrastructure.NewCacheService(config.AppConfig)
+	}
+	return infrastructure.NewOptimizedSQLExecutionService(cache)
+}
+
 // 你可以继续为其他 Service 添加类似的 CreateXXXService 方法
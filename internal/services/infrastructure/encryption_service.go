@@ -12,12 +12,23 @@ func NewEncryptionService() *EncryptionServiceImpl {
 	return &EncryptionServiceImpl{}
 }
 
-// Encrypt encrypts data using AES
+// Encrypt encrypts data with the active keyring key, falling back to legacy AES. Values that
+// look like secret references are encrypted as literals; references are stored as-is by
+// their callers.
 func (s *EncryptionServiceImpl) Encrypt(data string) (string, error) {
-	return utils.EncryptAES(data)
+	return utils.EncryptSecret(data)
 }
 
-// Decrypt decrypts data using AES
+// Decrypt decrypts envelope or legacy AES ciphertexts and resolves stored secret references
+// within the allowlist of secrets.allowed_*
 func (s *EncryptionServiceImpl) Decrypt(data string) (string, error) {
-	return utils.DecryptAES(data)
+	if utils.IsSecretReference(data) {
+		return utils.ResolveAllowedSecret(data)
+	}
+	return utils.DecryptSecret(data)
+}
+
+// NeedsRotation reports whether data is not encrypted with the active key
+func (s *EncryptionServiceImpl) NeedsRotation(data string) bool {
+	return utils.SecretNeedsRotation(data)
 }
@@ -2,6 +2,7 @@ package infrastructure
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
@@ -37,6 +38,7 @@ type ExecutionResult struct {
 	ExecutionTime time.Duration            `json:"execution_time"`
 	CacheHit      bool                     `json:"cache_hit"`
 	QueryPlan     *database.QueryPlan      `json:"query_plan,omitempty"`
+	Endpoint      string                   `json:"endpoint,omitempty"` // primary or replica:<host>:<port>
 	Error         error                    `json:"error,omitempty"`
 }
 
@@ -51,12 +53,13 @@ func NewOptimizedSQLExecutionService(cacheService *CacheService) *OptimizedSQLEx
 	}
 }
 
-// ExecuteWithOptimization executes a query with full optimization analysis
-func (s *OptimizedSQLExecutionService) ExecuteWithOptimization(ctx context.Context, ds models.DataSource, sql string) (*ExecutionResult, error) {
+// ExecuteWithOptimization executes a query with full optimization analysis. args are bound
+// to the query's placeholders and are part of the cache key.
+func (s *OptimizedSQLExecutionService) ExecuteWithOptimization(ctx context.Context, ds models.DataSource, sql string, args ...interface{}) (*ExecutionResult, error) {
 	startTime := time.Now()
 
 	// Check cache first
-	cacheKey := s.generateCacheKey(ds.ID, sql)
+	cacheKey := s.CacheKey(ds.ID, sql, args...)
 	if cached, found := s.cacheService.Get(cacheKey); found {
 		s.updateStats(true, time.Since(startTime), false)
 		if result, ok := cached.([]map[string]interface{}); ok {
@@ -69,7 +72,7 @@ func (s *OptimizedSQLExecutionService) ExecuteWithOptimization(ctx context.Conte
 	}
 
 	// Execute with optimization analysis
-	results, plan, err := s.optimizer.ExecuteWithOptimization(ctx, ds, sql)
+	results, plan, err := s.optimizer.ExecuteWithOptimization(ctx, ds, sql, args...)
 
 	executionTime := time.Since(startTime)
 	s.updateStats(false, executionTime, err != nil)
@@ -89,6 +92,7 @@ func (s *OptimizedSQLExecutionService) ExecuteWithOptimization(ctx context.Conte
 		ExecutionTime: executionTime,
 		CacheHit:      false,
 		QueryPlan:     plan,
+		Endpoint:      plan.Endpoint,
 	}, nil
 }
 
@@ -99,7 +103,7 @@ func (s *OptimizedSQLExecutionService) ExecuteWithTimeout(ctx context.Context, d
 	defer cancel()
 
 	// Check cache first
-	cacheKey := s.generateCacheKey(ds.ID, sql)
+	cacheKey := s.CacheKey(ds.ID, sql)
 	if cached, found := s.cacheService.Get(cacheKey); found {
 		if result, ok := cached.([]map[string]interface{}); ok {
 			return &ExecutionResult{
@@ -132,6 +136,7 @@ func (s *OptimizedSQLExecutionService) ExecuteWithTimeout(ctx context.Context, d
 		ExecutionTime: executionTime,
 		CacheHit:      false,
 		QueryPlan:     plan,
+		Endpoint:      plan.Endpoint,
 	}, nil
 }
 
@@ -139,14 +144,29 @@ func (s *OptimizedSQLExecutionService) ExecuteWithTimeout(ctx context.Context, d
 func (s *OptimizedSQLExecutionService) ExecuteWithLimit(ctx context.Context, ds models.DataSource, sql string, limit int) (*ExecutionResult, error) {
 	// Check if SQL already has LIMIT
 	if !s.containsLimit(sql) {
-		sql = s.addLimitClause(sql, limit)
+		sql = s.addLimitClause(ds.Type, sql, limit)
 	}
 
 	return s.ExecuteWithOptimization(ctx, ds, sql)
 }
 
+// BoundQuery is a query together with the arguments bound to its placeholders
+type BoundQuery struct {
+	SQL  string
+	Args []interface{}
+}
+
 // ExecuteBatch executes multiple queries in batch with optimization
 func (s *OptimizedSQLExecutionService) ExecuteBatch(ctx context.Context, ds models.DataSource, queries []string) ([]*ExecutionResult, error) {
+	bound := make([]BoundQuery, len(queries))
+	for i, query := range queries {
+		bound[i] = BoundQuery{SQL: query}
+	}
+	return s.ExecuteBoundBatch(ctx, ds, bound)
+}
+
+// ExecuteBoundBatch executes multiple parameterized queries in batch with optimization
+func (s *OptimizedSQLExecutionService) ExecuteBoundBatch(ctx context.Context, ds models.DataSource, queries []BoundQuery) ([]*ExecutionResult, error) {
 	results := make([]*ExecutionResult, len(queries))
 
 	// Execute queries concurrently with semaphore to limit concurrency
@@ -155,13 +175,13 @@ func (s *OptimizedSQLExecutionService) ExecuteBatch(ctx context.Context, ds mode
 
 	for i, query := range queries {
 		wg.Add(1)
-		go func(index int, sql string) {
+		go func(index int, query BoundQuery) {
 			defer wg.Done()
 
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
-			result, err := s.ExecuteWithOptimization(ctx, ds, sql)
+			result, err := s.ExecuteWithOptimization(ctx, ds, query.SQL, query.Args...)
 			if err != nil {
 				result = &ExecutionResult{Error: err}
 			}
@@ -174,6 +194,11 @@ func (s *OptimizedSQLExecutionService) ExecuteBatch(ctx context.Context, ds mode
 	return results, nil
 }
 
+// InvalidateCache drops the cached results of a query so the next execution hits the database
+func (s *OptimizedSQLExecutionService) InvalidateCache(datasourceID uint, sql string, args ...interface{}) {
+	s.cacheService.Delete(s.CacheKey(datasourceID, sql, args...))
+}
+
 // GetOptimizationStats returns optimization statistics
 func (s *OptimizedSQLExecutionService) GetOptimizationStats() map[string]interface{} {
 	s.mu.RLock()
@@ -334,9 +359,16 @@ func (s *OptimizedSQLExecutionService) isComplexQuery(sql string) bool {
 	return complexityScore >= 3
 }
 
-// generateCacheKey generates a unique cache key for a query
-func (s *OptimizedSQLExecutionService) generateCacheKey(datasourceID uint, sql string) string {
-	return utils.GenerateCacheKey(datasourceID, sql)
+// CacheKey returns the cache key of a query and its bound arguments
+func (s *OptimizedSQLExecutionService) CacheKey(datasourceID uint, sql string, args ...interface{}) string {
+	if len(args) == 0 {
+		return utils.GenerateCacheKey(datasourceID, sql)
+	}
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		encoded = []byte(fmt.Sprintf("%#v", args))
+	}
+	return utils.GenerateCacheKey(datasourceID, sql+"\x00"+string(encoded))
 }
 
 // containsLimit checks if SQL already contains a LIMIT clause
@@ -344,7 +376,10 @@ func (s *OptimizedSQLExecutionService) containsLimit(sql string) bool {
 	return utils.Contains(strings.ToUpper(sql), "LIMIT")
 }
 
-// addLimitClause adds a LIMIT clause to SQL
-func (s *OptimizedSQLExecutionService) addLimitClause(sql string, limit int) string {
+// addLimitClause limits SQL in the dialect of the data source type
+func (s *OptimizedSQLExecutionService) addLimitClause(dsType, sql string, limit int) string {
+	if driver, err := database.GetDriver(dsType); err == nil {
+		return driver.LimitQuery(sql, limit)
+	}
 	return fmt.Sprintf("%s LIMIT %d", sql, limit)
 }
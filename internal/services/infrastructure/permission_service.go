@@ -6,13 +6,15 @@ import (
 
 // PermissionServiceImpl implements PermissionService
 type PermissionServiceImpl struct {
-	userRepo repositories.UserRepository
+	userRepo      repositories.UserRepository
+	dashboardRepo repositories.DashboardRepository
 }
 
 // NewPermissionService creates a new PermissionService instance
-func NewPermissionService(userRepo repositories.UserRepository) *PermissionServiceImpl {
+func NewPermissionService(userRepo repositories.UserRepository, dashboardRepo repositories.DashboardRepository) *PermissionServiceImpl {
 	return &PermissionServiceImpl{
-		userRepo: userRepo,
+		userRepo:      userRepo,
+		dashboardRepo: dashboardRepo,
 	}
 }
 
@@ -31,6 +33,8 @@ func (s *PermissionServiceImpl) CanAccess(userID uint, resourceID uint, resource
 		return s.canAccessReport(userID, resourceID)
 	case "datasource":
 		return s.canAccessDataSource(userID, resourceID)
+	case "dashboard":
+		return s.canAccessDashboard(userID, resourceID)
 	default:
 		return false
 	}
@@ -47,6 +51,8 @@ func (s *PermissionServiceImpl) CheckOwnership(userID uint, resourceID uint, res
 		return s.checkReportOwnership(userID, resourceID)
 	case "datasource":
 		return s.checkDataSourceOwnership(userID, resourceID)
+	case "dashboard":
+		return s.checkDashboardOwnership(userID, resourceID)
 	default:
 		return false
 	}
@@ -80,6 +86,24 @@ func (s *PermissionServiceImpl) canAccessDataSource(userID uint, datasourceID ui
 	return s.checkDataSourceOwnership(userID, datasourceID)
 }
 
+// canAccessDashboard allows the owner, every user for public dashboards and the users
+// the dashboard is shared with
+func (s *PermissionServiceImpl) canAccessDashboard(userID uint, dashboardID uint) bool {
+	dashboard, err := s.dashboardRepo.FindByID(dashboardID)
+	if err != nil {
+		return false
+	}
+	if dashboard.UserID == userID || dashboard.IsPublic {
+		return true
+	}
+	for _, share := range dashboard.Shares {
+		if share.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *PermissionServiceImpl) checkQueryOwnership(_ uint, _ uint) bool {
 	// This would need to be implemented with actual database queries
 	// For now, we'll return true as a placeholder
@@ -103,3 +127,11 @@ func (s *PermissionServiceImpl) checkDataSourceOwnership(_ uint, _ uint) bool {
 	// For now, we'll return true as a placeholder
 	return true
 }
+
+func (s *PermissionServiceImpl) checkDashboardOwnership(userID uint, dashboardID uint) bool {
+	dashboard, err := s.dashboardRepo.FindByID(dashboardID)
+	if err != nil {
+		return false
+	}
+	return dashboard.UserID == userID
+}
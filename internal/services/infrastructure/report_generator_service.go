@@ -1,8 +1,14 @@
 package infrastructure
 
 import (
+	"encoding/json"
 	"fmt"
+	"gobi/config"
+	"gobi/pkg/pdf"
 	"gobi/pkg/utils"
+	"sort"
+	"strconv"
+	"time"
 )
 
 // ReportGeneratorServiceImpl implements ReportGeneratorService
@@ -18,9 +24,82 @@ func (s *ReportGeneratorServiceImpl) GenerateExcelFromTemplate(data string, temp
 	return utils.GenerateExcelFromTemplate(data, template, filename)
 }
 
-// GeneratePDFFromTemplate generates a PDF report from template
+// GeneratePDFFromTemplate generates a PDF report of JSON data rows. The template is a
+// JSON layout template and the filename the title of the report.
 func (s *ReportGeneratorServiceImpl) GeneratePDFFromTemplate(data string, template []byte, filename string) ([]byte, error) {
-	// For now, return a simple PDF-like structure
-	// In a real implementation, you would use a PDF library like gofpdf or similar
-	return []byte(fmt.Sprintf("PDF Report: %s\nData: %s", filename, data)), nil
+	var rows []map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &rows); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal report data: %w", err)
+	}
+	layout, err := pdf.ParseLayout(template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PDF layout: %w", err)
+	}
+
+	var fontConfig config.PDFConfig
+	if config.AppConfig != nil {
+		fontConfig = config.AppConfig.PDF
+	}
+	fonts, err := pdf.LoadFonts(fontConfig.FontPath, fontConfig.BoldFontPath, fontConfig.FontIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load PDF fonts: %w", err)
+	}
+
+	// Columns in name order, as the rows do not keep theirs
+	seen := map[string]bool{}
+	var columns []string
+	for _, row := range rows {
+		for column := range row {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	sort.Strings(columns)
+	table := pdf.Table{Columns: columns, Align: make([]pdf.Align, len(columns))}
+	for i, column := range columns {
+		if numericColumn(rows, column) {
+			table.Align[i] = pdf.AlignRight
+		}
+	}
+	for _, row := range rows {
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			switch value := row[column].(type) {
+			case nil:
+			case float64:
+				cells[i] = strconv.FormatFloat(value, 'f', -1, 64)
+			default:
+				cells[i] = fmt.Sprint(value)
+			}
+		}
+		table.Rows = append(table.Rows, cells)
+	}
+
+	now := time.Now()
+	doc := pdf.New(layout, fonts)
+	doc.SetInfo(filename, "", now)
+	doc.TitlePage(filename, now.Format("2006-01-02 15:04"), nil)
+	if len(rows) == 0 {
+		doc.Note("No data")
+	} else {
+		doc.Table(table)
+	}
+	return doc.Bytes()
+}
+
+// numericColumn reports whether every value of a column is a number
+func numericColumn(rows []map[string]interface{}, column string) bool {
+	numeric := false
+	for _, row := range rows {
+		switch row[column].(type) {
+		case nil:
+		case float64:
+			numeric = true
+		default:
+			return false
+		}
+	}
+	return numeric
 }
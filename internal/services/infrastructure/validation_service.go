@@ -1,8 +1,9 @@
 package infrastructure
 
 import (
-	"encoding/json"
 	"gobi/internal/models"
+	"gobi/pkg/charts"
+	"gobi/pkg/database"
 	"gobi/pkg/errors"
 	"gobi/pkg/utils"
 )
@@ -22,39 +23,7 @@ func (s *ValidationServiceImpl) ValidateSQL(sql string) error {
 
 // ValidateChartType validates chart type
 func (s *ValidationServiceImpl) ValidateChartType(chartType string) error {
-	validChartTypes := map[string]bool{
-		"bar":               true,
-		"line":              true,
-		"pie":               true,
-		"scatter":           true,
-		"radar":             true,
-		"heatmap":           true,
-		"gauge":             true,
-		"funnel":            true,
-		"area":              true,
-		"3d-bar":            true,
-		"3d-scatter":        true,
-		"3d-surface":        true,
-		"3d-bubble":         true,
-		"treemap":           true,
-		"sunburst":          true,
-		"tree":              true,
-		"boxplot":           true,
-		"candlestick":       true,
-		"wordcloud":         true,
-		"graph":             true,
-		"waterfall":         true,
-		"polar":             true,
-		"gantt":             true,
-		"rose":              true,
-		"geo":               true,
-		"map":               true,
-		"choropleth":        true,
-		"progress":          true,
-		"circular-progress": true,
-	}
-
-	if !validChartTypes[chartType] {
+	if _, ok := charts.Lookup(chartType); !ok {
 		return errors.ErrInvalidChartType
 	}
 
@@ -71,44 +40,39 @@ func (s *ValidationServiceImpl) ValidateDataSource(ds *models.DataSource) error
 		return errors.ErrDataSourceTypeRequired
 	}
 
-	if ds.Host == "" {
-		return errors.ErrDataSourceHostRequired
+	driver, err := database.GetDriver(ds.Type)
+	if err != nil {
+		return errors.NewError(errors.ErrCodeDataSourceInvalid, "Unsupported data source type", err)
 	}
 
-	if ds.Port == 0 {
-		return errors.ErrDataSourcePortRequired
-	}
-
-	if ds.Database == "" {
-		return errors.ErrDataSourceDatabaseRequired
-	}
-
-	return nil
+	return driver.Validate(ds)
 }
 
-// ValidateChartConfig validates chart configuration JSON
-func (s *ValidationServiceImpl) ValidateChartConfig(config string) error {
-	if config == "" {
-		return nil // Empty config is valid
+// ValidateChartConfig validates chart configuration against the schema of its type
+func (s *ValidationServiceImpl) ValidateChartConfig(chartType, config string) error {
+	t, ok := charts.Lookup(chartType)
+	if !ok {
+		return errors.ErrInvalidChartType
 	}
 
-	var configMap map[string]interface{}
-	if err := json.Unmarshal([]byte(config), &configMap); err != nil {
-		return errors.ErrInvalidChartConfig
+	if fieldErrors := t.ValidateConfig(config); len(fieldErrors) > 0 {
+		return errors.NewErrorWithDetails(errors.ErrCodeInvalidChartConfig, "Invalid chart configuration", nil,
+			map[string]interface{}{"fields": fieldErrors})
 	}
 
 	return nil
 }
 
-// ValidateChartData validates chart data JSON
-func (s *ValidationServiceImpl) ValidateChartData(data string) error {
-	if data == "" {
-		return nil // Empty data is valid
+// ValidateChartData validates that chart data has the shape its type requires
+func (s *ValidationServiceImpl) ValidateChartData(chartType, config, data string) error {
+	t, ok := charts.Lookup(chartType)
+	if !ok {
+		return errors.ErrInvalidChartType
 	}
 
-	var dataArray []map[string]interface{}
-	if err := json.Unmarshal([]byte(data), &dataArray); err != nil {
-		return errors.ErrInvalidChartData
+	if fieldErrors := t.ValidateData("", config, data); len(fieldErrors) > 0 {
+		return errors.NewErrorWithDetails(errors.ErrCodeInvalidChartData, "Invalid chart data", nil,
+			map[string]interface{}{"fields": fieldErrors})
 	}
 
 	return nil
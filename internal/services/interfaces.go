@@ -1,7 +1,10 @@
 package services
 
 import (
+	"context"
 	"gobi/internal/models"
+	"gobi/pkg/mail"
+	"io"
 	"time"
 )
 
@@ -18,6 +21,7 @@ type CacheService interface {
 type EncryptionService interface {
 	Encrypt(data string) (string, error)
 	Decrypt(data string) (string, error)
+	NeedsRotation(data string) bool
 }
 
 // AuthService defines the interface for authentication operations
@@ -40,8 +44,8 @@ type ValidationService interface {
 	ValidateSQL(sql string) error
 	ValidateChartType(chartType string) error
 	ValidateDataSource(ds *models.DataSource) error
-	ValidateChartConfig(config string) error
-	ValidateChartData(data string) error
+	ValidateChartConfig(chartType, config string) error
+	ValidateChartData(chartType, config, data string) error
 }
 
 // SQLExecutionService defines the interface for SQL execution
@@ -63,6 +67,18 @@ type WebhookTriggerService interface {
 	ValidateWebhookURL(url string) error
 }
 
+// MailSender defines the interface for sending email
+type MailSender interface {
+	Send(msg *mail.Message) error
+}
+
+// BlobStore defines the interface for storing report and template files
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
 // ReportRepository defines the interface for report data operations
 type ReportRepository interface {
 	Create(report *models.Report) error
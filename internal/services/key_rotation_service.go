@@ -0,0 +1,148 @@
+package services
+
+import (
+	"gobi/internal/repositories"
+	"gobi/pkg/errors"
+	"gobi/pkg/utils"
+	"strings"
+)
+
+// KeyRotationService re-encrypts stored secrets with the active encryption key
+type KeyRotationService struct {
+	dsRepo            repositories.DataSourceRepository
+	webhookRepo       repositories.WebhookRepository
+	embedRepo         repositories.EmbedRepository
+	encryptionService EncryptionService
+}
+
+// KeyRotationResult summarizes a key rotation run
+type KeyRotationResult struct {
+	ActiveKeyID         string   `json:"active_key_id"`
+	DataSourcesRotated  int      `json:"datasources_rotated"`
+	DataSourcesSkipped  int      `json:"datasources_skipped"`
+	WebhooksRotated     int      `json:"webhooks_rotated"`
+	WebhooksSkipped     int      `json:"webhooks_skipped"`
+	EmbedSecretsRotated int      `json:"embed_secrets_rotated"`
+	EmbedSecretsSkipped int      `json:"embed_secrets_skipped"`
+	Failures            []string `json:"failures,omitempty"`
+}
+
+// NewKeyRotationService creates a new KeyRotationService instance
+func NewKeyRotationService(
+	dsRepo repositories.DataSourceRepository,
+	webhookRepo repositories.WebhookRepository,
+	embedRepo repositories.EmbedRepository,
+	encryptionService EncryptionService,
+) *KeyRotationService {
+	return &KeyRotationService{
+		dsRepo:            dsRepo,
+		webhookRepo:       webhookRepo,
+		embedRepo:         embedRepo,
+		encryptionService: encryptionService,
+	}
+}
+
+// RotateAll re-encrypts every datasource password, webhook secret and embed secret
+// that is not yet encrypted with the active key. Old keys stay in the keyring, so
+// records that fail to rotate remain readable.
+func (s *KeyRotationService) RotateAll() (*KeyRotationResult, error) {
+	result := &KeyRotationResult{}
+	if kr := utils.GetKeyring(); kr != nil {
+		result.ActiveKeyID = kr.ActiveKeyID()
+	}
+
+	dataSources, err := s.dsRepo.FindByUser(0, true)
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not list data sources")
+	}
+	for i := range dataSources {
+		ds := &dataSources[i]
+		if ds.Password == "" || !s.encryptionService.NeedsRotation(ds.Password) {
+			result.DataSourcesSkipped++
+			continue
+		}
+		rotated, err := s.reencrypt(ds.Password)
+		if err == nil {
+			ds.Password = rotated
+			err = s.dsRepo.Update(ds)
+		}
+		if err != nil {
+			result.Failures = append(result.Failures, "datasource "+ds.Name+": "+err.Error())
+			continue
+		}
+		result.DataSourcesRotated++
+	}
+
+	webhooks, err := s.webhookRepo.FindByUser(0, true)
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not list webhooks")
+	}
+	for i := range webhooks {
+		webhook := &webhooks[i]
+		if webhook.Secret == "" {
+			result.WebhooksSkipped++
+			continue
+		}
+		var rotated string
+		if strings.HasPrefix(webhook.Secret, webhookSecretPrefix) {
+			// Secrets created before encryption was introduced are plaintext
+			rotated, err = s.encryptionService.Encrypt(webhook.Secret)
+		} else if s.encryptionService.NeedsRotation(webhook.Secret) {
+			rotated, err = s.reencrypt(webhook.Secret)
+		} else {
+			result.WebhooksSkipped++
+			continue
+		}
+		if err == nil {
+			webhook.Secret = rotated
+			err = s.webhookRepo.Update(webhook)
+		}
+		if err != nil {
+			result.Failures = append(result.Failures, "webhook "+webhook.Name+": "+err.Error())
+			continue
+		}
+		result.WebhooksRotated++
+	}
+
+	embedSecrets, err := s.embedRepo.FindAllSecrets()
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not list embed secrets")
+	}
+	for i := range embedSecrets {
+		secret := &embedSecrets[i]
+		if !s.encryptionService.NeedsRotation(secret.Secret) {
+			result.EmbedSecretsSkipped++
+			continue
+		}
+		rotated, err := s.reencrypt(secret.Secret)
+		if err == nil {
+			secret.Secret = rotated
+			err = s.embedRepo.SaveSecret(secret)
+		}
+		if err != nil {
+			result.Failures = append(result.Failures, "embed secret "+secret.KeyID+": "+err.Error())
+			continue
+		}
+		result.EmbedSecretsRotated++
+	}
+
+	utils.Logger.WithFields(map[string]interface{}{
+		"action":                "rotate_encryption_keys",
+		"active_key_id":         result.ActiveKeyID,
+		"datasources_rotated":   result.DataSourcesRotated,
+		"webhooks_rotated":      result.WebhooksRotated,
+		"embed_secrets_rotated": result.EmbedSecretsRotated,
+		"failures":              len(result.Failures),
+	}).Info("Encryption key rotation finished")
+
+	return result, nil
+}
+
+// reencrypt decrypts a ciphertext with whichever key produced it and encrypts it with the active key
+func (s *KeyRotationService) reencrypt(ciphertext string) (string, error) {
+	plaintext, err := s.encryptionService.Decrypt(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return s.encryptionService.Encrypt(plaintext)
+}
@@ -177,10 +177,39 @@ func isSimpleQuery(sql string) bool {
 	return true
 }
 
+// RefreshQuery drops the cached results of a query and executes it again
+func (s *QueryService) RefreshQuery(queryID uint, userID uint, isAdmin bool) (*ExecuteQueryResult, error) {
+	query, err := s.queryRepo.FindByID(queryID)
+	if err != nil {
+		return nil, errors.ErrNotFound
+	}
+	if !isAdmin && query.UserID != userID && !query.IsPublic {
+		return nil, errors.NewErrorWithSeverity(
+			errors.ErrCodeForbidden,
+			"Access denied to this query",
+			nil,
+			errors.SeverityMedium,
+			errors.CategoryAuthz,
+		)
+	}
+
+	s.cacheService.Delete(queryResultCacheKey(queryID))
+	if optimizedService, ok := s.sqlExecutionService.(*infrastructure.OptimizedSQLExecutionService); ok {
+		optimizedService.InvalidateCache(query.DataSourceID, query.SQL)
+	}
+
+	return s.ExecuteQuery(queryID, userID, isAdmin)
+}
+
+// queryResultCacheKey returns the cache key of a query's results
+func queryResultCacheKey(queryID uint) string {
+	return "query_result_" + strconv.FormatUint(uint64(queryID), 10)
+}
+
 // ExecuteQuery executes a query and returns the results with optimization
 func (s *QueryService) ExecuteQuery(queryID uint, userID uint, isAdmin bool) (*ExecuteQueryResult, error) {
 	// Check cache first
-	cacheKey := "query_result_" + strconv.FormatUint(uint64(queryID), 10)
+	cacheKey := queryResultCacheKey(queryID)
 	if result, found := s.cacheService.Get(cacheKey); found {
 		// Handle different cache result types
 		var data []map[string]interface{}
@@ -0,0 +1,1082 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gobi/internal/models"
+	"gobi/pkg/charts"
+	"gobi/pkg/errors"
+	"gobi/pkg/exceltemplate"
+	"gobi/pkg/pdf"
+	"gobi/pkg/utils"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Statuses of a generated report
+const (
+	ReportStatusGenerating = "generating"
+	ReportStatusSuccess    = "success"
+	ReportStatusPartial    = "partial" // some sections failed
+	ReportStatusFailed     = "failed"
+)
+
+// Output formats of a report
+const (
+	ReportFormatExcel = "xlsx"
+	ReportFormatPDF   = "pdf"
+)
+
+const (
+	// reportCoverSheet is the name of the sheet with the run metadata
+	reportCoverSheet = "Cover"
+	// reportBuildTimeout bounds the queries of one report run
+	reportBuildTimeout = 5 * time.Minute
+	// maxReportColumnWidth caps the width of the columns of a result sheet
+	maxReportColumnWidth = 60
+)
+
+var (
+	// sheetNameReplacer drops the characters Excel does not allow in sheet names
+	sheetNameReplacer = strings.NewReplacer("[", "(", "]", ")", ":", "-", "*", "_", "?", "_", "/", "-", "\\", "-")
+	// numericTextPattern matches text that is written as a number; leading zeros and
+	// exponents keep text such as codes as text
+	numericTextPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]{0,14})(\.[0-9]+)?$`)
+)
+
+// ReportSection is the outcome of one sheet of a generated report
+type ReportSection struct {
+	Sheet  string `json:"sheet,omitempty"`
+	Kind   string `json:"kind"` // query, chart or template
+	ID     uint   `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"` // success, failed or skipped
+	Rows   int    `json:"rows"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReportFormat returns the output format of a report or schedule; empty is xlsx
+func ReportFormat(format string) string {
+	if format == "" {
+		return ReportFormatExcel
+	}
+	return format
+}
+
+// ReportContentType returns the MIME type of a report format
+func ReportContentType(format string) string {
+	if ReportFormat(format) == ReportFormatPDF {
+		return "application/pdf"
+	}
+	return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+}
+
+// ReportFileName returns the file name of a report without extension: its name and the
+// period it covers, in the time zone of its schedule
+func ReportFileName(report *models.Report) string {
+	generatedAt := report.GeneratedAt.In(reportLocation(report.TimeZone))
+	switch report.Type {
+	case "daily":
+		return report.Name + "_" + generatedAt.Format("2006-01-02")
+	case "weekly":
+		return report.Name + "_week_" + generatedAt.Format("2006-01-02")
+	case "monthly":
+		return report.Name + "_" + generatedAt.Format("2006-01")
+	}
+	return report.Name
+}
+
+// reportLocation returns the time zone of a schedule or report; the server's when it is
+// unset or no longer known
+func reportLocation(zone string) *time.Location {
+	loc, err := utils.LoadScheduleLocation(zone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// ReportSections decodes the section outcomes stored on a report
+func ReportSections(report *models.Report) []ReportSection {
+	var sections []ReportSection
+	if report.Sections != "" {
+		json.Unmarshal([]byte(report.Sections), &sections)
+	}
+	return sections
+}
+
+// parseReportParameters decodes the parameters of a report schedule. Values are scalars,
+// lists for IN (...) and {"from": ..., "to": ...} objects for {{name.from}} and {{name.to}}.
+func parseReportParameters(raw string) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	if strings.TrimSpace(raw) == "" {
+		return params, nil
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+		return nil, fmt.Errorf("parameters must be a JSON object: %w", err)
+	}
+	for name, value := range decoded {
+		if !filterNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid parameter name %q", name)
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var r dateRange
+			for key, bound := range v {
+				s, ok := bound.(string)
+				if !ok || (key != "from" && key != "to") {
+					return nil, fmt.Errorf("parameter %q must only have string from and to bounds", name)
+				}
+				if key == "from" {
+					r.From = s
+				} else {
+					r.To = s
+				}
+			}
+			params[name] = r
+		case []interface{}:
+			for _, item := range v {
+				switch item.(type) {
+				case map[string]interface{}, []interface{}:
+					return nil, fmt.Errorf("parameter %q must be a list of scalars", name)
+				}
+			}
+			params[name] = v
+		default:
+			params[name] = v
+		}
+	}
+	return params, nil
+}
+
+// bindLogicalDate adds the schedule time a report stands for to its parameters, as
+// logical_date (2006-01-02) and logical_time (2006-01-02 15:04:05) in the schedule's time
+// zone, unless the schedule sets them itself. Parameter values may use {{logical_date}} and
+// {{logical_time}}, e.g. {"day": {"from": "{{logical_date}}", "to": "{{logical_date}}"}}.
+// Reports generated before runs had a logical date use their generation time.
+func bindLogicalDate(params map[string]interface{}, report *models.Report) {
+	at := report.GeneratedAt
+	if report.LogicalDate != nil {
+		at = *report.LogicalDate
+	}
+	at = at.In(reportLocation(report.TimeZone))
+	date, datetime := at.Format("2006-01-02"), at.Format("2006-01-02 15:04:05")
+	replacer := strings.NewReplacer("{{logical_date}}", date, "{{logical_time}}", datetime)
+
+	for name, value := range params {
+		switch v := value.(type) {
+		case string:
+			params[name] = replacer.Replace(v)
+		case dateRange:
+			params[name] = dateRange{From: replacer.Replace(v.From), To: replacer.Replace(v.To)}
+		case []interface{}:
+			items := make([]interface{}, len(v))
+			for i, item := range v {
+				if text, ok := item.(string); ok {
+					item = replacer.Replace(text)
+				}
+				items[i] = item
+			}
+			params[name] = items
+		}
+	}
+	if _, ok := params["logical_date"]; !ok {
+		params["logical_date"] = date
+	}
+	if _, ok := params["logical_time"]; !ok {
+		params["logical_time"] = datetime
+	}
+}
+
+// reportStyles are the cell styles of the sheets a report adds
+type reportStyles struct {
+	title, label, header, integer, decimal, date, datetime int
+}
+
+// newReportStyles registers the report styles with a workbook
+func newReportStyles(f *excelize.File) (reportStyles, error) {
+	dateFormat, datetimeFormat := "yyyy-mm-dd", "yyyy-mm-dd hh:mm:ss"
+	var styles reportStyles
+	type spec struct {
+		id    *int
+		style *excelize.Style
+	}
+	for _, spec := range []spec{
+		{&styles.title, &excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}}},
+		{&styles.label, &excelize.Style{Font: &excelize.Font{Bold: true}}},
+		{&styles.header, &excelize.Style{
+			Font:   &excelize.Font{Bold: true},
+			Fill:   excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"D9E1F2"}},
+			Border: []excelize.Border{{Type: "bottom", Color: "8EA9DB", Style: 1}},
+		}},
+		{&styles.integer, &excelize.Style{NumFmt: 3}}, // #,##0
+		{&styles.decimal, &excelize.Style{NumFmt: 4}}, // #,##0.00
+		{&styles.date, &excelize.Style{CustomNumFmt: &dateFormat}},
+		{&styles.datetime, &excelize.Style{CustomNumFmt: &datetimeFormat}},
+	} {
+		id, err := f.NewStyle(spec.style)
+		if err != nil {
+			return styles, err
+		}
+		*spec.id = id
+	}
+	return styles, nil
+}
+
+// Value types of the columns of a result sheet
+const (
+	columnText = iota
+	columnInteger
+	columnDecimal
+	columnDate
+	columnDateTime
+	columnBool
+)
+
+// reportTable is a result set laid out for a sheet
+type reportTable struct {
+	columns []string
+	types   []int
+	rows    [][]interface{}
+}
+
+// newReportTable converts rows to typed cell values, the columns in the given order
+func newReportTable(columns []string, rows []map[string]interface{}) *reportTable {
+	t := &reportTable{columns: columns, types: make([]int, len(columns)), rows: make([][]interface{}, len(rows))}
+	for i := range t.rows {
+		t.rows[i] = make([]interface{}, len(columns))
+	}
+	for j, column := range columns {
+		t.types[j] = inferColumnType(column, rows)
+		for i, row := range rows {
+			t.rows[i][j] = cellValue(row[column], t.types[j])
+		}
+	}
+	return t
+}
+
+// inferColumnType returns the type every non-empty value of a column has
+func inferColumnType(column string, rows []map[string]interface{}) int {
+	typ := -1
+	for _, row := range rows {
+		v := row[column]
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		valueType := columnText
+		switch x := v.(type) {
+		case nil:
+			continue
+		case bool:
+			valueType = columnBool
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			valueType = columnInteger
+		case float32, float64:
+			valueType = columnDecimal
+		case time.Time:
+			valueType = columnDateTime
+			if x.Equal(time.Date(x.Year(), x.Month(), x.Day(), 0, 0, 0, 0, x.Location())) {
+				valueType = columnDate
+			}
+		case string:
+			if x == "" {
+				continue
+			}
+			if numericTextPattern.MatchString(x) {
+				valueType = columnInteger
+				if strings.Contains(x, ".") {
+					valueType = columnDecimal
+				}
+			} else if _, err := time.Parse("2006-01-02", x); err == nil {
+				valueType = columnDate
+			} else if _, ok := parseReportTime(x); ok {
+				valueType = columnDateTime
+			}
+		}
+		switch {
+		case typ == -1 || typ == valueType:
+			typ = valueType
+		case (typ == columnInteger && valueType == columnDecimal) || (typ == columnDecimal && valueType == columnInteger):
+			typ = columnDecimal
+		case (typ == columnDate && valueType == columnDateTime) || (typ == columnDateTime && valueType == columnDate):
+			typ = columnDateTime
+		default:
+			return columnText
+		}
+	}
+	if typ == -1 {
+		return columnText
+	}
+	return typ
+}
+
+// parseReportTime parses the date and time layouts drivers return as text
+func parseReportTime(s string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04:05", "2006-01-02 15:04:05.999999999", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// wallClock returns a time as its wall clock time in UTC. Excel has no time zones, so
+// cells and text show the wall clock time.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// cellValue converts a value to what is written to a cell of a column of type typ
+func cellValue(v interface{}, typ int) interface{} {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	s, isText := v.(string)
+	if !isText {
+		if t, ok := v.(time.Time); ok {
+			return wallClock(t)
+		}
+		return v
+	}
+	if s == "" {
+		return nil
+	}
+	switch typ {
+	case columnInteger:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case columnDecimal:
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n
+		}
+	case columnDate, columnDateTime:
+		if t, ok := parseReportTime(s); ok {
+			return cellValue(t, typ)
+		}
+	}
+	return s
+}
+
+// orderColumns orders the columns of a result by where they appear in the SQL, the
+// order of its select list, and the remaining ones by name
+func orderColumns(sqlText string, rows []map[string]interface{}) []string {
+	seen := map[string]bool{}
+	var columns []string
+	for _, row := range rows {
+		for column := range row {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	lower := strings.ToLower(sqlText)
+	position := func(column string) int {
+		if i := strings.Index(lower, strings.ToLower(column)); i >= 0 {
+			return i
+		}
+		return len(lower)
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		pi, pj := position(columns[i]), position(columns[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return columns[i] < columns[j]
+	})
+	return columns
+}
+
+// reportBuild is one run of a report schedule
+type reportBuild struct {
+	service  *ReportGenerationService
+	schedule *models.ReportSchedule
+	report   *models.Report
+	owner    models.User
+	isAdmin  bool
+	params   map[string]interface{}
+	format   string
+	file     *excelize.File
+	styles   reportStyles
+	template *models.ExcelTemplate
+	// templateSheets are the sheets of the template not yet filled by a section
+	templateSheets map[string]bool
+	// bindings are the placeholders of the template, filled with templateData once every
+	// section has run; unbound are the names of bound queries that failed
+	bindings     []models.TemplateBinding
+	templateData exceltemplate.Data
+	unbound      map[string]bool
+	sections     []ReportSection
+	// layout and pdfContent are the layout and the content by section of PDF output
+	layout     pdf.Layout
+	pdfContent map[int]*reportPDFContent
+}
+
+// buildReport generates the workbook or PDF of a schedule into report. Sections that fail are
+// recorded on the report; an error is only returned when the schedule cannot be run.
+func (s *ReportGenerationService) buildReport(schedule *models.ReportSchedule, report *models.Report) error {
+	var queryIDs, chartIDs, templateIDs []uint
+	for _, list := range []struct {
+		name string
+		raw  string
+		ids  *[]uint
+	}{{"queries", schedule.Queries, &queryIDs}, {"charts", schedule.Charts, &chartIDs}, {"templates", schedule.Templates, &templateIDs}} {
+		if strings.TrimSpace(list.raw) == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(list.raw), list.ids); err != nil {
+			return errors.NewBadRequestError(fmt.Sprintf("Invalid %s configuration", list.name), err)
+		}
+	}
+	if len(queryIDs) == 0 && len(chartIDs) == 0 {
+		return errors.NewBadRequestError("Report schedule has no queries or charts", nil)
+	}
+	params, err := parseReportParameters(schedule.Parameters)
+	if err != nil {
+		return errors.NewBadRequestError("Invalid report parameters", err)
+	}
+	bindLogicalDate(params, report)
+
+	b := &reportBuild{service: s, schedule: schedule, report: report, params: params, format: ReportFormat(schedule.Format)}
+	if b.format == ReportFormatPDF {
+		if b.layout, err = pdf.ParseLayout([]byte(schedule.Layout)); err != nil {
+			return errors.NewBadRequestError("Invalid PDF layout", err)
+		}
+	}
+	if err := s.db.First(&b.owner, schedule.UserID).Error; err != nil {
+		return errors.WrapError(err, "Could not fetch report schedule owner")
+	}
+	b.isAdmin = b.owner.Role == "admin"
+
+	if b.format == ReportFormatPDF {
+		b.skipTemplates(templateIDs)
+	} else {
+		b.openWorkbook(templateIDs)
+		defer b.file.Close()
+		if b.styles, err = newReportStyles(b.file); err != nil {
+			return errors.WrapError(err, "Could not create report styles")
+		}
+	}
+
+	b.addQuerySections(queryIDs)
+	b.addChartSections(chartIDs)
+	if b.format != ReportFormatPDF {
+		if err := b.fillTemplate(); err != nil {
+			return errors.WrapError(err, "Could not fill report template")
+		}
+	}
+
+	report.Status = b.status()
+	report.Error = ""
+	if failed := b.failedSections(); failed > 0 {
+		report.Error = fmt.Sprintf("%d of %d sections failed", failed, len(queryIDs)+len(chartIDs))
+	}
+	report.Format = b.format
+	sections, _ := json.Marshal(b.sections)
+	report.Sections = string(sections)
+
+	if b.format == ReportFormatPDF {
+		content, err := b.writePDF()
+		if err != nil {
+			return errors.WrapError(err, "Could not write PDF report")
+		}
+		report.Content = content
+		return nil
+	}
+	if err := b.writeCover(); err != nil {
+		return errors.WrapError(err, "Could not write report cover")
+	}
+	buf, err := b.file.WriteToBuffer()
+	if err != nil {
+		return errors.WrapError(err, "Could not write report workbook")
+	}
+	report.Content = buf.Bytes()
+	return nil
+}
+
+// openWorkbook opens the first template as the workbook of the report, or a new workbook
+// when there is none or it cannot be used
+func (b *reportBuild) openWorkbook(templateIDs []uint) {
+	b.templateSheets = map[string]bool{}
+	for i, id := range templateIDs {
+		section := ReportSection{Kind: "template", ID: id, Status: "success"}
+		if i > 0 {
+			section.Status = "skipped"
+			section.Error = "Only the first template is applied"
+			b.sections = append(b.sections, section)
+			continue
+		}
+		var template models.ExcelTemplate
+		err := b.service.db.First(&template, id).Error
+		switch {
+		case err != nil:
+			err = errors.ErrNotFound
+		case !b.isAdmin && template.UserID != b.owner.ID:
+			err = errors.ErrForbidden
+		default:
+			section.Name = template.Name
+			var content []byte
+			var f *excelize.File
+			if content, err = readBlob(b.service.blobStore, template.StorageKey, template.Checksum); err != nil {
+				break
+			}
+			if f, err = excelize.OpenReader(bytes.NewReader(content)); err == nil {
+				b.file, b.template = f, &template
+				for _, sheet := range f.GetSheetList() {
+					b.templateSheets[sheet] = true
+				}
+				if b.bindings, err = exceltemplate.Scan(f); err != nil {
+					err = fmt.Errorf("invalid template placeholders: %w", err)
+				}
+			} else {
+				err = fmt.Errorf("template is not an Excel workbook: %w", err)
+			}
+		}
+		if err != nil {
+			section.Status = "failed"
+			section.Error = tileErrorMessage(err)
+		}
+		b.sections = append(b.sections, section)
+	}
+	if b.file == nil {
+		b.file = excelize.NewFile()
+		b.file.SetSheetName(b.file.GetSheetName(0), reportCoverSheet)
+		b.templateSheets[reportCoverSheet] = true
+	}
+}
+
+// addQuerySections runs the queries with the parameters bound and adds each result
+func (b *reportBuild) addQuerySections(queryIDs []uint) {
+	if len(queryIDs) == 0 {
+		return
+	}
+	b.unbound = map[string]bool{}
+	plan := b.service.dashboardService.newDashboardPlan(b.params)
+	tile := b.paramTile()
+	keys := make([]string, len(queryIDs))
+	errs := make([]error, len(queryIDs))
+	for i, id := range queryIDs {
+		keys[i], errs[i] = b.addStatement(plan, id, tile)
+	}
+	outcomes := b.runFresh(plan)
+
+	for i, id := range queryIDs {
+		section := ReportSection{Kind: "query", ID: id}
+		if query, ok := plan.queries[id]; ok {
+			section.Name = query.Name
+		}
+		err := errs[i]
+		var outcome queryOutcome
+		if err == nil {
+			outcome = outcomes[keys[i]]
+			err = outcome.err
+		}
+		if err == nil {
+			table := newReportTable(orderColumns(plan.statements[keys[i]].bound.SQL, outcome.rows), outcome.rows)
+			if !b.bindTable(&section, table) {
+				err = b.addTable(&section, table, nil)
+			}
+		} else {
+			b.unbound[exceltemplate.BindingName(section.Name)] = true
+			b.unbound[strconv.FormatUint(uint64(id), 10)] = true
+		}
+		b.finishSection(section, err)
+	}
+}
+
+// paramTile returns a tile mapping every parameter of the report to the {{parameter}} of
+// the same name, so that queries and charts see the same bound values
+func (b *reportBuild) paramTile() models.DashboardTile {
+	mappings := make(map[string]filterTarget, len(b.params))
+	for name := range b.params {
+		mappings[name] = filterTarget{Parameter: name}
+	}
+	encodedMappings, _ := json.Marshal(mappings)
+	return models.DashboardTile{FilterMappings: string(encodedMappings)}
+}
+
+// addStatement adds a query the report owner may run to a plan and returns the key of its
+// statement
+func (b *reportBuild) addStatement(plan *dashboardPlan, queryID uint, tile models.DashboardTile) (string, error) {
+	query, err := plan.loadQuery(queryID)
+	if err != nil {
+		return "", err
+	}
+	if !b.isAdmin && query.UserID != b.owner.ID && !query.IsPublic {
+		return "", errors.ErrForbidden
+	}
+	return plan.add(queryID, tile)
+}
+
+// runFresh runs the statements of a plan past the query cache, so that a report always
+// shows the data at the time it is generated
+func (b *reportBuild) runFresh(plan *dashboardPlan) map[string]queryOutcome {
+	executor := b.service.dashboardService.executor
+	for _, statement := range plan.statements {
+		executor.InvalidateCache(statement.query.DataSourceID, statement.bound.SQL, statement.bound.Args...)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), reportBuildTimeout)
+	defer cancel()
+	return b.service.dashboardService.runStatements(ctx, plan.statements)
+}
+
+// bindTable binds the result of a query section to the template when the template has
+// placeholders for it, by the name or the ID of the query. A bound result gets no sheet
+// of its own.
+func (b *reportBuild) bindTable(section *ReportSection, table *reportTable) bool {
+	if b.format == ReportFormatPDF {
+		return false
+	}
+	names := []string{exceltemplate.BindingName(section.Name), strconv.FormatUint(uint64(section.ID), 10)}
+	for _, binding := range b.bindings {
+		if binding.Source != exceltemplate.SourceQuery || (binding.Name != names[0] && binding.Name != names[1]) {
+			continue
+		}
+		if b.templateData.Tables == nil {
+			b.templateData.Tables = map[string]*exceltemplate.Table{}
+		}
+		bound := &exceltemplate.Table{Columns: table.columns, Rows: table.rows}
+		for _, name := range names {
+			b.templateData.Tables[name] = bound
+		}
+		section.Sheet = binding.Sheet
+		section.Rows = len(table.rows)
+		return true
+	}
+	return false
+}
+
+// fillTemplate fills the placeholders of the template with the bound results, the
+// parameters and the report fields. Bindings without data fail the template section,
+// unless they belong to a query that failed.
+func (b *reportBuild) fillTemplate() error {
+	if len(b.bindings) == 0 {
+		return nil
+	}
+	b.templateData.Values = reportValues(b.schedule, b.report, b.owner.Username, b.params)
+
+	missing, err := exceltemplate.Fill(b.file, b.templateData)
+	if err != nil {
+		return err
+	}
+	var unexpected []string
+	for _, key := range missing {
+		parts := strings.SplitN(key, ".", 3)
+		if parts[0] == exceltemplate.SourceQuery && b.unbound[parts[1]] {
+			continue
+		}
+		unexpected = append(unexpected, key)
+	}
+	if len(unexpected) > 0 {
+		for i := range b.sections {
+			if b.sections[i].Kind == "template" && b.sections[i].Status == "success" {
+				b.sections[i].Status = "failed"
+				b.sections[i].Error = "No data for template bindings: " + strings.Join(unexpected, ", ")
+			}
+		}
+	}
+	return nil
+}
+
+// reportValues returns the values of the {{report.*}} and {{param.*}} placeholders of a
+// report run. Ranges are written "from – to" and lists comma separated.
+func reportValues(schedule *models.ReportSchedule, report *models.Report, owner string, params map[string]interface{}) map[string]interface{} {
+	values := map[string]interface{}{
+		"report.name":          schedule.Name,
+		"report.type":          schedule.Type,
+		"report.generated_at":  wallClock(report.GeneratedAt.In(reportLocation(report.TimeZone))),
+		"report.generated_for": owner,
+	}
+	for name, value := range params {
+		switch v := value.(type) {
+		case dateRange:
+			values["param."+name] = strings.TrimSpace(v.From + " – " + v.To)
+			values["param."+name+".from"] = v.From
+			values["param."+name+".to"] = v.To
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values["param."+name] = strings.Join(items, ", ")
+		default:
+			values["param."+name] = v
+		}
+	}
+	return values
+}
+
+// addChartSections adds the data of each chart, a column per chart field, and draws the
+// chart next to it in workbooks. Chart queries are run with the report parameters bound,
+// like query sections.
+func (b *reportBuild) addChartSections(chartIDs []uint) {
+	if len(chartIDs) == 0 {
+		return
+	}
+	plan := b.service.dashboardService.newDashboardPlan(b.params)
+	tile := b.paramTile()
+	sectionCharts := make([]*models.Chart, len(chartIDs))
+	keys := make([]string, len(chartIDs))
+	errs := make([]error, len(chartIDs))
+	for i, id := range chartIDs {
+		chart, err := b.service.chartService.GetChart(id, b.owner.ID, b.isAdmin)
+		if err == nil && chart.QueryID != 0 {
+			keys[i], err = b.addStatement(plan, chart.QueryID, tile)
+		}
+		sectionCharts[i], errs[i] = chart, err
+	}
+	outcomes := b.runFresh(plan)
+
+	for i, id := range chartIDs {
+		section := ReportSection{Kind: "chart", ID: id}
+		chart, err := sectionCharts[i], errs[i]
+		if chart != nil {
+			section.Name = chart.Name
+		}
+		var data *ChartData
+		if err == nil {
+			data, err = b.chartData(chart, outcomes[keys[i]])
+		}
+		if err == nil {
+			table, fields := chartTable(data)
+			err = b.addTable(&section, table, &reportPDFContent{chart: chart, rows: data.Rows})
+			if err == nil && b.format != ReportFormatPDF {
+				b.addWorkbookChart(&section, chart, data, table, fields)
+			}
+		}
+		b.finishSection(section, err)
+	}
+}
+
+// chartData shapes the rows of a chart section: the outcome of its statement, or the
+// chart's static data
+func (b *reportBuild) chartData(chart *models.Chart, outcome queryOutcome) (*ChartData, error) {
+	rows, source, err := outcome.rows, outcome.source, outcome.err
+	if chart.QueryID == 0 {
+		rows, err = staticChartRows(chart)
+		source = "static"
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := shapeChartData(chart, rows, source)
+	if err != nil {
+		return nil, err
+	}
+	b.service.dashboardService.boundaryService.MatchRegions(chart, data)
+	return data, nil
+}
+
+// chartTable lays out the data of a chart as a table, a column per chart field named
+// after the column it is mapped to, and returns the fields of the columns
+func chartTable(data *ChartData) (*reportTable, []string) {
+	var fields []string
+	if t, ok := charts.Lookup(data.Type); ok {
+		for _, field := range t.DataFields {
+			if _, ok := data.Fields[field.Name]; ok && chartFieldPresent(data.Rows, field.Name) {
+				fields = append(fields, field.Name)
+			}
+		}
+	}
+	table := newReportTable(fields, data.Rows)
+	for i, field := range fields {
+		if column := data.Fields[field]; column != "" && column != field {
+			table.columns[i] = column
+		}
+	}
+	return table, fields
+}
+
+// chartFieldPresent reports whether a field of a chart has a value in some row
+func chartFieldPresent(rows []map[string]interface{}, field string) bool {
+	for _, row := range rows {
+		if _, ok := row[field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// finishSection records the outcome of a section
+func (b *reportBuild) finishSection(section ReportSection, err error) {
+	section.Status = "success"
+	if err != nil {
+		section.Status = "failed"
+		section.Error = tileErrorMessage(err)
+	}
+	b.sections = append(b.sections, section)
+}
+
+// addTable adds the result of a section to the report: a sheet of the workbook, or a
+// table of the PDF written once every section has run. content carries the chart of a
+// chart section.
+func (b *reportBuild) addTable(section *ReportSection, table *reportTable, content *reportPDFContent) error {
+	if b.format != ReportFormatPDF {
+		return b.addSheet(section, table)
+	}
+	if content == nil {
+		content = &reportPDFContent{}
+	}
+	content.table = table
+	section.Rows = len(table.rows)
+	if b.pdfContent == nil {
+		b.pdfContent = map[int]*reportPDFContent{}
+	}
+	// finishSection appends the section next, at this index
+	b.pdfContent[len(b.sections)] = content
+	return nil
+}
+
+// sheetName returns the sheet of a section: the template sheet of the same name, or a new
+// sheet named after the section
+func (b *reportBuild) sheetName(section *ReportSection) (string, bool) {
+	base := section.Name
+	if strings.TrimSpace(base) == "" {
+		base = fmt.Sprintf("%s %d", strings.ToUpper(section.Kind[:1])+section.Kind[1:], section.ID)
+	}
+	base = strings.Trim(sheetNameReplacer.Replace(base), "' ")
+	if utf8.RuneCountInString(base) > 31 {
+		base = string([]rune(base)[:31])
+	}
+	if b.templateSheets[base] && base != reportCoverSheet {
+		delete(b.templateSheets, base)
+		return base, false
+	}
+	name := base
+	for n := 2; ; n++ {
+		if index, _ := b.file.GetSheetIndex(name); index < 0 && !strings.EqualFold(name, reportCoverSheet) {
+			return name, true
+		}
+		suffix := fmt.Sprintf(" (%d)", n)
+		runes := []rune(base)
+		if len(runes)+len(suffix) > 31 {
+			runes = runes[:31-len(suffix)]
+		}
+		name = string(runes) + suffix
+	}
+}
+
+// addSheet writes a table to the sheet of a section. New sheets get a styled header,
+// number and date formats, a frozen header row and a filter; template sheets keep their
+// own formatting.
+func (b *reportBuild) addSheet(section *ReportSection, table *reportTable) error {
+	if len(table.columns) == 0 && len(table.rows) > 0 {
+		return fmt.Errorf("result has no columns")
+	}
+	if len(table.rows)+1 > excelize.TotalRows {
+		return fmt.Errorf("result has %d rows; a sheet holds at most %d", len(table.rows), excelize.TotalRows-1)
+	}
+	sheet, fresh := b.sheetName(section)
+	if fresh {
+		if _, err := b.file.NewSheet(sheet); err != nil {
+			return err
+		}
+	}
+	section.Sheet = sheet
+	section.Rows = len(table.rows)
+
+	header := make([]interface{}, len(table.columns))
+	for i, column := range table.columns {
+		header[i] = column
+	}
+	if err := b.file.SetSheetRow(sheet, "A1", &header); err != nil {
+		return err
+	}
+	for i, row := range table.rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		if err := b.file.SetSheetRow(sheet, cell, &row); err != nil {
+			return err
+		}
+	}
+	if !fresh || len(table.columns) == 0 {
+		return nil
+	}
+
+	lastColumn, _ := excelize.ColumnNumberToName(len(table.columns))
+	lastRow := len(table.rows) + 1
+	b.file.SetCellStyle(sheet, "A1", lastColumn+"1", b.styles.header)
+	for i, column := range table.columns {
+		name, _ := excelize.ColumnNumberToName(i + 1)
+		style := map[int]int{
+			columnInteger:  b.styles.integer,
+			columnDecimal:  b.styles.decimal,
+			columnDate:     b.styles.date,
+			columnDateTime: b.styles.datetime,
+		}[table.types[i]]
+		if style != 0 && lastRow > 1 {
+			b.file.SetCellStyle(sheet, name+"2", fmt.Sprintf("%s%d", name, lastRow), style)
+		}
+		b.file.SetColWidth(sheet, name, name, columnWidth(column, table, i))
+	}
+	b.file.SetPanes(sheet, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+	return b.file.AutoFilter(sheet, fmt.Sprintf("A1:%s%d", lastColumn, lastRow), nil)
+}
+
+// columnWidth fits a column to its longest value
+func columnWidth(column string, table *reportTable, index int) float64 {
+	width := utf8.RuneCountInString(column)
+	switch table.types[index] {
+	case columnDate:
+		width = max(width, 10)
+	case columnDateTime:
+		width = max(width, 19)
+	default:
+		for _, row := range table.rows {
+			if row[index] != nil {
+				width = max(width, utf8.RuneCountInString(fmt.Sprint(row[index])))
+			}
+		}
+	}
+	return float64(min(width+2, maxReportColumnWidth))
+}
+
+// failedSections counts the query and chart sections that failed
+func (b *reportBuild) failedSections() int {
+	failed := 0
+	for _, section := range b.sections {
+		if section.Kind != "template" && section.Status == "failed" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// status is the report status: failed when no section succeeded and partial when some did
+func (b *reportBuild) status() string {
+	succeeded := 0
+	for _, section := range b.sections {
+		if section.Kind != "template" && section.Status == "success" {
+			succeeded++
+		}
+	}
+	failed := b.failedSections()
+	for _, section := range b.sections {
+		if section.Kind == "template" && section.Status == "failed" {
+			failed++
+		}
+	}
+	switch {
+	case succeeded == 0:
+		return ReportStatusFailed
+	case failed > 0:
+		return ReportStatusPartial
+	default:
+		return ReportStatusSuccess
+	}
+}
+
+// writeCover writes the run metadata, the parameters and the outcome of every section to
+// the first sheet of the report
+func (b *reportBuild) writeCover() error {
+	if index, _ := b.file.GetSheetIndex(reportCoverSheet); index < 0 {
+		if _, err := b.file.NewSheet(reportCoverSheet); err != nil {
+			return err
+		}
+	}
+	if first := b.file.GetSheetName(0); first != reportCoverSheet {
+		if err := b.file.MoveSheet(reportCoverSheet, first); err != nil {
+			return err
+		}
+	}
+	b.file.SetActiveSheet(0)
+
+	type coverRow struct {
+		cells []interface{}
+		style int // applied to every cell of the row, or the label of metadata rows
+	}
+	rows := []coverRow{
+		{[]interface{}{b.schedule.Name}, b.styles.title},
+		{},
+	}
+	for _, detail := range b.details() {
+		rows = append(rows, coverRow{[]interface{}{detail[0], detail[1]}, b.styles.label})
+	}
+
+	rows = append(rows, coverRow{}, coverRow{[]interface{}{"Parameters"}, b.styles.label})
+	rows = append(rows, coverRow{[]interface{}{"Name", "Value"}, b.styles.header})
+	for _, parameter := range b.parameters() {
+		rows = append(rows, coverRow{cells: []interface{}{parameter[0], parameter[1]}})
+	}
+
+	rows = append(rows, coverRow{}, coverRow{[]interface{}{"Sections"}, b.styles.label})
+	rows = append(rows, coverRow{[]interface{}{"Sheet", "Kind", "ID", "Name", "Status", "Rows", "Error"}, b.styles.header})
+	for _, section := range b.sections {
+		rows = append(rows, coverRow{cells: []interface{}{section.Sheet, section.Kind, section.ID, section.Name, section.Status, section.Rows, section.Error}})
+	}
+
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := b.file.SetSheetRow(reportCoverSheet, cell, &row.cells); err != nil {
+			return err
+		}
+		if row.style == 0 {
+			continue
+		}
+		last := cell
+		if row.style == b.styles.header {
+			last, _ = excelize.CoordinatesToCellName(len(row.cells), i+1)
+		}
+		b.file.SetCellStyle(reportCoverSheet, cell, last, row.style)
+	}
+	b.file.SetColWidth(reportCoverSheet, "A", "A", 18)
+	b.file.SetColWidth(reportCoverSheet, "B", "D", 24)
+	b.file.SetColWidth(reportCoverSheet, "G", "G", 48)
+	return nil
+}
+
+// details returns the labelled run metadata shown first in a report
+func (b *reportBuild) details() [][2]string {
+	templateName := ""
+	if b.template != nil {
+		templateName = b.template.Name
+	}
+	return [][2]string{
+		{"Report", strconv.FormatUint(uint64(b.report.ID), 10)},
+		{"Schedule", fmt.Sprintf("%s (#%d)", b.schedule.Name, b.schedule.ID)},
+		{"Type", b.schedule.Type},
+		{"Cron pattern", b.schedule.CronPattern},
+		{"Generated at", b.report.GeneratedAt.In(reportLocation(b.report.TimeZone)).Format("2006-01-02 15:04:05 MST")},
+		{"Time zone", reportLocation(b.report.TimeZone).String()},
+		{"Logical date", b.logicalDate()},
+		{"Generated for", b.owner.Username},
+		{"Template", templateName},
+		{"Status", b.report.Status},
+	}
+}
+
+// logicalDate returns the schedule time the run stands for, empty when it has none
+func (b *reportBuild) logicalDate() string {
+	if b.report.LogicalDate == nil {
+		return ""
+	}
+	return b.report.LogicalDate.In(reportLocation(b.report.TimeZone)).Format("2006-01-02 15:04:05 MST")
+}
+
+// parameters returns the parameters of the run by name, values other than text as JSON
+func (b *reportBuild) parameters() [][2]string {
+	names := make([]string, 0, len(b.params))
+	for name := range b.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parameters := make([][2]string, len(names))
+	for i, name := range names {
+		value, isText := b.params[name].(string)
+		if !isText {
+			encoded, _ := json.Marshal(b.params[name])
+			value = string(encoded)
+		}
+		parameters[i] = [2]string{name, value}
+	}
+	return parameters
+}
@@ -0,0 +1,439 @@
+package services
+
+import (
+	"fmt"
+	"gobi/internal/models"
+	"gobi/pkg/charts"
+	"gobi/pkg/render"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Size in pixels of the chart drawn next to the data of a chart section
+const (
+	workbookChartWidth  = 640
+	workbookChartHeight = 360
+)
+
+// workbookChart lays out the data of a chart section for a native Excel chart: the series
+// bind to the columns of the section table, or to a block of pivoted data written to the
+// right of it when the rows have to be split into series or summed
+type workbookChart struct {
+	b         *reportBuild
+	chartType string
+	sheet     string
+	table     *reportTable
+	columns   map[string]int // chart field to column of the table
+	cfg       map[string]interface{}
+	// next is the first free column right of the table and the blocks written so far
+	next int
+}
+
+// addWorkbookChart draws the chart of a chart section next to its data: a native Excel
+// chart for the types Excel draws, a rendered image for the other types that can be
+// rendered. A chart that cannot be drawn leaves a note in its place.
+func (b *reportBuild) addWorkbookChart(section *ReportSection, chart *models.Chart, data *ChartData, table *reportTable, fields []string) {
+	if section.Sheet == "" || len(table.rows) == 0 {
+		return
+	}
+	cfg, err := charts.ParseConfig(chart.Config)
+	if err != nil {
+		return
+	}
+	if t, ok := charts.Lookup(chart.Type); ok {
+		cfg = t.Options(cfg)
+	}
+	w := &workbookChart{
+		b:         b,
+		chartType: chart.Type,
+		sheet:     section.Sheet,
+		table:     table,
+		columns:   map[string]int{},
+		cfg:       cfg,
+		next:      len(table.columns) + 2,
+	}
+	for i, field := range fields {
+		w.columns[field] = i
+	}
+
+	if typ, ok := workbookChartType(chart.Type, cfg); ok {
+		err = w.addChart(chart, typ)
+	} else if render.Supported(chart.Type) {
+		err = w.addImage(chart, data.Rows)
+	}
+	if err != nil {
+		anchor, _ := excelize.CoordinatesToCellName(w.next, 1)
+		b.file.SetCellValue(w.sheet, anchor, "The chart could not be drawn: "+err.Error())
+	}
+}
+
+// addChart adds the native Excel chart of a chart section right of its data
+func (w *workbookChart) addChart(chart *models.Chart, typ excelize.ChartType) error {
+	main, combo, err := w.charts(chart, typ)
+	if err != nil {
+		return err
+	}
+	anchor, _ := excelize.CoordinatesToCellName(w.next, 1)
+	return w.b.file.AddChart(w.sheet, anchor, main, combo...)
+}
+
+// workbookChartType returns the native Excel chart of a chart type and its configuration
+func workbookChartType(chartType string, cfg map[string]interface{}) (excelize.ChartType, bool) {
+	switch chartType {
+	case "bar", "line", "area":
+		return workbookSeriesType(chartType, cfg), true
+	case "pie":
+		if inner, ok := cfg["innerRadius"].(float64); ok && inner > 0 {
+			return excelize.Doughnut, true
+		}
+		return excelize.Pie, true
+	case "scatter":
+		return excelize.Scatter, true
+	case "radar":
+		return excelize.Radar, true
+	}
+	return 0, false
+}
+
+// workbookSeriesType returns the Excel chart drawing series of a bar, line or area kind
+func workbookSeriesType(kind string, cfg map[string]interface{}) excelize.ChartType {
+	stack, _ := cfg["stack"].(bool)
+	switch kind {
+	case "bar":
+		horizontal, _ := cfg["horizontal"].(bool)
+		switch {
+		case horizontal && stack:
+			return excelize.BarStacked
+		case horizontal:
+			return excelize.Bar
+		case stack:
+			return excelize.ColStacked
+		}
+		return excelize.Col
+	case "area":
+		if stack {
+			return excelize.AreaStacked
+		}
+		return excelize.Area
+	}
+	return excelize.Line
+}
+
+// charts builds the Excel chart of a chart section. Series of a bar, line or area chart
+// drawn as another type by seriesTypes go to combo charts, a chart per type.
+func (w *workbookChart) charts(chart *models.Chart, typ excelize.ChartType) (*excelize.Chart, []*excelize.Chart, error) {
+	var series []excelize.ChartSeries
+	var kinds []string
+	switch chart.Type {
+	case "scatter":
+		series = w.scatterSeries()
+	case "pie":
+		series, kinds = w.categorySeries("name", "value")
+	case "radar":
+		series, kinds = w.categorySeries("indicator", "value")
+	default:
+		series, kinds = w.categorySeries("x", "y")
+	}
+	if len(series) == 0 {
+		return nil, nil, fmt.Errorf("the chart has no series")
+	}
+
+	main := w.newChart(chart, typ)
+	horizontal, _ := w.cfg["horizontal"].(bool)
+	if chart.Type == "scatter" || chart.Type == "pie" || chart.Type == "radar" || horizontal {
+		main.Series = series
+		return main, nil, nil
+	}
+	var combo []*excelize.Chart
+	byKind := map[string]*excelize.Chart{chart.Type: main}
+	for i, s := range series {
+		c, ok := byKind[kinds[i]]
+		if !ok {
+			c = &excelize.Chart{Type: workbookSeriesType(kinds[i], w.cfg)}
+			byKind[kinds[i]] = c
+			combo = append(combo, c)
+		}
+		c.Series = append(c.Series, s)
+	}
+	if len(main.Series) == 0 {
+		// every series is drawn as another type; the first of them takes the frame of the chart
+		main.Type, main.Series = combo[0].Type, combo[0].Series
+		combo = combo[1:]
+	}
+	return main, combo, nil
+}
+
+// newChart returns an Excel chart with the title, legend, axis titles and options of a chart
+func (w *workbookChart) newChart(chart *models.Chart, typ excelize.ChartType) *excelize.Chart {
+	title := chart.Name
+	if t, ok := w.cfg["title"].(string); ok && t != "" {
+		title = t
+	}
+	varyColors := typ == excelize.Pie || typ == excelize.Doughnut
+	c := &excelize.Chart{
+		Type:       typ,
+		Dimension:  excelize.ChartDimension{Width: workbookChartWidth, Height: workbookChartHeight},
+		Title:      []excelize.RichTextRun{{Text: title}},
+		Legend:     excelize.ChartLegend{Position: "bottom"},
+		VaryColors: &varyColors,
+		XAxis:      excelize.ChartAxis{Title: axisTitle(w.cfg, "xAxisName")},
+		YAxis:      excelize.ChartAxis{Title: axisTitle(w.cfg, "yAxisName"), MajorGridLines: typ != excelize.Radar},
+	}
+	if legend, ok := w.cfg["legend"].(bool); ok && !legend {
+		c.Legend.Position = "none"
+	}
+	if varyColors {
+		c.PlotArea.ShowPercent, _ = w.cfg["showLabel"].(bool)
+		if inner, ok := w.cfg["innerRadius"].(float64); ok && typ == excelize.Doughnut {
+			// Excel holes range from 10 to 90 percent
+			c.HoleSize = int(math.Max(10, math.Min(90, math.Round(inner))))
+		}
+	}
+	return c
+}
+
+// axisTitle returns the title of an axis set by a configuration option
+func axisTitle(cfg map[string]interface{}, key string) []excelize.RichTextRun {
+	if title, ok := cfg[key].(string); ok && title != "" {
+		return []excelize.RichTextRun{{Text: title}}
+	}
+	return nil
+}
+
+// categorySeries returns a series per series name over the categories of the category
+// field, and the kind each series is drawn as. Without a series field and with distinct
+// categories the series binds to the section table; otherwise the values are pivoted into
+// a block, summing rows of the same category and series the way the ECharts option does.
+func (w *workbookChart) categorySeries(categoryField, valueField string) ([]excelize.ChartSeries, []string) {
+	category, ok := w.columns[categoryField]
+	value, hasValue := w.columns[valueField]
+	if !ok || !hasValue {
+		return nil, nil
+	}
+	seriesColumn, split := w.columns["series"]
+	last := len(w.table.rows) + 1
+
+	if !split && distinctValues(w.table.rows, category) {
+		s := excelize.ChartSeries{
+			Name:       w.ref(value+1, 1, value+1, 1),
+			Categories: w.ref(category+1, 2, category+1, last),
+			Values:     w.ref(value+1, 2, value+1, last),
+		}
+		w.style(&s, 0)
+		return []excelize.ChartSeries{s}, []string{charts.SeriesKind(w.cfg, "", w.chartType)}
+	}
+
+	var categories []interface{}
+	var names []string
+	categoryIndex, nameIndex := map[string]int{}, map[string]int{}
+	var grid [][]interface{}
+	for _, row := range w.table.rows {
+		name := w.table.columns[value]
+		if split && row[seriesColumn] != nil && fmt.Sprint(row[seriesColumn]) != "" {
+			name = fmt.Sprint(row[seriesColumn])
+		}
+		c, ok := categoryIndex[fmt.Sprint(row[category])]
+		if !ok {
+			c = len(categories)
+			categoryIndex[fmt.Sprint(row[category])] = c
+			categories = append(categories, row[category])
+			for i := range grid {
+				grid[i] = append(grid[i], nil)
+			}
+		}
+		s, ok := nameIndex[name]
+		if !ok {
+			s = len(names)
+			nameIndex[name] = s
+			names = append(names, name)
+			grid = append(grid, make([]interface{}, len(categories)))
+		}
+		if v, ok := cellNumber(row[value]); ok {
+			if existing, ok := grid[s][c].(float64); ok {
+				v += existing
+			}
+			grid[s][c] = v
+		}
+	}
+
+	start := w.next
+	header := make([]interface{}, len(names)+1)
+	header[0] = w.table.columns[category]
+	for i, name := range names {
+		header[i+1] = name
+	}
+	w.setRow(start, 1, header)
+	for c, label := range categories {
+		cells := make([]interface{}, len(names)+1)
+		cells[0] = label
+		for s := range names {
+			cells[s+1] = grid[s][c]
+		}
+		w.setRow(start, c+2, cells)
+	}
+	w.next += len(names) + 2
+
+	last = len(categories) + 1
+	series := make([]excelize.ChartSeries, len(names))
+	kinds := make([]string, len(names))
+	for i, name := range names {
+		series[i] = excelize.ChartSeries{
+			Name:       w.ref(start+i+1, 1, start+i+1, 1),
+			Categories: w.ref(start, 2, start, last),
+			Values:     w.ref(start+i+1, 2, start+i+1, last),
+		}
+		w.style(&series[i], i)
+		kinds[i] = charts.SeriesKind(w.cfg, name, w.chartType)
+	}
+	return series, kinds
+}
+
+// scatterSeries returns a series of points per series name. Without a series field the
+// series binds to the x and y columns of the section table; otherwise the points of each
+// series are written to a pair of columns of a block.
+func (w *workbookChart) scatterSeries() []excelize.ChartSeries {
+	x, okx := w.columns["x"]
+	y, oky := w.columns["y"]
+	if !okx || !oky {
+		return nil
+	}
+	seriesColumn, split := w.columns["series"]
+	if !split {
+		last := len(w.table.rows) + 1
+		s := excelize.ChartSeries{
+			Name:       w.ref(y+1, 1, y+1, 1),
+			Categories: w.ref(x+1, 2, x+1, last),
+			Values:     w.ref(y+1, 2, y+1, last),
+		}
+		w.style(&s, 0)
+		return []excelize.ChartSeries{s}
+	}
+
+	var names []string
+	points := map[string][][]interface{}{}
+	for _, row := range w.table.rows {
+		name := w.table.columns[y]
+		if row[seriesColumn] != nil && fmt.Sprint(row[seriesColumn]) != "" {
+			name = fmt.Sprint(row[seriesColumn])
+		}
+		if _, ok := points[name]; !ok {
+			names = append(names, name)
+		}
+		points[name] = append(points[name], []interface{}{row[x], row[y]})
+	}
+	series := make([]excelize.ChartSeries, len(names))
+	for i, name := range names {
+		column := w.next + 2*i
+		w.setRow(column, 1, []interface{}{w.table.columns[x], name})
+		for j, point := range points[name] {
+			w.setRow(column, j+2, point)
+		}
+		last := len(points[name]) + 1
+		series[i] = excelize.ChartSeries{
+			Name:       w.ref(column+1, 1, column+1, 1),
+			Categories: w.ref(column, 2, column, last),
+			Values:     w.ref(column+1, 2, column+1, last),
+		}
+		w.style(&series[i], i)
+	}
+	w.next += 2*len(names) + 1
+	return series
+}
+
+// style applies the configured palette color and line smoothing to the i-th series; pie
+// slices keep the colors Excel varies by point
+func (w *workbookChart) style(s *excelize.ChartSeries, i int) {
+	s.Line.Smooth, _ = w.cfg["smooth"].(bool)
+	colors, _ := w.cfg["color"].([]interface{})
+	if len(colors) == 0 || w.chartType == "pie" {
+		return
+	}
+	if color, ok := colors[i%len(colors)].(string); ok {
+		if hex := strings.TrimPrefix(color, "#"); len(hex) == 6 {
+			if _, err := strconv.ParseUint(hex, 16, 32); err == nil {
+				s.Fill = excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{strings.ToUpper(hex)}}
+			}
+		}
+	}
+}
+
+// setRow writes cells to a row of the sheet starting at a column
+func (w *workbookChart) setRow(column, row int, cells []interface{}) {
+	cell, _ := excelize.CoordinatesToCellName(column, row)
+	w.b.file.SetSheetRow(w.sheet, cell, &cells)
+}
+
+// ref returns the absolute reference to a range of the sheet, as chart series take it
+func (w *workbookChart) ref(column1, row1, column2, row2 int) string {
+	from, _ := excelize.CoordinatesToCellName(column1, row1, true)
+	to, _ := excelize.CoordinatesToCellName(column2, row2, true)
+	return "'" + strings.ReplaceAll(w.sheet, "'", "''") + "'!" + from + ":" + to
+}
+
+// addImage embeds the rendered image of a chart Excel cannot draw natively
+func (w *workbookChart) addImage(chart *models.Chart, rows []map[string]interface{}) error {
+	spec, err := render.NewSpec(chart, rows, workbookChartWidth, workbookChartHeight)
+	if err != nil {
+		return err
+	}
+	image, err := render.Render(spec, render.FormatPNG)
+	if err != nil {
+		return err
+	}
+	anchor, _ := excelize.CoordinatesToCellName(w.next, 1)
+	return w.b.file.AddPictureFromBytes(w.sheet, anchor, &excelize.Picture{
+		Extension: ".png",
+		File:      image,
+		Format:    &excelize.GraphicOptions{AltText: spec.Title},
+	})
+}
+
+// distinctValues reports whether no two rows share the value of a column
+func distinctValues(rows [][]interface{}, column int) bool {
+	seen := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		key := fmt.Sprint(row[column])
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+	}
+	return true
+}
+
+// cellNumber reads a number from a cell value of a result sheet
+func cellNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
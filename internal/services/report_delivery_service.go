@@ -0,0 +1,632 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	errs "errors"
+	"fmt"
+	"gobi/config"
+	"gobi/internal/models"
+	"gobi/pkg/errors"
+	"gobi/pkg/exceltemplate"
+	"gobi/pkg/mail"
+	"gobi/pkg/utils"
+	"io"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// ReportFormatCSV attaches the result sheets of a workbook report as CSV files
+const ReportFormatCSV = "csv"
+
+// Methods of a report delivery
+const (
+	DeliveryMethodAttachment = "attachment"
+	DeliveryMethodLink       = "link" // the report exceeds the attachment size limit
+)
+
+// Statuses of a report delivery
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSent    = "sent"
+	DeliveryStatusFailed  = "failed"
+)
+
+const (
+	// defaultReportSubject is the subject of report emails of schedules without one
+	defaultReportSubject = "{{report.name}}"
+	// defaultReportBody is the body of report emails of schedules without one
+	defaultReportBody = "The report {{report.name}} generated at {{report.generated_at}} is attached."
+	// maxReportRecipients caps the recipients of a schedule
+	maxReportRecipients = 50
+	// reportLinkKeyLabel derives the key of download links from the JWT secret, so that
+	// links and login tokens cannot stand in for each other
+	reportLinkKeyLabel = "gobi report download link"
+	// deliveryRetryInterval is how often the retry worker looks for deliveries due again
+	deliveryRetryInterval = 15 * time.Second
+	// deliveryRetryBatch caps the deliveries the retry worker sends per pass
+	deliveryRetryBatch = 50
+	// deliveryClaimMargin is added to the SMTP timeout to claim a delivery being sent; a
+	// delivery whose sender died is due again once its claim runs out
+	deliveryClaimMargin = time.Minute
+)
+
+// reportEmailPlaceholders are the placeholders of subjects and bodies besides param.*
+var reportEmailPlaceholders = func() map[string]bool {
+	names := map[string]bool{"recipient": true, "link": true}
+	for _, field := range exceltemplate.ReportFields {
+		names["report."+field] = true
+	}
+	return names
+}()
+
+// ReportDownload is a report file opened through a download link. Content is streamed
+// from the blob store and must be closed.
+type ReportDownload struct {
+	FileName    string
+	ContentType string
+	Size        int64
+	Content     io.ReadCloser
+}
+
+// reportLinkClaims are the claims of a report download link; the subject is the recipient
+type reportLinkClaims struct {
+	ReportID uint   `json:"report_id"`
+	Format   string `json:"format"`
+	jwt.RegisteredClaims
+}
+
+// ReportDeliveryService emails generated reports to the recipients of their schedule.
+// Deliveries failing temporarily are retried by a background worker from their records,
+// so that retries survive restarts.
+type ReportDeliveryService struct {
+	db        *gorm.DB
+	sender    MailSender // nil when no SMTP server is configured
+	blobStore BlobStore
+	cfg       config.EmailConfig
+	linkKey   []byte
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewReportDeliveryService creates a new ReportDeliveryService instance. Download links
+// are signed with a key derived from signingSecret.
+func NewReportDeliveryService(db *gorm.DB, sender MailSender, blobStore BlobStore, cfg config.EmailConfig, signingSecret string) *ReportDeliveryService {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte(reportLinkKeyLabel))
+	return &ReportDeliveryService{
+		db:        db,
+		sender:    sender,
+		blobStore: blobStore,
+		cfg:       cfg,
+		linkKey:   mac.Sum(nil),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Deliver emails a report to each recipient of its schedule, in the background. Every
+// recipient gets its own message and delivery record, retried on temporary failures by
+// the retry worker.
+func (s *ReportDeliveryService) Deliver(schedule *models.ReportSchedule, report *models.Report) {
+	recipients, err := parseReportRecipients(schedule.Recipients)
+	if err != nil {
+		utils.Logger.Errorf("Invalid recipients of report schedule %d: %v", schedule.ID, err)
+		return
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	format := reportAttachmentFormat(schedule, report)
+	files, err := reportFiles(report, format)
+	method := DeliveryMethodAttachment
+	if err == nil && attachmentsSize(files) > s.cfg.MaxAttachmentSize {
+		method = DeliveryMethodLink
+		if s.cfg.LinkBaseURL == "" {
+			err = fmt.Errorf("report exceeds the attachment size limit of %d bytes and no download link base URL is configured", s.cfg.MaxAttachmentSize)
+		}
+	}
+	if err == nil && s.sender == nil {
+		err = fmt.Errorf("email delivery is not configured")
+	}
+
+	var owner models.User
+	s.db.Select("username").First(&owner, schedule.UserID)
+	params, _ := parseReportParameters(schedule.Parameters)
+	values := reportValues(schedule, report, owner.Username, params)
+
+	for _, recipient := range recipients {
+		// Created claimed for the first attempt below
+		claimedUntil := s.claimUntil(time.Now())
+		delivery := &models.ReportDelivery{
+			ReportID:      report.ID,
+			ScheduleID:    schedule.ID,
+			Recipient:     recipient,
+			Method:        method,
+			Format:        format,
+			Status:        DeliveryStatusPending,
+			NextAttemptAt: &claimedUntil,
+		}
+		if err != nil {
+			delivery.Status = DeliveryStatusFailed
+			delivery.Error = err.Error()
+			delivery.NextAttemptAt = nil
+		}
+		if createErr := s.db.Create(delivery).Error; createErr != nil {
+			utils.Logger.Errorf("Failed to create report delivery record: %v", createErr)
+			continue
+		}
+		if err != nil {
+			utils.Logger.Errorf("Report %d was not delivered to %s: %v", report.ID, recipient, err)
+			continue
+		}
+
+		msg, msgErr := s.message(schedule, report, delivery, values, files)
+		if msgErr != nil {
+			delivery.Status = DeliveryStatusFailed
+			delivery.Error = msgErr.Error()
+			delivery.NextAttemptAt = nil
+			s.db.Save(delivery)
+			continue
+		}
+		go s.send(delivery, msg)
+	}
+}
+
+// Start runs the retry worker in the background until Stop. It does nothing when email
+// delivery is not configured.
+func (s *ReportDeliveryService) Start() {
+	if s.sender == nil || s.done != nil {
+		return
+	}
+	s.done = make(chan struct{})
+	go s.run()
+}
+
+// Stop stops the retry worker and waits for the deliveries it is sending
+func (s *ReportDeliveryService) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	if s.done != nil {
+		<-s.done
+	}
+}
+
+// run retries due deliveries every deliveryRetryInterval until stopped
+func (s *ReportDeliveryService) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(deliveryRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		s.retryDue(time.Now())
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// retryDue sends the pending deliveries whose next attempt is due and returns how many it
+// attempted. Each is claimed first, so that several servers never send the same delivery
+// at once. Pending deliveries without a next attempt time were left behind by servers
+// that retried in memory and are due too.
+func (s *ReportDeliveryService) retryDue(now time.Time) int {
+	var due []models.ReportDelivery
+	err := s.db.Where("status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", DeliveryStatusPending, now).
+		Order("id").Limit(deliveryRetryBatch).Find(&due).Error
+	if err != nil {
+		utils.Logger.Errorf("Failed to fetch due report deliveries: %v", err)
+		return 0
+	}
+
+	attempted := 0
+	for i := range due {
+		delivery := &due[i]
+		claimedUntil := s.claimUntil(now)
+		result := s.db.Model(&models.ReportDelivery{}).
+			Where("id = ? AND status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", delivery.ID, DeliveryStatusPending, now).
+			Update("next_attempt_at", claimedUntil)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		delivery.NextAttemptAt = &claimedUntil
+
+		msg, err := s.rebuild(delivery)
+		if err != nil {
+			delivery.Status = DeliveryStatusFailed
+			delivery.Error = err.Error()
+			delivery.NextAttemptAt = nil
+			s.db.Save(delivery)
+			utils.Logger.Errorf("Report %d could not be delivered to %s: %v", delivery.ReportID, delivery.Recipient, err)
+			continue
+		}
+		s.send(delivery, msg)
+		attempted++
+	}
+	return attempted
+}
+
+// claimUntil returns how long a delivery sent at now is claimed for
+func (s *ReportDeliveryService) claimUntil(now time.Time) time.Time {
+	return now.Add(s.cfg.Timeout + deliveryClaimMargin)
+}
+
+// rebuild writes the message of a delivery again from its report and schedule
+func (s *ReportDeliveryService) rebuild(delivery *models.ReportDelivery) (*mail.Message, error) {
+	var report models.Report
+	if err := s.db.First(&report, delivery.ReportID).Error; err != nil {
+		return nil, fmt.Errorf("could not load report: %w", err)
+	}
+	var schedule models.ReportSchedule
+	if err := s.db.First(&schedule, delivery.ScheduleID).Error; err != nil {
+		return nil, fmt.Errorf("could not load report schedule: %w", err)
+	}
+
+	var files []mail.Attachment
+	if delivery.Method == DeliveryMethodAttachment {
+		content, err := readBlob(s.blobStore, report.StorageKey, report.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		report.Content = content
+		if files, err = reportFiles(&report, delivery.Format); err != nil {
+			return nil, err
+		}
+	}
+
+	var owner models.User
+	s.db.Select("username").First(&owner, schedule.UserID)
+	params, _ := parseReportParameters(schedule.Parameters)
+	return s.message(&schedule, &report, delivery, reportValues(&schedule, &report, owner.Username, params), files)
+}
+
+// ListDeliveries returns the email deliveries of a report
+func (s *ReportDeliveryService) ListDeliveries(reportID uint, userID uint, isAdmin bool) ([]models.ReportDelivery, error) {
+	var report models.Report
+	if err := s.db.Select("id", "user_id").First(&report, reportID).Error; err != nil {
+		if errs.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.WrapError(err, "Could not fetch report")
+	}
+	if !isAdmin && report.UserID != userID {
+		return nil, errors.ErrForbidden
+	}
+
+	var deliveries []models.ReportDelivery
+	if err := s.db.Where("report_id = ?", reportID).Order("id").Find(&deliveries).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not fetch report deliveries")
+	}
+	return deliveries, nil
+}
+
+// OpenDownload returns the report file a download link was signed for. CSV reports with
+// several sheets are downloaded as a zip archive of the CSV files.
+func (s *ReportDeliveryService) OpenDownload(token string) (*ReportDownload, error) {
+	claims := &reportLinkClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return s.linkKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		if errs.Is(err, jwt.ErrTokenExpired) {
+			return nil, errors.ErrTokenExpired
+		}
+		return nil, errors.NewErrorWithSeverity(errors.ErrCodeInvalidToken, "Invalid download link", err, errors.SeverityMedium, errors.CategoryAuth)
+	}
+
+	var report models.Report
+	if err := s.db.First(&report, claims.ReportID).Error; err != nil {
+		if errs.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.WrapError(err, "Could not fetch report")
+	}
+	if report.Status != ReportStatusSuccess && report.Status != ReportStatusPartial {
+		return nil, errors.NewBadRequestError("Report is not ready for download", nil)
+	}
+
+	if claims.Format != ReportFormatCSV {
+		content, err := openBlob(s.blobStore, report.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		return &ReportDownload{
+			FileName:    ReportFileName(&report) + "." + ReportFormat(report.Format),
+			ContentType: ReportContentType(report.Format),
+			Size:        report.Size,
+			Content:     content,
+		}, nil
+	}
+
+	if report.Content, err = readBlob(s.blobStore, report.StorageKey, report.Checksum); err != nil {
+		return nil, err
+	}
+	files, err := reportFiles(&report, claims.Format)
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not read report")
+	}
+	download := &ReportDownload{FileName: ReportFileName(&report) + ".zip", ContentType: "application/zip"}
+	var data []byte
+	if len(files) == 1 {
+		download.FileName, download.ContentType, data = files[0].Name, files[0].ContentType, files[0].Data
+	} else if data, err = zipFiles(files); err != nil {
+		return nil, errors.WrapError(err, "Could not write report archive")
+	}
+	download.Size = int64(len(data))
+	download.Content = io.NopCloser(bytes.NewReader(data))
+	return download, nil
+}
+
+// message writes the email of a delivery, with the report attached or linked
+func (s *ReportDeliveryService) message(schedule *models.ReportSchedule, report *models.Report, delivery *models.ReportDelivery, values map[string]interface{}, files []mail.Attachment) (*mail.Message, error) {
+	link := ""
+	if s.cfg.LinkBaseURL != "" {
+		var err error
+		if link, err = s.downloadLink(report, delivery.Format, delivery.Recipient); err != nil {
+			return nil, fmt.Errorf("could not sign download link: %w", err)
+		}
+	}
+	recipientValues := make(map[string]interface{}, len(values)+2)
+	for k, v := range values {
+		recipientValues[k] = v
+	}
+	recipientValues["recipient"] = delivery.Recipient
+	recipientValues["link"] = link
+
+	subject, body := schedule.Subject, schedule.Body
+	if subject == "" {
+		subject = defaultReportSubject
+	}
+	if body == "" {
+		body = defaultReportBody
+		if delivery.Method == DeliveryMethodLink {
+			body = "The report {{report.name}} generated at {{report.generated_at}} is too large to attach."
+		}
+	}
+	msg := &mail.Message{From: s.cfg.From, To: []string{delivery.Recipient}}
+	msg.Subject, _ = exceltemplate.Expand(subject, recipientValues)
+	msg.Body, _ = exceltemplate.Expand(body, recipientValues)
+
+	if delivery.Method == DeliveryMethodLink {
+		if !strings.Contains(body, "{{link}}") {
+			msg.Body += fmt.Sprintf("\n\nDownload it from %s\nThe link expires on %s.", link, time.Now().Add(s.cfg.LinkTTL).In(reportLocation(report.TimeZone)).Format("2006-01-02 15:04 MST"))
+		}
+		return msg, nil
+	}
+	msg.Attachments = files
+	return msg, nil
+}
+
+// send makes one attempt at sending the message of a claimed delivery. A temporary
+// failure schedules the next attempt after a delay doubling with every attempt.
+func (s *ReportDeliveryService) send(delivery *models.ReportDelivery, msg *mail.Message) {
+	delivery.Attempts++
+	err := s.sender.Send(msg)
+	now := time.Now()
+	delivery.NextAttemptAt = nil
+	switch {
+	case err == nil:
+		delivery.Status = DeliveryStatusSent
+		delivery.Error = ""
+		delivery.SentAt = &now
+		utils.Logger.Infof("Report %d delivered to %s", delivery.ReportID, delivery.Recipient)
+	case mail.IsPermanent(err) || delivery.Attempts >= s.cfg.MaxAttempts:
+		delivery.Status = DeliveryStatusFailed
+		delivery.Error = err.Error()
+		utils.Logger.Errorf("Report %d could not be delivered to %s after %d attempts: %v", delivery.ReportID, delivery.Recipient, delivery.Attempts, err)
+	default:
+		delivery.Error = err.Error()
+		next := now.Add(retryBackoff(s.cfg.RetryDelay, delivery.Attempts))
+		delivery.NextAttemptAt = &next
+	}
+	if err := s.db.Save(delivery).Error; err != nil {
+		utils.Logger.Errorf("Failed to update report delivery record: %v", err)
+	}
+}
+
+// retryBackoff returns the delay after a failed attempt: delay, doubled for every earlier
+// attempt, up to a day
+func retryBackoff(delay time.Duration, attempts int) time.Duration {
+	for i := 1; i < attempts && delay < 24*time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > 24*time.Hour {
+		delay = 24 * time.Hour
+	}
+	return delay
+}
+
+// downloadLink signs a link to download a report in a format
+func (s *ReportDeliveryService) downloadLink(report *models.Report, format, recipient string) (string, error) {
+	now := time.Now()
+	claims := reportLinkClaims{
+		ReportID: report.ID,
+		Format:   format,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   recipient,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.LinkTTL)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.linkKey)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(s.cfg.LinkBaseURL, "/") + "/reports/download/" + url.PathEscape(token), nil
+}
+
+// SMTPMailSender sends email through the configured SMTP server. A password given as a
+// secret reference is resolved on every send, so that rotated passwords are picked up.
+type SMTPMailSender struct {
+	cfg config.EmailConfig
+}
+
+// NewSMTPMailSender creates a new SMTPMailSender instance
+func NewSMTPMailSender(cfg config.EmailConfig) *SMTPMailSender {
+	return &SMTPMailSender{cfg: cfg}
+}
+
+// Send sends a message in one SMTP session
+func (s *SMTPMailSender) Send(msg *mail.Message) error {
+	password := s.cfg.Password
+	if utils.IsSecretReference(password) {
+		resolved, err := utils.ResolveSecret(password)
+		if err != nil {
+			return fmt.Errorf("could not resolve SMTP password: %w", err)
+		}
+		password = resolved
+	}
+	return mail.NewClient(mail.Config{
+		Host:     s.cfg.Host,
+		Port:     s.cfg.Port,
+		Username: s.cfg.Username,
+		Password: password,
+		StartTLS: s.cfg.StartTLS,
+		Timeout:  s.cfg.Timeout,
+	}).Send(msg)
+}
+
+// parseReportRecipients decodes the JSON array of email addresses of a schedule
+func parseReportRecipients(raw string) ([]string, error) {
+	var recipients []string
+	if strings.TrimSpace(raw) == "" {
+		return recipients, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &recipients); err != nil {
+		return nil, fmt.Errorf("recipients must be a JSON array of email addresses: %w", err)
+	}
+	if len(recipients) > maxReportRecipients {
+		return nil, fmt.Errorf("a schedule can have at most %d recipients", maxReportRecipients)
+	}
+	for _, recipient := range recipients {
+		if _, err := netmail.ParseAddress(recipient); err != nil {
+			return nil, fmt.Errorf("invalid email address %q", recipient)
+		}
+	}
+	return recipients, nil
+}
+
+// validateReportEmail checks the recipients, subject and body templates and attachment
+// format of a schedule
+func validateReportEmail(schedule *models.ReportSchedule) error {
+	if _, err := parseReportRecipients(schedule.Recipients); err != nil {
+		return errors.NewBadRequestError("Invalid report recipients", err)
+	}
+	for _, text := range []string{schedule.Subject, schedule.Body} {
+		_, missing := exceltemplate.Expand(text, nil)
+		for _, name := range missing {
+			if !reportEmailPlaceholders[name] && !strings.HasPrefix(name, "param.") {
+				return errors.NewBadRequestError(fmt.Sprintf("Unknown placeholder {{%s}} in report email", name), nil)
+			}
+		}
+	}
+	switch schedule.AttachmentFormat {
+	case "", ReportFormat(schedule.Format):
+	case ReportFormatCSV:
+		if ReportFormat(schedule.Format) != ReportFormatExcel {
+			return errors.NewBadRequestError("CSV attachments require xlsx reports", nil)
+		}
+	default:
+		return errors.NewBadRequestError("Attachment format must be the report format or csv", nil)
+	}
+	return nil
+}
+
+// reportAttachmentFormat returns the format a report is emailed in
+func reportAttachmentFormat(schedule *models.ReportSchedule, report *models.Report) string {
+	if schedule.AttachmentFormat == ReportFormatCSV && ReportFormat(report.Format) == ReportFormatExcel {
+		return ReportFormatCSV
+	}
+	return ReportFormat(report.Format)
+}
+
+// reportFiles returns the files of a report in a format: the report itself, or a CSV file
+// per result sheet of a workbook
+func reportFiles(report *models.Report, format string) ([]mail.Attachment, error) {
+	name := ReportFileName(report)
+	if format != ReportFormatCSV {
+		return []mail.Attachment{{
+			Name:        name + "." + ReportFormat(report.Format),
+			ContentType: ReportContentType(report.Format),
+			Data:        report.Content,
+		}}, nil
+	}
+	if ReportFormat(report.Format) != ReportFormatExcel {
+		return nil, fmt.Errorf("%s reports cannot be converted to csv", report.Format)
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(report.Content))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var sheets []string
+	for _, sheet := range f.GetSheetList() {
+		if sheet != reportCoverSheet {
+			sheets = append(sheets, sheet)
+		}
+	}
+	files := make([]mail.Attachment, 0, len(sheets))
+	for _, sheet := range sheets {
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.WriteAll(rows)
+		if err := w.Error(); err != nil {
+			return nil, err
+		}
+		fileName := name + ".csv"
+		if len(sheets) > 1 {
+			fileName = name + "_" + sheet + ".csv"
+		}
+		files = append(files, mail.Attachment{Name: fileName, ContentType: "text/csv", Data: buf.Bytes()})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("report has no sheets to convert to csv")
+	}
+	return files, nil
+}
+
+// attachmentsSize returns the total size of files
+func attachmentsSize(files []mail.Attachment) int64 {
+	var size int64
+	for _, f := range files {
+		size += int64(len(f.Data))
+	}
+	return size
+}
+
+// zipFiles writes files into a zip archive
+func zipFiles(files []mail.Attachment) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(f.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"gobi/config"
+	"gobi/internal/models"
+	"gobi/pkg/blob"
+	"gobi/pkg/mail"
+	"io"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fakeMailSender records the messages it is given and fails with its scripted errors first
+type fakeMailSender struct {
+	mu       sync.Mutex
+	failures []error
+	sent     []*mail.Message
+}
+
+func (f *fakeMailSender) Send(msg *mail.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.failures) > 0 {
+		err := f.failures[0]
+		f.failures = f.failures[1:]
+		return err
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeMailSender) messages() []*mail.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*mail.Message(nil), f.sent...)
+}
+
+type deliveryFixture struct {
+	db        *gorm.DB
+	blobStore BlobStore
+	cfg       config.EmailConfig
+	schedule  *models.ReportSchedule
+	report    *models.Report
+}
+
+func newDeliveryFixture(t *testing.T) *deliveryFixture {
+	t.Helper()
+	db := newTestDB(t, &models.User{}, &models.ReportSchedule{}, &models.Report{}, &models.ReportDelivery{})
+	store, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	db.Create(owner)
+	schedule := &models.ReportSchedule{UserID: owner.ID, Name: "Weekly sales", Recipients: `["alice@example.com"]`}
+	db.Create(schedule)
+	report := &models.Report{UserID: owner.ID, ScheduleID: schedule.ID, Name: "Weekly sales", Format: ReportFormatPDF,
+		Status: ReportStatusSuccess, GeneratedAt: time.Now(), Content: []byte("%PDF-1.4 weekly sales")}
+	report.StorageKey, report.Checksum, err = putBlob(store, blobKindReport, owner.ID, ReportFormatPDF, report.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report.Size = int64(len(report.Content))
+	db.Create(report)
+
+	return &deliveryFixture{
+		db:        db,
+		blobStore: store,
+		cfg: config.EmailConfig{
+			From:              "reports@example.com",
+			Timeout:           30 * time.Second,
+			MaxAttachmentSize: 1 << 20,
+			MaxAttempts:       3,
+			RetryDelay:        time.Minute,
+			LinkTTL:           time.Hour,
+		},
+		schedule: schedule,
+		report:   report,
+	}
+}
+
+func (f *deliveryFixture) service(sender MailSender) *ReportDeliveryService {
+	return NewReportDeliveryService(f.db, sender, f.blobStore, f.cfg, "test-secret")
+}
+
+// waitForDelivery waits until the only delivery has made an attempt or failed
+func (f *deliveryFixture) waitForDelivery(t *testing.T) models.ReportDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var delivery models.ReportDelivery
+		if err := f.db.First(&delivery).Error; err == nil && (delivery.Attempts > 0 || delivery.Status == DeliveryStatusFailed) {
+			return delivery
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("delivery was not attempted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReportDeliveryRetriesSurviveRestart(t *testing.T) {
+	f := newDeliveryFixture(t)
+	first := &fakeMailSender{failures: []error{&textproto.Error{Code: 451, Msg: "4.3.0 try again later"}}}
+	f.service(first).Deliver(f.schedule, f.report)
+
+	delivery := f.waitForDelivery(t)
+	if delivery.Status != DeliveryStatusPending || delivery.NextAttemptAt == nil {
+		t.Fatalf("delivery after a temporary failure = %+v, want pending with a next attempt", delivery)
+	}
+	if wait := time.Until(*delivery.NextAttemptAt); wait < 50*time.Second || wait > f.cfg.RetryDelay {
+		t.Errorf("next attempt in %s, want about %s", wait, f.cfg.RetryDelay)
+	}
+
+	// A new service stands for the server after a restart: the retry comes from the record
+	second := &fakeMailSender{}
+	restarted := f.service(second)
+	if n := restarted.retryDue(time.Now()); n != 0 {
+		t.Fatalf("retryDue before the next attempt sent %d deliveries, want 0", n)
+	}
+	if n := restarted.retryDue(delivery.NextAttemptAt.Add(time.Second)); n != 1 {
+		t.Fatalf("retryDue sent %d deliveries, want 1", n)
+	}
+
+	delivery = models.ReportDelivery{}
+	f.db.First(&delivery)
+	if delivery.Status != DeliveryStatusSent || delivery.Attempts != 2 || delivery.NextAttemptAt != nil || delivery.SentAt == nil {
+		t.Errorf("delivery after the retry = %+v, want sent on the second attempt", delivery)
+	}
+	messages := second.messages()
+	if len(messages) != 1 || len(messages[0].Attachments) != 1 || string(messages[0].Attachments[0].Data) != string(f.report.Content) {
+		t.Fatalf("retried messages = %+v, want the report attached", messages)
+	}
+	if n := restarted.retryDue(time.Now().Add(time.Hour)); n != 0 {
+		t.Errorf("retryDue sent a delivered report again")
+	}
+}
+
+func TestReportDeliveryPermanentFailureIsNotRetried(t *testing.T) {
+	f := newDeliveryFixture(t)
+	sender := &fakeMailSender{failures: []error{&textproto.Error{Code: 550, Msg: "5.1.1 no such user"}}}
+	service := f.service(sender)
+	service.Deliver(f.schedule, f.report)
+
+	delivery := f.waitForDelivery(t)
+	if delivery.Status != DeliveryStatusFailed || delivery.NextAttemptAt != nil || !strings.Contains(delivery.Error, "no such user") {
+		t.Fatalf("delivery after a permanent failure = %+v, want failed", delivery)
+	}
+	if n := service.retryDue(time.Now().Add(24 * time.Hour)); n != 0 {
+		t.Errorf("retryDue sent %d failed deliveries, want 0", n)
+	}
+}
+
+func TestReportDeliveryLinksReportsOverTheAttachmentLimit(t *testing.T) {
+	f := newDeliveryFixture(t)
+	f.cfg.MaxAttachmentSize = int64(len(f.report.Content)) - 1
+	f.cfg.LinkBaseURL = "https://bi.example.com/"
+	sender := &fakeMailSender{}
+	service := f.service(sender)
+	service.Deliver(f.schedule, f.report)
+
+	delivery := f.waitForDelivery(t)
+	if delivery.Status != DeliveryStatusSent || delivery.Method != DeliveryMethodLink {
+		t.Fatalf("delivery = %+v, want sent as a link", delivery)
+	}
+	messages := sender.messages()
+	if len(messages) != 1 || len(messages[0].Attachments) != 0 {
+		t.Fatalf("messages = %+v, want one message without attachments", messages)
+	}
+	const prefix = "https://bi.example.com/reports/download/"
+	start := strings.Index(messages[0].Body, prefix)
+	if start < 0 {
+		t.Fatalf("body %q has no download link", messages[0].Body)
+	}
+	token := strings.Fields(messages[0].Body[start+len(prefix):])[0]
+
+	download, err := service.OpenDownload(token)
+	if err != nil {
+		t.Fatalf("OpenDownload: %v", err)
+	}
+	defer download.Content.Close()
+	content, _ := io.ReadAll(download.Content)
+	if string(content) != string(f.report.Content) {
+		t.Errorf("downloaded %q, want the report", content)
+	}
+	if _, err := service.OpenDownload(token + "x"); err == nil {
+		t.Error("OpenDownload accepted a tampered link")
+	}
+}
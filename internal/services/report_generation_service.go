@@ -3,11 +3,13 @@ package services
 import (
 	"encoding/json"
 	errs "errors"
-	"fmt"
+	"gobi/config"
 	"gobi/internal/models"
 	"gobi/internal/repositories"
 	"gobi/pkg/errors"
+	"gobi/pkg/pdf"
 	"gobi/pkg/utils"
+	"io"
 	"strconv"
 	"time"
 
@@ -16,9 +18,14 @@ import (
 
 // ReportGenerationService handles report generation business logic
 type ReportGenerationService struct {
-	db             *gorm.DB
-	webhookRepo    repositories.WebhookRepository
-	webhookTrigger WebhookTriggerService
+	db               *gorm.DB
+	webhookRepo      repositories.WebhookRepository
+	webhookTrigger   WebhookTriggerService
+	dashboardService *DashboardService
+	chartService     *ChartService
+	deliveryService  *ReportDeliveryService
+	blobStore        BlobStore
+	pdfConfig        config.PDFConfig
 }
 
 // NewReportGenerationService creates a new ReportGenerationService instance
@@ -26,11 +33,21 @@ func NewReportGenerationService(
 	db *gorm.DB,
 	webhookRepo repositories.WebhookRepository,
 	webhookTrigger WebhookTriggerService,
+	dashboardService *DashboardService,
+	chartService *ChartService,
+	deliveryService *ReportDeliveryService,
+	blobStore BlobStore,
+	pdfConfig config.PDFConfig,
 ) *ReportGenerationService {
 	return &ReportGenerationService{
-		db:             db,
-		webhookRepo:    webhookRepo,
-		webhookTrigger: webhookTrigger,
+		db:               db,
+		webhookRepo:      webhookRepo,
+		webhookTrigger:   webhookTrigger,
+		dashboardService: dashboardService,
+		chartService:     chartService,
+		deliveryService:  deliveryService,
+		blobStore:        blobStore,
+		pdfConfig:        pdfConfig,
 	}
 }
 
@@ -64,150 +81,204 @@ func (s *ReportGenerationService) GenerateExcelReport(chartID uint, templateID u
 		return nil, errors.ErrForbidden
 	}
 
+	content, err := readBlob(s.blobStore, template.StorageKey, template.Checksum)
+	if err != nil {
+		return nil, err
+	}
+
 	// Generate Excel report
-	return utils.GenerateExcelFromTemplate(chart.Data, template.Template, strconv.Itoa(int(chart.ID)))
+	return utils.GenerateExcelFromTemplate(chart.Data, content, strconv.Itoa(int(chart.ID)))
 }
 
-// GeneratePDFReport generates a PDF report from chart data
-func (s *ReportGenerationService) GeneratePDFReport(chartID uint, userID uint, isAdmin bool) ([]byte, error) {
-	// Get chart
-	var chart models.Chart
-	if err := s.db.First(&chart, chartID).Error; err != nil {
-		return nil, errors.ErrNotFound
+// GeneratePDFReport generates a PDF report of a chart: its image and its data. The layout
+// is a JSON layout template; empty is the default layout without a title page.
+func (s *ReportGenerationService) GeneratePDFReport(chartID uint, userID uint, isAdmin bool, layout []byte) ([]byte, error) {
+	pdfLayout, err := pdf.ParseLayout(layout)
+	if err != nil {
+		return nil, errors.NewBadRequestError("Invalid PDF layout", err)
 	}
-
-	// Permission check
-	if !isAdmin && chart.UserID != userID {
-		return nil, errors.ErrForbidden
+	if len(layout) == 0 {
+		titlePage := false
+		pdfLayout.TitlePage = &titlePage
 	}
 
-	// For now, return a simple PDF content
-	// In a real implementation, you would use a PDF generation library
-	pdfContent := fmt.Sprintf("PDF Report for Chart: %s\nGenerated at: %s\nData: %s",
-		chart.Name, time.Now().Format("2006-01-02 15:04:05"), chart.Data)
+	chart, err := s.chartService.GetChart(chartID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.chartService.chartData(chart, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
 
-	return []byte(pdfContent), nil
+	doc, err := s.newPDFDocument(pdfLayout)
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not create PDF report")
+	}
+	now := time.Now()
+	doc.SetInfo(chart.Name, "", now)
+	doc.TitlePage(chart.Name, now.Format("2006-01-02 15:04"), nil)
+	s.drawChart(doc, chart, data.Rows)
+	table, _ := chartTable(data)
+	s.writePDFTable(doc, table)
+	content, err := doc.Bytes()
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not write PDF report")
+	}
+	return content, nil
 }
 
-// GenerateScheduledReport generates a report based on a schedule
-func (s *ReportGenerationService) GenerateScheduledReport(schedule *models.ReportSchedule) error {
-	// Create a new report record
-	report := models.Report{
+// GenerateScheduledReport generates the report of a schedule run and emails it to the
+// recipients of the schedule; backfilled reports are not emailed. A run queued to
+// regenerate a report replaces that report instead. Sections that fail are recorded on
+// the report, which is then partial; it only fails when no section succeeds. The report
+// is returned with the error of a failed run; its ID is 0 when it could not be recorded.
+func (s *ReportGenerationService) GenerateScheduledReport(schedule *models.ReportSchedule, run *models.ScheduleRun) (*models.Report, error) {
+	if run.ReportID != 0 {
+		return s.regenerate(schedule, run)
+	}
+	report := &models.Report{
 		UserID:      schedule.UserID,
 		Name:        schedule.Name,
 		Type:        schedule.Type,
-		Status:      "generating",
-		GeneratedAt: time.Now(),
+		ScheduleID:  schedule.ID,
+		LogicalDate: run.LogicalDate,
 	}
-
-	if err := s.db.Create(&report).Error; err != nil {
-		return errors.WrapError(err, "Could not create report record")
+	if err := s.generate(schedule, report); err != nil {
+		return report, err
 	}
-
-	// Parse queries, charts, and templates
-	var queryIDs []uint
-	var chartIDs []uint
-	var templateIDs []uint
-
-	if schedule.Queries != "" {
-		if err := json.Unmarshal([]byte(schedule.Queries), &queryIDs); err != nil {
-			report.Status = "failed"
-			report.Error = "Invalid queries configuration"
-			s.db.Save(&report)
-			s.triggerReportWebhooks(schedule, &report, errors.NewError(errors.ErrCodeInvalidRequest, "Invalid queries configuration", err))
-			return errors.WrapError(err, "Invalid queries configuration")
-		}
+	if run.Trigger == ScheduleRunTriggerBackfill {
+		return report, nil
 	}
-
-	if schedule.Charts != "" {
-		if err := json.Unmarshal([]byte(schedule.Charts), &chartIDs); err != nil {
-			report.Status = "failed"
-			report.Error = "Invalid charts configuration"
-			s.db.Save(&report)
-			s.triggerReportWebhooks(schedule, &report, errors.NewError(errors.ErrCodeInvalidRequest, "Invalid charts configuration", err))
-			return errors.WrapError(err, "Invalid charts configuration")
-		}
+	if report.Status == ReportStatusSuccess || report.Status == ReportStatusPartial {
+		s.deliveryService.Deliver(schedule, report)
 	}
 
-	if schedule.Templates != "" {
-		if err := json.Unmarshal([]byte(schedule.Templates), &templateIDs); err != nil {
-			report.Status = "failed"
-			report.Error = "Invalid templates configuration"
-			s.db.Save(&report)
-			s.triggerReportWebhooks(schedule, &report, errors.NewError(errors.ErrCodeInvalidRequest, "Invalid templates configuration", err))
-			return errors.WrapError(err, "Invalid templates configuration")
+	// Update schedule status
+	schedule.LastRun = time.Now()
+	s.db.Model(schedule).Update("last_run", schedule.LastRun)
+	return report, nil
+}
+
+// RegenerateReport queues a manual run of the schedule of a report that generates the
+// report again in place, standing for the report's logical date. The report scheduler runs
+// it like other queued runs; the report is not emailed again.
+func (s *ReportGenerationService) RegenerateReport(report *models.Report, userID uint) (*models.ScheduleRun, error) {
+	if report.ScheduleID == 0 {
+		return nil, errors.NewBadRequestError("Report was not generated from a report schedule", nil)
+	}
+	var schedule models.ReportSchedule
+	if err := s.db.Select("id").First(&schedule, report.ScheduleID).Error; err != nil {
+		if errs.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.NewBadRequestError("Report schedule no longer exists", nil)
 		}
+		return nil, errors.WrapError(err, "Could not fetch report schedule")
 	}
 
-	// Generate report content based on configuration
-	content, err := s.generateReportContent(queryIDs, chartIDs, templateIDs)
+	var pending int64
+	err := s.db.Model(&models.ScheduleRun{}).
+		Where(map[string]interface{}{"report_id": report.ID, "status": []string{ScheduleRunQueued, ScheduleRunRunning}}).
+		Count(&pending).Error
 	if err != nil {
-		report.Status = "failed"
-		report.Error = err.Error()
-		s.db.Save(&report)
-		s.triggerReportWebhooks(schedule, &report, err)
-		return err
+		return nil, errors.WrapError(err, "Could not fetch schedule runs")
 	}
-
-	// Update report with success status
-	report.Status = "success"
-	report.Content = content
-	report.Error = ""
-	if err := s.db.Save(&report).Error; err != nil {
-		return errors.WrapError(err, "Could not update report status")
+	if pending > 0 || report.Status == ReportStatusGenerating {
+		return nil, errors.NewConflictError("Report is already being generated", nil)
 	}
 
-	// Update schedule status
-	schedule.LastRun = time.Now()
-	s.db.Save(schedule)
-
-	// Trigger webhook notifications
-	s.triggerReportWebhooks(schedule, &report, nil)
-
-	return nil
+	run := &models.ScheduleRun{
+		ScheduleID:  report.ScheduleID,
+		ReportID:    report.ID,
+		Trigger:     ScheduleRunTriggerManual,
+		LogicalDate: report.LogicalDate,
+		TriggeredBy: userID,
+		Status:      ScheduleRunQueued,
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not queue schedule run")
+	}
+	return run, nil
 }
 
-// generateReportContent generates the actual report content
-func (s *ReportGenerationService) generateReportContent(queryIDs []uint, chartIDs []uint, templateIDs []uint) ([]byte, error) {
-	// This is a simplified implementation
-	// In a real scenario, you would:
-	// 1. Execute queries and collect data
-	// 2. Generate charts
-	// 3. Apply templates
-	// 4. Combine everything into a final report
+// regenerate generates the report a run was queued for again, replacing its content. A
+// report that cannot be loaded is returned empty, so that no other report of the schedule
+// is failed in its place.
+func (s *ReportGenerationService) regenerate(schedule *models.ReportSchedule, run *models.ScheduleRun) (*models.Report, error) {
+	var report models.Report
+	if err := s.db.First(&report, run.ReportID).Error; err != nil {
+		if errs.Is(err, gorm.ErrRecordNotFound) {
+			return &models.Report{}, errors.NewError(errors.ErrCodeNotFound, "Report no longer exists", nil)
+		}
+		return &models.Report{}, errors.WrapError(err, "Could not fetch report")
+	}
+	if err := s.generate(schedule, &report); err != nil {
+		return &report, err
+	}
+	return &report, nil
+}
 
-	reportData := map[string]interface{}{
-		"generated_at": time.Now().Format("2006-01-02 15:04:05"),
-		"queries":      queryIDs,
-		"charts":       chartIDs,
-		"templates":    templateIDs,
+// generate builds the file of a schedule into report, saving the report before and
+// after, and notifies the webhooks of the schedule owner. The file goes to the blob store
+// under a new key; the file of an earlier run is deleted once the report points away from it.
+func (s *ReportGenerationService) generate(schedule *models.ReportSchedule, report *models.Report) error {
+	report.Status = ReportStatusGenerating
+	report.Error = ""
+	report.GeneratedAt = time.Now()
+	report.TimeZone = schedule.TimeZone
+	if err := s.db.Save(report).Error; err != nil {
+		return errors.WrapError(err, "Could not create report record")
 	}
 
-	// Convert to JSON for now
-	content, err := json.Marshal(reportData)
+	previousKey := report.StorageKey
+	err := s.buildReport(schedule, report)
+	if err == nil {
+		report.StorageKey, report.Checksum, err = putBlob(s.blobStore, blobKindReport, report.UserID, ReportFormat(report.Format), report.Content)
+		report.Size = int64(len(report.Content))
+	}
 	if err != nil {
-		return nil, errors.WrapError(err, "Could not marshal report data")
+		report.Status = ReportStatusFailed
+		report.Error = tileErrorMessage(err)
+		report.Content = nil
+		report.StorageKey, report.Size, report.Checksum = "", 0, ""
+		report.Sections = ""
+		s.db.Save(report)
+		deleteBlob(s.blobStore, previousKey)
+		s.triggerReportWebhooks(schedule, report, err)
+		return err
+	}
+	if err := s.db.Save(report).Error; err != nil {
+		deleteBlob(s.blobStore, report.StorageKey)
+		return errors.WrapError(err, "Could not update report status")
 	}
+	deleteBlob(s.blobStore, previousKey)
 
-	return content, nil
+	if report.Status == ReportStatusFailed {
+		err = errors.NewError(errors.ErrCodeInternalServer, report.Error, nil)
+	}
+	s.triggerReportWebhooks(schedule, report, err)
+	return nil
 }
 
-// DownloadReport downloads a generated report
-func (s *ReportGenerationService) DownloadReport(reportID uint, userID uint, isAdmin bool) (*models.Report, error) {
+// DownloadReport opens the file of a generated report for streaming from the blob store
+func (s *ReportGenerationService) DownloadReport(reportID uint, userID uint, isAdmin bool) (*models.Report, io.ReadCloser, error) {
 	var report models.Report
 	if err := s.db.First(&report, reportID).Error; err != nil {
-		return nil, errors.ErrNotFound
+		return nil, nil, errors.ErrNotFound
 	}
 
 	if !isAdmin && report.UserID != userID {
-		return nil, errors.ErrForbidden
+		return nil, nil, errors.ErrForbidden
 	}
 
-	if report.Status != "success" {
-		return nil, errors.NewBadRequestError("Report is not ready for download", nil)
+	if report.Status != ReportStatusSuccess && report.Status != ReportStatusPartial {
+		return nil, nil, errors.NewBadRequestError("Report is not ready for download", nil)
 	}
 
-	return &report, nil
+	content, err := openBlob(s.blobStore, report.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &report, content, nil
 }
 
 // triggerReportWebhooks sends webhook notifications for report events
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"fmt"
+	"gobi/internal/models"
+	"gobi/pkg/pdf"
+	"gobi/pkg/render"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportPDFContent is the content of a section of a PDF report: its table, and the chart
+// drawn above it for chart sections
+type reportPDFContent struct {
+	table *reportTable
+	chart *models.Chart
+	rows  []map[string]interface{}
+}
+
+// skipTemplates records the templates of a PDF report as skipped
+func (b *reportBuild) skipTemplates(templateIDs []uint) {
+	for _, id := range templateIDs {
+		b.sections = append(b.sections, ReportSection{
+			Kind:   "template",
+			ID:     id,
+			Status: "skipped",
+			Error:  "Excel templates do not apply to PDF reports",
+		})
+	}
+}
+
+// writePDF writes the report as a PDF: a title page with the run metadata, the parameters
+// and the outcome of every section, then a heading and a table per section
+func (b *reportBuild) writePDF() ([]byte, error) {
+	doc, err := b.service.newPDFDocument(b.layout)
+	if err != nil {
+		return nil, err
+	}
+	doc.SetInfo(b.schedule.Name, b.owner.Username, b.report.GeneratedAt)
+	doc.TitlePage(b.schedule.Name, b.report.GeneratedAt.In(reportLocation(b.report.TimeZone)).Format("2006-01-02 15:04 MST"), b.details())
+
+	if parameters := b.parameters(); len(parameters) > 0 {
+		doc.Heading("Parameters")
+		table := pdf.Table{Columns: []string{"Name", "Value"}}
+		for _, parameter := range parameters {
+			table.Rows = append(table.Rows, []string{parameter[0], parameter[1]})
+		}
+		doc.Table(table)
+	}
+	doc.Heading("Sections")
+	summary := pdf.Table{
+		Columns: []string{"Kind", "ID", "Name", "Status", "Rows", "Error"},
+		Align:   []pdf.Align{pdf.AlignLeft, pdf.AlignRight, pdf.AlignLeft, pdf.AlignLeft, pdf.AlignRight},
+	}
+	for _, section := range b.sections {
+		summary.Rows = append(summary.Rows, []string{
+			section.Kind, strconv.FormatUint(uint64(section.ID), 10), section.Name, section.Status, strconv.Itoa(section.Rows), section.Error,
+		})
+	}
+	doc.Table(summary)
+
+	for i, section := range b.sections {
+		if section.Kind == "template" {
+			continue
+		}
+		name := section.Name
+		if strings.TrimSpace(name) == "" {
+			name = fmt.Sprintf("%s %d", strings.ToUpper(section.Kind[:1])+section.Kind[1:], section.ID)
+		}
+		doc.Heading(name)
+		content, ok := b.pdfContent[i]
+		if !ok {
+			doc.Note("This section failed: " + section.Error)
+			continue
+		}
+		if content.chart != nil {
+			b.service.drawChart(doc, content.chart, content.rows)
+		}
+		b.service.writePDFTable(doc, content.table)
+	}
+	return doc.Bytes()
+}
+
+// newPDFDocument creates a document with the configured fonts
+func (s *ReportGenerationService) newPDFDocument(layout pdf.Layout) (*pdf.Document, error) {
+	fonts, err := pdf.LoadFonts(s.pdfConfig.FontPath, s.pdfConfig.BoldFontPath, s.pdfConfig.FontIndex)
+	if err != nil {
+		return nil, fmt.Errorf("could not load PDF fonts: %w", err)
+	}
+	return pdf.New(layout, fonts), nil
+}
+
+// drawChart adds the image of a chart to a document; charts that cannot be drawn get a note
+func (s *ReportGenerationService) drawChart(doc *pdf.Document, chart *models.Chart, rows []map[string]interface{}) {
+	if !render.Supported(chart.Type) {
+		return
+	}
+	spec, err := render.NewSpec(chart, rows, render.DefaultWidth, render.DefaultHeight)
+	var image []byte
+	if err == nil {
+		image, err = render.Render(spec, render.FormatPNG)
+	}
+	if err == nil {
+		err = doc.Image(image, "")
+	}
+	if err != nil {
+		doc.Note("The chart could not be drawn: " + err.Error())
+	}
+}
+
+// writePDFTable adds a result table to a document, numbers right aligned, and at most the
+// configured number of rows
+func (s *ReportGenerationService) writePDFTable(doc *pdf.Document, table *reportTable) {
+	if len(table.columns) == 0 {
+		doc.Note("No data")
+		return
+	}
+	t := pdf.Table{Columns: table.columns, Align: make([]pdf.Align, len(table.columns))}
+	for i, typ := range table.types {
+		if typ == columnInteger || typ == columnDecimal {
+			t.Align[i] = pdf.AlignRight
+		}
+	}
+	rows := table.rows
+	if limit := s.pdfConfig.MaxTableRows; limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, value := range row {
+			cells[i] = formatPDFValue(value, table.types[i])
+		}
+		t.Rows = append(t.Rows, cells)
+	}
+	if len(t.Rows) == 0 {
+		doc.Note("No rows")
+		return
+	}
+	doc.Table(t)
+	if len(rows) < len(table.rows) {
+		doc.Note(fmt.Sprintf("Showing the first %d of %d rows.", len(rows), len(table.rows)))
+	}
+}
+
+// formatPDFValue formats a cell value of a column of type typ the way the number and date
+// formats of the workbook show it
+func formatPDFValue(value interface{}, typ int) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		if typ == columnDate {
+			return v.Format("2006-01-02")
+		}
+		return v.Format("2006-01-02 15:04:05")
+	case float32:
+		return formatPDFValue(float64(v), typ)
+	case float64:
+		if typ == columnDecimal {
+			return groupThousands(strconv.FormatFloat(v, 'f', 2, 64))
+		}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		if typ == columnInteger || typ == columnDecimal {
+			text := fmt.Sprint(v)
+			if typ == columnDecimal {
+				text += ".00"
+			}
+			return groupThousands(text)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// groupThousands inserts thousands separators into a formatted number
+func groupThousands(number string) string {
+	sign := ""
+	if strings.HasPrefix(number, "-") {
+		sign, number = "-", number[1:]
+	}
+	integer, fraction := number, ""
+	if i := strings.IndexByte(number, '.'); i >= 0 {
+		integer, fraction = number[:i], number[i:]
+	}
+	var b strings.Builder
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return sign + b.String() + fraction
+}
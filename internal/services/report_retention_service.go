@@ -0,0 +1,490 @@
+package services
+
+import (
+	"context"
+	errs "errors"
+	"gobi/config"
+	"gobi/internal/models"
+	"gobi/pkg/blob"
+	"gobi/pkg/errors"
+	"gobi/pkg/utils"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Actions of a retention policy on expired reports
+const (
+	RetentionActionDelete  = "delete"
+	RetentionActionArchive = "archive" // the report stays, its file moves under archiveBlobPrefix
+)
+
+// Reasons a report expires
+const (
+	RetentionReasonKeepLast       = "keep_last"
+	RetentionReasonKeepDays       = "keep_days"
+	RetentionReasonKeepFailedDays = "keep_failed_days"
+)
+
+// archiveBlobPrefix is prepended to the keys of archived report files, so that bucket
+// lifecycle rules can move them to cheaper storage
+const archiveBlobPrefix = "archive/"
+
+// retentionRetryDelay is how long retention leaves a report alone after it failed to
+// delete or archive it, so that a few broken reports neither fill every batch nor log the
+// same error every interval
+const retentionRetryDelay = 24 * time.Hour
+
+// RetentionCandidate is a report that has expired under its retention policy
+type RetentionCandidate struct {
+	ReportID    uint      `json:"report_id"`
+	UserID      uint      `json:"user_id"`
+	ScheduleID  uint      `json:"schedule_id"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Size        int64     `json:"size"`
+	Action      string    `json:"action"`
+	Reason      string    `json:"reason"`
+}
+
+// RetentionPreview lists the reports the next retention run would delete or archive
+type RetentionPreview struct {
+	Reports []RetentionCandidate `json:"reports"`
+	Count   int                  `json:"count"`
+	Size    int64                `json:"size"` // bytes of report files freed or archived
+}
+
+// retentionScope is the user and schedule a policy applies to; schedule 0 is the user default
+type retentionScope struct {
+	userID     uint
+	scheduleID uint
+}
+
+// retentionReport is the part of a report retention looks at
+type retentionReport struct {
+	ID          uint
+	UserID      uint
+	ScheduleID  uint
+	Name        string
+	Status      string
+	Size        int64
+	GeneratedAt time.Time
+	CreatedAt   time.Time
+	// RetentionRetryAt is set while the report is left alone after a failure
+	RetentionRetryAt *time.Time
+}
+
+// ReportRetentionService applies retention policies to generated reports. A janitor runs
+// in the background and deletes or archives expired reports with their files; pinned
+// reports are exempt.
+type ReportRetentionService struct {
+	db        *gorm.DB
+	blobStore BlobStore
+	cfg       config.RetentionConfig
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewReportRetentionService creates a new ReportRetentionService instance. The policy of
+// cfg applies to users without a policy of their own.
+func NewReportRetentionService(db *gorm.DB, blobStore BlobStore, cfg config.RetentionConfig) *ReportRetentionService {
+	return &ReportRetentionService{
+		db:        db,
+		blobStore: blobStore,
+		cfg:       cfg,
+		stop:      make(chan struct{}),
+	}
+}
+
+// ListPolicies lists the retention policies of a user, or all policies for admins
+func (s *ReportRetentionService) ListPolicies(userID uint, isAdmin bool) ([]models.ReportRetentionPolicy, error) {
+	var policies []models.ReportRetentionPolicy
+	query := s.db.Order("user_id, schedule_id")
+	if !isAdmin {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.Find(&policies).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not fetch retention policies")
+	}
+	return policies, nil
+}
+
+// SetPolicy creates or replaces the default policy of a user, or the policy of a schedule
+// when policy.ScheduleID is set
+func (s *ReportRetentionService) SetPolicy(policy *models.ReportRetentionPolicy, userID uint, isAdmin bool) error {
+	if policy.KeepLast < 0 || policy.KeepDays < 0 || policy.KeepFailedDays < 0 {
+		return errors.NewBadRequestError("Retention limits must not be negative", nil)
+	}
+	switch policy.Action {
+	case "":
+		policy.Action = RetentionActionDelete
+	case RetentionActionDelete, RetentionActionArchive:
+	default:
+		return errors.NewBadRequestError("Retention action must be delete or archive", nil)
+	}
+
+	policy.UserID = userID
+	if policy.ScheduleID != 0 {
+		schedule, err := s.schedule(policy.ScheduleID, userID, isAdmin)
+		if err != nil {
+			return err
+		}
+		policy.UserID = schedule.UserID
+	}
+
+	var existing models.ReportRetentionPolicy
+	err := s.db.Where("user_id = ? AND schedule_id = ?", policy.UserID, policy.ScheduleID).Limit(1).Find(&existing).Error
+	if err != nil {
+		return errors.WrapError(err, "Could not fetch retention policy")
+	}
+	policy.ID, policy.CreatedAt = existing.ID, existing.CreatedAt
+	if err := s.db.Save(policy).Error; err != nil {
+		return errors.WrapError(err, "Could not save retention policy")
+	}
+	return nil
+}
+
+// DeletePolicy removes the default policy of a user, or the policy of a schedule when
+// scheduleID is not 0. The reports then fall back to the next policy.
+func (s *ReportRetentionService) DeletePolicy(scheduleID uint, userID uint, isAdmin bool) error {
+	ownerID := userID
+	if scheduleID != 0 {
+		schedule, err := s.schedule(scheduleID, userID, isAdmin)
+		if err != nil {
+			return err
+		}
+		ownerID = schedule.UserID
+	}
+
+	result := s.db.Where("user_id = ? AND schedule_id = ?", ownerID, scheduleID).Delete(&models.ReportRetentionPolicy{})
+	if result.Error != nil {
+		return errors.WrapError(result.Error, "Could not delete retention policy")
+	}
+	if result.RowsAffected == 0 {
+		return errors.ErrNotFound
+	}
+	return nil
+}
+
+// Preview lists the reports of a user, or of all users for admins, that a retention run
+// would delete or archive now
+func (s *ReportRetentionService) Preview(userID uint, isAdmin bool) (*RetentionPreview, error) {
+	ownerID := userID
+	if isAdmin {
+		ownerID = 0
+	}
+	candidates, err := s.expired(time.Now(), ownerID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &RetentionPreview{Reports: candidates, Count: len(candidates)}
+	if preview.Reports == nil {
+		preview.Reports = []RetentionCandidate{}
+	}
+	for _, c := range candidates {
+		preview.Size += c.Size
+	}
+	return preview, nil
+}
+
+// Apply deletes or archives up to one batch of expired reports and returns how many it
+// removed or archived. A report that fails is left alone for retentionRetryDelay.
+func (s *ReportRetentionService) Apply(now time.Time) (int, error) {
+	candidates, err := s.expired(now, 0, s.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, c := range candidates {
+		var err error
+		if c.Action == RetentionActionArchive {
+			err = s.archive(c, now)
+		} else {
+			err = s.delete(c)
+		}
+		if err != nil {
+			utils.Logger.Errorf("Failed to %s expired report %d, retrying in %s: %v", c.Action, c.ReportID, retentionRetryDelay, err)
+			s.postpone(c.ReportID, now.Add(retentionRetryDelay))
+			continue
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// postpone leaves a report alone until retryAt
+func (s *ReportRetentionService) postpone(reportID uint, retryAt time.Time) {
+	err := s.db.Model(&models.Report{}).Where("id = ?", reportID).Update("retention_retry_at", retryAt).Error
+	if err != nil {
+		utils.Logger.Errorf("Failed to postpone retention of report %d: %v", reportID, err)
+	}
+}
+
+// Start runs the janitor in the background every configured interval until Stop. It does
+// nothing when retention is disabled.
+func (s *ReportRetentionService) Start() {
+	if !s.cfg.Enabled || s.done != nil {
+		return
+	}
+	s.done = make(chan struct{})
+	go s.run()
+}
+
+// Stop stops the janitor and waits for a running pass to finish
+func (s *ReportRetentionService) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	if s.done != nil {
+		<-s.done
+	}
+}
+
+// run applies retention until stopped. A pass that fills its batch is followed by the
+// next one right away.
+func (s *ReportRetentionService) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		applied, err := s.Apply(time.Now())
+		if err != nil {
+			utils.Logger.Errorf("Failed to apply report retention: %v", err)
+		} else if applied > 0 {
+			utils.Logger.Infof("Report retention removed or archived %d reports", applied)
+		}
+
+		if applied >= s.cfg.BatchSize {
+			select {
+			case <-s.stop:
+				return
+			default:
+				continue
+			}
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expired returns the reports that have expired under their policy, of one user or of all
+// users when ownerID is 0, and at most limit of them unless limit is 0. Reports are
+// grouped by schedule, newest first; pinned, archived and generating reports are skipped
+// and do not count towards keep_last. Reports postponed after a failure still count but
+// are not returned until their retry time.
+func (s *ReportRetentionService) expired(now time.Time, ownerID uint, limit int) ([]RetentionCandidate, error) {
+	policies, err := s.ListPolicies(ownerID, ownerID == 0)
+	if err != nil {
+		return nil, err
+	}
+	byScope := make(map[retentionScope]models.ReportRetentionPolicy, len(policies))
+	for _, p := range policies {
+		byScope[retentionScope{p.UserID, p.ScheduleID}] = p
+	}
+	fallback := models.ReportRetentionPolicy{
+		KeepLast:       s.cfg.KeepLast,
+		KeepDays:       s.cfg.KeepDays,
+		KeepFailedDays: s.cfg.KeepFailedDays,
+		Action:         s.cfg.Action,
+	}
+
+	query := s.db.Model(&models.Report{}).
+		Select("id, user_id, schedule_id, name, status, size, generated_at, created_at, retention_retry_at").
+		Where("pinned = ? AND archived_at IS NULL AND status <> ?", false, ReportStatusGenerating).
+		Order("user_id, schedule_id, generated_at DESC, id DESC")
+	if ownerID != 0 {
+		query = query.Where("user_id = ?", ownerID)
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, errors.WrapError(err, "Could not fetch reports")
+	}
+	defer rows.Close()
+
+	var candidates []RetentionCandidate
+	var scope retentionScope
+	var policy models.ReportRetentionPolicy
+	kept := 0
+	first := true
+	for rows.Next() {
+		var r retentionReport
+		if err := s.db.ScanRows(rows, &r); err != nil {
+			return nil, errors.WrapError(err, "Could not read reports")
+		}
+		if current := (retentionScope{r.UserID, r.ScheduleID}); first || current != scope {
+			scope, kept, first = current, 0, false
+			policy = retentionPolicy(byScope, scope, fallback)
+		}
+
+		reason := retentionReason(policy, &r, now, &kept)
+		if reason == "" || (r.RetentionRetryAt != nil && r.RetentionRetryAt.After(now)) {
+			continue
+		}
+		candidates = append(candidates, RetentionCandidate{
+			ReportID:    r.ID,
+			UserID:      r.UserID,
+			ScheduleID:  r.ScheduleID,
+			Name:        r.Name,
+			Status:      r.Status,
+			GeneratedAt: r.GeneratedAt,
+			Size:        r.Size,
+			Action:      policy.Action,
+			Reason:      reason,
+		})
+		if limit > 0 && len(candidates) >= limit {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.WrapError(err, "Could not read reports")
+	}
+	return candidates, nil
+}
+
+// retentionPolicy returns the policy of the reports of a schedule: the schedule's own,
+// else the default of its user, else the server default
+func retentionPolicy(policies map[retentionScope]models.ReportRetentionPolicy, scope retentionScope, fallback models.ReportRetentionPolicy) models.ReportRetentionPolicy {
+	policy, ok := policies[scope]
+	if !ok {
+		policy, ok = policies[retentionScope{userID: scope.userID}]
+	}
+	if !ok {
+		policy = fallback
+	}
+	if policy.Action == "" {
+		policy.Action = RetentionActionDelete
+	}
+	return policy
+}
+
+// retentionReason returns why a report has expired under a policy, or "" when it is kept.
+// kept counts the newer successful reports of the same schedule.
+func retentionReason(policy models.ReportRetentionPolicy, r *retentionReport, now time.Time, kept *int) string {
+	generatedAt := r.GeneratedAt
+	if generatedAt.IsZero() {
+		generatedAt = r.CreatedAt
+	}
+	olderThan := func(days int) bool {
+		return days > 0 && generatedAt.Before(now.AddDate(0, 0, -days))
+	}
+
+	if r.Status == ReportStatusFailed {
+		days := policy.KeepFailedDays
+		if days == 0 {
+			days = policy.KeepDays
+		}
+		if olderThan(days) {
+			return RetentionReasonKeepFailedDays
+		}
+		return ""
+	}
+
+	*kept++
+	if policy.KeepLast > 0 && *kept > policy.KeepLast {
+		return RetentionReasonKeepLast
+	}
+	if olderThan(policy.KeepDays) {
+		return RetentionReasonKeepDays
+	}
+	return ""
+}
+
+// delete removes an expired report with its deliveries and file, unless it was pinned or
+// regenerated since it was found
+func (s *ReportRetentionService) delete(c RetentionCandidate) error {
+	var report models.Report
+	if err := s.db.Select("id, storage_key").First(&report, c.ReportID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+
+	removed := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().
+			Where("id = ? AND pinned = ? AND status <> ? AND COALESCE(storage_key, '') = ?", report.ID, false, ReportStatusGenerating, report.StorageKey).
+			Delete(&models.Report{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		removed = true
+		return tx.Where("report_id = ?", report.ID).Delete(&models.ReportDelivery{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	if removed {
+		deleteBlob(s.blobStore, report.StorageKey)
+	}
+	return nil
+}
+
+// archive moves the file of an expired report under archiveBlobPrefix and marks the report
+// archived. The report stays listed and downloadable. Every attempt copies the file to a
+// key of its own, so when several servers archive the same report, the one whose update
+// loses only deletes its own copy. A report whose file is missing is marked archived as
+// it is: there is nothing to move, and trying again would not find the file either.
+func (s *ReportRetentionService) archive(c RetentionCandidate, now time.Time) error {
+	var report models.Report
+	if err := s.db.Select("id, user_id, storage_key, checksum").First(&report, c.ReportID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+
+	archivedKey := report.StorageKey
+	if report.StorageKey != "" {
+		data, err := readBlob(s.blobStore, report.StorageKey, report.Checksum)
+		switch {
+		case errs.Is(err, blob.ErrNotFound):
+			utils.Logger.Warnf("File %s of expired report %d is missing, archiving the report without it", report.StorageKey, report.ID)
+		case err != nil:
+			return err
+		default:
+			archivedKey = archiveBlobPrefix + blob.NewKey(blobKindReport, report.UserID, strings.TrimPrefix(path.Ext(report.StorageKey), "."))
+			if err := s.blobStore.Put(context.Background(), archivedKey, data); err != nil {
+				return errors.WrapError(err, "Could not store file")
+			}
+		}
+	}
+
+	result := s.db.Model(&models.Report{}).
+		Where("id = ? AND pinned = ? AND archived_at IS NULL AND COALESCE(storage_key, '') = ?", report.ID, false, report.StorageKey).
+		Updates(map[string]interface{}{"storage_key": archivedKey, "archived_at": now, "retention_retry_at": nil})
+	if result.Error != nil || result.RowsAffected == 0 {
+		if archivedKey != report.StorageKey {
+			deleteBlob(s.blobStore, archivedKey)
+		}
+		return result.Error
+	}
+	if archivedKey != report.StorageKey {
+		deleteBlob(s.blobStore, report.StorageKey)
+	}
+	return nil
+}
+
+// schedule returns a schedule the user may manage
+func (s *ReportRetentionService) schedule(scheduleID uint, userID uint, isAdmin bool) (*models.ReportSchedule, error) {
+	var schedule models.ReportSchedule
+	if err := s.db.Select("id, user_id").First(&schedule, scheduleID).Error; err != nil {
+		return nil, errors.ErrNotFound
+	}
+	if !isAdmin && schedule.UserID != userID {
+		return nil, errors.ErrForbidden
+	}
+	return &schedule, nil
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"gobi/config"
+	"gobi/internal/models"
+	"gobi/pkg/blob"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetentionPostponesReportsItCannotArchive(t *testing.T) {
+	db := newTestDB(t, &models.Report{}, &models.ReportDelivery{}, &models.ReportRetentionPolicy{})
+	store, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	newReport := func(name string, age time.Duration, content string) *models.Report {
+		report := &models.Report{UserID: 1, ScheduleID: 1, Name: name, Status: ReportStatusSuccess, GeneratedAt: now.Add(-age)}
+		report.StorageKey, report.Checksum, err = putBlob(store, blobKindReport, 1, "xlsx", []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Create(report)
+		return report
+	}
+	newReport("kept", time.Hour, "newest")
+	corrupt := newReport("corrupt", 2*time.Hour, "original")
+	db.Model(corrupt).Update("checksum", blob.Checksum([]byte("something else")))
+	missing := newReport("missing", 3*time.Hour, "gone")
+	deleteBlob(store, missing.StorageKey)
+	healthy := newReport("healthy", 4*time.Hour, "oldest")
+
+	// A batch of two fills with the corrupt and the missing report first
+	service := NewReportRetentionService(db, store, config.RetentionConfig{KeepLast: 1, Action: RetentionActionArchive, BatchSize: 2})
+	if applied, err := service.Apply(now); err != nil || applied != 1 {
+		t.Fatalf("first Apply = %d, %v, want the report with a missing file archived", applied, err)
+	}
+	if applied, err := service.Apply(now); err != nil || applied != 1 {
+		t.Fatalf("second Apply = %d, %v, want it to get past the corrupt report", applied, err)
+	}
+	if applied, err := service.Apply(now.Add(time.Hour)); err != nil || applied != 0 {
+		t.Fatalf("third Apply = %d, %v, want the corrupt report left alone", applied, err)
+	}
+
+	reload := func(id uint) models.Report {
+		var report models.Report
+		db.First(&report, id)
+		return report
+	}
+	if r := reload(missing.ID); r.ArchivedAt == nil || r.StorageKey != missing.StorageKey {
+		t.Errorf("report with a missing file = %+v, want it archived as it is", r)
+	}
+	if r := reload(healthy.ID); r.ArchivedAt == nil || !strings.HasPrefix(r.StorageKey, archiveBlobPrefix) {
+		t.Errorf("healthy report = %+v, want it archived", r)
+	} else if data, err := readBlob(store, r.StorageKey, r.Checksum); err != nil || string(data) != "oldest" {
+		t.Errorf("archived file = %q, %v, want the report", data, err)
+	}
+	r := reload(corrupt.ID)
+	if r.ArchivedAt != nil || r.RetentionRetryAt == nil || !r.RetentionRetryAt.Equal(now.Add(retentionRetryDelay)) {
+		t.Errorf("corrupt report = %+v, want it postponed by %s", r, retentionRetryDelay)
+	}
+
+	preview, err := service.Preview(0, true)
+	if err != nil || preview.Count != 0 {
+		t.Errorf("Preview = %+v, %v, want the postponed report left out", preview, err)
+	}
+	if candidates, _ := service.expired(now.Add(retentionRetryDelay+time.Minute), 0, 0); len(candidates) != 1 || candidates[0].ReportID != corrupt.ID {
+		t.Errorf("candidates after the retry delay = %+v, want the corrupt report again", candidates)
+	}
+}
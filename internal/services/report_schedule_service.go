@@ -1,14 +1,28 @@
 package services
 
 import (
+	"fmt"
 	"gobi/internal/models"
 	"gobi/pkg/errors"
+	"gobi/pkg/pdf"
 	"gobi/pkg/utils"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+const (
+	// defaultSchedulePreviewRuns is the number of run times previewed by default
+	defaultSchedulePreviewRuns = 5
+	// maxSchedulePreviewRuns caps the run times of a preview
+	maxSchedulePreviewRuns = 100
+	// maxBackfillRuns caps the runs queued by one backfill
+	maxBackfillRuns = 100
+	// scheduleRunLimit is the default number of runs listed, up to maxScheduleRunLimit
+	scheduleRunLimit    = 100
+	maxScheduleRunLimit = 1000
+)
+
 // ReportScheduleService handles report schedule-related business logic
 type ReportScheduleService struct {
 	db *gorm.DB
@@ -25,10 +39,22 @@ func (s *ReportScheduleService) CreateReportSchedule(schedule *models.ReportSche
 	if err := utils.ValidateCronPattern(schedule.CronPattern); err != nil {
 		return errors.NewBadRequestError("Invalid cron pattern", err)
 	}
+	if _, err := utils.LoadScheduleLocation(schedule.TimeZone); err != nil {
+		return errors.NewBadRequestError("Invalid time zone", err)
+	}
+	if _, err := parseReportParameters(schedule.Parameters); err != nil {
+		return errors.NewBadRequestError("Invalid report parameters", err)
+	}
+	if err := validateReportOutput(schedule.Format, schedule.Layout); err != nil {
+		return err
+	}
+	if err := validateReportEmail(schedule); err != nil {
+		return err
+	}
 
 	schedule.UserID = userID
 	schedule.Active = true
-	schedule.NextRun = s.calculateNextRunFromCron(schedule.CronPattern)
+	schedule.NextRun = s.calculateNextRun(schedule)
 
 	if err := s.db.Create(schedule).Error; err != nil {
 		return errors.WrapError(err, "Could not create report schedule")
@@ -67,7 +93,9 @@ func (s *ReportScheduleService) GetReportSchedule(scheduleID uint, userID uint,
 	return &schedule, nil
 }
 
-// UpdateReportSchedule updates a report schedule
+// UpdateReportSchedule updates a report schedule. Only the edited columns are written, so
+// an edit does not undo the lease, next run or last run a scheduler sets meanwhile; the
+// next run is only recalculated when the cron pattern or time zone changes.
 func (s *ReportScheduleService) UpdateReportSchedule(scheduleID uint, updates *models.ReportSchedule, userID uint, isAdmin bool) (*models.ReportSchedule, error) {
 	var schedule models.ReportSchedule
 	if err := s.db.First(&schedule, scheduleID).Error; err != nil {
@@ -78,35 +106,100 @@ func (s *ReportScheduleService) UpdateReportSchedule(scheduleID uint, updates *m
 		return nil, errors.ErrForbidden
 	}
 
+	changes := map[string]interface{}{}
 	if updates.Active != schedule.Active {
 		schedule.Active = updates.Active
+		changes["active"] = updates.Active
 	}
 
 	if updates.Name != "" {
 		schedule.Name = updates.Name
+		changes["name"] = updates.Name
 	}
 	if updates.Type != "" {
 		schedule.Type = updates.Type
+		changes["type"] = updates.Type
 	}
 	if updates.Queries != "" {
 		schedule.Queries = updates.Queries
+		changes["queries"] = updates.Queries
 	}
 	if updates.Charts != "" {
 		schedule.Charts = updates.Charts
+		changes["charts"] = updates.Charts
 	}
 	if updates.Templates != "" {
 		schedule.Templates = updates.Templates
+		changes["templates"] = updates.Templates
+	}
+	if updates.Parameters != "" {
+		if _, err := parseReportParameters(updates.Parameters); err != nil {
+			return nil, errors.NewBadRequestError("Invalid report parameters", err)
+		}
+		schedule.Parameters = updates.Parameters
+		changes["parameters"] = updates.Parameters
+	}
+	if updates.Format != "" || updates.Layout != "" {
+		format, layout := schedule.Format, schedule.Layout
+		if updates.Format != "" {
+			format = updates.Format
+		}
+		if updates.Layout != "" {
+			layout = updates.Layout
+		}
+		if err := validateReportOutput(format, layout); err != nil {
+			return nil, err
+		}
+		schedule.Format, schedule.Layout = format, layout
+		changes["format"], changes["layout"] = format, layout
+	}
+	if updates.Recipients != "" {
+		schedule.Recipients = updates.Recipients
+		changes["recipients"] = updates.Recipients
+	}
+	if updates.Subject != "" {
+		schedule.Subject = updates.Subject
+		changes["subject"] = updates.Subject
 	}
-	if updates.CronPattern != "" {
+	if updates.Body != "" {
+		schedule.Body = updates.Body
+		changes["body"] = updates.Body
+	}
+	if updates.AttachmentFormat != "" {
+		schedule.AttachmentFormat = updates.AttachmentFormat
+		changes["attachment_format"] = updates.AttachmentFormat
+	}
+	if err := validateReportEmail(&schedule); err != nil {
+		return nil, err
+	}
+	timing := false
+	if updates.CronPattern != "" && updates.CronPattern != schedule.CronPattern {
 		if err := utils.ValidateCronPattern(updates.CronPattern); err != nil {
 			return nil, errors.NewBadRequestError("Invalid cron pattern", err)
 		}
 		schedule.CronPattern = updates.CronPattern
-		schedule.NextRun = s.calculateNextRunFromCron(updates.CronPattern)
+		changes["cron_pattern"] = updates.CronPattern
+		timing = true
+	}
+	if updates.TimeZone != "" && updates.TimeZone != schedule.TimeZone {
+		if _, err := utils.LoadScheduleLocation(updates.TimeZone); err != nil {
+			return nil, errors.NewBadRequestError("Invalid time zone", err)
+		}
+		schedule.TimeZone = updates.TimeZone
+		changes["time_zone"] = updates.TimeZone
+		timing = true
+	}
+	if timing {
+		changes["next_run"] = s.calculateNextRun(&schedule)
 	}
 
-	if err := s.db.Save(&schedule).Error; err != nil {
-		return nil, errors.WrapError(err, "Could not update report schedule")
+	if len(changes) > 0 {
+		if err := s.db.Model(&models.ReportSchedule{}).Where("id = ?", schedule.ID).Updates(changes).Error; err != nil {
+			return nil, errors.WrapError(err, "Could not update report schedule")
+		}
+	}
+	if err := s.db.First(&schedule, scheduleID).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not fetch report schedule")
 	}
 
 	return &schedule, nil
@@ -130,7 +223,166 @@ func (s *ReportScheduleService) DeleteReportSchedule(scheduleID uint, userID uin
 	return nil
 }
 
-// calculateNextRunFromCron calculates the next run time based on cron pattern
-func (s *ReportScheduleService) calculateNextRunFromCron(cronPattern string) time.Time {
-	return utils.CalculateNextRunFromCron(cronPattern, time.Now().Add(24*time.Hour))
+// RunSchedule queues a run of a schedule now, standing for the current time. The report
+// scheduler picks it up and emails the report as for a cron run.
+func (s *ReportScheduleService) RunSchedule(scheduleID uint, userID uint, isAdmin bool) (*models.ScheduleRun, error) {
+	if _, err := s.GetReportSchedule(scheduleID, userID, isAdmin); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Truncate(time.Minute)
+	run := &models.ScheduleRun{
+		ScheduleID:  scheduleID,
+		Trigger:     ScheduleRunTriggerManual,
+		LogicalDate: &now,
+		TriggeredBy: userID,
+		Status:      ScheduleRunQueued,
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not queue schedule run")
+	}
+	return run, nil
+}
+
+// BackfillSchedule queues a run for every time the cron pattern of a schedule fired between
+// from and to, read in the schedule's time zone, each standing for its run time. Dates
+// without a time take the whole day for to. Run times still to come are left to the
+// scheduler. Backfilled reports are not emailed.
+func (s *ReportScheduleService) BackfillSchedule(scheduleID uint, from, to string, userID uint, isAdmin bool) ([]models.ScheduleRun, error) {
+	schedule, err := s.GetReportSchedule(scheduleID, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := utils.LoadScheduleLocation(schedule.TimeZone)
+	if err != nil {
+		return nil, errors.NewBadRequestError("Invalid time zone", err)
+	}
+	start, _, err := parseBackfillTime(from, loc)
+	if err != nil {
+		return nil, errors.NewBadRequestError("Invalid backfill start", err)
+	}
+	end, dateOnly, err := parseBackfillTime(to, loc)
+	if err != nil {
+		return nil, errors.NewBadRequestError("Invalid backfill end", err)
+	}
+	if dateOnly {
+		end = time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, loc).Add(-time.Nanosecond)
+	}
+	if now := time.Now(); end.After(now) {
+		end = now
+	}
+	if end.Before(start) {
+		return nil, errors.NewBadRequestError("Backfill must end after it starts, and start in the past", nil)
+	}
+
+	times, err := utils.NextCronRuns(schedule.CronPattern, loc, start.Add(-time.Nanosecond), maxBackfillRuns+1)
+	if err != nil {
+		return nil, errors.NewBadRequestError("Invalid cron pattern", err)
+	}
+	var runs []models.ScheduleRun
+	for i := range times {
+		if times[i].After(end) {
+			break
+		}
+		runs = append(runs, models.ScheduleRun{
+			ScheduleID:  scheduleID,
+			Trigger:     ScheduleRunTriggerBackfill,
+			LogicalDate: &times[i],
+			TriggeredBy: userID,
+			Status:      ScheduleRunQueued,
+		})
+	}
+	if len(runs) == 0 {
+		return nil, errors.NewBadRequestError("Schedule did not run between the backfill start and end", nil)
+	}
+	if len(runs) > maxBackfillRuns {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("At most %d runs can be backfilled at once", maxBackfillRuns), nil)
+	}
+
+	if err := s.db.Create(&runs).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not queue backfill runs")
+	}
+	return runs, nil
+}
+
+// ListScheduleRuns lists the latest runs of a schedule, newest first, optionally only
+// those of a trigger or status
+func (s *ReportScheduleService) ListScheduleRuns(scheduleID uint, trigger, status string, limit int, userID uint, isAdmin bool) ([]models.ScheduleRun, error) {
+	if _, err := s.GetReportSchedule(scheduleID, userID, isAdmin); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = scheduleRunLimit
+	}
+	if limit > maxScheduleRunLimit {
+		limit = maxScheduleRunLimit
+	}
+
+	// trigger is a reserved word in some databases; map conditions quote the column
+	conditions := map[string]interface{}{"schedule_id": scheduleID}
+	if trigger != "" {
+		conditions["trigger"] = trigger
+	}
+	if status != "" {
+		conditions["status"] = status
+	}
+	var runs []models.ScheduleRun
+	if err := s.db.Where(conditions).Order("id DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, errors.WrapError(err, "Could not fetch schedule runs")
+	}
+	return runs, nil
+}
+
+// parseBackfillTime parses a backfill bound in one of the date filter formats, in loc
+// unless it has an offset, and reports whether it is a date without a time
+func parseBackfillTime(value string, loc *time.Location) (time.Time, bool, error) {
+	for _, layout := range filterDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, layout == "2006-01-02", nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("%q must be a date such as 2006-01-02", value)
+}
+
+// SchedulePreview lists the next run times of a cron pattern in a time zone
+type SchedulePreview struct {
+	CronPattern string      `json:"cron_pattern"`
+	TimeZone    string      `json:"time_zone"`
+	NextRuns    []time.Time `json:"next_runs"`
+}
+
+// PreviewNextRuns returns the next count run times of a cron pattern in an IANA time zone,
+// the server's when empty, so that a schedule can be checked before it is saved
+func (s *ReportScheduleService) PreviewNextRuns(cronPattern, zone string, count int) (*SchedulePreview, error) {
+	if count <= 0 {
+		count = defaultSchedulePreviewRuns
+	}
+	if count > maxSchedulePreviewRuns {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("At most %d run times can be previewed", maxSchedulePreviewRuns), nil)
+	}
+	loc, err := utils.LoadScheduleLocation(zone)
+	if err != nil {
+		return nil, errors.NewBadRequestError("Invalid time zone", err)
+	}
+	runs, err := utils.NextCronRuns(cronPattern, loc, time.Now(), count)
+	if err != nil {
+		return nil, errors.NewBadRequestError("Invalid cron pattern", err)
+	}
+	return &SchedulePreview{CronPattern: cronPattern, TimeZone: loc.String(), NextRuns: runs}, nil
+}
+
+// calculateNextRun calculates the next run time of a schedule in its time zone
+func (s *ReportScheduleService) calculateNextRun(schedule *models.ReportSchedule) time.Time {
+	return utils.CalculateNextRunInZone(schedule.CronPattern, schedule.TimeZone, time.Now().Add(24*time.Hour))
+}
+
+// validateReportOutput checks the output format of a schedule and its PDF layout template
+func validateReportOutput(format, layout string) error {
+	if format != "" && format != ReportFormatExcel && format != ReportFormatPDF {
+		return errors.NewBadRequestError("Report format must be xlsx or pdf", nil)
+	}
+	if _, err := pdf.ParseLayout([]byte(layout)); err != nil {
+		return errors.NewBadRequestError("Invalid PDF layout", err)
+	}
+	return nil
 }
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"gobi/config"
+	"gobi/internal/models"
+	"testing"
+	"time"
+)
+
+func TestUpdateReportScheduleKeepsTheLeaseOfARunningSchedule(t *testing.T) {
+	db := newTestDB(t, &models.User{}, &models.ReportSchedule{})
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	db.Create(owner)
+	now := time.Now()
+	due := now.Add(-time.Minute)
+	schedule := &models.ReportSchedule{UserID: owner.ID, Name: "Daily sales", Active: true, CronPattern: "0 8 * * *", NextRun: due}
+	db.Create(schedule)
+
+	schedules := NewReportScheduleService(db)
+	scheduler := NewReportScheduler(db, nil, config.SchedulerConfig{Workers: 1, LeaseDuration: time.Minute})
+	claimed, ok := scheduler.claim(schedule.ID, now)
+	if !ok {
+		t.Fatal("claim failed")
+	}
+
+	// The owner edits the schedule while the scheduler generates its report
+	updated, err := schedules.UpdateReportSchedule(schedule.ID, &models.ReportSchedule{Name: "Daily revenue", Active: true, CronPattern: "0 8 * * *"}, owner.ID, false)
+	if err != nil {
+		t.Fatalf("UpdateReportSchedule: %v", err)
+	}
+	if updated.Name != "Daily revenue" {
+		t.Errorf("name = %q, want the edit", updated.Name)
+	}
+	var stored models.ReportSchedule
+	db.First(&stored, schedule.ID)
+	if stored.LeaseOwner != scheduler.id || stored.LeaseExpiresAt == nil || !stored.NextRun.Equal(due) {
+		t.Fatalf("schedule after the edit has lease %q until %v and next run %v, want the claim kept",
+			stored.LeaseOwner, stored.LeaseExpiresAt, stored.NextRun)
+	}
+
+	scheduler.release(claimed)
+	stored = models.ReportSchedule{}
+	db.First(&stored, schedule.ID)
+	if stored.LeaseOwner != "" || stored.LeaseExpiresAt != nil || !stored.NextRun.After(now) {
+		t.Fatalf("schedule after release has lease %q until %v and next run %v, want it released and moved on",
+			stored.LeaseOwner, stored.LeaseExpiresAt, stored.NextRun)
+	}
+	if _, ok := scheduler.claim(schedule.ID, now); ok {
+		t.Error("the schedule was claimed again for the run that just finished")
+	}
+
+	// Changing the cron pattern moves the next run
+	updated, err = schedules.UpdateReportSchedule(schedule.ID, &models.ReportSchedule{Active: true, CronPattern: "0 9 * * *"}, owner.ID, false)
+	if err != nil {
+		t.Fatalf("UpdateReportSchedule: %v", err)
+	}
+	if updated.NextRun.In(time.Local).Hour() != 9 {
+		t.Errorf("next run after changing the cron pattern = %v, want 09:00", updated.NextRun)
+	}
+}
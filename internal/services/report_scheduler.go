@@ -0,0 +1,385 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"gobi/config"
+	"gobi/internal/models"
+	"gobi/pkg/utils"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Statuses of schedule runs. Finished runs take the status of their report.
+const (
+	ScheduleRunQueued  = "queued"
+	ScheduleRunRunning = "running"
+)
+
+// Triggers of schedule runs
+const (
+	ScheduleRunTriggerCron     = "cron"
+	ScheduleRunTriggerManual   = "manual"
+	ScheduleRunTriggerBackfill = "backfill"
+)
+
+// scheduleRunAbandoned is the error of runs whose scheduler stopped renewing their lease
+const scheduleRunAbandoned = "run abandoned: the scheduler running it stopped before it finished"
+
+// ReportScheduler runs due report schedules and the runs queued from the API. Every
+// instance of the server may run one: a schedule is claimed with a lease on its row, and a
+// queued run by moving it to running, so each run happens on one instance only. The lease
+// is renewed while the report is generated; when an instance dies, its lease expires, its
+// run is recorded as abandoned and another instance runs the schedule again. Abandoned
+// queued runs are not retried.
+type ReportScheduler struct {
+	db         *gorm.DB
+	generation *ReportGenerationService
+	cfg        config.SchedulerConfig
+	id         string // identifies this instance in leases and runs
+
+	slots    chan struct{} // one per report being generated
+	jobs     sync.WaitGroup
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewReportScheduler creates a new ReportScheduler instance
+func NewReportScheduler(db *gorm.DB, generation *ReportGenerationService, cfg config.SchedulerConfig) *ReportScheduler {
+	host, _ := os.Hostname()
+	return &ReportScheduler{
+		db:         db,
+		generation: generation,
+		cfg:        cfg,
+		id:         fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.NewString()[:8]),
+		slots:      make(chan struct{}, cfg.Workers),
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start polls for due schedules in the background until Stop. It does nothing when the
+// scheduler is disabled.
+func (s *ReportScheduler) Start() {
+	if !s.cfg.Enabled || s.done != nil {
+		return
+	}
+	s.done = make(chan struct{})
+	go s.loop()
+	utils.Logger.Infof("Report scheduler %s started with %d workers", s.id, s.cfg.Workers)
+}
+
+// Stop stops claiming schedules and waits up to the shutdown timeout for the reports being
+// generated. Runs still going when it returns keep their lease until it expires, and are
+// then recovered by another instance.
+func (s *ReportScheduler) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	if s.done == nil {
+		return
+	}
+	<-s.done
+
+	finished := make(chan struct{})
+	go func() {
+		s.jobs.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		utils.Logger.Infof("Report scheduler %s stopped", s.id)
+	case <-time.After(s.cfg.ShutdownTimeout):
+		utils.Logger.Warnf("Report scheduler %s stopped with reports still being generated", s.id)
+	}
+}
+
+// loop recovers abandoned runs and claims due schedules every poll interval
+func (s *ReportScheduler) loop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.recoverAbandoned(time.Now())
+		s.claimDue(time.Now())
+		s.claimQueued(time.Now())
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimDue claims as many due schedules as there are free workers and runs them
+func (s *ReportScheduler) claimDue(now time.Time) {
+	free := cap(s.slots) - len(s.slots)
+	if free == 0 {
+		return
+	}
+
+	var ids []uint
+	err := s.db.Model(&models.ReportSchedule{}).
+		Where("active = ? AND next_run <= ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", true, now, now).
+		Order("next_run").Limit(free).
+		Pluck("id", &ids).Error
+	if err != nil {
+		utils.Logger.WithFields(map[string]interface{}{
+			"action": "check_reports",
+			"error":  err.Error(),
+		}).Error("Failed to fetch report schedules")
+		return
+	}
+
+	for _, id := range ids {
+		schedule, ok := s.claim(id, now)
+		if !ok {
+			continue
+		}
+		s.spawn(func() { s.run(schedule) })
+	}
+}
+
+// claimQueued claims as many queued runs as there are free workers, oldest first, and
+// runs them
+func (s *ReportScheduler) claimQueued(now time.Time) {
+	free := cap(s.slots) - len(s.slots)
+	if free == 0 {
+		return
+	}
+
+	var runs []models.ScheduleRun
+	if err := s.db.Where("status = ?", ScheduleRunQueued).Order("id").Limit(free).Find(&runs).Error; err != nil {
+		utils.Logger.Errorf("Failed to fetch queued schedule runs: %v", err)
+		return
+	}
+
+	for i := range runs {
+		run := &runs[i]
+		leaseExpiresAt := now.Add(s.cfg.LeaseDuration)
+		result := s.db.Model(&models.ScheduleRun{}).
+			Where("id = ? AND status = ?", run.ID, ScheduleRunQueued).
+			Updates(map[string]interface{}{
+				"status":           ScheduleRunRunning,
+				"worker":           s.id,
+				"lease_expires_at": leaseExpiresAt,
+				"started_at":       now,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		run.Status, run.Worker, run.LeaseExpiresAt, run.StartedAt = ScheduleRunRunning, s.id, &leaseExpiresAt, &now
+		s.spawn(func() { s.runQueued(run) })
+	}
+}
+
+// spawn runs a job on a worker slot
+func (s *ReportScheduler) spawn(job func()) {
+	s.slots <- struct{}{}
+	s.jobs.Add(1)
+	go func() {
+		defer func() {
+			<-s.slots
+			s.jobs.Done()
+		}()
+		job()
+	}()
+}
+
+// claim takes the lease of a due schedule. It fails when another instance claimed the
+// schedule first.
+func (s *ReportScheduler) claim(scheduleID uint, now time.Time) (*models.ReportSchedule, bool) {
+	result := s.db.Model(&models.ReportSchedule{}).
+		Where("id = ? AND active = ? AND next_run <= ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", scheduleID, true, now, now).
+		Updates(map[string]interface{}{
+			"lease_owner":      s.id,
+			"lease_expires_at": now.Add(s.cfg.LeaseDuration),
+		})
+	if result.Error != nil {
+		utils.Logger.Errorf("Failed to claim report schedule %d: %v", scheduleID, result.Error)
+		return nil, false
+	}
+	if result.RowsAffected == 0 {
+		return nil, false
+	}
+
+	// A schedule that cannot be loaded is claimed again once the lease expires
+	var schedule models.ReportSchedule
+	if err := s.db.First(&schedule, scheduleID).Error; err != nil {
+		utils.Logger.Errorf("Failed to load report schedule %d: %v", scheduleID, err)
+		return nil, false
+	}
+	return &schedule, true
+}
+
+// run generates the report of a claimed schedule, records the run and releases the lease.
+// The run stands for the time the schedule was due.
+func (s *ReportScheduler) run(schedule *models.ReportSchedule) {
+	started := time.Now()
+	leaseExpiresAt := started.Add(s.cfg.LeaseDuration)
+	logicalDate := schedule.NextRun
+	run := &models.ScheduleRun{
+		ScheduleID:     schedule.ID,
+		Trigger:        ScheduleRunTriggerCron,
+		LogicalDate:    &logicalDate,
+		Status:         ScheduleRunRunning,
+		Worker:         s.id,
+		LeaseExpiresAt: &leaseExpiresAt,
+		StartedAt:      &started,
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		utils.Logger.Errorf("Failed to record run of report schedule %d: %v", schedule.ID, err)
+	}
+
+	s.execute(schedule, run, true)
+	s.release(schedule)
+}
+
+// runQueued runs a queued run claimed by this instance. It fails when its schedule no
+// longer exists.
+func (s *ReportScheduler) runQueued(run *models.ScheduleRun) {
+	var schedule models.ReportSchedule
+	if err := s.db.First(&schedule, run.ScheduleID).Error; err != nil {
+		s.db.Model(run).Updates(map[string]interface{}{
+			"status":           ReportStatusFailed,
+			"error":            "report schedule no longer exists",
+			"finished_at":      time.Now(),
+			"lease_expires_at": nil,
+		})
+		return
+	}
+	s.execute(&schedule, run, false)
+}
+
+// execute generates the report of a run and records its outcome. The lease of the run,
+// and of the schedule when leased, is renewed meanwhile.
+func (s *ReportScheduler) execute(schedule *models.ReportSchedule, run *models.ScheduleRun, leased bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.renew(ctx, schedule.ID, run.ID, leased)
+
+	report, err := s.generate(schedule, run)
+	cancel()
+
+	finished := time.Now()
+	updates := map[string]interface{}{
+		"status":           ReportStatusFailed,
+		"finished_at":      finished,
+		"lease_expires_at": nil,
+	}
+	if report != nil {
+		updates["report_id"] = report.ID
+		if report.Status != "" && report.Status != ReportStatusGenerating {
+			updates["status"] = report.Status
+		}
+		if report.Error != "" {
+			updates["error"] = report.Error
+		}
+	}
+	if report == nil {
+		s.failGenerating(schedule.ID, *run.StartedAt, err.Error())
+	}
+	if err != nil {
+		updates["status"] = ReportStatusFailed
+		updates["error"] = err.Error()
+		utils.Logger.WithFields(map[string]interface{}{
+			"action":     "generate_report",
+			"scheduleID": schedule.ID,
+			"trigger":    run.Trigger,
+			"error":      err.Error(),
+		}).Error("Failed to generate scheduled report")
+	}
+	if run.ID != 0 {
+		s.db.Model(run).Updates(updates)
+	}
+}
+
+// generate generates the report of a run, turning a panic into an error so that the run
+// is recorded and the lease released
+func (s *ReportScheduler) generate(schedule *models.ReportSchedule, run *models.ScheduleRun) (report *models.Report, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("report generation panicked: %v", r)
+		}
+	}()
+	return s.generation.GenerateScheduledReport(schedule, run)
+}
+
+// renew extends the lease of a run, and of its schedule when leased, until ctx is done
+func (s *ReportScheduler) renew(ctx context.Context, scheduleID, runID uint, leased bool) {
+	ticker := time.NewTicker(s.cfg.LeaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			expiresAt := now.Add(s.cfg.LeaseDuration)
+			if leased {
+				result := s.db.Model(&models.ReportSchedule{}).
+					Where("id = ? AND lease_owner = ?", scheduleID, s.id).
+					Update("lease_expires_at", expiresAt)
+				if result.Error != nil || result.RowsAffected == 0 {
+					utils.Logger.Warnf("Failed to renew the lease of report schedule %d: %v", scheduleID, result.Error)
+				}
+			}
+			if runID != 0 {
+				s.db.Model(&models.ScheduleRun{}).Where("id = ?", runID).Update("lease_expires_at", expiresAt)
+			}
+		}
+	}
+}
+
+// release moves a schedule to its next run time in its time zone and gives up its lease
+func (s *ReportScheduler) release(schedule *models.ReportSchedule) {
+	err := s.db.Model(&models.ReportSchedule{}).
+		Where("id = ? AND lease_owner = ?", schedule.ID, s.id).
+		Updates(map[string]interface{}{
+			"next_run":         utils.CalculateNextRunInZone(schedule.CronPattern, schedule.TimeZone, time.Now().Add(24*time.Hour)),
+			"lease_owner":      "",
+			"lease_expires_at": nil,
+		}).Error
+	if err != nil {
+		utils.Logger.Errorf("Failed to release report schedule %d: %v", schedule.ID, err)
+	}
+}
+
+// recoverAbandoned records runs whose lease expired as failed, with the reports they left
+// generating. The schedules of cron runs are still due and are claimed again.
+func (s *ReportScheduler) recoverAbandoned(now time.Time) {
+	var runs []models.ScheduleRun
+	if err := s.db.Where("status = ? AND lease_expires_at < ?", ScheduleRunRunning, now).Find(&runs).Error; err != nil {
+		utils.Logger.Errorf("Failed to fetch abandoned schedule runs: %v", err)
+		return
+	}
+
+	for _, run := range runs {
+		result := s.db.Model(&models.ScheduleRun{}).
+			Where("id = ? AND status = ?", run.ID, ScheduleRunRunning).
+			Updates(map[string]interface{}{
+				"status":           ReportStatusFailed,
+				"error":            scheduleRunAbandoned,
+				"finished_at":      now,
+				"lease_expires_at": nil,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		since := run.CreatedAt
+		if run.StartedAt != nil {
+			since = *run.StartedAt
+		}
+		s.failGenerating(run.ScheduleID, since, scheduleRunAbandoned)
+		utils.Logger.Warnf("Recovered abandoned run %d of report schedule %d started by %s", run.ID, run.ScheduleID, run.Worker)
+	}
+}
+
+// failGenerating fails the reports of a schedule left generating by a run started at since
+func (s *ReportScheduler) failGenerating(scheduleID uint, since time.Time, message string) {
+	s.db.Model(&models.Report{}).
+		Where("schedule_id = ? AND status = ? AND generated_at >= ?", scheduleID, ReportStatusGenerating, since).
+		Updates(map[string]interface{}{"status": ReportStatusFailed, "error": message})
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"fmt"
 	"gobi/internal/models"
 	"gobi/pkg/errors"
 	"time"
@@ -11,20 +12,23 @@ import (
 // ReportService handles report-related business logic
 type ReportService struct {
 	reportRepo        ReportRepository
-	reportGenerator   ReportGeneratorService
+	reportGeneration  *ReportGenerationService
 	permissionService PermissionService
+	blobStore         BlobStore
 }
 
 // NewReportService creates a new ReportService instance
 func NewReportService(
 	reportRepo ReportRepository,
-	reportGenerator ReportGeneratorService,
+	reportGeneration *ReportGenerationService,
 	permissionService PermissionService,
+	blobStore BlobStore,
 ) *ReportService {
 	return &ReportService{
 		reportRepo:        reportRepo,
-		reportGenerator:   reportGenerator,
+		reportGeneration:  reportGeneration,
 		permissionService: permissionService,
+		blobStore:         blobStore,
 	}
 }
 
@@ -97,9 +101,9 @@ func (s *ReportService) UpdateReport(reportID uint, updates *models.Report, user
 	return report, nil
 }
 
-// DeleteReport deletes a report
+// DeleteReport deletes a report and its file
 func (s *ReportService) DeleteReport(reportID uint, userID uint, isAdmin bool) error {
-	_, err := s.reportRepo.FindByID(reportID)
+	report, err := s.reportRepo.FindByID(reportID)
 	if err != nil {
 		if errs.Is(err, errors.ErrNotFound) {
 			return errors.ErrNotFound
@@ -114,73 +118,59 @@ func (s *ReportService) DeleteReport(reportID uint, userID uint, isAdmin bool) e
 	if err := s.reportRepo.Delete(reportID); err != nil {
 		return errors.WrapError(err, "Could not delete report")
 	}
+	deleteBlob(s.blobStore, report.StorageKey)
 
 	return nil
 }
 
-// GenerateReportResult represents the result of report generation
-type GenerateReportResult struct {
-	ReportID     uint      `json:"reportId"`
-	FileName     string    `json:"fileName"`
-	FileSize     int64     `json:"fileSize"`
-	GeneratedAt  time.Time `json:"generatedAt"`
-	DownloadURL  string    `json:"downloadUrl"`
-	Status       string    `json:"status"`
-	ErrorMessage string    `json:"errorMessage,omitempty"`
-}
-
-// GenerateReport generates a report based on the report configuration
-func (s *ReportService) GenerateReport(reportID uint, userID uint, isAdmin bool) (*GenerateReportResult, error) {
-	// Get report with all related data
+// PinReport pins a report, exempting it from retention, or unpins it
+func (s *ReportService) PinReport(reportID uint, pinned bool, userID uint, isAdmin bool) (*models.Report, error) {
 	report, err := s.reportRepo.FindByID(reportID)
 	if err != nil {
-		return nil, errors.ErrNotFound
+		if errs.Is(err, errors.ErrNotFound) {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.WrapError(err, "Could not fetch report")
 	}
 
-	// Permission check
 	if !s.permissionService.CanAccess(userID, reportID, "report", isAdmin) {
 		return nil, errors.ErrForbidden
 	}
 
-	// Update report status
-	report.Status = "generating"
-	report.GeneratedAt = time.Now()
-	if err := s.reportRepo.UpdateStatus(reportID, "generating", ""); err != nil {
-		return nil, errors.WrapError(err, "Could not update report status")
+	report.Pinned = pinned
+	if err := s.reportRepo.Update(report); err != nil {
+		return nil, errors.WrapError(err, "Could not update report")
 	}
 
-	// For now, we'll create a simple Excel report
-	// In a real implementation, you would use the actual template and data
-	content, err := s.reportGenerator.GenerateExcelFromTemplate("[]", []byte{}, "report")
-	if err != nil {
-		// Update report status to failed
-		if updateErr := s.reportRepo.UpdateStatus(reportID, "failed", err.Error()); updateErr != nil {
-			// Log the error but don't fail the operation
-		}
+	return report, nil
+}
 
-		return &GenerateReportResult{
-			ReportID:     reportID,
-			Status:       "failed",
-			ErrorMessage: err.Error(),
-		}, nil
+// GenerateReportResult represents the result of report generation
+type GenerateReportResult struct {
+	ReportID     uint            `json:"reportId"`
+	FileName     string          `json:"fileName"`
+	FileSize     int64           `json:"fileSize"`
+	GeneratedAt  time.Time       `json:"generatedAt"`
+	DownloadURL  string          `json:"downloadUrl"`
+	Status       string          `json:"status"`
+	ErrorMessage string          `json:"errorMessage,omitempty"`
+	Sections     []ReportSection `json:"sections,omitempty"`
+}
+
+// GenerateReport queues a run that generates a report again from the schedule it was
+// generated from
+func (s *ReportService) GenerateReport(reportID uint, userID uint, isAdmin bool) (*models.ScheduleRun, error) {
+	report, err := s.reportRepo.FindByID(reportID)
+	if err != nil {
+		return nil, errors.ErrNotFound
 	}
 
-	// Update report status to success
-	report.Status = "success"
-	report.Content = content
-	report.Error = ""
-	if err := s.reportRepo.Update(report); err != nil {
-		return nil, errors.WrapError(err, "Could not update report")
+	// Permission check
+	if !s.permissionService.CanAccess(userID, reportID, "report", isAdmin) {
+		return nil, errors.ErrForbidden
 	}
 
-	return &GenerateReportResult{
-		ReportID:    reportID,
-		FileName:    "report.xlsx",
-		FileSize:    int64(len(content)),
-		GeneratedAt: time.Now(),
-		DownloadURL: "/api/reports/" + string(rune(reportID)) + "/download",
-		Status:      "success",
-	}, nil
+	return s.reportGeneration.RegenerateReport(report, userID)
 }
 
 // GetReportStatus gets the current status of a report
@@ -194,13 +184,19 @@ func (s *ReportService) GetReportStatus(reportID uint, userID uint, isAdmin bool
 		return nil, errors.ErrForbidden
 	}
 
+	return reportResult(report), nil
+}
+
+// reportResult describes the generated file of a report
+func reportResult(report *models.Report) *GenerateReportResult {
 	return &GenerateReportResult{
-		ReportID:     reportID,
-		FileName:     "report.xlsx",
-		FileSize:     int64(len(report.Content)),
+		ReportID:     report.ID,
+		FileName:     "report." + ReportFormat(report.Format),
+		FileSize:     report.Size,
 		GeneratedAt:  report.GeneratedAt,
-		DownloadURL:  "/api/reports/" + string(rune(reportID)) + "/download",
+		DownloadURL:  fmt.Sprintf("/api/reports/%d/download", report.ID),
 		Status:       report.Status,
 		ErrorMessage: report.Error,
-	}, nil
+		Sections:     ReportSections(report),
+	}
 }
@@ -1,32 +1,52 @@
 package services
 
 import (
+	"bytes"
+	"encoding/json"
 	"gobi/internal/models"
 	"gobi/pkg/errors"
+	"gobi/pkg/exceltemplate"
+	"io"
+
+	"github.com/xuri/excelize/v2"
 )
 
 // TemplateService handles template-related business logic
 type TemplateService struct {
 	templateRepo      TemplateRepository
 	permissionService PermissionService
+	blobStore         BlobStore
 }
 
 // NewTemplateService creates a new TemplateService instance
 func NewTemplateService(
 	templateRepo TemplateRepository,
 	permissionService PermissionService,
+	blobStore BlobStore,
 ) *TemplateService {
 	return &TemplateService{
 		templateRepo:      templateRepo,
 		permissionService: permissionService,
+		blobStore:         blobStore,
 	}
 }
 
-// CreateTemplate creates a new template
+// CreateTemplate creates a new template after checking its placeholders, and lists the
+// bindings it expects. The workbook goes to the blob store.
 func (s *TemplateService) CreateTemplate(template *models.ExcelTemplate, userID uint) error {
 	template.UserID = userID
+	if err := scanTemplate(template); err != nil {
+		return err
+	}
+
+	key, checksum, err := putBlob(s.blobStore, blobKindTemplate, userID, "xlsx", template.Template)
+	if err != nil {
+		return err
+	}
+	template.StorageKey, template.Checksum, template.Size = key, checksum, int64(len(template.Template))
 
 	if err := s.templateRepo.Create(template); err != nil {
+		deleteBlob(s.blobStore, key)
 		return errors.WrapError(err, "Could not create template")
 	}
 
@@ -40,9 +60,8 @@ func (s *TemplateService) ListTemplates(userID uint, isAdmin bool) ([]models.Exc
 		return nil, errors.WrapError(err, "Could not fetch templates")
 	}
 
-	// Do not return template content in list view
 	for i := range templates {
-		templates[i].Template = nil
+		s.templateBindings(&templates[i])
 	}
 
 	return templates, nil
@@ -59,8 +78,7 @@ func (s *TemplateService) GetTemplate(templateID uint, userID uint, isAdmin bool
 		return nil, errors.ErrForbidden
 	}
 
-	// Do not return template content in detail view
-	template.Template = nil
+	s.templateBindings(template)
 
 	return template, nil
 }
@@ -88,15 +106,14 @@ func (s *TemplateService) UpdateTemplate(templateID uint, updates *models.ExcelT
 		return nil, errors.WrapError(err, "Could not update template")
 	}
 
-	// Do not return template content
-	template.Template = nil
+	s.templateBindings(template)
 
 	return template, nil
 }
 
-// DeleteTemplate deletes a template
+// DeleteTemplate deletes a template and its workbook
 func (s *TemplateService) DeleteTemplate(templateID uint, userID uint, isAdmin bool) error {
-	_, err := s.templateRepo.FindByID(templateID)
+	template, err := s.templateRepo.FindByID(templateID)
 	if err != nil {
 		return errors.ErrNotFound
 	}
@@ -108,22 +125,27 @@ func (s *TemplateService) DeleteTemplate(templateID uint, userID uint, isAdmin b
 	if err := s.templateRepo.Delete(templateID); err != nil {
 		return errors.WrapError(err, "Could not delete template")
 	}
+	deleteBlob(s.blobStore, template.StorageKey)
 
 	return nil
 }
 
-// DownloadTemplate retrieves a template for download
-func (s *TemplateService) DownloadTemplate(templateID uint, userID uint, isAdmin bool) (*models.ExcelTemplate, error) {
+// DownloadTemplate opens the workbook of a template for streaming from the blob store
+func (s *TemplateService) DownloadTemplate(templateID uint, userID uint, isAdmin bool) (*models.ExcelTemplate, io.ReadCloser, error) {
 	template, err := s.templateRepo.FindByID(templateID)
 	if err != nil {
-		return nil, errors.ErrNotFound
+		return nil, nil, errors.ErrNotFound
 	}
 
 	if !s.permissionService.CanAccess(userID, templateID, "template", isAdmin) {
-		return nil, errors.ErrForbidden
+		return nil, nil, errors.ErrForbidden
 	}
 
-	return template, nil
+	content, err := openBlob(s.blobStore, template.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return template, content, nil
 }
 
 // GetDashboardStats retrieves dashboard statistics
@@ -135,3 +157,44 @@ func (s *TemplateService) GetDashboardStats() (map[string]interface{}, error) {
 
 	return stats, nil
 }
+
+// scanTemplate checks that a template is a workbook with valid placeholders and records
+// its bindings
+func scanTemplate(template *models.ExcelTemplate) error {
+	f, err := excelize.OpenReader(bytes.NewReader(template.Template))
+	if err != nil {
+		return errors.NewBadRequestError("Template is not an Excel workbook", err)
+	}
+	defer f.Close()
+	bindings, err := exceltemplate.Scan(f)
+	if err != nil {
+		return errors.NewBadRequestError("Invalid template", err)
+	}
+	if bindings == nil {
+		bindings = []models.TemplateBinding{}
+	}
+	encoded, _ := json.Marshal(bindings)
+	template.Bindings = string(encoded)
+	template.BindingList = bindings
+	return nil
+}
+
+// templateBindings decodes the bindings of a template. Templates stored before bindings
+// were recorded are scanned.
+func (s *TemplateService) templateBindings(template *models.ExcelTemplate) {
+	if template.Bindings == "" && template.StorageKey != "" {
+		content, err := readBlob(s.blobStore, template.StorageKey, template.Checksum)
+		if err == nil {
+			template.Template = content
+			err = scanTemplate(template)
+			template.Template = nil
+		}
+		if err == nil {
+			return
+		}
+	}
+	template.BindingList = []models.TemplateBinding{}
+	if template.Bindings != "" {
+		json.Unmarshal([]byte(template.Bindings), &template.BindingList)
+	}
+}
@@ -11,16 +11,21 @@ import (
 	"gobi/pkg/errors"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	errs "errors"
 )
 
+// webhookSecretPrefix marks a plaintext webhook secret
+const webhookSecretPrefix = "whsec_"
+
 // WebhookService handles webhook-related business logic
 type WebhookService struct {
 	webhookRepo       WebhookRepository
 	webhookTrigger    WebhookTriggerService
 	permissionService PermissionService
+	encryptionService EncryptionService
 }
 
 // NewWebhookService creates a new WebhookService instance
@@ -28,11 +33,13 @@ func NewWebhookService(
 	webhookRepo WebhookRepository,
 	webhookTrigger WebhookTriggerService,
 	permissionService PermissionService,
+	encryptionService EncryptionService,
 ) *WebhookService {
 	return &WebhookService{
 		webhookRepo:       webhookRepo,
 		webhookTrigger:    webhookTrigger,
 		permissionService: permissionService,
+		encryptionService: encryptionService,
 	}
 }
 
@@ -42,8 +49,18 @@ func (s *WebhookService) CreateWebhook(webhook *models.Webhook, userID uint) err
 	webhook.CreatedAt = time.Now()
 	webhook.UpdatedAt = time.Now()
 
-	// Generate secret for signature verification
-	webhook.Secret = generateWebhookSecret()
+	// Generate secret for signature verification, stored encrypted
+	secret, err := s.encryptionService.Encrypt(generateWebhookSecret())
+	if err != nil {
+		return errors.NewErrorWithSeverity(
+			errors.ErrCodeInternalServer,
+			"Could not encrypt webhook secret",
+			err,
+			errors.SeverityHigh,
+			errors.CategorySecurity,
+		)
+	}
+	webhook.Secret = secret
 
 	if err := s.webhookRepo.Create(webhook); err != nil {
 		return errors.WrapError(err, "Could not create webhook")
@@ -216,8 +233,13 @@ func (s *WebhookService) sendSingleWebhook(webhook *models.Webhook, delivery *mo
 	headers["User-Agent"] = "Gobi-Webhook/1.0"
 
 	// Generate signature
+	secret, err := s.decryptSecret(webhook.Secret)
+	if err != nil {
+		delivery.Response = fmt.Sprintf("Failed to decrypt webhook secret: %v", err)
+		return false
+	}
 	timestamp := time.Now().Unix()
-	signature := s.generateSignature(webhook.Secret, delivery.Payload, timestamp)
+	signature := s.generateSignature(secret, delivery.Payload, timestamp)
 	headers["X-Gobi-Signature"] = signature
 	headers["X-Gobi-Timestamp"] = fmt.Sprintf("%d", timestamp)
 	headers["X-Gobi-Event"] = delivery.Event
@@ -292,10 +314,19 @@ func (s *WebhookService) generateSignature(secret, payload string, timestamp int
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// decryptSecret decrypts a stored webhook secret; secrets created before
+// encryption was introduced are stored as plaintext and returned unchanged
+func (s *WebhookService) decryptSecret(secret string) (string, error) {
+	if strings.HasPrefix(secret, webhookSecretPrefix) {
+		return secret, nil
+	}
+	return s.encryptionService.Decrypt(secret)
+}
+
 // generateWebhookSecret generates a random webhook secret
 func generateWebhookSecret() string {
 	// Simple implementation - in production, use crypto/rand
-	return fmt.Sprintf("whsec_%d", time.Now().UnixNano())
+	return fmt.Sprintf("%s%d", webhookSecretPrefix, time.Now().UnixNano())
 }
 
 // ListWebhookDeliveries lists webhook delivery attempts
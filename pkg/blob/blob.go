@@ -0,0 +1,124 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gobi/config"
+	"gobi/pkg/utils"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Storage drivers
+const (
+	DriverLocal = "local"
+	DriverS3    = "s3"
+)
+
+// ErrNotFound is returned when a key has no blob
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs by key. Keys are slash separated relative paths such as
+// reports/7/2f1c.xlsx; they cannot be empty or contain . or .. segments.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	defaultStore Store
+	defaultMu    sync.RWMutex
+)
+
+// Open creates the store a storage configuration names
+func Open(cfg config.StorageConfig) (Store, error) {
+	switch cfg.Driver {
+	case "", DriverLocal:
+		return NewFileStore(cfg.Local.Path)
+	case DriverS3:
+		secret := cfg.S3.SecretAccessKey
+		if utils.IsSecretReference(secret) {
+			resolved, err := utils.ResolveSecret(secret)
+			if err != nil {
+				return nil, fmt.Errorf("could not resolve S3 secret access key: %w", err)
+			}
+			secret = resolved
+		}
+		return NewS3Store(S3Config{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: secret,
+			PathStyle:       cfg.S3.PathStyle,
+			Prefix:          cfg.S3.Prefix,
+			Timeout:         cfg.S3.Timeout,
+		})
+	}
+	return nil, fmt.Errorf("unsupported storage driver %q", cfg.Driver)
+}
+
+// Init opens the store of the configuration as the default store
+func Init(cfg *config.Config) error {
+	store, err := Open(cfg.Storage)
+	if err != nil {
+		return err
+	}
+	SetDefault(store)
+	return nil
+}
+
+// SetDefault replaces the default store
+func SetDefault(store Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = store
+}
+
+// Default returns the default store
+func Default() Store {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStore
+}
+
+// ReadAll reads the whole blob of a key
+func ReadAll(ctx context.Context, store Store, key string) ([]byte, error) {
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// validateKey checks that a key is a relative path without . or .. segments
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
+
+// NewKey returns a new key for a blob of a kind, such as reports, owned by a user
+func NewKey(kind string, ownerID uint, ext string) string {
+	return fmt.Sprintf("%s/%d/%s.%s", kind, ownerID, uuid.NewString(), ext)
+}
+
+// Checksum returns the hex SHA-256 of a blob
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,236 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKeyID = "AKIDEXAMPLE"
+	testSecretKey   = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+// s3Server is an S3 stand-in that keeps objects in memory and rejects requests whose
+// Signature Version 4 it cannot verify
+type s3Server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	objects  map[string][]byte // by escaped request path
+	requests []string
+}
+
+func startS3Server(t *testing.T) *s3Server {
+	t.Helper()
+	s := &s3Server{objects: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *s3Server) store(t *testing.T) *S3Store {
+	t.Helper()
+	store, err := NewS3Store(S3Config{
+		Endpoint:        s.URL,
+		Region:          "eu-west-1",
+		Bucket:          "gobi",
+		AccessKeyID:     testAccessKeyID,
+		SecretAccessKey: testSecretKey,
+		PathStyle:       true,
+		Prefix:          "/prod/",
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func (s *s3Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := verifySigV4(r, body, "eu-west-1", testSecretKey); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>%s</Message></Error>", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.EscapedPath())
+	path := r.URL.EscapedPath()
+	switch r.Method {
+	case http.MethodPut:
+		s.objects[path] = body
+	case http.MethodGet:
+		data, ok := s.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		if _, ok := s.objects[path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// verifySigV4 checks the authorization of a request the way S3 does, from the request as
+// it arrived rather than from the signer's own values
+func verifySigV4(r *http.Request, body []byte, region, secret string) error {
+	var credential, signedHeaders, signature string
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("missing AWS4-HMAC-SHA256 authorization")
+	}
+	for _, part := range strings.Split(auth, ", ") {
+		name, value, _ := strings.Cut(part, "=")
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			signature = value
+		}
+	}
+
+	amzDate := r.Header.Get("x-amz-date")
+	date, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(date).Abs() > 15*time.Minute {
+		return fmt.Errorf("bad x-amz-date %q", amzDate)
+	}
+	scope := date.Format("20060102") + "/" + region + "/s3/aws4_request"
+	if credential != testAccessKeyID+"/"+scope {
+		return fmt.Errorf("credential %q, want scope %s", credential, scope)
+	}
+	payloadHash := sha256.Sum256(body)
+	if r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(payloadHash[:]) {
+		return errors.New("x-amz-content-sha256 does not match the body")
+	}
+
+	names := strings.Split(signedHeaders, ";")
+	if !sort.StringsAreSorted(names) || !strings.Contains(signedHeaders, "host") {
+		return fmt.Errorf("bad signed headers %q", signedHeaders)
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{r.Method, r.URL.EscapedPath(), r.URL.RawQuery,
+		canonicalHeaders.String(), signedHeaders, r.Header.Get("x-amz-content-sha256")}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac(mac(mac(mac([]byte("AWS4"+secret), date.Format("20060102")), region), "s3"), "aws4_request")
+	if want := hex.EncodeToString(mac(key, stringToSign)); signature != want {
+		return fmt.Errorf("signature %s, want %s", signature, want)
+	}
+	return nil
+}
+
+func TestS3StorePutGetDelete(t *testing.T) {
+	server := startS3Server(t)
+	store := server.store(t)
+	ctx := context.Background()
+	// Spaces and plus signs must be escaped the same way in the URL and the signature
+	key := "reports/7/weekly sales+q3.xlsx"
+	data := []byte("PK\x03\x04 report")
+
+	if err := store.Put(ctx, key, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got, err := ReadAll(ctx, store, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("Get = %q, want %q", got, data)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing object = %v, want nil", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	want := "PUT /gobi/prod/reports/7/weekly%20sales%2Bq3.xlsx"
+	if len(server.requests) == 0 || server.requests[0] != want {
+		t.Errorf("requests = %q, want %q first", server.requests, want)
+	}
+}
+
+func TestS3StoreReportsRejectedSignatures(t *testing.T) {
+	server := startS3Server(t)
+	store := server.store(t)
+	store.cfg.SecretAccessKey = "wrong"
+
+	err := store.Put(context.Background(), "reports/7/a.csv", []byte("a,b\n"))
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("Put with a wrong secret = %v, want SignatureDoesNotMatch", err)
+	}
+}
+
+func TestS3StoreTimeoutDoesNotCutOffDownloads(t *testing.T) {
+	const chunks = 5
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/stalled") {
+			time.Sleep(500 * time.Millisecond)
+		}
+		for i := 0; i < chunks; i++ {
+			fmt.Fprintf(w, "chunk %d\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	store, err := NewS3Store(S3Config{Endpoint: server.URL, Bucket: "gobi", PathStyle: true, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The body takes longer than the timeout to arrive and is still read in full
+	got, err := ReadAll(context.Background(), store, "reports/7/big.csv")
+	if err != nil {
+		t.Fatalf("streamed download failed: %v", err)
+	}
+	if lines := strings.Count(string(got), "\n"); lines != chunks {
+		t.Errorf("downloaded %d chunks, want %d", lines, chunks)
+	}
+
+	// A response that does not start within the timeout is given up on
+	if _, err := store.Get(context.Background(), "reports/7/stalled"); err == nil {
+		t.Error("Get of a stalled response succeeded, want a timeout")
+	}
+}
@@ -0,0 +1,131 @@
+package charts
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseFieldMappings decodes the role to column mappings of a chart
+func ParseFieldMappings(mappings string) (map[string]string, error) {
+	result := map[string]string{}
+	if strings.TrimSpace(mappings) == "" {
+		return result, nil
+	}
+	if err := json.Unmarshal([]byte(mappings), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ValidateFieldMappings checks that mappings only bind roles the chart type reads
+func (t *ChartType) ValidateFieldMappings(mappings string) []FieldError {
+	parsed, err := ParseFieldMappings(mappings)
+	if err != nil {
+		return []FieldError{{Field: "field_mappings", Message: "must be a JSON object of role to column names"}}
+	}
+	roles := make([]string, 0, len(parsed))
+	for role := range parsed {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	var errs []FieldError
+	for _, role := range roles {
+		path := joinPath("field_mappings", role)
+		if _, ok := t.field(role); !ok {
+			addFieldError(&errs, path, fmt.Sprintf("is not a field of %s charts", t.Name))
+		} else if strings.TrimSpace(parsed[role]) == "" {
+			addFieldError(&errs, path, "must name a column")
+		}
+	}
+	return errs
+}
+
+// Columns resolves the column read for every field: an explicit field mapping wins over
+// the configuration's *Field key, which wins over the field's default name
+func (t *ChartType) Columns(mappings map[string]string, cfg map[string]interface{}) map[string]string {
+	columns := make(map[string]string, len(t.DataFields))
+	for _, field := range t.DataFields {
+		column := field.Name
+		if field.ConfigKey != "" {
+			if configured, ok := cfg[field.ConfigKey].(string); ok && configured != "" {
+				column = configured
+			}
+		}
+		if mapped := mappings[field.Name]; mapped != "" {
+			column = mapped
+		}
+		columns[field.Name] = column
+	}
+	return columns
+}
+
+// Shape converts query rows into rows keyed by field name, converting numeric fields to
+// numbers. Required fields whose column is missing from the result are reported.
+func (t *ChartType) Shape(columns map[string]string, rows []map[string]interface{}) ([]map[string]interface{}, []FieldError) {
+	var errs []FieldError
+	if len(rows) > 0 {
+		for _, field := range t.DataFields {
+			column := columns[field.Name]
+			if _, ok := rows[0][column]; !ok && field.Required {
+				addFieldError(&errs, joinPath("field_mappings", field.Name),
+					fmt.Sprintf("column %q is not in the query result", column))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	shaped := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		out := make(map[string]interface{}, len(t.DataFields))
+		for _, field := range t.DataFields {
+			value, ok := row[columns[field.Name]]
+			if !ok {
+				continue
+			}
+			if field.Type == "number" {
+				value = toNumber(value)
+			}
+			out[field.Name] = value
+		}
+		shaped = append(shaped, out)
+	}
+	return shaped, nil
+}
+
+// field returns the data field with the given name
+func (t *ChartType) field(name string) (DataField, bool) {
+	for _, f := range t.DataFields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return DataField{}, false
+}
+
+// toNumber converts numeric strings and byte slices to float64, leaving other values as they are
+func toNumber(v interface{}) interface{} {
+	switch n := v.(type) {
+	case []byte:
+		if f, err := strconv.ParseFloat(string(n), 64); err == nil {
+			return f
+		}
+		return string(n)
+	case string:
+		if f, err := strconv.ParseFloat(n, 64); err == nil {
+			return f
+		}
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
@@ -0,0 +1,288 @@
+package charts
+
+func init() {
+	for _, t := range builtinTypes() {
+		Register(t)
+	}
+}
+
+// commonProperties are the options shared by every chart type
+func commonProperties() map[string]*Schema {
+	return map[string]*Schema{
+		"title":     stringSchema("Chart title"),
+		"subtitle":  stringSchema("Chart subtitle"),
+		"legend":    booleanSchema("Show the legend"),
+		"tooltip":   booleanSchema("Show tooltips"),
+		"animation": booleanSchema("Animate rendering"),
+		"color":     arraySchema("Series color palette", stringSchema("CSS color")),
+		"echarts":   {Type: "object", Description: "ECharts option properties merged over the generated option"},
+	}
+}
+
+// configSchema builds a configuration schema from the common options and type-specific ones
+func configSchema(extra map[string]*Schema) *Schema {
+	props := commonProperties()
+	for name, prop := range extra {
+		props[name] = prop
+	}
+	return objectSchema(props)
+}
+
+// fieldKeys returns the schema properties mapping each data field to a column
+func fieldKeys(fields []DataField) map[string]*Schema {
+	props := make(map[string]*Schema, len(fields))
+	for _, f := range fields {
+		if f.ConfigKey != "" {
+			props[f.ConfigKey] = stringSchema("Column used as " + f.Name + " (default \"" + f.Name + "\")")
+		}
+	}
+	return props
+}
+
+func required(name, configKey, typ, description string) DataField {
+	return DataField{Name: name, ConfigKey: configKey, Type: typ, Required: true, Description: description}
+}
+
+func optional(name, configKey, typ, description string) DataField {
+	return DataField{Name: name, ConfigKey: configKey, Type: typ, Description: description}
+}
+
+// newType assembles a chart type whose schema includes the field mapping keys
+func newType(name, label, category string, fields []DataField, extra map[string]*Schema, defaults map[string]interface{}) *ChartType {
+	props := fieldKeys(fields)
+	for k, v := range extra {
+		props[k] = v
+	}
+	if defaults == nil {
+		defaults = map[string]interface{}{}
+	}
+	if _, ok := defaults["tooltip"]; !ok {
+		defaults["tooltip"] = true
+	}
+	return &ChartType{
+		Name:           name,
+		Label:          label,
+		Category:       category,
+		ConfigSchema:   configSchema(props),
+		DataFields:     fields,
+		DefaultOptions: defaults,
+	}
+}
+
+func builtinTypes() []*ChartType {
+	xy := func() []DataField {
+		return []DataField{
+			required("x", "xField", "any", "Category or x axis value"),
+			required("y", "yField", "number", "Measured value"),
+			optional("series", "seriesField", "any", "Splits rows into series"),
+		}
+	}
+	// axes adds the axis titles of types drawn on an x and y axis to their options
+	axes := func(extra map[string]*Schema) map[string]*Schema {
+		extra["xAxisName"] = stringSchema("Title of the x or category axis")
+		extra["yAxisName"] = stringSchema("Title of the y or value axis")
+		return extra
+	}
+	seriesTypes := &Schema{Type: "object", Description: "Series drawn as another type, by series name: \"bar\", \"line\" or \"area\""}
+	nameValue := func() []DataField {
+		return []DataField{
+			required("name", "nameField", "any", "Slice or item name"),
+			required("value", "valueField", "number", "Slice or item value"),
+		}
+	}
+	grid3D := objectSchema(map[string]*Schema{
+		"boxWidth":  numberSchema("Width of the 3D box"),
+		"boxHeight": numberSchema("Height of the 3D box"),
+		"boxDepth":  numberSchema("Depth of the 3D box"),
+		"viewControl": objectSchema(map[string]*Schema{
+			"alpha":    numberSchema("Vertical rotation in degrees"),
+			"beta":     numberSchema("Horizontal rotation in degrees"),
+			"distance": numberSchema("Camera distance"),
+		}),
+	})
+	xyz := func(zType string) []DataField {
+		return []DataField{
+			required("x", "xField", "any", "X axis value"),
+			required("y", "yField", "any", "Y axis value"),
+			required("z", "zField", zType, "Z axis value"),
+		}
+	}
+	hierarchy := []DataField{
+		required("name", "nameField", "any", "Node name"),
+		optional("parent", "parentField", "any", "Parent node name; empty for roots"),
+		optional("value", "valueField", "number", "Node value"),
+	}
+	geoFields := []DataField{
+		required("name", "nameField", "any", "Region name or code"),
+		required("value", "valueField", "number", "Region value"),
+	}
+	mapOptions := map[string]*Schema{
+		"mapName":      stringSchema("Name of the registered map boundary set"),
+		"matching":     enumSchema("How region names are matched to a stored boundary set; defaults to the set's matching", "exact", "case-insensitive", "alias"),
+		"nameProperty": stringSchema("Feature property region names are matched against; defaults to the stored boundary set's key property or name"),
+		"roam":         booleanSchema("Allow zoom and pan"),
+		"visualMin":    numberSchema("Lower bound of the color scale"),
+		"visualMax":    numberSchema("Upper bound of the color scale"),
+	}
+	indicator := []DataField{
+		required("value", "valueField", "number", "Current value"),
+		optional("name", "nameField", "any", "Indicator label"),
+	}
+	indicatorOptions := map[string]*Schema{
+		"min":    numberSchema("Scale minimum"),
+		"max":    numberSchema("Scale maximum"),
+		"target": numberSchema("Target value"),
+		"unit":   stringSchema("Unit suffix"),
+	}
+
+	return []*ChartType{
+		newType("bar", "Bar", "basic", xy(), axes(map[string]*Schema{
+			"stack":       booleanSchema("Stack series"),
+			"horizontal":  booleanSchema("Draw bars horizontally"),
+			"seriesTypes": seriesTypes,
+		}), map[string]interface{}{"legend": true}),
+		newType("line", "Line", "basic", xy(), axes(map[string]*Schema{
+			"smooth":      booleanSchema("Smooth lines"),
+			"step":        booleanSchema("Draw a step line"),
+			"seriesTypes": seriesTypes,
+		}), map[string]interface{}{"legend": true, "smooth": false}),
+		newType("area", "Area", "basic", xy(), axes(map[string]*Schema{
+			"stack":       booleanSchema("Stack series"),
+			"smooth":      booleanSchema("Smooth lines"),
+			"seriesTypes": seriesTypes,
+		}), map[string]interface{}{"legend": true, "stack": false}),
+		newType("pie", "Pie", "basic", nameValue(), map[string]*Schema{
+			"innerRadius": rangeSchema("Inner radius in percent; above 0 draws a donut", 0, 100),
+			"showLabel":   booleanSchema("Show slice labels"),
+		}, map[string]interface{}{"legend": true, "showLabel": true}),
+		newType("scatter", "Scatter", "basic", []DataField{
+			required("x", "xField", "number", "X axis value"),
+			required("y", "yField", "number", "Y axis value"),
+			optional("size", "sizeField", "number", "Point size"),
+			optional("series", "seriesField", "any", "Splits rows into series"),
+		}, axes(map[string]*Schema{
+			"symbolSize": numberSchema("Default point size"),
+		}), map[string]interface{}{"symbolSize": 10}),
+		newType("radar", "Radar", "basic", []DataField{
+			required("indicator", "indicatorField", "any", "Axis name"),
+			required("value", "valueField", "number", "Axis value"),
+			optional("series", "seriesField", "any", "Splits rows into series"),
+		}, map[string]*Schema{
+			"shape": enumSchema("Grid shape", "polygon", "circle"),
+		}, map[string]interface{}{"legend": true, "shape": "polygon"}),
+		newType("heatmap", "Heatmap", "statistical", []DataField{
+			required("x", "xField", "any", "Column category"),
+			required("y", "yField", "any", "Row category"),
+			required("value", "valueField", "number", "Cell value"),
+		}, map[string]*Schema{
+			"visualMin": numberSchema("Lower bound of the color scale"),
+			"visualMax": numberSchema("Upper bound of the color scale"),
+		}, nil),
+		newType("gauge", "Gauge", "indicator", indicator, indicatorOptions, map[string]interface{}{"min": 0, "max": 100}),
+		newType("funnel", "Funnel", "basic", nameValue(), map[string]*Schema{
+			"sort": enumSchema("Stage order", "descending", "ascending", "none"),
+		}, map[string]interface{}{"legend": true, "sort": "descending"}),
+		newType("3d-bar", "3D Bar", "3d", xyz("number"), map[string]*Schema{
+			"grid3D": grid3D,
+		}, nil),
+		newType("3d-scatter", "3D Scatter", "3d", append(xyz("number"),
+			optional("category", "colorField", "any", "Color category"),
+		), map[string]*Schema{
+			"grid3D":     grid3D,
+			"symbolSize": numberSchema("Point size"),
+		}, nil),
+		newType("3d-surface", "3D Surface", "3d", []DataField{
+			required("x", "xField", "number", "X axis value"),
+			required("y", "yField", "number", "Y axis value"),
+			required("z", "zField", "number", "Surface height"),
+		}, map[string]*Schema{
+			"grid3D":  grid3D,
+			"shading": enumSchema("Surface shading", "color", "lambert", "realistic"),
+		}, map[string]interface{}{"shading": "color"}),
+		newType("3d-bubble", "3D Bubble", "3d", append(xyz("number"),
+			required("size", "sizeField", "number", "Bubble size"),
+			optional("category", "colorField", "any", "Color category"),
+		), map[string]*Schema{
+			"grid3D": grid3D,
+		}, nil),
+		newType("treemap", "Treemap", "hierarchical", hierarchy, map[string]*Schema{
+			"leafDepth": numberSchema("Depth shown before drilling"),
+		}, nil),
+		newType("sunburst", "Sunburst", "hierarchical", hierarchy, map[string]*Schema{
+			"innerRadius": rangeSchema("Inner radius in percent", 0, 100),
+		}, nil),
+		newType("tree", "Tree", "hierarchical", hierarchy, map[string]*Schema{
+			"orient": enumSchema("Layout direction", "LR", "RL", "TB", "BT"),
+			"layout": enumSchema("Tree layout", "orthogonal", "radial"),
+		}, map[string]interface{}{"orient": "LR", "layout": "orthogonal"}),
+		newType("boxplot", "Box Plot", "statistical", []DataField{
+			required("category", "categoryField", "any", "Box category"),
+			required("min", "minField", "number", "Lower whisker"),
+			required("q1", "q1Field", "number", "First quartile"),
+			required("median", "medianField", "number", "Median"),
+			required("q3", "q3Field", "number", "Third quartile"),
+			required("max", "maxField", "number", "Upper whisker"),
+		}, nil, nil),
+		newType("candlestick", "Candlestick", "financial", []DataField{
+			required("date", "dateField", "any", "Trading period"),
+			required("open", "openField", "number", "Opening price"),
+			required("high", "highField", "number", "Highest price"),
+			required("low", "lowField", "number", "Lowest price"),
+			required("close", "closeField", "number", "Closing price"),
+			optional("volume", "volumeField", "number", "Traded volume"),
+		}, map[string]*Schema{
+			"upColor":   stringSchema("Color of rising candles"),
+			"downColor": stringSchema("Color of falling candles"),
+		}, map[string]interface{}{"upColor": "#ec0000", "downColor": "#00da3c"}),
+		newType("wordcloud", "Word Cloud", "basic", []DataField{
+			required("name", "nameField", "string", "Word"),
+			required("value", "valueField", "number", "Word weight"),
+		}, map[string]*Schema{
+			"sizeRange": &Schema{Type: "array", Description: "Minimum and maximum font size", Items: numberSchema("Font size"), MinItems: intPtr(2), MaxItems: intPtr(2)},
+			"shape":     enumSchema("Cloud shape", "circle", "cardioid", "diamond", "triangle", "pentagon", "star"),
+		}, map[string]interface{}{"sizeRange": []int{12, 60}, "shape": "circle"}),
+		newType("graph", "Graph", "relational", []DataField{
+			required("source", "sourceField", "any", "Source node"),
+			required("target", "targetField", "any", "Target node"),
+			optional("value", "valueField", "number", "Edge weight"),
+		}, map[string]*Schema{
+			"layout": enumSchema("Graph layout", "force", "circular", "none"),
+		}, map[string]interface{}{"layout": "force"}),
+		newType("waterfall", "Waterfall", "financial", []DataField{
+			required("x", "xField", "any", "Step name"),
+			required("y", "yField", "number", "Change at this step"),
+		}, map[string]*Schema{
+			"showTotal": booleanSchema("Append a total bar"),
+		}, map[string]interface{}{"showTotal": true}),
+		newType("polar", "Polar", "basic", []DataField{
+			required("angle", "angleField", "any", "Angle axis value"),
+			required("radius", "radiusField", "number", "Radius axis value"),
+			optional("series", "seriesField", "any", "Splits rows into series"),
+		}, map[string]*Schema{
+			"seriesType": enumSchema("Series drawn on the polar grid", "bar", "line", "scatter"),
+		}, map[string]interface{}{"seriesType": "bar"}),
+		newType("gantt", "Gantt", "timeline", []DataField{
+			required("task", "taskField", "any", "Task name"),
+			required("start", "startField", "any", "Start date"),
+			required("end", "endField", "any", "End date"),
+			optional("progress", "progressField", "number", "Completion in percent"),
+		}, nil, nil),
+		newType("rose", "Rose", "basic", nameValue(), map[string]*Schema{
+			"roseType": enumSchema("Encode value by radius or area", "radius", "area"),
+		}, map[string]interface{}{"legend": true, "roseType": "radius"}),
+		newType("geo", "Geo Points", "geographic", []DataField{
+			required("name", "nameField", "any", "Point name"),
+			required("lng", "lngField", "number", "Longitude"),
+			required("lat", "latField", "number", "Latitude"),
+			optional("value", "valueField", "number", "Point value"),
+		}, mapOptions, map[string]interface{}{"roam": true}),
+		newType("map", "Map", "geographic", geoFields, mapOptions, map[string]interface{}{"roam": true}),
+		newType("choropleth", "Choropleth", "geographic", geoFields, mapOptions, map[string]interface{}{"roam": false}),
+		newType("progress", "Progress", "indicator", indicator, indicatorOptions, map[string]interface{}{"min": 0, "max": 100}),
+		newType("circular-progress", "Circular Progress", "indicator", indicator, indicatorOptions, map[string]interface{}{"min": 0, "max": 100}),
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
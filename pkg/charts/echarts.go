@@ -0,0 +1,964 @@
+package charts
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option is an ECharts option object
+type Option map[string]interface{}
+
+// optionBuilder fills the type-specific parts of an option: axes, series and visual maps
+type optionBuilder func(b *optionContext)
+
+// optionContext carries the inputs of an option builder and the option being built
+type optionContext struct {
+	t      *ChartType
+	cfg    map[string]interface{}
+	rows   []map[string]interface{}
+	option Option
+	legend []string
+}
+
+// optionBuilders maps chart types to their ECharts option builders
+var optionBuilders = map[string]optionBuilder{
+	"bar":               buildCartesian("bar"),
+	"line":              buildCartesian("line"),
+	"area":              buildCartesian("area"),
+	"scatter":           buildScatter,
+	"waterfall":         buildWaterfall,
+	"pie":               buildPie,
+	"rose":              buildPie,
+	"funnel":            buildFunnel,
+	"radar":             buildRadar,
+	"polar":             buildPolar,
+	"heatmap":           buildHeatmap,
+	"gauge":             buildGauge,
+	"progress":          buildProgress,
+	"circular-progress": buildCircularProgress,
+	"boxplot":           buildBoxplot,
+	"candlestick":       buildCandlestick,
+	"wordcloud":         buildWordCloud,
+	"graph":             buildGraph,
+	"gantt":             buildGantt,
+	"treemap":           buildHierarchy("treemap"),
+	"sunburst":          buildHierarchy("sunburst"),
+	"tree":              buildHierarchy("tree"),
+	"3d-bar":            build3D("bar3D"),
+	"3d-scatter":        build3D("scatter3D"),
+	"3d-bubble":         build3D("scatter3D"),
+	"3d-surface":        build3D("surface"),
+	"geo":               buildGeoPoints,
+	"map":               buildMap,
+	"choropleth":        buildMap,
+}
+
+// Options returns the configuration with the type's default options filled in
+func (t *ChartType) Options(cfg map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(cfg)+len(t.DefaultOptions))
+	for k, v := range t.DefaultOptions {
+		merged[k] = v
+	}
+	for k, v := range cfg {
+		merged[k] = v
+	}
+	return merged
+}
+
+// EChartsOption builds a complete ECharts option from a configuration and rows shaped by
+// Shape. The configuration's "echarts" object is merged over the generated option last,
+// so any generated property can be overridden.
+func (t *ChartType) EChartsOption(cfg map[string]interface{}, rows []map[string]interface{}) Option {
+	build, ok := optionBuilders[t.Name]
+	b := &optionContext{t: t, cfg: t.Options(cfg), rows: rows, option: Option{}}
+	if title, ok := b.cfg["title"].(string); ok && title != "" {
+		titleOption := Option{"text": title, "left": "center"}
+		if subtitle, ok := b.cfg["subtitle"].(string); ok && subtitle != "" {
+			titleOption["subtext"] = subtitle
+		}
+		b.option["title"] = titleOption
+	}
+	if colors, ok := b.cfg["color"].([]interface{}); ok && len(colors) > 0 {
+		b.option["color"] = colors
+	}
+	if animation, ok := b.cfg["animation"].(bool); ok {
+		b.option["animation"] = animation
+	}
+	if b.bool("tooltip", true) {
+		b.option["tooltip"] = Option{"trigger": "item"}
+	}
+
+	if ok {
+		build(b)
+	} else {
+		b.option["dataset"] = Option{"source": rows}
+		b.option["series"] = []interface{}{Option{"type": t.Name}}
+	}
+
+	if len(b.legend) > 0 && b.bool("legend", false) {
+		b.option["legend"] = Option{"data": b.legend, "top": "bottom"}
+	}
+	if overrides, ok := b.cfg["echarts"].(map[string]interface{}); ok {
+		mergeOption(b.option, overrides)
+	}
+	return b.option
+}
+
+// mergeOption deep-merges src into dst; objects merge recursively, other values replace
+func mergeOption(dst Option, src map[string]interface{}) {
+	for k, v := range src {
+		srcObj, srcIsObj := v.(map[string]interface{})
+		if srcIsObj {
+			switch existing := dst[k].(type) {
+			case Option:
+				mergeOption(existing, srcObj)
+				continue
+			case map[string]interface{}:
+				mergeOption(Option(existing), srcObj)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+func (b *optionContext) bool(key string, def bool) bool {
+	if v, ok := b.cfg[key].(bool); ok {
+		return v
+	}
+	return def
+}
+
+func (b *optionContext) string(key, def string) string {
+	if v, ok := b.cfg[key].(string); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func (b *optionContext) number(key string) (float64, bool) {
+	return numberValue(b.cfg[key])
+}
+
+// axisNames titles the x or category axis and the y or value axis from xAxisName and yAxisName
+func (b *optionContext) axisNames(xAxis, yAxis Option) {
+	if name := b.string("xAxisName", ""); name != "" {
+		xAxis["name"] = name
+	}
+	if name := b.string("yAxisName", ""); name != "" {
+		yAxis["name"] = name
+	}
+}
+
+// SeriesKind returns how a series of a bar, line or area chart is drawn: the type its name
+// is given in the seriesTypes option, or the chart's own type
+func SeriesKind(cfg map[string]interface{}, name, kind string) string {
+	types, _ := cfg["seriesTypes"].(map[string]interface{})
+	switch t, _ := types[name].(string); t {
+	case "bar", "line", "area":
+		return t
+	}
+	return kind
+}
+
+func (b *optionContext) axisTooltip() {
+	if _, ok := b.option["tooltip"]; ok {
+		b.option["tooltip"] = Option{"trigger": "axis"}
+	}
+}
+
+// numberValue reads a number from a shaped row or configuration value
+func numberValue(v interface{}) (float64, bool) {
+	switch n := toNumber(v).(type) {
+	case float64:
+		return n, !math.IsNaN(n) && !math.IsInf(n, 0)
+	}
+	return 0, false
+}
+
+// numberOrNil returns the number in v, or nil so ECharts leaves a gap
+func numberOrNil(v interface{}) interface{} {
+	if f, ok := numberValue(v); ok {
+		return f
+	}
+	return nil
+}
+
+// categoryLabel formats a value used as a category or name
+func categoryLabel(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return n
+	case []byte:
+		return string(n)
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case time.Time:
+		if n.Hour() == 0 && n.Minute() == 0 && n.Second() == 0 && n.Nanosecond() == 0 {
+			return n.Format("2006-01-02")
+		}
+		return n.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// uniqueLabels returns the distinct labels of a field in order of first appearance
+func uniqueLabels(rows []map[string]interface{}, field string) ([]string, map[string]int) {
+	labels := []string{}
+	index := map[string]int{}
+	for _, row := range rows {
+		l := categoryLabel(row[field])
+		if _, ok := index[l]; !ok {
+			index[l] = len(labels)
+			labels = append(labels, l)
+		}
+	}
+	return labels, index
+}
+
+// seriesGrid pivots rows into a value per series and category; missing points are nil
+func seriesGrid(rows []map[string]interface{}, categoryField, valueField string) ([]string, []string, [][]interface{}) {
+	categories, catIndex := uniqueLabels(rows, categoryField)
+	names, nameIndex := uniqueLabels(rows, "series")
+	grid := make([][]interface{}, len(names))
+	for i := range grid {
+		grid[i] = make([]interface{}, len(categories))
+	}
+	for _, row := range rows {
+		v, ok := numberValue(row[valueField])
+		if !ok {
+			continue
+		}
+		s, c := nameIndex[categoryLabel(row["series"])], catIndex[categoryLabel(row[categoryField])]
+		if existing, ok := grid[s][c].(float64); ok {
+			v += existing
+		}
+		grid[s][c] = v
+	}
+	return categories, names, grid
+}
+
+// seriesName is the display name of a series; rows without a series field form one series
+func seriesName(name string, b *optionContext) string {
+	if name == "" {
+		if title, ok := b.cfg["title"].(string); ok {
+			return title
+		}
+	}
+	return name
+}
+
+// valueExtent returns the smallest and largest value of a field
+func valueExtent(rows []map[string]interface{}, field string) (float64, float64, bool) {
+	lo, hi, found := math.Inf(1), math.Inf(-1), false
+	for _, row := range rows {
+		if v, ok := numberValue(row[field]); ok {
+			lo, hi, found = math.Min(lo, v), math.Max(hi, v), true
+		}
+	}
+	return lo, hi, found
+}
+
+// visualMap builds a continuous visual map over a value field, honouring visualMin and visualMax
+func (b *optionContext) visualMap(field string, dimension int) Option {
+	lo, hi, found := valueExtent(b.rows, field)
+	if !found {
+		lo, hi = 0, 1
+	}
+	if v, ok := b.number("visualMin"); ok {
+		lo = v
+	}
+	if v, ok := b.number("visualMax"); ok {
+		hi = v
+	}
+	vm := Option{"type": "continuous", "min": lo, "max": hi, "calculable": true, "orient": "horizontal", "left": "center", "bottom": 0}
+	if dimension >= 0 {
+		vm["dimension"] = dimension
+	}
+	return vm
+}
+
+func buildCartesian(kind string) optionBuilder {
+	return func(b *optionContext) {
+		categories, names, grid := seriesGrid(b.rows, "x", "y")
+		stack := b.bool("stack", false)
+		series := make([]interface{}, len(names))
+		hasBars := kind == "bar"
+		for i, name := range names {
+			seriesKind := SeriesKind(b.cfg, name, kind)
+			hasBars = hasBars || seriesKind == "bar"
+			s := Option{"name": seriesName(name, b), "type": seriesKind, "data": grid[i]}
+			switch seriesKind {
+			case "line":
+				s["smooth"] = b.bool("smooth", false)
+				if b.bool("step", false) {
+					s["step"] = "middle"
+				}
+			case "area":
+				s["type"] = "line"
+				s["smooth"] = b.bool("smooth", false)
+				s["areaStyle"] = Option{}
+			}
+			if stack {
+				s["stack"] = "total"
+			}
+			series[i] = s
+			if name != "" {
+				b.legend = append(b.legend, name)
+			}
+		}
+
+		categoryAxis := Option{"type": "category", "data": categories, "boundaryGap": hasBars}
+		valueAxis := Option{"type": "value"}
+		b.axisNames(categoryAxis, valueAxis)
+		if kind == "bar" && b.bool("horizontal", false) {
+			b.option["xAxis"], b.option["yAxis"] = valueAxis, categoryAxis
+		} else {
+			b.option["xAxis"], b.option["yAxis"] = categoryAxis, valueAxis
+		}
+		b.option["series"] = series
+		b.axisTooltip()
+	}
+}
+
+func buildScatter(b *optionContext) {
+	names, nameIndex := uniqueLabels(b.rows, "series")
+	data := make([][]interface{}, len(names))
+	slo, shi, hasSize := valueExtent(b.rows, "size")
+	symbolSize, ok := b.number("symbolSize")
+	if !ok {
+		symbolSize = 10
+	}
+	for _, row := range b.rows {
+		x, okx := numberValue(row["x"])
+		y, oky := numberValue(row["y"])
+		if !okx || !oky {
+			continue
+		}
+		point := Option{"value": []float64{x, y}}
+		if size, ok := numberValue(row["size"]); ok && hasSize {
+			// Sizes are scaled onto 6 to 36 pixels
+			scaled := 21.0
+			if shi > slo {
+				scaled = 6 + (size-slo)/(shi-slo)*30
+			}
+			point["value"] = []float64{x, y, size}
+			point["symbolSize"] = math.Round(scaled*10) / 10
+		}
+		i := nameIndex[categoryLabel(row["series"])]
+		data[i] = append(data[i], point)
+	}
+	series := make([]interface{}, len(names))
+	for i, name := range names {
+		series[i] = Option{"name": seriesName(name, b), "type": "scatter", "symbolSize": symbolSize, "data": nonNil(data[i])}
+		if name != "" {
+			b.legend = append(b.legend, name)
+		}
+	}
+	xAxis, yAxis := Option{"type": "value", "scale": true}, Option{"type": "value", "scale": true}
+	b.axisNames(xAxis, yAxis)
+	b.option["xAxis"], b.option["yAxis"] = xAxis, yAxis
+	b.option["series"] = series
+}
+
+// nonNil keeps empty data lists serializing as [] rather than null
+func nonNil(data []interface{}) []interface{} {
+	if data == nil {
+		return []interface{}{}
+	}
+	return data
+}
+
+// buildWaterfall stacks each step on a transparent placeholder bar holding the running total
+func buildWaterfall(b *optionContext) {
+	categories, _, grid := seriesGrid(b.rows, "x", "y")
+	var steps []interface{}
+	if len(grid) > 0 {
+		steps = grid[0]
+	}
+	placeholder := make([]interface{}, 0, len(steps)+1)
+	increase := make([]interface{}, 0, len(steps)+1)
+	decrease := make([]interface{}, 0, len(steps)+1)
+	running := 0.0
+	for _, step := range steps {
+		v, _ := step.(float64)
+		next := running + v
+		placeholder = append(placeholder, math.Min(running, next))
+		if v >= 0 {
+			increase, decrease = append(increase, v), append(decrease, "-")
+		} else {
+			increase, decrease = append(increase, "-"), append(decrease, -v)
+		}
+		running = next
+	}
+	if b.bool("showTotal", true) {
+		categories = append(categories, "Total")
+		placeholder = append(placeholder, math.Min(0, running))
+		increase = append(increase, math.Abs(running))
+		decrease = append(decrease, "-")
+	}
+
+	b.option["xAxis"] = Option{"type": "category", "data": categories}
+	b.option["yAxis"] = Option{"type": "value"}
+	b.option["series"] = []interface{}{
+		Option{"name": "Placeholder", "type": "bar", "stack": "waterfall", "silent": true,
+			"itemStyle": Option{"borderColor": "transparent", "color": "transparent"}, "data": placeholder},
+		Option{"name": "Increase", "type": "bar", "stack": "waterfall", "data": increase},
+		Option{"name": "Decrease", "type": "bar", "stack": "waterfall", "data": decrease},
+	}
+	b.legend = []string{"Increase", "Decrease"}
+	b.axisTooltip()
+}
+
+// nameValueData returns {name, value} items, summing rows with the same name
+func nameValueData(b *optionContext) []interface{} {
+	names, index := uniqueLabels(b.rows, "name")
+	values := make([]float64, len(names))
+	for _, row := range b.rows {
+		if v, ok := numberValue(row["value"]); ok {
+			values[index[categoryLabel(row["name"])]] += v
+		}
+	}
+	data := make([]interface{}, len(names))
+	for i, name := range names {
+		data[i] = Option{"name": name, "value": values[i]}
+	}
+	b.legend = names
+	return data
+}
+
+func buildPie(b *optionContext) {
+	inner := 0.0
+	if v, ok := b.number("innerRadius"); ok {
+		inner = v
+	}
+	series := Option{
+		"type":   "pie",
+		"radius": []string{formatPercent(inner * 0.7), "70%"},
+		"data":   nameValueData(b),
+		"label":  Option{"show": b.bool("showLabel", true)},
+	}
+	if b.t.Name == "rose" {
+		series["roseType"] = b.string("roseType", "radius")
+		series["radius"] = []string{"10%", "70%"}
+	}
+	b.option["series"] = []interface{}{series}
+}
+
+func formatPercent(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64) + "%"
+}
+
+func buildFunnel(b *optionContext) {
+	b.option["series"] = []interface{}{Option{
+		"type": "funnel",
+		"sort": b.string("sort", "descending"),
+		"left": "10%", "width": "80%",
+		"label": Option{"show": true, "position": "inside"},
+		"data":  nameValueData(b),
+	}}
+}
+
+func buildRadar(b *optionContext) {
+	indicators, grid := pivot(b, "indicator")
+	names, _ := uniqueLabels(b.rows, "series")
+	maxima := make([]float64, len(indicators))
+	for _, values := range grid {
+		for i, v := range values {
+			if f, ok := v.(float64); ok {
+				maxima[i] = math.Max(maxima[i], f)
+			}
+		}
+	}
+	indicatorOption := make([]interface{}, len(indicators))
+	for i, name := range indicators {
+		indicatorOption[i] = Option{"name": name, "max": niceCeil(maxima[i])}
+	}
+	data := make([]interface{}, len(names))
+	for i, name := range names {
+		data[i] = Option{"name": seriesName(name, b), "value": grid[i]}
+		if name != "" {
+			b.legend = append(b.legend, name)
+		}
+	}
+	b.option["radar"] = Option{"indicator": indicatorOption, "shape": b.string("shape", "polygon")}
+	b.option["series"] = []interface{}{Option{"type": "radar", "data": data}}
+}
+
+// pivot groups "value" by the given category field and the series field
+func pivot(b *optionContext, categoryField string) ([]string, [][]interface{}) {
+	categories, _, grid := seriesGrid(b.rows, categoryField, "value")
+	return categories, grid
+}
+
+// niceCeil rounds a positive maximum up to a round axis bound
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	mag := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 2.5, 5, 10} {
+		if v <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+func buildPolar(b *optionContext) {
+	categories, names, grid := seriesGrid(b.rows, "angle", "radius")
+	kind := b.string("seriesType", "bar")
+	series := make([]interface{}, len(names))
+	for i, name := range names {
+		s := Option{"name": seriesName(name, b), "type": kind, "coordinateSystem": "polar", "data": grid[i]}
+		if kind == "bar" && len(names) > 1 {
+			s["stack"] = "total"
+		}
+		series[i] = s
+		if name != "" {
+			b.legend = append(b.legend, name)
+		}
+	}
+	b.option["polar"] = Option{}
+	b.option["angleAxis"] = Option{"type": "category", "data": categories}
+	b.option["radiusAxis"] = Option{}
+	b.option["series"] = series
+}
+
+func buildHeatmap(b *optionContext) {
+	xs, xIndex := uniqueLabels(b.rows, "x")
+	ys, yIndex := uniqueLabels(b.rows, "y")
+	data := make([]interface{}, 0, len(b.rows))
+	for _, row := range b.rows {
+		data = append(data, []interface{}{xIndex[categoryLabel(row["x"])], yIndex[categoryLabel(row["y"])], numberOrNil(row["value"])})
+	}
+	b.option["xAxis"] = Option{"type": "category", "data": xs, "splitArea": Option{"show": true}}
+	b.option["yAxis"] = Option{"type": "category", "data": ys, "splitArea": Option{"show": true}}
+	b.option["grid"] = Option{"bottom": 60}
+	b.option["visualMap"] = b.visualMap("value", -1)
+	b.option["series"] = []interface{}{Option{"type": "heatmap", "data": data, "label": Option{"show": true}}}
+}
+
+// indicatorValue returns the first row's value and name and the configured scale
+func indicatorValue(b *optionContext) (interface{}, string, float64, float64) {
+	var value interface{}
+	name := ""
+	if len(b.rows) > 0 {
+		value = numberOrNil(b.rows[0]["value"])
+		name = categoryLabel(b.rows[0]["name"])
+	}
+	lo, ok := b.number("min")
+	if !ok {
+		lo = 0
+	}
+	hi, ok := b.number("max")
+	if !ok {
+		hi = 100
+	}
+	return value, name, lo, hi
+}
+
+func buildGauge(b *optionContext) {
+	value, name, lo, hi := indicatorValue(b)
+	series := Option{
+		"type": "gauge", "min": lo, "max": hi,
+		"detail": Option{"formatter": "{value}" + b.string("unit", "")},
+		"data":   []interface{}{Option{"name": name, "value": value}},
+	}
+	if target, ok := b.number("target"); ok {
+		series["markLine"] = Option{"data": []interface{}{Option{"name": "Target", "value": target}}}
+	}
+	b.option["series"] = []interface{}{series}
+}
+
+func buildProgress(b *optionContext) {
+	value, name, lo, hi := indicatorValue(b)
+	b.option["xAxis"] = Option{"type": "value", "min": lo, "max": hi, "show": false}
+	b.option["yAxis"] = Option{"type": "category", "data": []string{name}, "show": name != ""}
+	series := Option{
+		"type": "bar", "data": []interface{}{value}, "barWidth": 24,
+		"showBackground": true, "backgroundStyle": Option{"borderRadius": 12},
+		"itemStyle": Option{"borderRadius": 12},
+		"label":     Option{"show": true, "position": "right", "formatter": "{c}" + b.string("unit", "")},
+	}
+	if target, ok := b.number("target"); ok {
+		series["markLine"] = Option{"symbol": "none", "data": []interface{}{Option{"name": "Target", "xAxis": target}}}
+	}
+	b.option["series"] = []interface{}{series}
+}
+
+func buildCircularProgress(b *optionContext) {
+	value, name, lo, hi := indicatorValue(b)
+	b.option["series"] = []interface{}{Option{
+		"type": "gauge", "min": lo, "max": hi, "startAngle": 90, "endAngle": -270,
+		"pointer":   Option{"show": false},
+		"progress":  Option{"show": true, "roundCap": true, "width": 18},
+		"axisLine":  Option{"lineStyle": Option{"width": 18}},
+		"splitLine": Option{"show": false}, "axisTick": Option{"show": false}, "axisLabel": Option{"show": false},
+		"title":  Option{"offsetCenter": []string{"0%", "30%"}},
+		"detail": Option{"offsetCenter": []string{"0%", "-10%"}, "formatter": "{value}" + b.string("unit", "")},
+		"data":   []interface{}{Option{"name": name, "value": value}},
+	}}
+}
+
+func buildBoxplot(b *optionContext) {
+	categories := make([]string, 0, len(b.rows))
+	data := make([]interface{}, 0, len(b.rows))
+	for _, row := range b.rows {
+		categories = append(categories, categoryLabel(row["category"]))
+		data = append(data, []interface{}{numberOrNil(row["min"]), numberOrNil(row["q1"]), numberOrNil(row["median"]), numberOrNil(row["q3"]), numberOrNil(row["max"])})
+	}
+	b.option["xAxis"] = Option{"type": "category", "data": categories}
+	b.option["yAxis"] = Option{"type": "value", "scale": true}
+	b.option["series"] = []interface{}{Option{"type": "boxplot", "data": data}}
+}
+
+// buildCandlestick draws prices, with traded volume in a second grid below when present
+func buildCandlestick(b *optionContext) {
+	dates := make([]string, 0, len(b.rows))
+	prices := make([]interface{}, 0, len(b.rows))
+	volumes := make([]interface{}, 0, len(b.rows))
+	hasVolume := false
+	for _, row := range b.rows {
+		dates = append(dates, categoryLabel(row["date"]))
+		// ECharts orders candlestick values open, close, lowest, highest
+		prices = append(prices, []interface{}{numberOrNil(row["open"]), numberOrNil(row["close"]), numberOrNil(row["low"]), numberOrNil(row["high"])})
+		volume := numberOrNil(row["volume"])
+		hasVolume = hasVolume || volume != nil
+		volumes = append(volumes, volume)
+	}
+	candles := Option{
+		"name": "Price", "type": "candlestick", "data": prices,
+		"itemStyle": Option{
+			"color": b.string("upColor", "#ec0000"), "borderColor": b.string("upColor", "#ec0000"),
+			"color0": b.string("downColor", "#00da3c"), "borderColor0": b.string("downColor", "#00da3c"),
+		},
+	}
+	b.axisTooltip()
+	if !hasVolume {
+		b.option["xAxis"] = Option{"type": "category", "data": dates, "scale": true}
+		b.option["yAxis"] = Option{"type": "value", "scale": true}
+		b.option["series"] = []interface{}{candles}
+		return
+	}
+	b.option["grid"] = []interface{}{Option{"top": 60, "height": "55%"}, Option{"top": "75%", "height": "15%"}}
+	b.option["xAxis"] = []interface{}{
+		Option{"type": "category", "data": dates, "scale": true},
+		Option{"type": "category", "data": dates, "gridIndex": 1, "axisLabel": Option{"show": false}},
+	}
+	b.option["yAxis"] = []interface{}{
+		Option{"type": "value", "scale": true},
+		Option{"type": "value", "gridIndex": 1, "splitNumber": 2},
+	}
+	b.option["series"] = []interface{}{
+		candles,
+		Option{"name": "Volume", "type": "bar", "xAxisIndex": 1, "yAxisIndex": 1, "data": volumes},
+	}
+}
+
+// buildWordCloud targets the echarts-wordcloud extension
+func buildWordCloud(b *optionContext) {
+	sizeRange := b.cfg["sizeRange"]
+	if sizeRange == nil {
+		sizeRange = []int{12, 60}
+	}
+	b.option["series"] = []interface{}{Option{
+		"type": "wordCloud", "shape": b.string("shape", "circle"), "sizeRange": sizeRange,
+		"data": nameValueData(b),
+	}}
+	b.legend = nil
+}
+
+// buildGraph derives nodes from the edge endpoints; nodes are sized by their total edge weight
+func buildGraph(b *optionContext) {
+	var nodes []string
+	weight := map[string]float64{}
+	links := make([]interface{}, 0, len(b.rows))
+	for _, row := range b.rows {
+		source, target := categoryLabel(row["source"]), categoryLabel(row["target"])
+		v, ok := numberValue(row["value"])
+		if !ok {
+			v = 1
+		}
+		for _, n := range []string{source, target} {
+			if _, seen := weight[n]; !seen {
+				nodes = append(nodes, n)
+			}
+			weight[n] += v
+		}
+		link := Option{"source": source, "target": target}
+		if value := numberOrNil(row["value"]); value != nil {
+			link["value"] = value
+		}
+		links = append(links, link)
+	}
+	maxWeight := 0.0
+	for _, w := range weight {
+		maxWeight = math.Max(maxWeight, w)
+	}
+	layout := b.string("layout", "force")
+	nodeData := make([]interface{}, len(nodes))
+	for i, n := range nodes {
+		node := Option{"name": n, "value": weight[n], "symbolSize": math.Round(10 + 30*weight[n]/maxWeight)}
+		if layout == "none" {
+			// Without a layout ECharts needs coordinates; place nodes on a circle
+			a := 2 * math.Pi * float64(i) / float64(len(nodes))
+			node["x"], node["y"] = math.Round(400+300*math.Cos(a)), math.Round(300+300*math.Sin(a))
+		}
+		nodeData[i] = node
+	}
+	series := Option{
+		"type": "graph", "layout": layout, "roam": true,
+		"label": Option{"show": true, "position": "right"},
+		"data":  nodeData, "links": links,
+		"lineStyle": Option{"curveness": 0.2, "opacity": 0.7},
+	}
+	if layout == "force" {
+		series["force"] = Option{"repulsion": 200, "edgeLength": []int{50, 150}}
+	}
+	b.option["series"] = []interface{}{series}
+}
+
+// buildGantt draws each task as a bar from its start to its end on a time axis, stacked on
+// a transparent bar that offsets it to the start time
+func buildGantt(b *optionContext) {
+	tasks := make([]string, 0, len(b.rows))
+	offsets := make([]interface{}, 0, len(b.rows))
+	durations := make([]interface{}, 0, len(b.rows))
+	minStart := math.Inf(1)
+	for _, row := range b.rows {
+		start, okStart := timeValue(row["start"])
+		end, okEnd := timeValue(row["end"])
+		tasks = append(tasks, categoryLabel(row["task"]))
+		if !okStart || !okEnd || end.Before(start) {
+			offsets, durations = append(offsets, nil), append(durations, nil)
+			continue
+		}
+		startMs := float64(start.UnixMilli())
+		minStart = math.Min(minStart, startMs)
+		offsets = append(offsets, startMs)
+		item := Option{"value": float64(end.Sub(start).Milliseconds())}
+		if progress, ok := numberValue(row["progress"]); ok {
+			item["label"] = Option{"show": true, "formatter": strconv.FormatFloat(progress, 'f', -1, 64) + "%"}
+		}
+		durations = append(durations, item)
+	}
+	xAxis := Option{"type": "time"}
+	if !math.IsInf(minStart, 1) {
+		xAxis["min"] = minStart
+	}
+	b.option["xAxis"] = xAxis
+	b.option["yAxis"] = Option{"type": "category", "data": tasks, "inverse": true}
+	b.option["series"] = []interface{}{
+		Option{"type": "bar", "stack": "gantt", "silent": true, "itemStyle": Option{"color": "transparent"}, "data": offsets},
+		Option{"name": "Duration", "type": "bar", "stack": "gantt", "data": durations},
+	}
+}
+
+// timeValue parses a date from a time, a Unix millisecond number or a date string
+func timeValue(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case []byte:
+		return timeValue(string(t))
+	case string:
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+			if parsed, err := time.Parse(layout, strings.TrimSpace(t)); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	if ms, ok := numberValue(v); ok {
+		return time.UnixMilli(int64(ms)).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+type treeNode struct {
+	name     string
+	value    interface{}
+	children []*treeNode
+}
+
+func (n *treeNode) option() Option {
+	o := Option{"name": n.name}
+	if n.value != nil {
+		o["value"] = n.value
+	}
+	if len(n.children) > 0 {
+		children := make([]interface{}, len(n.children))
+		for i, c := range n.children {
+			children[i] = c.option()
+		}
+		o["children"] = children
+	}
+	return o
+}
+
+// hierarchyRoots links name/parent rows into trees. Rows whose parent is empty or unknown
+// become roots; a parent cycle is broken at the row that closes it.
+func hierarchyRoots(rows []map[string]interface{}) []*treeNode {
+	nodes := map[string]*treeNode{}
+	var order []string
+	parents := map[string]string{}
+	for _, row := range rows {
+		name := categoryLabel(row["name"])
+		if _, ok := nodes[name]; !ok {
+			nodes[name] = &treeNode{name: name}
+			order = append(order, name)
+		}
+		nodes[name].value = numberOrNil(row["value"])
+		parents[name] = categoryLabel(row["parent"])
+	}
+	var roots []*treeNode
+	for _, name := range order {
+		parent := parents[name]
+		if _, ok := nodes[parent]; !ok || parent == "" || createsCycle(parents, name) {
+			roots = append(roots, nodes[name])
+			continue
+		}
+		nodes[parent].children = append(nodes[parent].children, nodes[name])
+	}
+	return roots
+}
+
+// createsCycle reports whether following parents from name leads back to it
+func createsCycle(parents map[string]string, name string) bool {
+	seen := map[string]bool{name: true}
+	for p := parents[name]; p != ""; p = parents[p] {
+		if seen[p] {
+			return p == name
+		}
+		seen[p] = true
+	}
+	return false
+}
+
+func buildHierarchy(kind string) optionBuilder {
+	return func(b *optionContext) {
+		roots := hierarchyRoots(b.rows)
+		data := make([]interface{}, len(roots))
+		for i, r := range roots {
+			data[i] = r.option()
+		}
+		series := Option{"type": kind, "data": data}
+		switch kind {
+		case "treemap":
+			if depth, ok := b.number("leafDepth"); ok {
+				series["leafDepth"] = depth
+			}
+		case "sunburst":
+			inner := 0.0
+			if v, ok := b.number("innerRadius"); ok {
+				inner = v
+			}
+			series["radius"] = []string{formatPercent(inner * 0.9), "90%"}
+		case "tree":
+			// A tree series draws a single root, so several roots hang off a synthetic one
+			if len(roots) != 1 {
+				series["data"] = []interface{}{Option{"name": b.string("title", "root"), "children": data}}
+			}
+			series["orient"] = b.string("orient", "LR")
+			series["layout"] = b.string("layout", "orthogonal")
+			series["label"] = Option{"position": "left", "verticalAlign": "middle"}
+			series["leaves"] = Option{"label": Option{"position": "right"}}
+			series["expandAndCollapse"] = true
+		}
+		b.option["series"] = []interface{}{series}
+	}
+}
+
+// build3D targets echarts-gl. Bars use category axes for x and y; the other types plot
+// numeric coordinates. Bubble sizes are scaled onto 6 to 36 pixels.
+func build3D(kind string) optionBuilder {
+	return func(b *optionContext) {
+		grid3D := Option{}
+		if g, ok := b.cfg["grid3D"].(map[string]interface{}); ok {
+			mergeOption(grid3D, g)
+		}
+		b.option["grid3D"] = grid3D
+		b.option["visualMap"] = b.visualMap("z", 2)
+
+		slo, shi, _ := valueExtent(b.rows, "size")
+		data := make([]interface{}, 0, len(b.rows))
+		var xs, ys []string
+		if kind == "bar3D" {
+			var xIndex, yIndex map[string]int
+			xs, xIndex = uniqueLabels(b.rows, "x")
+			ys, yIndex = uniqueLabels(b.rows, "y")
+			for _, row := range b.rows {
+				data = append(data, []interface{}{xIndex[categoryLabel(row["x"])], yIndex[categoryLabel(row["y"])], numberOrNil(row["z"])})
+			}
+			b.option["xAxis3D"] = Option{"type": "category", "data": xs}
+			b.option["yAxis3D"] = Option{"type": "category", "data": ys}
+		} else {
+			for _, row := range b.rows {
+				item := Option{"value": []interface{}{numberOrNil(row["x"]), numberOrNil(row["y"]), numberOrNil(row["z"])}}
+				if category, ok := row["category"]; ok {
+					item["name"] = categoryLabel(category)
+				}
+				if size, ok := numberValue(row["size"]); ok && b.t.Name == "3d-bubble" {
+					scaled := 21.0
+					if shi > slo {
+						scaled = 6 + (size-slo)/(shi-slo)*30
+					}
+					item["symbolSize"] = math.Round(scaled*10) / 10
+				}
+				data = append(data, item)
+			}
+			b.option["xAxis3D"] = Option{"type": "value"}
+			b.option["yAxis3D"] = Option{"type": "value"}
+		}
+		b.option["zAxis3D"] = Option{"type": "value"}
+
+		series := Option{"type": kind, "data": data}
+		switch b.t.Name {
+		case "3d-scatter":
+			if size, ok := b.number("symbolSize"); ok {
+				series["symbolSize"] = size
+			}
+		case "3d-surface":
+			series["shading"] = b.string("shading", "color")
+			series["wireframe"] = Option{"show": true}
+		case "3d-bar":
+			series["shading"] = "lambert"
+		}
+		b.option["series"] = []interface{}{series}
+	}
+}
+
+// buildGeoPoints plots points by longitude and latitude on a registered map
+func buildGeoPoints(b *optionContext) {
+	data := make([]interface{}, 0, len(b.rows))
+	for _, row := range b.rows {
+		data = append(data, Option{
+			"name":  categoryLabel(row["name"]),
+			"value": []interface{}{numberOrNil(row["lng"]), numberOrNil(row["lat"]), numberOrNil(row["value"])},
+		})
+	}
+	b.option["geo"] = Option{"map": b.string("mapName", "world"), "roam": b.bool("roam", true)}
+	if _, _, ok := valueExtent(b.rows, "value"); ok {
+		b.option["visualMap"] = b.visualMap("value", 2)
+	}
+	b.option["series"] = []interface{}{Option{"type": "scatter", "coordinateSystem": "geo", "data": data}}
+}
+
+// buildMap colors the regions of a registered map by value
+func buildMap(b *optionContext) {
+	b.option["visualMap"] = b.visualMap("value", -1)
+	series := Option{
+		"type": "map", "map": b.string("mapName", "world"), "roam": b.bool("roam", b.t.Name == "map"),
+		"data": nameValueData(b),
+	}
+	if property := b.string("nameProperty", ""); property != "" {
+		series["nameProperty"] = property
+	}
+	b.option["series"] = []interface{}{series}
+	b.legend = nil
+}
@@ -0,0 +1,74 @@
+package charts
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden ECharts options in testdata")
+
+// optionFixture is the input of a golden test: a chart configuration and query rows
+type optionFixture struct {
+	Config map[string]interface{}   `json:"config"`
+	Rows   []map[string]interface{} `json:"rows"`
+}
+
+// TestEChartsOptionGolden builds the option of every built-in type from
+// testdata/<type>.input.json and compares it with testdata/<type>.golden.json.
+// Run with -update to rewrite the golden files after an intended change.
+func TestEChartsOptionGolden(t *testing.T) {
+	for _, chartType := range builtinTypes() {
+		chartType := chartType
+		t.Run(chartType.Name, func(t *testing.T) {
+			registered, ok := Lookup(chartType.Name)
+			if !ok {
+				t.Fatalf("built-in type %q is not registered", chartType.Name)
+			}
+
+			input, err := os.ReadFile(filepath.Join("testdata", chartType.Name+".input.json"))
+			if err != nil {
+				t.Fatalf("every built-in type needs a golden fixture: %v", err)
+			}
+			var fixture optionFixture
+			if err := json.Unmarshal(input, &fixture); err != nil {
+				t.Fatalf("decode fixture: %v", err)
+			}
+			if errs := registered.ValidateConfig(string(mustJSON(t, fixture.Config))); len(errs) > 0 {
+				t.Fatalf("fixture config is invalid: %v", errs)
+			}
+
+			rows, errs := registered.Shape(registered.Columns(nil, fixture.Config), fixture.Rows)
+			if len(errs) > 0 {
+				t.Fatalf("Shape: %v", errs)
+			}
+			got := mustJSON(t, registered.EChartsOption(fixture.Config, rows))
+
+			golden := filepath.Join("testdata", chartType.Name+".golden.json")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden file (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("option differs from %s:\n got: %s\nwant: %s", golden, got, want)
+			}
+		})
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return append(data, '\n')
+}
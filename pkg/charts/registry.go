@@ -0,0 +1,156 @@
+package charts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DataField describes a column a chart type reads from each data row
+type DataField struct {
+	// Name is the role name and the column read when nothing maps the field elsewhere
+	Name string `json:"name"`
+	// ConfigKey is the configuration property that maps the field to another column
+	ConfigKey string `json:"config_key,omitempty"`
+	// Type is number, string or any
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	Description string `json:"description,omitempty"`
+}
+
+// ChartType describes a chart type: its configuration schema, data shape and default options
+type ChartType struct {
+	Name           string                 `json:"name"`
+	Label          string                 `json:"label"`
+	Category       string                 `json:"category"`
+	ConfigSchema   *Schema                `json:"config_schema"`
+	DataFields     []DataField            `json:"data_fields"`
+	DefaultOptions map[string]interface{} `json:"default_options"`
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]*ChartType)
+	order      []string
+)
+
+// Register adds a chart type to the registry, replacing a type with the same name
+func Register(t *ChartType) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[t.Name]; !exists {
+		order = append(order, t.Name)
+	}
+	registry[t.Name] = t
+}
+
+// Lookup returns the chart type registered under a name
+func Lookup(name string) (*ChartType, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	t, ok := registry[name]
+	return t, ok
+}
+
+// Types returns all registered chart types in registration order
+func Types() []*ChartType {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	types := make([]*ChartType, 0, len(order))
+	for _, name := range order {
+		types = append(types, registry[name])
+	}
+	return types
+}
+
+// ParseConfig decodes a chart configuration; an empty configuration is an empty object
+func ParseConfig(config string) (map[string]interface{}, error) {
+	cfg := map[string]interface{}{}
+	if strings.TrimSpace(config) == "" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ValidateConfig checks a chart configuration against the type's schema
+func (t *ChartType) ValidateConfig(config string) []FieldError {
+	var value interface{}
+	if strings.TrimSpace(config) == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(config), &value); err != nil {
+		return []FieldError{{Field: "config", Message: "must be valid JSON: " + err.Error()}}
+	}
+	errs := t.ConfigSchema.Validate(value)
+	for i := range errs {
+		errs[i].Field = joinPath("config", errs[i].Field)
+	}
+	return errs
+}
+
+// ValidateData checks that every data row provides the columns the chart type reads.
+// Column names are resolved through the field mappings and the configuration.
+func (t *ChartType) ValidateData(mappings, config, data string) []FieldError {
+	if strings.TrimSpace(data) == "" {
+		return nil
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &rows); err != nil {
+		return []FieldError{{Field: "data", Message: "must be a JSON array of objects"}}
+	}
+	cfg, err := ParseConfig(config)
+	if err != nil {
+		cfg = map[string]interface{}{}
+	}
+	fieldMappings, err := ParseFieldMappings(mappings)
+	if err != nil {
+		fieldMappings = map[string]string{}
+	}
+	columns := t.Columns(fieldMappings, cfg)
+
+	var errs []FieldError
+	for i, row := range rows {
+		for _, field := range t.DataFields {
+			column := columns[field.Name]
+			path := fmt.Sprintf("data[%d].%s", i, column)
+			value, ok := row[column]
+			if !ok || value == nil {
+				if field.Required {
+					addFieldError(&errs, path, fmt.Sprintf("is required by %s charts (%s)", t.Name, field.Name))
+				}
+				continue
+			}
+			if !matchesFieldType(field.Type, value) {
+				addFieldError(&errs, path, fmt.Sprintf("must be a %s", field.Type))
+			}
+		}
+		if len(errs) >= maxFieldErrors {
+			break
+		}
+	}
+	return errs
+}
+
+// matchesFieldType accepts numeric strings as numbers since many drivers return decimals as text
+func matchesFieldType(typ string, value interface{}) bool {
+	switch typ {
+	case "number":
+		switch v := value.(type) {
+		case float64:
+			return true
+		case string:
+			_, err := strconv.ParseFloat(v, 64)
+			return err == nil
+		}
+		return false
+	case "string":
+		_, ok := value.(string)
+		return ok
+	}
+	return true
+}
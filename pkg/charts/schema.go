@@ -0,0 +1,188 @@
+package charts
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Schema is the subset of JSON Schema used to describe chart configurations
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+}
+
+// FieldError describes a validation failure of a single field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// maxFieldErrors caps the number of errors reported for one document
+const maxFieldErrors = 20
+
+// Validate checks a decoded JSON value against the schema
+func (s *Schema) Validate(value interface{}) []FieldError {
+	var errs []FieldError
+	s.validate(value, "", &errs)
+	return errs
+}
+
+func (s *Schema) validate(value interface{}, path string, errs *[]FieldError) {
+	if len(*errs) >= maxFieldErrors {
+		return
+	}
+	if s.Type != "" && !matchesType(s.Type, value) {
+		addFieldError(errs, path, fmt.Sprintf("must be of type %s", s.Type))
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		addFieldError(errs, path, fmt.Sprintf("must be one of %s", formatEnum(s.Enum)))
+		return
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			addFieldError(errs, path, fmt.Sprintf("must be at least %v", *s.Minimum))
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			addFieldError(errs, path, fmt.Sprintf("must be at most %v", *s.Maximum))
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			addFieldError(errs, path, fmt.Sprintf("must contain at least %d items", *s.MinItems))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			addFieldError(errs, path, fmt.Sprintf("must contain at most %d items", *s.MaxItems))
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				addFieldError(errs, joinPath(path, name), "is required")
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if prop, ok := s.Properties[key]; ok {
+				prop.validate(v[key], joinPath(path, key), errs)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				addFieldError(errs, joinPath(path, key), "is not a supported property")
+			}
+		}
+	}
+}
+
+// matchesType reports whether a decoded JSON value has the given JSON Schema type
+func matchesType(typ string, value interface{}) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, candidate := range enum {
+		if reflect.DeepEqual(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(enum []interface{}) string {
+	parts := make([]string, len(enum))
+	for i, v := range enum {
+		parts[i] = fmt.Sprintf("%v", v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	if key == "" {
+		return path
+	}
+	return path + "." + key
+}
+
+func addFieldError(errs *[]FieldError, field, message string) {
+	if len(*errs) < maxFieldErrors {
+		*errs = append(*errs, FieldError{Field: field, Message: message})
+	}
+}
+
+// Schema constructors used by the built-in chart type definitions
+
+func objectSchema(props map[string]*Schema, required ...string) *Schema {
+	return &Schema{Type: "object", Properties: props, Required: required}
+}
+
+func stringSchema(description string) *Schema {
+	return &Schema{Type: "string", Description: description}
+}
+
+func booleanSchema(description string) *Schema {
+	return &Schema{Type: "boolean", Description: description}
+}
+
+func numberSchema(description string) *Schema {
+	return &Schema{Type: "number", Description: description}
+}
+
+func rangeSchema(description string, min, max float64) *Schema {
+	return &Schema{Type: "number", Description: description, Minimum: &min, Maximum: &max}
+}
+
+func enumSchema(description string, values ...string) *Schema {
+	enum := make([]interface{}, len(values))
+	for i, v := range values {
+		enum[i] = v
+	}
+	return &Schema{Type: "string", Description: description, Enum: enum}
+}
+
+func arraySchema(description string, items *Schema) *Schema {
+	return &Schema{Type: "array", Description: description, Items: items}
+}
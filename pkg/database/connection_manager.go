@@ -1,16 +1,36 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"gobi/config"
 	"gobi/internal/models"
+	"math/rand"
+	"strings"
 	"sync"
 	"time"
 )
 
+// PrimaryEndpoint is the endpoint name of a data source's primary database
+const PrimaryEndpoint = "primary"
+
+// replicaRetryInterval is how long a failed replica is skipped before it is tried again
+const replicaRetryInterval = 30 * time.Second
+
+// endpointState tracks usage and health of a single data source endpoint
+type endpointState struct {
+	dataSourceID uint
+	endpoint     string
+	served       int64
+	failures     int64
+	lastFailure  time.Time
+}
+
 var (
-	connectionPools = make(map[uint]*sql.DB)
+	connectionPools = make(map[string]*sql.DB)
+	endpointStates  = make(map[string]*endpointState)
 	mu              sync.Mutex
 	appConfig       *config.Config
 )
@@ -20,35 +40,275 @@ func InitConnectionManager(cfg *config.Config) {
 	appConfig = cfg
 }
 
-// GetConnection retrieves a cached database connection pool for a given data source.
+// GetConnection retrieves a cached database connection pool for the primary of a given data source.
 // If a pool does not exist, it creates a new one and caches it.
 func GetConnection(ds *models.DataSource) (*sql.DB, error) {
+	return getPrimaryConnection(ds)
+}
+
+// GetReadConnection returns a connection pool for read-only queries. Healthy replicas are
+// chosen by weight; replicas that fail to respond are skipped and the primary is used as
+// the last resort. The name of the serving endpoint is returned with the pool.
+func GetReadConnection(ds *models.DataSource) (*sql.DB, string, error) {
+	replicas, err := ParseReplicas(ds)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, replica := range weightedOrder(replicas) {
+		endpoint := replicaEndpoint(replica)
+		mu.Lock()
+		state := getEndpointState(ds.ID, endpoint)
+		skip := !state.lastFailure.IsZero() && time.Since(state.lastFailure) < replicaRetryInterval
+		mu.Unlock()
+		if skip {
+			continue
+		}
+
+		db, err := getReplicaConnection(ds, replica)
+		mu.Lock()
+		if err != nil {
+			state.failures++
+			state.lastFailure = time.Now()
+		} else {
+			state.lastFailure = time.Time{}
+		}
+		mu.Unlock()
+		if err == nil {
+			return db, endpoint, nil
+		}
+	}
+
+	db, err := getPrimaryConnection(ds)
+	if err != nil {
+		return nil, "", err
+	}
+	return db, PrimaryEndpoint, nil
+}
+
+// GetConnectionForQuery routes read queries to replicas and all other statements to the primary
+func GetConnectionForQuery(ds *models.DataSource, sqlStr string) (*sql.DB, string, error) {
+	if IsReadQuery(sqlStr) {
+		return GetReadConnection(ds)
+	}
+	db, err := GetConnection(ds)
+	if err != nil {
+		return nil, "", err
+	}
+	return db, PrimaryEndpoint, nil
+}
+
+// QueryWithFailover runs a query on the endpoint chosen by GetConnectionForQuery. If a replica
+// fails and no longer answers pings it is marked unhealthy and the query is retried on the primary.
+func QueryWithFailover(ctx context.Context, ds *models.DataSource, sqlStr string, args ...interface{}) (*sql.Rows, string, error) {
+	db, endpoint, err := GetConnectionForQuery(ds, sqlStr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rows, err := db.QueryContext(ctx, sqlStr, args...)
+	if err != nil && endpoint != PrimaryEndpoint && ctx.Err() == nil && db.PingContext(ctx) != nil {
+		markEndpointFailed(ds.ID, endpoint)
+		if db, err = GetConnection(ds); err != nil {
+			return nil, "", err
+		}
+		endpoint = PrimaryEndpoint
+		rows, err = db.QueryContext(ctx, sqlStr, args...)
+	}
+	if err != nil {
+		return nil, endpoint, err
+	}
+
+	mu.Lock()
+	getEndpointState(ds.ID, endpoint).served++
+	mu.Unlock()
+
+	return rows, endpoint, nil
+}
+
+// IsReadQuery reports whether a statement only reads data and may be served by a replica
+func IsReadQuery(sqlStr string) bool {
+	upper := strings.ToUpper(strings.TrimSpace(sqlStr))
+	for _, prefix := range []string{"SELECT", "SHOW", "EXPLAIN", "DESCRIBE", "DESC "} {
+		if strings.HasPrefix(upper, prefix) {
+			return !strings.Contains(upper, " FOR UPDATE") && !strings.Contains(upper, " INTO ")
+		}
+	}
+	if strings.HasPrefix(upper, "WITH") {
+		for _, keyword := range []string{"INSERT ", "UPDATE ", "DELETE ", "MERGE "} {
+			if strings.Contains(upper, keyword) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ParseReplicas parses and validates the replica list of a data source
+func ParseReplicas(ds *models.DataSource) ([]models.DataSourceReplica, error) {
+	if strings.TrimSpace(ds.Replicas) == "" {
+		return nil, nil
+	}
+	var replicas []models.DataSourceReplica
+	if err := json.Unmarshal([]byte(ds.Replicas), &replicas); err != nil {
+		return nil, fmt.Errorf("invalid replicas definition: %w", err)
+	}
+	if len(replicas) > 0 {
+		driver, err := GetDriver(ds.Type)
+		if err != nil {
+			return nil, err
+		}
+		if !driver.SupportsReplicas() {
+			return nil, fmt.Errorf("%s data sources do not support replicas", ds.Type)
+		}
+	}
+	for i, replica := range replicas {
+		if replica.Host == "" {
+			return nil, fmt.Errorf("replica %d: host is required", i)
+		}
+		if replica.Port <= 0 || replica.Port > 65535 {
+			return nil, fmt.Errorf("replica %d: port must be between 1 and 65535", i)
+		}
+		if replica.Weight < 0 {
+			return nil, fmt.Errorf("replica %d: weight must not be negative", i)
+		}
+	}
+	return replicas, nil
+}
+
+// CloseAllConnections closes all cached database connection pools.
+// This should be called on application shutdown.
+func CloseAllConnections() {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if db, ok := connectionPools[ds.ID]; ok {
-		if err := db.Ping(); err == nil {
-			return db, nil
+	for _, db := range connectionPools {
+		db.Close()
+	}
+	connectionPools = make(map[string]*sql.DB) // Clear the map
+}
+
+// GetConnectionStats returns statistics about connection pools, one entry per endpoint
+func GetConnectionStats() map[string]interface{} {
+	mu.Lock()
+	defer mu.Unlock()
+
+	stats := make(map[string]interface{})
+	stats["total_pools"] = len(connectionPools)
+
+	poolDetails := make(map[string]map[string]interface{})
+	for key, state := range endpointStates {
+		detail := map[string]interface{}{
+			"data_source_id": state.dataSourceID,
+			"endpoint":       state.endpoint,
+			"queries_served": state.served,
+			"failures":       state.failures,
+			"healthy":        state.lastFailure.IsZero() || time.Since(state.lastFailure) >= replicaRetryInterval,
+		}
+		if !state.lastFailure.IsZero() {
+			detail["last_failure"] = state.lastFailure
+		}
+		if db, ok := connectionPools[key]; ok {
+			dbStats := db.Stats()
+			detail["max_open_connections"] = dbStats.MaxOpenConnections
+			detail["open_connections"] = dbStats.OpenConnections
+			detail["in_use"] = dbStats.InUse
+			detail["idle"] = dbStats.Idle
+		}
+		poolDetails[key] = detail
+	}
+	stats["pool_details"] = poolDetails
+
+	return stats
+}
+
+// getPrimaryConnection returns the primary pool of a data source
+func getPrimaryConnection(ds *models.DataSource) (*sql.DB, error) {
+	mu.Lock()
+	getEndpointState(ds.ID, PrimaryEndpoint)
+	mu.Unlock()
+
+	return getPool(endpointKey(ds.ID, PrimaryEndpoint), false, func() (*sql.DB, error) {
+		driver, dsn, err := buildDSN(ds, ds.Host, ds.Port, ds.Username)
+		if err != nil {
+			return nil, err
 		}
+		return openPool(driver, dsn)
+	})
+}
+
+// getReplicaConnection returns a verified pool for a replica
+func getReplicaConnection(ds *models.DataSource, replica models.DataSourceReplica) (*sql.DB, error) {
+	return getPool(endpointKey(ds.ID, replicaEndpoint(replica)), true, func() (*sql.DB, error) {
+		username := replica.Username
+		if username == "" {
+			username = ds.Username
+		}
+		driver, dsn, err := buildDSN(ds, replica.Host, replica.Port, username)
+		if err != nil {
+			return nil, err
+		}
+		return openPool(driver, dsn)
+	})
+}
+
+// getPool returns the cached pool of an endpoint if it answers a ping, or caches a pool from
+// open, pinged first when verify is set. Pings are network round trips and run without mu
+// held, so a slow endpoint does not stall the lookups of the others.
+func getPool(key string, verify bool, open func() (*sql.DB, error)) (*sql.DB, error) {
+	mu.Lock()
+	cached, ok := connectionPools[key]
+	mu.Unlock()
+	if ok {
+		if err := cached.Ping(); err == nil {
+			return cached, nil
+		}
+		mu.Lock()
+		if connectionPools[key] == cached {
+			delete(connectionPools, key)
+		}
+		mu.Unlock()
+		cached.Close()
+	}
+
+	db, err := open()
+	if err != nil {
+		return nil, err
+	}
+	if verify {
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if existing, ok := connectionPools[key]; ok {
+		// Another lookup cached a pool for the endpoint meanwhile
 		db.Close()
-		delete(connectionPools, ds.ID)
+		return existing, nil
 	}
+	connectionPools[key] = db
+	return db, nil
+}
 
-	var dsn, driver string
-	switch ds.Type {
-	case "mysql":
-		driver = "mysql"
-		dsn = fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", ds.Username, ds.Password, ds.Host, ds.Port, ds.Database)
-	case "postgres":
-		driver = "postgres"
-		dsn = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", ds.Host, ds.Port, ds.Username, ds.Password, ds.Database)
-	case "sqlite":
-		driver = "sqlite3"
-		dsn = ds.Database
-	default:
-		return nil, fmt.Errorf("unsupported data source type: %s", ds.Type)
+// buildDSN builds the database/sql driver name and DSN for one endpoint of a data source
+func buildDSN(ds *models.DataSource, host string, port int, username string) (string, string, error) {
+	driver, err := GetDriver(ds.Type)
+	if err != nil {
+		return "", "", err
+	}
+	dsn, err := driver.DSN(ds, Endpoint{Host: host, Port: port, Username: username})
+	if err != nil {
+		return "", "", err
 	}
+	return driver.SQLDriverName(), dsn, nil
+}
 
+// openPool opens a connection pool and applies the configured pool limits
+func openPool(driver, dsn string) (*sql.DB, error) {
 	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
@@ -67,41 +327,69 @@ func GetConnection(ds *models.DataSource) (*sql.DB, error) {
 		db.SetConnMaxIdleTime(1 * time.Minute)
 	}
 
-	connectionPools[ds.ID] = db
-
 	return db, nil
 }
 
-// CloseAllConnections closes all cached database connection pools.
-// This should be called on application shutdown.
-func CloseAllConnections() {
+// markEndpointFailed marks an endpoint unhealthy and drops its pool
+func markEndpointFailed(dsID uint, endpoint string) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	for _, db := range connectionPools {
+	key := endpointKey(dsID, endpoint)
+	state := getEndpointState(dsID, endpoint)
+	state.failures++
+	state.lastFailure = time.Now()
+	if db, ok := connectionPools[key]; ok {
 		db.Close()
+		delete(connectionPools, key)
 	}
-	connectionPools = make(map[uint]*sql.DB) // Clear the map
 }
 
-// GetConnectionStats returns statistics about connection pools
-func GetConnectionStats() map[string]interface{} {
-	mu.Lock()
-	defer mu.Unlock()
-
-	stats := make(map[string]interface{})
-	stats["total_pools"] = len(connectionPools)
+// getEndpointState returns the state of an endpoint, creating it if needed; mu must be held
+func getEndpointState(dsID uint, endpoint string) *endpointState {
+	key := endpointKey(dsID, endpoint)
+	state, ok := endpointStates[key]
+	if !ok {
+		state = &endpointState{dataSourceID: dsID, endpoint: endpoint}
+		endpointStates[key] = state
+	}
+	return state
+}
 
-	poolDetails := make(map[uint]map[string]interface{})
-	for id, db := range connectionPools {
-		poolDetails[id] = map[string]interface{}{
-			"max_open_connections": db.Stats().MaxOpenConnections,
-			"open_connections":     db.Stats().OpenConnections,
-			"in_use":               db.Stats().InUse,
-			"idle":                 db.Stats().Idle,
+// weightedOrder returns replicas in a random order where heavier replicas tend to come first
+func weightedOrder(replicas []models.DataSourceReplica) []models.DataSourceReplica {
+	remaining := append([]models.DataSourceReplica(nil), replicas...)
+	ordered := make([]models.DataSourceReplica, 0, len(replicas))
+	for len(remaining) > 0 {
+		total := 0
+		for _, r := range remaining {
+			total += replicaWeight(r)
+		}
+		pick := rand.Intn(total)
+		for i, r := range remaining {
+			pick -= replicaWeight(r)
+			if pick < 0 {
+				ordered = append(ordered, r)
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
 		}
 	}
-	stats["pool_details"] = poolDetails
+	return ordered
+}
 
-	return stats
+// replicaWeight returns the routing weight of a replica; an unset weight counts as 1
+func replicaWeight(r models.DataSourceReplica) int {
+	if r.Weight <= 0 {
+		return 1
+	}
+	return r.Weight
+}
+
+func replicaEndpoint(r models.DataSourceReplica) string {
+	return fmt.Sprintf("replica:%s:%d", r.Host, r.Port)
+}
+
+func endpointKey(dsID uint, endpoint string) string {
+	return fmt.Sprintf("%d/%s", dsID, endpoint)
 }
@@ -38,9 +38,21 @@ func InitDB(cfg *config.Config) error {
 		&models.ExcelTemplate{},
 		&models.Report{},
 		&models.ReportSchedule{},
+		&models.ScheduleRun{},
 		&models.APIKey{},
 		&models.Webhook{},
 		&models.WebhookDelivery{},
+		&models.ReportDelivery{},
+		&models.ReportRetentionPolicy{},
+		&models.DataProfile{},
+		&models.Dashboard{},
+		&models.DashboardTile{},
+		&models.DashboardFilter{},
+		&models.DashboardShare{},
+		&models.EmbedSecret{},
+		&models.EmbedUsage{},
+		&models.BoundarySet{},
+		&models.BoundaryLevel{},
 	)
 	if err != nil {
 		return errors.WrapError(err, "Failed to auto-migrate database schema")
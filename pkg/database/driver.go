@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gobi/internal/models"
+	"gobi/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Endpoint is the network location a DSN is built for: the primary or one of its replicas
+type Endpoint struct {
+	Host     string
+	Port     int
+	Username string
+}
+
+// Dialect describes the SQL flavour of a driver
+type Dialect interface {
+	// QuoteIdentifier quotes a table or column name
+	QuoteIdentifier(name string) string
+	// LimitQuery restricts a SELECT statement to at most limit rows
+	LimitQuery(query string, limit int) string
+	// Placeholder returns the bind parameter marker of the n-th (1-based) argument
+	Placeholder(n int) string
+}
+
+// Driver integrates a data source type with Gobi. Drivers are registered by
+// name with RegisterDriver; the name is the value stored in DataSource.Type.
+type Driver interface {
+	Dialect
+
+	// Name returns the data source type handled by the driver
+	Name() string
+	// SQLDriverName returns the database/sql driver used to open connections
+	SQLDriverName() string
+	// DSN builds the connection string of a data source endpoint
+	DSN(ds *models.DataSource, endpoint Endpoint) (string, error)
+	// Validate checks the driver-specific fields of a data source
+	Validate(ds *models.DataSource) error
+	// SupportsReplicas reports whether read replicas can be configured
+	SupportsReplicas() bool
+	// TestConnection verifies that an opened pool can run queries
+	TestConnection(ctx context.Context, db *sql.DB) error
+	// IntrospectSchema lists the tables and columns visible to the connection
+	IntrospectSchema(ctx context.Context, db *sql.DB) ([]TableSchema, error)
+	// AnalyzeIndexes lists the existing indexes
+	AnalyzeIndexes(ctx context.Context, db *sql.DB) ([]*IndexInfo, error)
+	// DropIndexSQL returns the statement that drops an index
+	DropIndexSQL(table, index string) string
+	// Explain returns the execution plan of a query
+	Explain(ctx context.Context, db *sql.DB, query string) ([]map[string]interface{}, error)
+}
+
+// ProfilingDialect is implemented by drivers that support column profiling
+type ProfilingDialect interface {
+	// ProfileValueExpr wraps a column so it can be used in MIN/MAX/COUNT DISTINCT and GROUP BY
+	ProfileValueExpr(col, kind string) string
+	// NumericBucketExpr maps a numeric column to a 0-based histogram bucket index
+	NumericBucketExpr(col string, min, max, width float64, buckets int) string
+	// DateBucketExpr formats a date column as a day, month or year label
+	DateBucketExpr(col, granularity string) string
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+func init() {
+	RegisterDriver(&MySQLDriver{})
+	RegisterDriver(&PostgresDriver{})
+	RegisterDriver(&SQLiteDriver{})
+}
+
+// RegisterDriver makes a driver available by its name. Registering the same name
+// again replaces the earlier driver.
+func RegisterDriver(d Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[d.Name()] = d
+}
+
+// GetDriver returns the driver registered for a data source type
+func GetDriver(name string) (Driver, error) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	d, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported data source type: %s", name)
+	}
+	return d, nil
+}
+
+// RegisteredDrivers returns the names of all registered drivers
+func RegisteredDrivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExplainQuery returns the execution plan of a query on the data source primary
+func ExplainQuery(ctx context.Context, ds *models.DataSource, query string) ([]map[string]interface{}, error) {
+	driver, err := GetDriver(ds.Type)
+	if err != nil {
+		return nil, err
+	}
+	db, err := GetConnection(ds)
+	if err != nil {
+		return nil, err
+	}
+	return driver.Explain(ctx, db, query)
+}
+
+// appendLimit appends a LIMIT clause, the form shared by MySQL, PostgreSQL and SQLite
+func appendLimit(query string, limit int) string {
+	return fmt.Sprintf("%s LIMIT %d", strings.TrimRight(strings.TrimSpace(query), ";"), limit)
+}
+
+// validateNetworkDataSource checks the fields required by server based databases
+func validateNetworkDataSource(ds *models.DataSource) error {
+	if ds.Host == "" {
+		return errors.ErrDataSourceHostRequired
+	}
+	if ds.Port == 0 {
+		return errors.ErrDataSourcePortRequired
+	}
+	if ds.Port < 0 || ds.Port > 65535 {
+		return errors.NewError(errors.ErrCodeDataSourceInvalid, "DataSource port must be between 1 and 65535", nil)
+	}
+	if ds.Database == "" {
+		return errors.ErrDataSourceDatabaseRequired
+	}
+	return nil
+}
+
+// queryMaps runs a query and returns every row as a column name to value map
+func queryMaps(ctx context.Context, db *sql.DB, query string) ([]map[string]interface{}, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var results []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		scanArgs := make([]interface{}, len(cols))
+		for i := range values {
+			scanArgs[i] = &values[i]
+		}
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			row[col] = normalizeValue(values[i])
+		}
+		results = append(results, row)
+	}
+	return results, rows.Err()
+}
+
+// pingAndSelect pings the pool and runs a trivial query
+func pingAndSelect(ctx context.Context, db *sql.DB) error {
+	if err := db.PingContext(ctx); err != nil {
+		return err
+	}
+	var one int
+	return db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gobi/internal/models"
+	"strings"
+)
+
+// MySQLDriver implements Driver for MySQL and MariaDB
+type MySQLDriver struct{}
+
+// Name returns mysql
+func (d *MySQLDriver) Name() string { return "mysql" }
+
+// SQLDriverName returns the go-sql-driver/mysql driver name
+func (d *MySQLDriver) SQLDriverName() string { return "mysql" }
+
+// DSN builds a go-sql-driver/mysql DSN
+func (d *MySQLDriver) DSN(ds *models.DataSource, endpoint Endpoint) (string, error) {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", endpoint.Username, ds.Password, endpoint.Host, endpoint.Port, ds.Database), nil
+}
+
+// Validate requires host, port and database
+func (d *MySQLDriver) Validate(ds *models.DataSource) error {
+	return validateNetworkDataSource(ds)
+}
+
+// SupportsReplicas returns true
+func (d *MySQLDriver) SupportsReplicas() bool { return true }
+
+// TestConnection pings the server and runs SELECT 1
+func (d *MySQLDriver) TestConnection(ctx context.Context, db *sql.DB) error {
+	return pingAndSelect(ctx, db)
+}
+
+// QuoteIdentifier quotes with backticks
+func (d *MySQLDriver) QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// LimitQuery appends a LIMIT clause
+func (d *MySQLDriver) LimitQuery(query string, limit int) string {
+	return appendLimit(query, limit)
+}
+
+// Placeholder uses question marks
+func (d *MySQLDriver) Placeholder(n int) string {
+	return "?"
+}
+
+// IntrospectSchema reads INFORMATION_SCHEMA.COLUMNS of the current database
+func (d *MySQLDriver) IntrospectSchema(ctx context.Context, db *sql.DB) ([]TableSchema, error) {
+	return introspectInformationSchema(ctx, db, `
+		SELECT TABLE_NAME, COLUMN_NAME, DATA_TYPE, IS_NULLABLE
+		FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE()
+		ORDER BY TABLE_NAME, ORDINAL_POSITION`)
+}
+
+// AnalyzeIndexes reads INFORMATION_SCHEMA.STATISTICS of the current database
+func (d *MySQLDriver) AnalyzeIndexes(ctx context.Context, db *sql.DB) ([]*IndexInfo, error) {
+	query := `
+		SELECT
+			TABLE_NAME,
+			INDEX_NAME,
+			COLUMN_NAME,
+			INDEX_TYPE,
+			NON_UNIQUE = 0 as IS_UNIQUE,
+			CARDINALITY,
+			INDEX_LENGTH as SIZE
+		FROM INFORMATION_SCHEMA.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE()
+		ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX
+	`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []*IndexInfo
+	for rows.Next() {
+		var index IndexInfo
+		var isUnique int
+		err := rows.Scan(
+			&index.TableName,
+			&index.IndexName,
+			&index.ColumnName,
+			&index.IndexType,
+			&isUnique,
+			&index.Cardinality,
+			&index.Size,
+		)
+		if err != nil {
+			return nil, err
+		}
+		index.IsUnique = isUnique == 1
+		indexes = append(indexes, &index)
+	}
+
+	return indexes, rows.Err()
+}
+
+// DropIndexSQL returns DROP INDEX ... ON ...
+func (d *MySQLDriver) DropIndexSQL(table, index string) string {
+	return fmt.Sprintf("DROP INDEX %s ON %s", d.QuoteIdentifier(index), d.QuoteIdentifier(table))
+}
+
+// Explain runs EXPLAIN
+func (d *MySQLDriver) Explain(ctx context.Context, db *sql.DB, query string) ([]map[string]interface{}, error) {
+	return queryMaps(ctx, db, "EXPLAIN "+query)
+}
+
+// ProfileValueExpr casts types without a useful ordering to CHAR
+func (d *MySQLDriver) ProfileValueExpr(col, kind string) string {
+	if kind == "other" {
+		return "CAST(" + col + " AS CHAR)"
+	}
+	return col
+}
+
+// NumericBucketExpr uses FLOOR over the bucket width
+func (d *MySQLDriver) NumericBucketExpr(col string, min, max, width float64, buckets int) string {
+	return fmt.Sprintf("LEAST(FLOOR((%s - %s) / %s), %d)", col, formatFloat(min), formatFloat(width), buckets-1)
+}
+
+// DateBucketExpr uses DATE_FORMAT
+func (d *MySQLDriver) DateBucketExpr(col, granularity string) string {
+	formats := map[string]string{"day": "%Y-%m-%d", "month": "%Y-%m", "year": "%Y"}
+	return fmt.Sprintf("DATE_FORMAT(%s, '%s')", col, formats[granularity])
+}
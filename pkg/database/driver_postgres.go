@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gobi/internal/models"
+	"strconv"
+	"strings"
+)
+
+// PostgresDriver implements Driver for PostgreSQL
+type PostgresDriver struct{}
+
+// Name returns postgres
+func (d *PostgresDriver) Name() string { return "postgres" }
+
+// SQLDriverName returns the lib/pq driver name
+func (d *PostgresDriver) SQLDriverName() string { return "postgres" }
+
+// DSN builds a key/value PostgreSQL connection string
+func (d *PostgresDriver) DSN(ds *models.DataSource, endpoint Endpoint) (string, error) {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", endpoint.Host, endpoint.Port, endpoint.Username, ds.Password, ds.Database), nil
+}
+
+// Validate requires host, port and database
+func (d *PostgresDriver) Validate(ds *models.DataSource) error {
+	return validateNetworkDataSource(ds)
+}
+
+// SupportsReplicas returns true
+func (d *PostgresDriver) SupportsReplicas() bool { return true }
+
+// TestConnection pings the server and runs SELECT 1
+func (d *PostgresDriver) TestConnection(ctx context.Context, db *sql.DB) error {
+	return pingAndSelect(ctx, db)
+}
+
+// QuoteIdentifier quotes with double quotes
+func (d *PostgresDriver) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// LimitQuery appends a LIMIT clause
+func (d *PostgresDriver) LimitQuery(query string, limit int) string {
+	return appendLimit(query, limit)
+}
+
+// Placeholder uses numbered $n markers
+func (d *PostgresDriver) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// IntrospectSchema reads information_schema.columns of the current schema
+func (d *PostgresDriver) IntrospectSchema(ctx context.Context, db *sql.DB) ([]TableSchema, error) {
+	return introspectInformationSchema(ctx, db, `
+		SELECT table_name, column_name, data_type, is_nullable
+		FROM information_schema.columns
+		WHERE table_schema = current_schema()
+		ORDER BY table_name, ordinal_position`)
+}
+
+// AnalyzeIndexes reads pg_index for all user schemas
+func (d *PostgresDriver) AnalyzeIndexes(ctx context.Context, db *sql.DB) ([]*IndexInfo, error) {
+	query := `
+		SELECT
+			t.schemaname,
+			t.tablename as table_name,
+			i.indexname as index_name,
+			a.attname as column_name,
+			am.amname as index_type,
+			i.indisunique as is_unique,
+			pg_stat_get_live_tuples(c.oid) as cardinality,
+			pg_relation_size(i.indexrelid) as size
+		FROM pg_index i
+		JOIN pg_class c ON i.indrelid = c.oid
+		JOIN pg_class ic ON i.indexrelid = ic.oid
+		JOIN pg_namespace n ON c.relnamespace = n.oid
+		JOIN pg_tables t ON t.tablename = c.relname AND t.schemaname = n.nspname
+		JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum = ANY(i.indkey)
+		JOIN pg_am am ON ic.relam = am.oid
+		WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')
+		ORDER BY t.tablename, i.indexname
+	`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []*IndexInfo
+	for rows.Next() {
+		var index IndexInfo
+		var schemaName string
+		var isUnique bool
+		err := rows.Scan(
+			&schemaName,
+			&index.TableName,
+			&index.IndexName,
+			&index.ColumnName,
+			&index.IndexType,
+			&isUnique,
+			&index.Cardinality,
+			&index.Size,
+		)
+		if err != nil {
+			return nil, err
+		}
+		index.IsUnique = isUnique
+		indexes = append(indexes, &index)
+	}
+
+	return indexes, rows.Err()
+}
+
+// DropIndexSQL returns DROP INDEX; PostgreSQL index names are schema wide
+func (d *PostgresDriver) DropIndexSQL(table, index string) string {
+	return fmt.Sprintf("DROP INDEX %s", d.QuoteIdentifier(index))
+}
+
+// Explain runs EXPLAIN
+func (d *PostgresDriver) Explain(ctx context.Context, db *sql.DB, query string) ([]map[string]interface{}, error) {
+	return queryMaps(ctx, db, "EXPLAIN "+query)
+}
+
+// ProfileValueExpr casts json, bool, uuid and similar types to TEXT, as they have
+// no ordering or equality suitable for aggregates
+func (d *PostgresDriver) ProfileValueExpr(col, kind string) string {
+	if kind == "other" {
+		return "CAST(" + col + " AS TEXT)"
+	}
+	return col
+}
+
+// NumericBucketExpr uses width_bucket
+func (d *PostgresDriver) NumericBucketExpr(col string, min, max, width float64, buckets int) string {
+	return fmt.Sprintf("LEAST(width_bucket(CAST(%s AS DOUBLE PRECISION), %s, %s, %d), %d) - 1", col, formatFloat(min), formatFloat(max), buckets, buckets)
+}
+
+// DateBucketExpr uses to_char
+func (d *PostgresDriver) DateBucketExpr(col, granularity string) string {
+	formats := map[string]string{"day": "YYYY-MM-DD", "month": "YYYY-MM", "year": "YYYY"}
+	return fmt.Sprintf("to_char(%s, '%s')", col, formats[granularity])
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gobi/internal/models"
+	"strings"
+
+	"gobi/pkg/errors"
+)
+
+// SQLiteDriver implements Driver for SQLite database files
+type SQLiteDriver struct{}
+
+// Name returns sqlite
+func (d *SQLiteDriver) Name() string { return "sqlite" }
+
+// SQLDriverName returns the go-sqlite3 driver name
+func (d *SQLiteDriver) SQLDriverName() string { return "sqlite3" }
+
+// DSN returns the database file path; SQLite has no network endpoint
+func (d *SQLiteDriver) DSN(ds *models.DataSource, endpoint Endpoint) (string, error) {
+	return ds.Database, nil
+}
+
+// Validate requires the database file path
+func (d *SQLiteDriver) Validate(ds *models.DataSource) error {
+	if ds.Database == "" {
+		return errors.ErrDataSourceDatabaseRequired
+	}
+	return nil
+}
+
+// SupportsReplicas returns false
+func (d *SQLiteDriver) SupportsReplicas() bool { return false }
+
+// TestConnection opens the file and runs SELECT 1
+func (d *SQLiteDriver) TestConnection(ctx context.Context, db *sql.DB) error {
+	return pingAndSelect(ctx, db)
+}
+
+// QuoteIdentifier quotes with double quotes
+func (d *SQLiteDriver) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// LimitQuery appends a LIMIT clause
+func (d *SQLiteDriver) LimitQuery(query string, limit int) string {
+	return appendLimit(query, limit)
+}
+
+// Placeholder uses question marks
+func (d *SQLiteDriver) Placeholder(n int) string {
+	return "?"
+}
+
+// IntrospectSchema reads tables from sqlite_master and columns from PRAGMA table_info
+func (d *SQLiteDriver) IntrospectSchema(ctx context.Context, db *sql.DB) ([]TableSchema, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to introspect schema: %w", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	tables := make([]TableSchema, 0, len(names))
+	for _, name := range names {
+		colRows, err := db.QueryContext(ctx, "PRAGMA table_info("+d.QuoteIdentifier(name)+")")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read columns of %s: %w", name, err)
+		}
+		table := TableSchema{Name: name}
+		for colRows.Next() {
+			var cid, notNull, pk int
+			var colName, colType string
+			var defaultValue interface{}
+			if err := colRows.Scan(&cid, &colName, &colType, &notNull, &defaultValue, &pk); err != nil {
+				colRows.Close()
+				return nil, err
+			}
+			table.Columns = append(table.Columns, ColumnSchema{Name: colName, DataType: colType, Nullable: notNull == 0})
+		}
+		colRows.Close()
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// AnalyzeIndexes reads index definitions from sqlite_master
+func (d *SQLiteDriver) AnalyzeIndexes(ctx context.Context, db *sql.DB) ([]*IndexInfo, error) {
+	query := `
+		SELECT
+			tbl_name as table_name,
+			name as index_name,
+			sql as index_sql
+		FROM sqlite_master
+		WHERE type = 'index' AND tbl_name NOT LIKE 'sqlite_%'
+		ORDER BY tbl_name, name
+	`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []*IndexInfo
+	for rows.Next() {
+		var index IndexInfo
+		var indexSQL sql.NullString
+		err := rows.Scan(
+			&index.TableName,
+			&index.IndexName,
+			&indexSQL,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// Parse index SQL to extract column and type information
+		index.ColumnName = extractColumnFromSQLiteIndex(indexSQL.String)
+		index.IndexType = "BTREE" // SQLite default
+		index.IsUnique = strings.Contains(strings.ToUpper(indexSQL.String), "UNIQUE")
+
+		indexes = append(indexes, &index)
+	}
+
+	return indexes, rows.Err()
+}
+
+// DropIndexSQL returns DROP INDEX
+func (d *SQLiteDriver) DropIndexSQL(table, index string) string {
+	return fmt.Sprintf("DROP INDEX %s", d.QuoteIdentifier(index))
+}
+
+// Explain runs EXPLAIN QUERY PLAN
+func (d *SQLiteDriver) Explain(ctx context.Context, db *sql.DB, query string) ([]map[string]interface{}, error) {
+	return queryMaps(ctx, db, "EXPLAIN QUERY PLAN "+query)
+}
+
+// ProfileValueExpr returns the column unchanged; SQLite values are always comparable
+func (d *SQLiteDriver) ProfileValueExpr(col, kind string) string {
+	return col
+}
+
+// NumericBucketExpr truncates with CAST AS INTEGER, as SQLite lacks FLOOR
+func (d *SQLiteDriver) NumericBucketExpr(col string, min, max, width float64, buckets int) string {
+	return fmt.Sprintf("MIN(CAST((%s - %s) / %s AS INTEGER), %d)", col, formatFloat(min), formatFloat(width), buckets-1)
+}
+
+// DateBucketExpr uses strftime
+func (d *SQLiteDriver) DateBucketExpr(col, granularity string) string {
+	formats := map[string]string{"day": "%Y-%m-%d", "month": "%Y-%m", "year": "%Y"}
+	return fmt.Sprintf("strftime('%s', %s)", formats[granularity], col)
+}
+
+// extractColumnFromSQLiteIndex extracts column name from SQLite index SQL
+func extractColumnFromSQLiteIndex(indexSQL string) string {
+	// Simple extraction - look for column name in parentheses
+	start := strings.Index(indexSQL, "(")
+	end := strings.Index(indexSQL, ")")
+	if start != -1 && end != -1 && end > start {
+		return strings.TrimSpace(indexSQL[start+1 : end])
+	}
+	return ""
+}
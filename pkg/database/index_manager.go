@@ -1,7 +1,7 @@
 package database
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"strings"
 	"sync"
@@ -66,24 +66,17 @@ func NewIndexManager() *IndexManager {
 
 // AnalyzeIndexes analyzes existing indexes in the database
 func (im *IndexManager) AnalyzeIndexes(ds models.DataSource) ([]*IndexInfo, error) {
-	db, err := GetConnection(&ds)
+	driver, err := GetDriver(ds.Type)
 	if err != nil {
-		return nil, errors.WrapError(err, "could not get database connection")
+		return nil, err
 	}
 
-	var indexes []*IndexInfo
-
-	switch ds.Type {
-	case "mysql":
-		indexes, err = im.analyzeMySQLIndexes(db)
-	case "postgres":
-		indexes, err = im.analyzePostgresIndexes(db)
-	case "sqlite":
-		indexes, err = im.analyzeSQLiteIndexes(db)
-	default:
-		return nil, fmt.Errorf("unsupported database type: %s", ds.Type)
+	db, err := GetConnection(&ds)
+	if err != nil {
+		return nil, errors.WrapError(err, "could not get database connection")
 	}
 
+	indexes, err := driver.AnalyzeIndexes(context.Background(), db)
 	if err != nil {
 		return nil, err
 	}
@@ -103,6 +96,11 @@ func (im *IndexManager) AnalyzeIndexes(ds models.DataSource) ([]*IndexInfo, erro
 
 // SuggestIndexes suggests indexes based on query patterns
 func (im *IndexManager) SuggestIndexes(queryPatterns []string, ds models.DataSource) ([]*IndexSuggestion, error) {
+	driver, err := GetDriver(ds.Type)
+	if err != nil {
+		return nil, err
+	}
+
 	im.mu.Lock()
 	defer im.mu.Unlock()
 
@@ -135,18 +133,10 @@ func (im *IndexManager) SuggestIndexes(queryPatterns []string, ds models.DataSou
 					EstimatedBenefit: "High",
 				}
 
-				// Generate SQL based on database type
-				switch ds.Type {
-				case "mysql":
-					suggestion.SQL = fmt.Sprintf("CREATE INDEX idx_%s_%s ON %s(%s);",
-						table, column, table, column)
-				case "postgres":
-					suggestion.SQL = fmt.Sprintf("CREATE INDEX idx_%s_%s ON %s(%s);",
-						table, column, table, column)
-				case "sqlite":
-					suggestion.SQL = fmt.Sprintf("CREATE INDEX idx_%s_%s ON %s(%s);",
-						table, column, table, column)
-				}
+				// Generate SQL in the dialect of the data source
+				suggestion.SQL = fmt.Sprintf("CREATE INDEX %s ON %s(%s);",
+					driver.QuoteIdentifier(fmt.Sprintf("idx_%s_%s", table, column)),
+					driver.QuoteIdentifier(table), driver.QuoteIdentifier(column))
 
 				suggestions = append(suggestions, suggestion)
 			}
@@ -180,17 +170,17 @@ func (im *IndexManager) CreateIndex(suggestion *IndexSuggestion, ds models.DataS
 
 // DropIndex drops an index
 func (im *IndexManager) DropIndex(tableName, indexName string, ds models.DataSource) error {
-	db, err := GetConnection(&ds)
+	driver, err := GetDriver(ds.Type)
 	if err != nil {
-		return errors.WrapError(err, "could not get database connection")
+		return err
 	}
 
-	dropSQL := fmt.Sprintf("DROP INDEX %s ON %s", indexName, tableName)
-	if ds.Type == "postgres" {
-		dropSQL = fmt.Sprintf("DROP INDEX %s", indexName)
+	db, err := GetConnection(&ds)
+	if err != nil {
+		return errors.WrapError(err, "could not get database connection")
 	}
 
-	_, err = db.Exec(dropSQL)
+	_, err = db.Exec(driver.DropIndexSQL(tableName, indexName))
 	if err != nil {
 		return errors.WrapError(err, "failed to drop index")
 	}
@@ -226,158 +216,6 @@ func (im *IndexManager) GetUnusedIndexes(threshold time.Duration) []*IndexInfo {
 	return unusedIndexes
 }
 
-// analyzeMySQLIndexes analyzes MySQL indexes
-func (im *IndexManager) analyzeMySQLIndexes(db *sql.DB) ([]*IndexInfo, error) {
-	query := `
-		SELECT 
-			TABLE_NAME,
-			INDEX_NAME,
-			COLUMN_NAME,
-			INDEX_TYPE,
-			NON_UNIQUE = 0 as IS_UNIQUE,
-			CARDINALITY,
-			INDEX_LENGTH as SIZE
-		FROM INFORMATION_SCHEMA.STATISTICS 
-		WHERE TABLE_SCHEMA = DATABASE()
-		ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX
-	`
-
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var indexes []*IndexInfo
-	for rows.Next() {
-		var index IndexInfo
-		var isUnique int
-		err := rows.Scan(
-			&index.TableName,
-			&index.IndexName,
-			&index.ColumnName,
-			&index.IndexType,
-			&isUnique,
-			&index.Cardinality,
-			&index.Size,
-		)
-		if err != nil {
-			return nil, err
-		}
-		index.IsUnique = isUnique == 1
-		indexes = append(indexes, &index)
-	}
-
-	return indexes, nil
-}
-
-// analyzePostgresIndexes analyzes PostgreSQL indexes
-func (im *IndexManager) analyzePostgresIndexes(db *sql.DB) ([]*IndexInfo, error) {
-	query := `
-		SELECT 
-			t.schemaname,
-			t.tablename as table_name,
-			i.indexname as index_name,
-			a.attname as column_name,
-			am.amname as index_type,
-			i.indisunique as is_unique,
-			pg_stat_get_live_tuples(c.oid) as cardinality,
-			pg_relation_size(i.indexrelid) as size
-		FROM pg_index i
-		JOIN pg_class c ON i.indrelid = c.oid
-		JOIN pg_class ic ON i.indexrelid = ic.oid
-		JOIN pg_namespace n ON c.relnamespace = n.oid
-		JOIN pg_tables t ON t.tablename = c.relname AND t.schemaname = n.nspname
-		JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum = ANY(i.indkey)
-		JOIN pg_am am ON ic.relam = am.oid
-		WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')
-		ORDER BY t.tablename, i.indexname
-	`
-
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var indexes []*IndexInfo
-	for rows.Next() {
-		var index IndexInfo
-		var schemaName string
-		var isUnique bool
-		err := rows.Scan(
-			&schemaName,
-			&index.TableName,
-			&index.IndexName,
-			&index.ColumnName,
-			&index.IndexType,
-			&isUnique,
-			&index.Cardinality,
-			&index.Size,
-		)
-		if err != nil {
-			return nil, err
-		}
-		index.IsUnique = isUnique
-		indexes = append(indexes, &index)
-	}
-
-	return indexes, nil
-}
-
-// analyzeSQLiteIndexes analyzes SQLite indexes
-func (im *IndexManager) analyzeSQLiteIndexes(db *sql.DB) ([]*IndexInfo, error) {
-	query := `
-		SELECT 
-			tbl_name as table_name,
-			name as index_name,
-			sql as index_sql
-		FROM sqlite_master 
-		WHERE type = 'index' AND tbl_name NOT LIKE 'sqlite_%'
-		ORDER BY tbl_name, name
-	`
-
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var indexes []*IndexInfo
-	for rows.Next() {
-		var index IndexInfo
-		var indexSQL string
-		err := rows.Scan(
-			&index.TableName,
-			&index.IndexName,
-			&indexSQL,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		// Parse index SQL to extract column and type information
-		index.ColumnName = im.extractColumnFromSQLiteIndex(indexSQL)
-		index.IndexType = "BTREE" // SQLite default
-		index.IsUnique = strings.Contains(strings.ToUpper(indexSQL), "UNIQUE")
-
-		indexes = append(indexes, &index)
-	}
-
-	return indexes, nil
-}
-
-// extractColumnFromSQLiteIndex extracts column name from SQLite index SQL
-func (im *IndexManager) extractColumnFromSQLiteIndex(indexSQL string) string {
-	// Simple extraction - look for column name in parentheses
-	start := strings.Index(indexSQL, "(")
-	end := strings.Index(indexSQL, ")")
-	if start != -1 && end != -1 && end > start {
-		return strings.TrimSpace(indexSQL[start+1 : end])
-	}
-	return ""
-}
-
 // extractTableColumnUsage extracts table and column usage from SQL
 func (im *IndexManager) extractTableColumnUsage(sql string) ([]string, []string) {
 	upperSQL := strings.ToUpper(sql)
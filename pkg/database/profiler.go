@@ -0,0 +1,329 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gobi/internal/models"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	profileTopValues        = 10
+	profileHistogramBuckets = 10
+)
+
+// ColumnSchema describes a column returned by schema introspection
+type ColumnSchema struct {
+	Name     string `json:"name"`
+	DataType string `json:"data_type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// TableSchema describes a table returned by schema introspection
+type TableSchema struct {
+	Name    string         `json:"name"`
+	Columns []ColumnSchema `json:"columns"`
+}
+
+// profilingDriver is a driver that can generate the profiling SQL fragments
+type profilingDriver interface {
+	Dialect
+	ProfilingDialect
+}
+
+// IntrospectSchema lists the tables and columns of a data source
+func IntrospectSchema(ctx context.Context, ds *models.DataSource) ([]TableSchema, error) {
+	driver, err := GetDriver(ds.Type)
+	if err != nil {
+		return nil, err
+	}
+	db, _, err := GetReadConnection(ds)
+	if err != nil {
+		return nil, err
+	}
+	return driver.IntrospectSchema(ctx, db)
+}
+
+// ProfileTable computes a profile for every column of a table. sampleSize limits
+// the number of rows scanned; 0 profiles the whole table.
+func ProfileTable(ctx context.Context, ds *models.DataSource, table string, sampleSize int) ([]models.ColumnProfile, error) {
+	driver, err := GetDriver(ds.Type)
+	if err != nil {
+		return nil, err
+	}
+	dialect, ok := driver.(profilingDriver)
+	if !ok {
+		return nil, fmt.Errorf("profiling is not supported for data source type: %s", ds.Type)
+	}
+
+	// Only tables known to the schema can be profiled, so identifiers never come from raw user input
+	tables, err := IntrospectSchema(ctx, ds)
+	if err != nil {
+		return nil, err
+	}
+	var schema *TableSchema
+	for i := range tables {
+		if tables[i].Name == table {
+			schema = &tables[i]
+			break
+		}
+	}
+	if schema == nil {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+
+	db, _, err := GetReadConnection(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	source := dialect.QuoteIdentifier(schema.Name)
+	if sampleSize > 0 {
+		source = fmt.Sprintf("(%s) AS profile_sample", dialect.LimitQuery("SELECT * FROM "+source, sampleSize))
+	}
+
+	var rowCount int64
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+source).Scan(&rowCount); err != nil {
+		return nil, fmt.Errorf("failed to count rows: %w", err)
+	}
+
+	profiles := make([]models.ColumnProfile, 0, len(schema.Columns))
+	for _, column := range schema.Columns {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		profile := models.ColumnProfile{
+			Column:   column.Name,
+			DataType: column.DataType,
+			Kind:     columnKind(column.DataType),
+			RowCount: rowCount,
+		}
+		if err := profileColumn(ctx, db, dialect, source, &profile); err != nil {
+			profile.Error = err.Error()
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// profileColumn fills in the statistics of one column
+func profileColumn(ctx context.Context, db *sql.DB, dialect profilingDriver, source string, profile *models.ColumnProfile) error {
+	col := dialect.QuoteIdentifier(profile.Column)
+	value := dialect.ProfileValueExpr(col, profile.Kind)
+
+	var nonNull, distinct int64
+	var min, max interface{}
+	query := fmt.Sprintf("SELECT COUNT(%s), COUNT(DISTINCT %s), MIN(%s), MAX(%s) FROM %s", col, value, value, value, source)
+	if err := db.QueryRowContext(ctx, query).Scan(&nonNull, &distinct, &min, &max); err != nil {
+		return fmt.Errorf("aggregate query failed: %w", err)
+	}
+	profile.NullCount = profile.RowCount - nonNull
+	if profile.RowCount > 0 {
+		profile.NullRate = float64(profile.NullCount) / float64(profile.RowCount)
+	}
+	profile.DistinctCount = distinct
+	profile.Min = normalizeValue(min)
+	profile.Max = normalizeValue(max)
+
+	query = fmt.Sprintf("SELECT %s, COUNT(*) FROM %s WHERE %s IS NOT NULL GROUP BY 1 ORDER BY 2 DESC LIMIT %d", value, source, col, profileTopValues)
+	topValues, err := queryValueCounts(ctx, db, query)
+	if err != nil {
+		return fmt.Errorf("top values query failed: %w", err)
+	}
+	profile.TopValues = make([]models.ValueCount, 0, len(topValues))
+	for _, vc := range topValues {
+		profile.TopValues = append(profile.TopValues, models.ValueCount{Value: vc.Value, Count: vc.Count})
+	}
+
+	if nonNull == 0 {
+		return nil
+	}
+	switch profile.Kind {
+	case "numeric":
+		return numericHistogram(ctx, db, dialect, source, col, nonNull, profile)
+	case "temporal":
+		return dateHistogram(ctx, db, dialect, source, col, profile)
+	}
+	return nil
+}
+
+// numericHistogram builds equal-width buckets between the column's min and max
+func numericHistogram(ctx context.Context, db *sql.DB, dialect profilingDriver, source, col string, nonNull int64, profile *models.ColumnProfile) error {
+	min, okMin := toFloat(profile.Min)
+	max, okMax := toFloat(profile.Max)
+	if !okMin || !okMax {
+		return nil
+	}
+	if min == max {
+		profile.Histogram = []models.HistogramBucket{{Label: formatFloat(min), Lower: min, Upper: max, Count: nonNull}}
+		return nil
+	}
+
+	buckets := profileHistogramBuckets
+	width := (max - min) / float64(buckets)
+	query := fmt.Sprintf("SELECT b, COUNT(*) FROM (SELECT %s AS b FROM %s WHERE %s IS NOT NULL) h GROUP BY b ORDER BY b",
+		dialect.NumericBucketExpr(col, min, max, width, buckets), source, col)
+	counts, err := queryValueCounts(ctx, db, query)
+	if err != nil {
+		return fmt.Errorf("histogram query failed: %w", err)
+	}
+
+	histogram := make([]models.HistogramBucket, buckets)
+	for i := range histogram {
+		lower := min + float64(i)*width
+		upper := lower + width
+		if i == buckets-1 {
+			upper = max
+		}
+		histogram[i] = models.HistogramBucket{
+			Label: fmt.Sprintf("[%s, %s)", formatFloat(lower), formatFloat(upper)),
+			Lower: lower,
+			Upper: upper,
+		}
+	}
+	histogram[buckets-1].Label = fmt.Sprintf("[%s, %s]", formatFloat(histogram[buckets-1].Lower.(float64)), formatFloat(max))
+	for _, vc := range counts {
+		if idx, ok := toFloat(vc.Value); ok && int(idx) >= 0 && int(idx) < buckets {
+			histogram[int(idx)].Count += vc.Count
+		}
+	}
+	profile.Histogram = histogram
+	return nil
+}
+
+// dateHistogram groups a date column by day, month or year depending on its range
+func dateHistogram(ctx context.Context, db *sql.DB, dialect profilingDriver, source, col string, profile *models.ColumnProfile) error {
+	granularity := "month"
+	if min, okMin := toTime(profile.Min); okMin {
+		if max, okMax := toTime(profile.Max); okMax {
+			span := max.Sub(min)
+			switch {
+			case span <= 62*24*time.Hour:
+				granularity = "day"
+			case span > 5*365*24*time.Hour:
+				granularity = "year"
+			}
+		}
+	}
+
+	query := fmt.Sprintf("SELECT b, COUNT(*) FROM (SELECT %s AS b FROM %s WHERE %s IS NOT NULL) h GROUP BY b ORDER BY b",
+		dialect.DateBucketExpr(col, granularity), source, col)
+	counts, err := queryValueCounts(ctx, db, query)
+	if err != nil {
+		return fmt.Errorf("histogram query failed: %w", err)
+	}
+	profile.Histogram = make([]models.HistogramBucket, 0, len(counts))
+	for _, vc := range counts {
+		profile.Histogram = append(profile.Histogram, models.HistogramBucket{Label: fmt.Sprintf("%v", vc.Value), Count: vc.Count})
+	}
+	return nil
+}
+
+// queryValueCounts runs a two-column value/count query
+func queryValueCounts(ctx context.Context, db *sql.DB, query string) ([]models.ValueCount, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.ValueCount
+	for rows.Next() {
+		var value interface{}
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, err
+		}
+		results = append(results, models.ValueCount{Value: normalizeValue(value), Count: count})
+	}
+	return results, rows.Err()
+}
+
+// introspectInformationSchema reads table/column/type/nullable rows from an information_schema query
+func introspectInformationSchema(ctx context.Context, db *sql.DB, query string) ([]TableSchema, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to introspect schema: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []TableSchema
+	for rows.Next() {
+		var table, column, dataType, nullable string
+		if err := rows.Scan(&table, &column, &dataType, &nullable); err != nil {
+			return nil, err
+		}
+		if len(tables) == 0 || tables[len(tables)-1].Name != table {
+			tables = append(tables, TableSchema{Name: table})
+		}
+		last := &tables[len(tables)-1]
+		last.Columns = append(last.Columns, ColumnSchema{Name: column, DataType: dataType, Nullable: strings.EqualFold(nullable, "YES")})
+	}
+	return tables, rows.Err()
+}
+
+// columnKind classifies a column data type for profiling
+func columnKind(dataType string) string {
+	t := strings.ToLower(dataType)
+	switch {
+	case strings.Contains(t, "interval"), strings.Contains(t, "point"):
+		return "other"
+	case strings.Contains(t, "date"), strings.Contains(t, "time"):
+		return "temporal"
+	case strings.Contains(t, "int"), strings.Contains(t, "dec"), strings.Contains(t, "numeric"),
+		strings.Contains(t, "float"), strings.Contains(t, "double"), strings.Contains(t, "real"),
+		strings.Contains(t, "serial"):
+		return "numeric"
+	case strings.Contains(t, "char"), strings.Contains(t, "text"), strings.Contains(t, "clob"), t == "enum", t == "set":
+		return "text"
+	default:
+		return "other"
+	}
+}
+
+// normalizeValue converts driver values into JSON friendly values
+func normalizeValue(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, !math.IsNaN(n) && !math.IsInf(n, 0)
+	case float32:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+			if parsed, err := time.Parse(layout, t); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -23,6 +23,7 @@ type QueryPlan struct {
 	Complexity    string                 `json:"complexity"`
 	Suggestions   []string               `json:"suggestions"`
 	Metrics       map[string]interface{} `json:"metrics"`
+	Endpoint      string                 `json:"endpoint,omitempty"`
 }
 
 // QueryOptimizer provides database query optimization features
@@ -79,8 +80,9 @@ func (qo *QueryOptimizer) AnalyzeQuery(sql string, ds models.DataSource) (*Query
 	return plan, nil
 }
 
-// ExecuteWithOptimization executes a query with optimization analysis
-func (qo *QueryOptimizer) ExecuteWithOptimization(ctx context.Context, ds models.DataSource, sql string) ([]map[string]interface{}, *QueryPlan, error) {
+// ExecuteWithOptimization executes a query with optimization analysis. args are bound to
+// the query's placeholders.
+func (qo *QueryOptimizer) ExecuteWithOptimization(ctx context.Context, ds models.DataSource, sql string, args ...interface{}) ([]map[string]interface{}, *QueryPlan, error) {
 	// Analyze query first
 	plan, err := qo.AnalyzeQuery(sql, ds)
 	if err != nil {
@@ -89,7 +91,8 @@ func (qo *QueryOptimizer) ExecuteWithOptimization(ctx context.Context, ds models
 
 	// Execute query with timing
 	startTime := time.Now()
-	results, err := qo.executeQuery(ctx, ds, sql)
+	results, endpoint, err := qo.executeQuery(ctx, ds, sql, args...)
+	plan.Endpoint = endpoint
 	plan.ExecutionTime = time.Since(startTime)
 	plan.RowCount = int64(len(results))
 
@@ -311,22 +314,17 @@ func (qo *QueryOptimizer) generateSuggestions(sql string, plan *QueryPlan) []str
 	return suggestions
 }
 
-// executeQuery executes the actual query
-func (qo *QueryOptimizer) executeQuery(ctx context.Context, ds models.DataSource, sql string) ([]map[string]interface{}, error) {
-	db, err := GetConnection(&ds)
+// executeQuery executes the actual query and returns the endpoint that served it
+func (qo *QueryOptimizer) executeQuery(ctx context.Context, ds models.DataSource, sql string, args ...interface{}) ([]map[string]interface{}, string, error) {
+	rows, endpoint, err := QueryWithFailover(ctx, &ds, sql, args...)
 	if err != nil {
-		return nil, errors.WrapError(err, "could not get database connection")
-	}
-
-	rows, err := db.QueryContext(ctx, sql)
-	if err != nil {
-		return nil, errors.WrapError(err, "query execution failed")
+		return nil, endpoint, errors.WrapError(err, "query execution failed")
 	}
 	defer rows.Close()
 
 	cols, err := rows.Columns()
 	if err != nil {
-		return nil, errors.WrapError(err, "failed to get column information")
+		return nil, endpoint, errors.WrapError(err, "failed to get column information")
 	}
 
 	results := []map[string]interface{}{}
@@ -338,7 +336,7 @@ func (qo *QueryOptimizer) executeQuery(ctx context.Context, ds models.DataSource
 		}
 
 		if err := rows.Scan(scanArgs...); err != nil {
-			return nil, errors.WrapError(err, "failed to scan row")
+			return nil, endpoint, errors.WrapError(err, "failed to scan row")
 		}
 
 		rowMap := make(map[string]interface{})
@@ -354,10 +352,10 @@ func (qo *QueryOptimizer) executeQuery(ctx context.Context, ds models.DataSource
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, errors.WrapError(err, "error during result iteration")
+		return nil, endpoint, errors.WrapError(err, "error during result iteration")
 	}
 
-	return results, nil
+	return results, endpoint, nil
 }
 
 // estimateMemoryUsage estimates memory usage of query results
@@ -421,7 +421,7 @@ func getHTTPStatus(code ErrorCode) int {
 	switch code {
 	case ErrCodeSuccess:
 		return http.StatusOK
-	case ErrCodeInvalidRequest, ErrCodeInvalidChartType, ErrCodeInvalidChartConfig, ErrCodeInvalidChartData, ErrCodeInvalidSQL:
+	case ErrCodeInvalidRequest, ErrCodeInvalidChartType, ErrCodeInvalidChartConfig, ErrCodeInvalidChartData, ErrCodeInvalidSQL, ErrCodeDataSourceInvalid:
 		return http.StatusBadRequest
 	case ErrCodeUnauthorized, ErrCodeInvalidToken, ErrCodeTokenExpired, ErrCodeTokenNotValidYet, ErrCodeTokenMissingClaims, ErrCodeInvalidCredentials, ErrCodeInvalidAPIKey, ErrCodeAPIKeyExpired:
 		return http.StatusUnauthorized
@@ -0,0 +1,643 @@
+// Package exceltemplate fills Excel templates with data without touching their layout.
+//
+// Templates carry three kinds of bindings:
+//
+//   - placeholders in cells: {{query.sales.total}} is the total column of the first row
+//     of the sales result, {{param.region}} a report parameter and {{report.name}} a
+//     field of the report. A cell holding only a placeholder gets the typed value;
+//     placeholders within text are replaced by the formatted value.
+//   - table anchors: {{#table sales}} marks the row the sales result is written to, one
+//     row per result row, each styled like the anchor row. {{column}} placeholders in the
+//     anchor row place columns; without them the result columns are written in order from
+//     the anchor cell.
+//   - defined names: a name query.sales binds the sales result to the range it refers
+//     to. A one row range grows with the result like a table; a larger range is filled up
+//     to its size.
+package exceltemplate
+
+import (
+	"fmt"
+	"gobi/internal/models"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Kinds of bindings
+const (
+	KindValue = "value"
+	KindTable = "table"
+	KindRange = "range"
+)
+
+// Sources of the values of placeholders
+const (
+	SourceQuery  = "query"
+	SourceParam  = "param"
+	SourceReport = "report"
+)
+
+// ReportFields are the fields {{report.<field>}} placeholders can show
+var ReportFields = []string{"name", "type", "generated_at", "generated_for"}
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+	// bindingNamePattern matches the names of query results, which may be in any script
+	bindingNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_]+$`)
+	tableAnchorPattern = regexp.MustCompile(`^#table\s+(\S+)$`)
+	// cellRefPattern matches the cell references of a formula with what precedes them
+	cellRefPattern = regexp.MustCompile(`(^|[^A-Za-z0-9_.$])(\$?[A-Z]{1,3})(\$?)([0-9]+)\b`)
+	// rangeEndPattern matches the end of the ranges of a formula
+	rangeEndPattern = regexp.MustCompile(`(:\$?[A-Z]{1,3}\$?)([0-9]+)\b`)
+)
+
+// BindingName returns the name a query result is bound by: its name in lower case, with
+// runs of characters other than letters and digits replaced by an underscore. Names in
+// templates are matched regardless of case.
+func BindingName(name string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+// Table is a result bound to a template, its rows holding typed cell values
+type Table struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// Data is what a template is filled with
+type Data struct {
+	Tables map[string]*Table      // results by binding name
+	Values map[string]interface{} // "param.<name>" and "report.<field>" values
+}
+
+// placeholder is a placeholder found in a cell
+type placeholder struct {
+	text    string // as written, braces included
+	binding models.TemplateBinding
+	column  bool // a {{column}} of a table anchor row
+}
+
+// anchor is a table anchor or a one row defined name that grows with its result
+type anchor struct {
+	name    string
+	row     int
+	col     int
+	width   int         // columns of a defined name; 0 for table anchors
+	columns map[int]int // column of the sheet to column name index of the anchor row
+	names   []string    // column names of the anchor row
+	defined *excelize.DefinedName
+	height  int // rows of a defined name
+}
+
+// Scan lists the bindings of a template. Malformed placeholders are errors.
+func Scan(f *excelize.File) ([]models.TemplateBinding, error) {
+	var bindings []models.TemplateBinding
+	for _, sheet := range f.GetSheetList() {
+		cells, err := scanSheet(f, sheet)
+		if err != nil {
+			return nil, err
+		}
+		for _, cell := range cells {
+			for _, p := range cell.placeholders {
+				if !p.column {
+					bindings = append(bindings, p.binding)
+				}
+			}
+		}
+	}
+	ranges, err := definedRanges(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range ranges {
+		bindings = append(bindings, r.binding)
+	}
+	return bindings, nil
+}
+
+// scannedCell is a cell holding placeholders
+type scannedCell struct {
+	sheet        string
+	name         string
+	col, row     int
+	value        string
+	placeholders []placeholder
+}
+
+// scanSheet finds the placeholders of a sheet. The columns of a table anchor are listed
+// on its binding.
+func scanSheet(f *excelize.File, sheet string) ([]*scannedCell, error) {
+	rows, err := f.GetRows(sheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, err
+	}
+	var cells []*scannedCell
+	for r, row := range rows {
+		var anchor *placeholder
+		var columns []*placeholder
+		alone := true
+		for c, value := range row {
+			if !strings.Contains(value, "{{") {
+				continue
+			}
+			name, _ := excelize.CoordinatesToCellName(c+1, r+1)
+			cell := &scannedCell{sheet: sheet, name: name, col: c + 1, row: r + 1, value: value}
+			for _, match := range placeholderPattern.FindAllStringSubmatch(value, -1) {
+				p, err := parsePlaceholder(match[0], match[1])
+				if err != nil {
+					return nil, fmt.Errorf("%s!%s: %w", sheet, name, err)
+				}
+				p.binding.Sheet, p.binding.Cell = sheet, name
+				cell.placeholders = append(cell.placeholders, p)
+			}
+			for i := range cell.placeholders {
+				p := &cell.placeholders[i]
+				switch {
+				case p.binding.Kind == KindTable && anchor != nil:
+					return nil, fmt.Errorf("%s!%s: a row holds one table anchor", sheet, name)
+				case p.binding.Kind == KindTable:
+					if len(cell.placeholders) > 1 || strings.TrimSpace(value) != p.text {
+						return nil, fmt.Errorf("%s!%s: a table anchor must be alone in its cell", sheet, name)
+					}
+					anchor = p
+				case p.column:
+					alone = alone && len(cell.placeholders) == 1 && strings.TrimSpace(value) == p.text
+					columns = append(columns, p)
+				}
+			}
+			cells = append(cells, cell)
+		}
+		if len(columns) > 0 && anchor == nil {
+			return nil, fmt.Errorf("%s!%s: unknown placeholder %s outside a table row", sheet, columns[0].binding.Cell, columns[0].text)
+		}
+		if !alone {
+			return nil, fmt.Errorf("%s: a table column placeholder must be alone in its cell", sheet)
+		}
+		if anchor != nil {
+			for _, p := range columns {
+				anchor.binding.Columns = append(anchor.binding.Columns, p.binding.Column)
+			}
+		}
+	}
+	return cells, nil
+}
+
+// parsePlaceholder parses the content of a placeholder
+func parsePlaceholder(text, content string) (placeholder, error) {
+	p := placeholder{text: text, binding: models.TemplateBinding{Kind: KindValue}}
+	if strings.HasPrefix(content, "#") {
+		match := tableAnchorPattern.FindStringSubmatch(content)
+		if match == nil || !bindingNamePattern.MatchString(match[1]) {
+			return p, fmt.Errorf("table anchor %s must be {{#table <name>}}", text)
+		}
+		p.binding.Kind, p.binding.Source, p.binding.Name = KindTable, SourceQuery, strings.ToLower(match[1])
+		return p, nil
+	}
+	source, rest, found := strings.Cut(content, ".")
+	switch {
+	case found && source == SourceQuery:
+		name, column, ok := strings.Cut(rest, ".")
+		if !ok || !bindingNamePattern.MatchString(name) || column == "" {
+			return p, fmt.Errorf("placeholder %s must be {{query.<name>.<column>}}", text)
+		}
+		p.binding.Source, p.binding.Name, p.binding.Column = SourceQuery, strings.ToLower(name), column
+	case found && source == SourceParam:
+		if rest == "" {
+			return p, fmt.Errorf("placeholder %s must be {{param.<name>}}", text)
+		}
+		p.binding.Source, p.binding.Name = SourceParam, rest
+	case found && source == SourceReport:
+		if !contains(ReportFields, rest) {
+			return p, fmt.Errorf("placeholder %s must be one of {{report.%s}}", text, strings.Join(ReportFields, "}}, {{report."))
+		}
+		p.binding.Source, p.binding.Name = SourceReport, rest
+	case content == "":
+		return p, fmt.Errorf("empty placeholder")
+	default:
+		// A column of the table of the row
+		p.column = true
+		p.binding.Source, p.binding.Column = SourceQuery, content
+	}
+	return p, nil
+}
+
+// definedRange is a defined name bound to a result
+type definedRange struct {
+	binding models.TemplateBinding
+	defined excelize.DefinedName
+	sheet   string
+	x1, y1  int
+	x2, y2  int
+}
+
+// definedRanges returns the defined names named query.<name>
+func definedRanges(f *excelize.File) ([]definedRange, error) {
+	var ranges []definedRange
+	for _, defined := range f.GetDefinedName() {
+		source, name, found := strings.Cut(defined.Name, ".")
+		if !found || source != SourceQuery {
+			continue
+		}
+		if !bindingNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("defined name %s must be query.<name>", defined.Name)
+		}
+		sheet, ref, ok := strings.Cut(strings.TrimPrefix(defined.RefersTo, "="), "!")
+		if !ok {
+			return nil, fmt.Errorf("defined name %s must refer to a range of cells", defined.Name)
+		}
+		sheet = strings.ReplaceAll(strings.Trim(sheet, "'"), "''", "'")
+		start, end, isRange := strings.Cut(strings.ReplaceAll(ref, "$", ""), ":")
+		if !isRange {
+			end = start
+		}
+		x1, y1, err1 := excelize.CellNameToCoordinates(start)
+		x2, y2, err2 := excelize.CellNameToCoordinates(end)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("defined name %s must refer to a range of cells", defined.Name)
+		}
+		if index, _ := f.GetSheetIndex(sheet); index < 0 {
+			return nil, fmt.Errorf("defined name %s refers to missing sheet %s", defined.Name, sheet)
+		}
+		cell, _ := excelize.CoordinatesToCellName(x1, y1)
+		if x2 != x1 || y2 != y1 {
+			last, _ := excelize.CoordinatesToCellName(x2, y2)
+			cell += ":" + last
+		}
+		ranges = append(ranges, definedRange{
+			binding: models.TemplateBinding{Kind: KindRange, Source: SourceQuery, Name: strings.ToLower(name), Sheet: sheet, Cell: cell},
+			defined: defined,
+			sheet:   sheet,
+			x1:      min(x1, x2), y1: min(y1, y2), x2: max(x1, x2), y2: max(y1, y2),
+		})
+	}
+	return ranges, nil
+}
+
+// Fill writes data to the bindings of a template. It returns the bindings without data,
+// such as results or parameters not in data; their placeholders are cleared.
+func Fill(f *excelize.File, data Data) ([]string, error) {
+	missing := map[string]bool{}
+	ranges, err := definedRanges(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, sheet := range f.GetSheetList() {
+		cells, err := scanSheet(f, sheet)
+		if err != nil {
+			return nil, err
+		}
+
+		// Values first, while the cells are where the scan found them
+		var anchors []*anchor
+		rowAnchors := map[int]*anchor{}
+		for _, cell := range cells {
+			for _, p := range cell.placeholders {
+				if p.binding.Kind == KindTable {
+					a := &anchor{name: p.binding.Name, row: cell.row, col: cell.col, columns: map[int]int{}}
+					anchors = append(anchors, a)
+					rowAnchors[cell.row] = a
+				}
+			}
+		}
+		for _, cell := range cells {
+			if a := rowAnchors[cell.row]; a != nil && len(cell.placeholders) == 1 {
+				if p := cell.placeholders[0]; p.column {
+					a.columns[cell.col] = len(a.names)
+					a.names = append(a.names, p.binding.Column)
+					continue
+				}
+				if cell.placeholders[0].binding.Kind == KindTable {
+					continue
+				}
+			}
+			if err := fillCell(f, cell, data, missing); err != nil {
+				return nil, err
+			}
+		}
+
+		for i := range ranges {
+			r := &ranges[i]
+			if r.sheet != sheet {
+				continue
+			}
+			a := &anchor{name: r.binding.Name, row: r.y1, col: r.x1, width: r.x2 - r.x1 + 1, height: r.y2 - r.y1 + 1, defined: &r.defined}
+			anchors = append(anchors, a)
+		}
+
+		// Tables from the bottom up, so rows inserted for one do not move those above
+		sort.SliceStable(anchors, func(i, j int) bool { return anchors[i].row > anchors[j].row })
+		for _, a := range anchors {
+			table, ok := data.Tables[a.name]
+			if !ok {
+				missing[SourceQuery+"."+a.name] = true
+				table = &Table{}
+			}
+			if err := fillTable(f, sheet, a, table, missing); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// fillCell replaces the placeholders of a cell. A cell that is a single placeholder gets
+// the value itself, so numbers and dates keep the cell's number format.
+func fillCell(f *excelize.File, cell *scannedCell, data Data, missing map[string]bool) error {
+	values := make([]interface{}, len(cell.placeholders))
+	for i, p := range cell.placeholders {
+		value, ok := lookup(p.binding, data)
+		if !ok {
+			key := p.binding.Source + "." + p.binding.Name
+			if p.binding.Source == SourceQuery {
+				key += "." + p.binding.Column
+			}
+			missing[key] = true
+		}
+		values[i] = value
+	}
+	if len(cell.placeholders) == 1 && strings.TrimSpace(cell.value) == cell.placeholders[0].text {
+		if values[0] == nil {
+			return f.SetCellStr(cell.sheet, cell.name, "")
+		}
+		return f.SetCellValue(cell.sheet, cell.name, values[0])
+	}
+	i := 0
+	text := placeholderPattern.ReplaceAllStringFunc(cell.value, func(string) string {
+		value := values[i]
+		i++
+		return formatValue(value)
+	})
+	return f.SetCellStr(cell.sheet, cell.name, text)
+}
+
+// lookup returns the value of a placeholder
+func lookup(binding models.TemplateBinding, data Data) (interface{}, bool) {
+	if binding.Source != SourceQuery {
+		value, ok := data.Values[binding.Source+"."+binding.Name]
+		return value, ok
+	}
+	table, ok := data.Tables[binding.Name]
+	if !ok {
+		return nil, false
+	}
+	for i, column := range table.Columns {
+		if column == binding.Column {
+			if len(table.Rows) == 0 {
+				return nil, true
+			}
+			return table.Rows[0][i], true
+		}
+	}
+	return nil, false
+}
+
+// Expand replaces the {{name}} placeholders of plain text, such as an email subject, with
+// values and returns the names without a value, which are left as they are
+func Expand(text string, values map[string]interface{}) (string, []string) {
+	var missing []string
+	expanded := placeholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+		name := placeholderPattern.FindStringSubmatch(match)[1]
+		value, ok := values[name]
+		if !ok {
+			if !contains(missing, name) {
+				missing = append(missing, name)
+			}
+			return match
+		}
+		return formatValue(value)
+	})
+	return expanded, missing
+}
+
+// formatValue formats a value written within text
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 && v.Nanosecond() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format("2006-01-02 15:04:05")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = formatValue(item)
+		}
+		return strings.Join(parts, ", ")
+	}
+	return fmt.Sprint(value)
+}
+
+// fillTable writes a result at an anchor. Rows are inserted below the anchor row for all
+// but the first result row and take the style, height and formulas of the anchor row.
+func fillTable(f *excelize.File, sheet string, a *anchor, table *Table, missing map[string]bool) error {
+	// The sheet column of every result column written
+	targets := map[int]int{}
+	switch {
+	case a.width > 0:
+		for i := range table.Columns {
+			if i < a.width {
+				targets[a.col+i] = i
+			}
+		}
+	case len(a.names) > 0:
+		f.SetCellStr(sheet, cellName(a.col, a.row), "")
+		for col, name := range a.columns {
+			index := -1
+			for i, column := range table.Columns {
+				if column == a.names[name] {
+					index = i
+				}
+			}
+			if index < 0 && len(table.Columns) > 0 {
+				missing[SourceQuery+"."+a.name+"."+a.names[name]] = true
+			}
+			targets[col] = index
+		}
+	default:
+		f.SetCellStr(sheet, cellName(a.col, a.row), "")
+		for i := range table.Columns {
+			targets[a.col+i] = i
+		}
+	}
+
+	rows := len(table.Rows)
+	if a.height > 1 {
+		rows = min(rows, a.height)
+	} else if rows > 1 {
+		if err := copyRow(f, sheet, a.row, rows-1); err != nil {
+			return err
+		}
+	}
+
+	for col, index := range targets {
+		if index < 0 || rows == 0 {
+			f.SetCellStr(sheet, cellName(col, a.row), "")
+			continue
+		}
+		for i := 0; i < rows; i++ {
+			if value := table.Rows[i][index]; value != nil {
+				if err := f.SetCellValue(sheet, cellName(col, a.row+i), value); err != nil {
+					return err
+				}
+			} else if i == 0 {
+				f.SetCellStr(sheet, cellName(col, a.row), "")
+			}
+		}
+	}
+
+	// A one row defined name covers the rows written
+	if a.defined != nil && a.height == 1 && rows > 1 {
+		defined := *a.defined
+		f.DeleteDefinedName(&excelize.DefinedName{Name: defined.Name, Scope: defined.Scope})
+		if defined.Scope == "Workbook" {
+			defined.Scope = ""
+		}
+		defined.RefersTo = fmt.Sprintf("'%s'!%s:%s", strings.ReplaceAll(sheet, "'", "''"),
+			absoluteCellName(a.col, a.row), absoluteCellName(a.col+a.width-1, a.row+rows-1))
+		if err := f.SetDefinedName(&defined); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyRow inserts n copies of the style, height and formulas of a row below it. Ranges in
+// the formulas of the sheet that end at the row are extended over the copies.
+func copyRow(f *excelize.File, sheet string, row, n int) error {
+	if err := f.InsertRows(sheet, row+1, n); err != nil {
+		return err
+	}
+	lastCol, lastRow, err := sheetBounds(f, sheet)
+	if err != nil {
+		return err
+	}
+	for col := 1; col <= lastCol; col++ {
+		style, err := f.GetCellStyle(sheet, cellName(col, row))
+		if err != nil {
+			return err
+		}
+		if style != 0 {
+			if err := f.SetCellStyle(sheet, cellName(col, row+1), cellName(col, row+n), style); err != nil {
+				return err
+			}
+		}
+		formula, _ := f.GetCellFormula(sheet, cellName(col, row))
+		if formula == "" {
+			continue
+		}
+		for i := 1; i <= n; i++ {
+			if err := f.SetCellFormula(sheet, cellName(col, row+i), shiftFormula(formula, row, i)); err != nil {
+				return err
+			}
+		}
+	}
+	if height, err := f.GetRowHeight(sheet, row); err == nil && height != 15 {
+		for i := 1; i <= n; i++ {
+			f.SetRowHeight(sheet, row+i, height)
+		}
+	}
+
+	for r := 1; r <= lastRow; r++ {
+		if r > row && r <= row+n {
+			continue
+		}
+		for col := 1; col <= lastCol; col++ {
+			formula, _ := f.GetCellFormula(sheet, cellName(col, r))
+			if formula == "" {
+				continue
+			}
+			if extended := extendRanges(formula, row, n); extended != formula {
+				if err := f.SetCellFormula(sheet, cellName(col, r), extended); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sheetBounds returns the last column and row of a sheet, from its dimension and its cells
+func sheetBounds(f *excelize.File, sheet string) (int, int, error) {
+	rows, err := f.GetRows(sheet)
+	if err != nil {
+		return 0, 0, err
+	}
+	lastCol, lastRow := 0, len(rows)
+	for _, row := range rows {
+		lastCol = max(lastCol, len(row))
+	}
+	if dimension, err := f.GetSheetDimension(sheet); err == nil {
+		_, last, _ := strings.Cut(dimension, ":")
+		if col, row, err := excelize.CellNameToCoordinates(strings.ReplaceAll(last, "$", "")); err == nil {
+			lastCol, lastRow = max(lastCol, col), max(lastRow, row)
+		}
+	}
+	return lastCol, lastRow, nil
+}
+
+// extendRanges moves the end of the ranges of a formula that end at row by n rows
+func extendRanges(formula string, row, n int) string {
+	return rangeEndPattern.ReplaceAllStringFunc(formula, func(ref string) string {
+		match := rangeEndPattern.FindStringSubmatch(ref)
+		if match[2] != strconv.Itoa(row) {
+			return ref
+		}
+		return match[1] + strconv.Itoa(row+n)
+	})
+}
+
+// shiftFormula moves the relative references of a formula to row by offset rows
+func shiftFormula(formula string, row, offset int) string {
+	return cellRefPattern.ReplaceAllStringFunc(formula, func(ref string) string {
+		match := cellRefPattern.FindStringSubmatch(ref)
+		if match[3] == "$" || match[4] != strconv.Itoa(row) {
+			return ref
+		}
+		return match[1] + match[2] + strconv.Itoa(row+offset)
+	})
+}
+
+func cellName(col, row int) string {
+	name, _ := excelize.CoordinatesToCellName(col, row)
+	return name
+}
+
+func absoluteCellName(col, row int) string {
+	name, _ := excelize.CoordinatesToCellName(col, row, true)
+	return name
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,277 @@
+// Package geo parses GeoJSON and TopoJSON boundary sets, simplifies them for lower zoom
+// levels and matches region keys of query results to their features.
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Input formats of a boundary set
+const (
+	FormatGeoJSON  = "geojson"
+	FormatTopoJSON = "topojson"
+)
+
+// Position is a coordinate: longitude, latitude and optional extra values
+type Position []float64
+
+// FeatureCollection is a GeoJSON feature collection
+type FeatureCollection struct {
+	Type     string     `json:"type"`
+	Features []*Feature `json:"features"`
+}
+
+// Feature is a GeoJSON feature
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         interface{}            `json:"id,omitempty"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   *Geometry              `json:"geometry"`
+}
+
+// Geometry is a GeoJSON geometry. Coordinates are kept encoded and decoded by type when
+// the geometry is simplified.
+type Geometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates,omitempty"`
+	Geometries  []*Geometry     `json:"geometries,omitempty"` // GeometryCollection
+}
+
+// geometryTypes lists the GeoJSON geometry types
+var geometryTypes = map[string]bool{
+	"Point": true, "MultiPoint": true, "LineString": true, "MultiLineString": true,
+	"Polygon": true, "MultiPolygon": true, "GeometryCollection": true,
+}
+
+// Parse decodes a GeoJSON or TopoJSON document into a feature collection. object names
+// the TopoJSON object to convert; empty converts every object. It returns the detected
+// input format.
+func Parse(data []byte, object string) (*FeatureCollection, string, error) {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, "", fmt.Errorf("not a JSON object: %w", err)
+	}
+
+	switch head.Type {
+	case "Topology":
+		fc, err := parseTopology(data, object)
+		return fc, FormatTopoJSON, err
+	case "FeatureCollection":
+		var fc FeatureCollection
+		if err := json.Unmarshal(data, &fc); err != nil {
+			return nil, "", fmt.Errorf("invalid feature collection: %w", err)
+		}
+		return &fc, FormatGeoJSON, fc.validate()
+	case "Feature":
+		var f Feature
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, "", fmt.Errorf("invalid feature: %w", err)
+		}
+		fc := &FeatureCollection{Type: "FeatureCollection", Features: []*Feature{&f}}
+		return fc, FormatGeoJSON, fc.validate()
+	default:
+		if geometryTypes[head.Type] {
+			return nil, "", fmt.Errorf("a bare %s has no properties to name regions by; wrap it in a Feature", head.Type)
+		}
+		return nil, "", fmt.Errorf("unsupported document type %q; expected FeatureCollection, Feature or Topology", head.Type)
+	}
+}
+
+// validate checks the feature and geometry types of a collection
+func (fc *FeatureCollection) validate() error {
+	if len(fc.Features) == 0 {
+		return fmt.Errorf("no features")
+	}
+	for i, f := range fc.Features {
+		if f == nil || f.Type != "Feature" {
+			return fmt.Errorf("features[%d] is not a Feature", i)
+		}
+		if err := f.Geometry.validate(); err != nil {
+			return fmt.Errorf("features[%d]: %w", i, err)
+		}
+		if f.Properties == nil {
+			f.Properties = map[string]interface{}{}
+		}
+	}
+	return nil
+}
+
+// validate checks a geometry type and decodes its coordinates once
+func (g *Geometry) validate() error {
+	if g == nil {
+		return nil
+	}
+	if !geometryTypes[g.Type] {
+		return fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+	if g.Type == "GeometryCollection" {
+		for _, child := range g.Geometries {
+			if err := child.validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	short := false
+	_, err := g.transform(func(line []Position, _ lineKind) []Position {
+		for _, p := range line {
+			short = short || len(p) < 2
+		}
+		return line
+	})
+	if err == nil && short {
+		err = fmt.Errorf("%s has a position with fewer than two coordinates", g.Type)
+	}
+	return err
+}
+
+// Keys returns the region key of every feature: the value of keyProperty, or the feature
+// id when keyProperty is "id" and no property of that name exists. It writes each key to
+// keyProperty so clients can name regions by it, and returns the indexes of the features
+// that have no key.
+func (fc *FeatureCollection) Keys(keyProperty string) ([]string, []int) {
+	var keys []string
+	var missing []int
+	seen := map[string]bool{}
+	for i, f := range fc.Features {
+		raw, ok := f.Properties[keyProperty]
+		if (!ok || raw == nil) && keyProperty == "id" {
+			raw = f.ID
+		}
+		key := KeyString(raw)
+		if key == "" {
+			missing = append(missing, i)
+			continue
+		}
+		f.Properties[keyProperty] = key
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, missing
+}
+
+// KeyString formats a region key; numbers are written without exponent or trailing zeros
+func KeyString(v interface{}) string {
+	switch k := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(k)
+	case float64:
+		return strconv.FormatFloat(k, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(k), 'f', -1, 32)
+	case json.Number:
+		return k.String()
+	case []byte:
+		return strings.TrimSpace(string(k))
+	default:
+		return strings.TrimSpace(fmt.Sprint(k))
+	}
+}
+
+// Bounds returns the extent of every coordinate of the collection
+func (fc *FeatureCollection) Bounds() (minX, minY, maxX, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, f := range fc.Features {
+		f.Geometry.transform(func(line []Position, _ lineKind) []Position {
+			for _, p := range line {
+				if len(p) < 2 {
+					continue
+				}
+				minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+				minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+			}
+			return line
+		})
+	}
+	return minX, minY, maxX, maxY
+}
+
+// lineKind tells what a list of positions of a geometry is
+type lineKind int
+
+const (
+	pointList lineKind = iota
+	openLine
+	closedRing
+)
+
+// transform decodes the coordinates of a geometry, passes every point list, line and ring
+// to fn and returns a geometry with the lists fn returned
+func (g *Geometry) transform(fn func(line []Position, kind lineKind) []Position) (*Geometry, error) {
+	if g == nil {
+		return nil, nil
+	}
+	out := &Geometry{Type: g.Type}
+	var coords interface{}
+	var err error
+	switch g.Type {
+	case "GeometryCollection":
+		for _, child := range g.Geometries {
+			c, err := child.transform(fn)
+			if err != nil {
+				return nil, err
+			}
+			out.Geometries = append(out.Geometries, c)
+		}
+		return out, nil
+	case "Point":
+		var p Position
+		if err = json.Unmarshal(g.Coordinates, &p); err == nil {
+			if line := fn([]Position{p}, pointList); len(line) > 0 {
+				p = line[0]
+			}
+			coords = p
+		}
+	case "MultiPoint", "LineString":
+		var line []Position
+		if err = json.Unmarshal(g.Coordinates, &line); err == nil {
+			kind := openLine
+			if g.Type == "MultiPoint" {
+				kind = pointList
+			}
+			coords = fn(line, kind)
+		}
+	case "MultiLineString", "Polygon":
+		var lines [][]Position
+		if err = json.Unmarshal(g.Coordinates, &lines); err == nil {
+			kind := openLine
+			if g.Type == "Polygon" {
+				kind = closedRing
+			}
+			for i := range lines {
+				lines[i] = fn(lines[i], kind)
+			}
+			coords = lines
+		}
+	case "MultiPolygon":
+		var polygons [][][]Position
+		if err = json.Unmarshal(g.Coordinates, &polygons); err == nil {
+			for _, rings := range polygons {
+				for i := range rings {
+					rings[i] = fn(rings[i], closedRing)
+				}
+			}
+			coords = polygons
+		}
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s coordinates: %w", g.Type, err)
+	}
+	if out.Coordinates, err = json.Marshal(coords); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,71 @@
+package geo
+
+import (
+	"strings"
+)
+
+// Ways region keys are matched to feature keys
+const (
+	MatchExact           = "exact"
+	MatchCaseInsensitive = "case-insensitive"
+	MatchAlias           = "alias"
+)
+
+// ValidMatching reports whether mode is a matching mode
+func ValidMatching(mode string) bool {
+	return mode == MatchExact || mode == MatchCaseInsensitive || mode == MatchAlias
+}
+
+// Matcher matches the region keys of query results to the feature keys of a boundary set
+type Matcher struct {
+	mode    string
+	keys    map[string]bool
+	folded  map[string]string // folded feature key to feature key
+	aliases map[string]string // folded alias to feature key
+}
+
+// NewMatcher creates a Matcher. exact compares keys as they are, case-insensitive ignores
+// case and surrounding space, and alias looks keys up in the alias table before comparing
+// them case-insensitively.
+func NewMatcher(keys []string, mode string, aliases map[string]string) *Matcher {
+	m := &Matcher{mode: mode, keys: make(map[string]bool, len(keys))}
+	for _, key := range keys {
+		m.keys[key] = true
+	}
+	if mode == MatchExact {
+		return m
+	}
+	m.folded = make(map[string]string, len(keys))
+	for _, key := range keys {
+		if _, ok := m.folded[fold(key)]; !ok {
+			m.folded[fold(key)] = key
+		}
+	}
+	if mode == MatchAlias {
+		m.aliases = make(map[string]string, len(aliases))
+		for alias, key := range aliases {
+			m.aliases[fold(alias)] = key
+		}
+	}
+	return m
+}
+
+// Match returns the feature key a region key names
+func (m *Matcher) Match(key string) (string, bool) {
+	if m.keys[key] {
+		return key, true
+	}
+	if m.mode == MatchExact {
+		return "", false
+	}
+	folded := fold(key)
+	if target, ok := m.aliases[folded]; ok && m.keys[target] {
+		return target, true
+	}
+	target, ok := m.folded[folded]
+	return target, ok
+}
+
+func fold(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
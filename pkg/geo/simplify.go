@@ -0,0 +1,149 @@
+package geo
+
+import (
+	"math"
+)
+
+// Level is a zoom level boundary sets are simplified for
+type Level struct {
+	Zoom int
+	// Tolerance is the Douglas-Peucker tolerance as a fraction of the diagonal of the
+	// set's extent; 0 keeps every point
+	Tolerance float64
+	// Decimals coordinates are rounded to; negative keeps them as uploaded
+	Decimals int
+}
+
+// Levels are the zoom levels stored for every boundary set, from the whole map to the
+// uploaded detail
+var Levels = []Level{
+	{Zoom: 0, Tolerance: 0.002, Decimals: 3},
+	{Zoom: 1, Tolerance: 0.0005, Decimals: 4},
+	{Zoom: 2, Tolerance: 0.0001, Decimals: 5},
+	{Zoom: 3, Tolerance: 0, Decimals: -1},
+}
+
+// Simplify returns a copy of the collection simplified for a level. Rings and lines that
+// would collapse keep their points, so no region disappears at low zoom.
+func (fc *FeatureCollection) Simplify(level Level) (*FeatureCollection, error) {
+	tolerance := 0.0
+	if level.Tolerance > 0 {
+		minX, minY, maxX, maxY := fc.Bounds()
+		if diagonal := math.Hypot(maxX-minX, maxY-minY); !math.IsInf(diagonal, 0) && !math.IsNaN(diagonal) {
+			tolerance = diagonal * level.Tolerance
+		}
+	}
+	scale := 0.0
+	if level.Decimals >= 0 {
+		scale = math.Pow(10, float64(level.Decimals))
+	}
+
+	out := &FeatureCollection{Type: "FeatureCollection", Features: make([]*Feature, len(fc.Features))}
+	for i, f := range fc.Features {
+		geometry, err := f.Geometry.transform(func(line []Position, kind lineKind) []Position {
+			return simplifyLine(line, kind, tolerance, scale)
+		})
+		if err != nil {
+			return nil, err
+		}
+		out.Features[i] = &Feature{Type: "Feature", ID: f.ID, Properties: f.Properties, Geometry: geometry}
+	}
+	return out, nil
+}
+
+// simplifyLine rounds a line to scale, drops repeated points and simplifies it. Point
+// lists are only rounded.
+func simplifyLine(line []Position, kind lineKind, tolerance, scale float64) []Position {
+	minPoints := 2
+	if kind == closedRing {
+		minPoints = 4
+	}
+	rounded := line
+	if scale > 0 {
+		rounded = make([]Position, 0, len(line))
+		for _, p := range line {
+			q := make(Position, len(p))
+			for j, v := range p {
+				q[j] = math.Round(v*scale) / scale
+			}
+			if n := len(rounded); kind != pointList && n > 0 && samePoint(rounded[n-1], q) {
+				continue
+			}
+			rounded = append(rounded, q)
+		}
+		if len(rounded) < minPoints {
+			return line
+		}
+	}
+	if kind == pointList || tolerance <= 0 || len(rounded) <= minPoints {
+		return rounded
+	}
+
+	keep := make([]bool, len(rounded))
+	keep[0], keep[len(rounded)-1] = true, true
+	if kind == closedRing {
+		// A closed ring starts and ends on the same point; split it at its farthest point
+		// so both halves are simplified against a real segment
+		far, farDist := 0, -1.0
+		for i, p := range rounded {
+			if d := distance(rounded[0], p); d > farDist {
+				far, farDist = i, d
+			}
+		}
+		keep[far] = true
+		douglasPeucker(rounded, 0, far, tolerance, keep)
+		douglasPeucker(rounded, far, len(rounded)-1, tolerance, keep)
+	} else {
+		douglasPeucker(rounded, 0, len(rounded)-1, tolerance, keep)
+	}
+
+	simplified := make([]Position, 0, len(rounded))
+	for i, p := range rounded {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	if len(simplified) < minPoints {
+		return rounded
+	}
+	return simplified
+}
+
+// douglasPeucker marks the points between first and last that lie farther than tolerance
+// from the simplified line
+func douglasPeucker(points []Position, first, last int, tolerance float64, keep []bool) {
+	stack := [][2]int{{first, last}}
+	for len(stack) > 0 {
+		span := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		index, maxDist := -1, tolerance
+		for i := span[0] + 1; i < span[1]; i++ {
+			if d := segmentDistance(points[i], points[span[0]], points[span[1]]); d > maxDist {
+				index, maxDist = i, d
+			}
+		}
+		if index >= 0 {
+			keep[index] = true
+			stack = append(stack, [2]int{span[0], index}, [2]int{index, span[1]})
+		}
+	}
+}
+
+// segmentDistance is the distance from p to the segment a-b
+func segmentDistance(p, a, b Position) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	if dx == 0 && dy == 0 {
+		return distance(p, a)
+	}
+	t := ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(p[0]-(a[0]+t*dx), p[1]-(a[1]+t*dy))
+}
+
+func distance(a, b Position) float64 {
+	return math.Hypot(b[0]-a[0], b[1]-a[1])
+}
+
+func samePoint(a, b Position) bool {
+	return len(a) >= 2 && len(b) >= 2 && a[0] == b[0] && a[1] == b[1]
+}
@@ -0,0 +1,215 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// topology is a TopoJSON document
+type topology struct {
+	Type      string                     `json:"type"`
+	Transform *topoTransform             `json:"transform"`
+	Arcs      [][]Position               `json:"arcs"`
+	Objects   map[string]json.RawMessage `json:"objects"`
+}
+
+// topoTransform maps the quantized integer coordinates of a topology to positions
+type topoTransform struct {
+	Scale     [2]float64 `json:"scale"`
+	Translate [2]float64 `json:"translate"`
+}
+
+// topoGeometry is a TopoJSON geometry object
+type topoGeometry struct {
+	Type        string                 `json:"type"`
+	ID          interface{}            `json:"id"`
+	Properties  map[string]interface{} `json:"properties"`
+	Arcs        json.RawMessage        `json:"arcs"`
+	Coordinates json.RawMessage        `json:"coordinates"`
+	Geometries  []*topoGeometry        `json:"geometries"`
+}
+
+// parseTopology converts a TopoJSON object, or every object, to GeoJSON features. The
+// geometries of a GeometryCollection object become one feature each.
+func parseTopology(data []byte, object string) (*FeatureCollection, error) {
+	var topo topology
+	if err := json.Unmarshal(data, &topo); err != nil {
+		return nil, fmt.Errorf("invalid topology: %w", err)
+	}
+	names := make([]string, 0, len(topo.Objects))
+	for name := range topo.Objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if object != "" {
+		if _, ok := topo.Objects[object]; !ok {
+			return nil, fmt.Errorf("topology has no object %q; objects are %v", object, names)
+		}
+		names = []string{object}
+	}
+
+	arcs := topo.decodeArcs()
+	fc := &FeatureCollection{Type: "FeatureCollection"}
+	for _, name := range names {
+		var obj topoGeometry
+		if err := json.Unmarshal(topo.Objects[name], &obj); err != nil {
+			return nil, fmt.Errorf("object %q: %w", name, err)
+		}
+		members := []*topoGeometry{&obj}
+		if obj.Type == "GeometryCollection" {
+			members = obj.Geometries
+		}
+		for i, member := range members {
+			geometry, err := topo.geometry(member, arcs)
+			if err != nil {
+				return nil, fmt.Errorf("object %q geometry %d: %w", name, i, err)
+			}
+			properties := member.Properties
+			if properties == nil {
+				properties = map[string]interface{}{}
+			}
+			fc.Features = append(fc.Features, &Feature{Type: "Feature", ID: member.ID, Properties: properties, Geometry: geometry})
+		}
+	}
+	return fc, fc.validate()
+}
+
+// decodeArcs returns the arcs as absolute positions, undoing the delta encoding of
+// quantized topologies
+func (t *topology) decodeArcs() [][]Position {
+	arcs := make([][]Position, len(t.Arcs))
+	for i, arc := range t.Arcs {
+		decoded := make([]Position, 0, len(arc))
+		var x, y float64
+		for _, p := range arc {
+			if len(p) < 2 {
+				continue
+			}
+			if t.Transform == nil {
+				decoded = append(decoded, Position{p[0], p[1]})
+				continue
+			}
+			x, y = x+p[0], y+p[1]
+			decoded = append(decoded, t.position(Position{x, y}))
+		}
+		arcs[i] = decoded
+	}
+	return arcs
+}
+
+// position applies the transform of a quantized topology to a point
+func (t *topology) position(p Position) Position {
+	if t.Transform == nil || len(p) < 2 {
+		return p
+	}
+	return Position{p[0]*t.Transform.Scale[0] + t.Transform.Translate[0], p[1]*t.Transform.Scale[1] + t.Transform.Translate[1]}
+}
+
+// geometry converts a TopoJSON geometry object
+func (t *topology) geometry(g *topoGeometry, arcs [][]Position) (*Geometry, error) {
+	if g.Type == "" {
+		return nil, nil
+	}
+	var coords interface{}
+	var err error
+	switch g.Type {
+	case "GeometryCollection":
+		out := &Geometry{Type: g.Type}
+		for _, child := range g.Geometries {
+			c, err := t.geometry(child, arcs)
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				out.Geometries = append(out.Geometries, c)
+			}
+		}
+		return out, nil
+	case "Point":
+		var p Position
+		if err = json.Unmarshal(g.Coordinates, &p); err == nil {
+			coords = t.position(p)
+		}
+	case "MultiPoint":
+		var points []Position
+		if err = json.Unmarshal(g.Coordinates, &points); err == nil {
+			for i := range points {
+				points[i] = t.position(points[i])
+			}
+			coords = points
+		}
+	case "LineString":
+		var refs []int
+		if err = json.Unmarshal(g.Arcs, &refs); err == nil {
+			coords, err = stitch(arcs, refs)
+		}
+	case "MultiLineString", "Polygon":
+		var refs [][]int
+		if err = json.Unmarshal(g.Arcs, &refs); err == nil {
+			coords, err = stitchAll(arcs, refs)
+		}
+	case "MultiPolygon":
+		var refs [][][]int
+		if err = json.Unmarshal(g.Arcs, &refs); err == nil {
+			polygons := make([][][]Position, len(refs))
+			for i, polygon := range refs {
+				if polygons[i], err = stitchAll(arcs, polygon); err != nil {
+					break
+				}
+			}
+			coords = polygons
+		}
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", g.Type, err)
+	}
+	encoded, err := json.Marshal(coords)
+	if err != nil {
+		return nil, err
+	}
+	return &Geometry{Type: g.Type, Coordinates: encoded}, nil
+}
+
+// stitchAll joins the arcs of every line or ring
+func stitchAll(arcs [][]Position, refs [][]int) ([][]Position, error) {
+	lines := make([][]Position, len(refs))
+	for i, line := range refs {
+		var err error
+		if lines[i], err = stitch(arcs, line); err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+// stitch joins arcs into a line. A negative index ~i is arc i reversed; the first point
+// of every arc after the first repeats the last point of the one before.
+func stitch(arcs [][]Position, refs []int) ([]Position, error) {
+	var line []Position
+	for _, ref := range refs {
+		index, reversed := ref, false
+		if ref < 0 {
+			index, reversed = ^ref, true
+		}
+		if index >= len(arcs) {
+			return nil, fmt.Errorf("arc %d does not exist", index)
+		}
+		arc := arcs[index]
+		points := make([]Position, len(arc))
+		for i, p := range arc {
+			if reversed {
+				points[len(arc)-1-i] = p
+			} else {
+				points[i] = p
+			}
+		}
+		if len(line) > 0 && len(points) > 0 {
+			points = points[1:]
+		}
+		line = append(line, points...)
+	}
+	return line, nil
+}
@@ -0,0 +1,120 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// ErrStartTLSUnsupported is returned when STARTTLS is required and the server does not offer it
+var ErrStartTLSUnsupported = errors.New("smtp server does not support STARTTLS")
+
+// Config is the SMTP server messages are sent through
+type Config struct {
+	Host     string
+	Port     int
+	Username string // empty sends without authentication
+	Password string
+	StartTLS bool          // require STARTTLS; it is used whenever the server offers it
+	Timeout  time.Duration // bounds a whole SMTP session; zero is no limit
+	// TLSConfig overrides the TLS settings of STARTTLS, such as the trusted roots
+	TLSConfig *tls.Config
+}
+
+// Client sends messages through an SMTP server, one session per message
+type Client struct {
+	config Config
+}
+
+// NewClient creates a new SMTP client
+func NewClient(config Config) *Client {
+	return &Client{config: config}
+}
+
+// Send delivers a message to its recipients. The envelope sender is the address of From.
+func (c *Client) Send(msg *Message) error {
+	from, err := netmail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", msg.From, err)
+	}
+	recipients := make([]string, len(msg.To))
+	for i, to := range msg.To {
+		addr, err := netmail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", to, err)
+		}
+		recipients[i] = addr.Address
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return fmt.Errorf("could not write message: %w", err)
+	}
+
+	addr := net.JoinHostPort(c.config.Host, strconv.Itoa(c.config.Port))
+	conn, err := net.DialTimeout("tcp", addr, c.config.Timeout)
+	if err != nil {
+		return err
+	}
+	if c.config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.config.Timeout))
+	}
+	client, err := smtp.NewClient(conn, c.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		tlsConfig := &tls.Config{ServerName: c.config.Host, MinVersion: tls.VersionTLS12}
+		if c.config.TLSConfig != nil {
+			tlsConfig = c.config.TLSConfig.Clone()
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName = c.config.Host
+			}
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	} else if c.config.StartTLS {
+		return ErrStartTLSUnsupported
+	}
+	if c.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.config.Username, c.config.Password, c.config.Host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// IsPermanent reports whether err is a permanent SMTP failure (a 5xx reply), which
+// sending again will not fix
+func IsPermanent(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500
+}
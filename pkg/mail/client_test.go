@@ -0,0 +1,280 @@
+package mail
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpServer is an in-process SMTP server accepting one session at a time
+type smtpServer struct {
+	ln        net.Listener
+	tlsConfig *tls.Config // offers STARTTLS when set
+	authReply string      // reply to AUTH; empty accepts
+	rcptReply string      // reply to RCPT; empty accepts
+
+	mu          sync.Mutex
+	credentials string // username and password of the last AUTH
+	authOverTLS bool
+	from        string
+	to          []string
+	data        string
+}
+
+// startSMTPServer serves s on a local port until the test ends
+func startSMTPServer(t *testing.T, s *smtpServer) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ln = ln
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) config() Config {
+	return Config{Host: "127.0.0.1", Port: s.ln.Addr().(*net.TCPAddr).Port, Timeout: 5 * time.Second}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 test ESMTP")
+	secure := false
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			tp.PrintfLine("500 5.5.2 empty command")
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "EHLO":
+			lines := []string{"test"}
+			if s.tlsConfig != nil && !secure {
+				lines = append(lines, "STARTTLS")
+			}
+			lines = append(lines, "AUTH PLAIN")
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 2.0.0 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, tp, secure = tlsConn, textproto.NewConn(tlsConn), true
+		case "AUTH":
+			if s.authReply != "" {
+				tp.PrintfLine("%s", s.authReply)
+				continue
+			}
+			decoded, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			s.mu.Lock()
+			s.credentials = strings.TrimPrefix(string(decoded), "\x00")
+			s.authOverTLS = secure
+			s.mu.Unlock()
+			tp.PrintfLine("235 2.7.0 authenticated")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = line
+			s.mu.Unlock()
+			tp.PrintfLine("250 2.1.0 ok")
+		case "RCPT":
+			if s.rcptReply != "" {
+				tp.PrintfLine("%s", s.rcptReply)
+				continue
+			}
+			s.mu.Lock()
+			s.to = append(s.to, line)
+			s.mu.Unlock()
+			tp.PrintfLine("250 2.1.5 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = string(data)
+			s.mu.Unlock()
+			tp.PrintfLine("250 2.0.0 queued")
+		case "QUIT":
+			tp.PrintfLine("221 2.0.0 bye")
+			return
+		default:
+			tp.PrintfLine("502 5.5.1 unrecognized command")
+		}
+	}
+}
+
+// selfSignedTLS returns a server certificate for 127.0.0.1 and a client config trusting it
+func selfSignedTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return server, &tls.Config{RootCAs: roots}
+}
+
+func testMessage() *Message {
+	return &Message{
+		From:        "Reports <reports@example.com>",
+		To:          []string{"alice@example.com"},
+		Subject:     "Weekly sales",
+		Body:        "Attached.",
+		Attachments: []Attachment{{Name: "sales.csv", ContentType: "text/csv", Data: []byte("a,b\n1,2\n")}},
+	}
+}
+
+func TestClientSendsOverStartTLSWithAuth(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	server := startSMTPServer(t, &smtpServer{tlsConfig: serverTLS})
+	cfg := server.config()
+	cfg.Username, cfg.Password = "reports", "s3cret"
+	cfg.StartTLS = true
+	cfg.TLSConfig = clientTLS
+
+	if err := NewClient(cfg).Send(testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.credentials != "reports\x00s3cret" {
+		t.Errorf("credentials = %q, want reports and s3cret", server.credentials)
+	}
+	if !server.authOverTLS {
+		t.Error("authenticated before STARTTLS")
+	}
+	if !strings.HasPrefix(server.from, "MAIL FROM:<reports@example.com>") {
+		t.Errorf("MAIL = %q, want the address of From", server.from)
+	}
+	if len(server.to) != 1 || !strings.Contains(server.to[0], "<alice@example.com>") {
+		t.Errorf("RCPT = %q, want alice@example.com", server.to)
+	}
+	for _, want := range []string{"Subject: Weekly sales", "filename=sales.csv"} {
+		if !strings.Contains(server.data, want) {
+			t.Errorf("message does not contain %q", want)
+		}
+	}
+}
+
+func TestClientRejectsUntrustedStartTLSCertificate(t *testing.T) {
+	serverTLS, _ := selfSignedTLS(t)
+	server := startSMTPServer(t, &smtpServer{tlsConfig: serverTLS})
+
+	err := NewClient(server.config()).Send(testMessage())
+	if err == nil || !strings.Contains(err.Error(), "starttls") {
+		t.Fatalf("Send = %v, want a STARTTLS certificate error", err)
+	}
+	if IsPermanent(err) {
+		t.Error("a TLS failure is permanent, want it retried")
+	}
+}
+
+func TestClientRequiresStartTLS(t *testing.T) {
+	server := startSMTPServer(t, &smtpServer{})
+	cfg := server.config()
+	cfg.StartTLS = true
+
+	if err := NewClient(cfg).Send(testMessage()); !errors.Is(err, ErrStartTLSUnsupported) {
+		t.Fatalf("Send = %v, want ErrStartTLSUnsupported", err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.from != "" {
+		t.Error("message was sent without STARTTLS")
+	}
+}
+
+func TestClientClassifiesFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		authReply string
+		rcptReply string
+		permanent bool
+	}{
+		{name: "unknown mailbox", rcptReply: "550 5.1.1 no such user", permanent: true},
+		{name: "mailbox busy", rcptReply: "451 4.3.0 try again later", permanent: false},
+		{name: "bad credentials", authReply: "535 5.7.8 authentication failed", permanent: true},
+		{name: "auth unavailable", authReply: "454 4.7.0 temporary authentication failure", permanent: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startSMTPServer(t, &smtpServer{authReply: tt.authReply, rcptReply: tt.rcptReply})
+			cfg := server.config()
+			cfg.Username, cfg.Password = "reports", "s3cret"
+
+			err := NewClient(cfg).Send(testMessage())
+			if err == nil {
+				t.Fatal("Send succeeded, want an error")
+			}
+			if got := IsPermanent(err); got != tt.permanent {
+				t.Errorf("IsPermanent(%v) = %v, want %v", err, got, tt.permanent)
+			}
+		})
+	}
+
+	// A server that cannot be reached is a temporary failure
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	err = NewClient(Config{Host: "127.0.0.1", Port: port, Timeout: time.Second}).Send(testMessage())
+	if err == nil || IsPermanent(err) {
+		t.Errorf("Send to a closed port = %v, want a temporary error", err)
+	}
+}
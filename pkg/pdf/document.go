@@ -0,0 +1,499 @@
+// Package pdf writes PDF reports: a title page, headings, paragraphs, tables that break
+// across pages with their header repeated, images, and page headers and footers. Text is
+// set in an embedded TrueType font, subset to the glyphs used, so any script the font
+// covers, Chinese included, can be written; without a font it falls back to Helvetica.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	pointsPerMillimetre = 72 / 25.4
+	lineSpacing         = 1.4
+	cellPadding         = 3
+)
+
+// Fonts are the faces a document is set in. Without a regular face the document uses
+// Helvetica; without a bold face bold text is drawn from the regular face with a stroke.
+type Fonts struct {
+	Regular *Face
+	Bold    *Face
+}
+
+// LoadFonts loads the faces of a document from font files; empty paths are left unset
+func LoadFonts(regularPath, boldPath string, index int) (Fonts, error) {
+	var fonts Fonts
+	var err error
+	if regularPath != "" {
+		if fonts.Regular, err = LoadFace(regularPath, index); err != nil {
+			return fonts, err
+		}
+	}
+	if boldPath != "" && fonts.Regular != nil {
+		if fonts.Bold, err = LoadFace(boldPath, index); err != nil {
+			return fonts, err
+		}
+	}
+	return fonts, nil
+}
+
+// Align is the horizontal alignment of a table column
+type Align int
+
+// Alignments of table columns
+const (
+	AlignLeft Align = iota
+	AlignRight
+	AlignCenter
+)
+
+// Table is a table of text. Rows longer than the columns are cut.
+type Table struct {
+	Columns []string
+	Align   []Align
+	Rows    [][]string
+}
+
+// page is a page of a document being laid out
+type page struct {
+	content bytes.Buffer
+	title   bool
+}
+
+// Document is a PDF document laid out top to bottom
+type Document struct {
+	layout        Layout
+	width, height float64
+	margin        float64
+	fontSize      float64
+	accent        color
+
+	fonts    []font // resources /F1, /F2...
+	regular  int
+	bold     int
+	fakeBold bool
+
+	images []*image
+
+	title   string
+	author  string
+	created time.Time
+
+	pages []*page
+	page  *page
+	y     float64 // distance of the cursor from the top of the page
+}
+
+// New creates a document with a layout and fonts
+func New(layout Layout, fonts Fonts) *Document {
+	d := &Document{layout: layout, created: time.Now()}
+	d.width, d.height = layout.size()
+	d.margin = layout.Margin * pointsPerMillimetre
+	d.fontSize = layout.FontSize
+	d.accent, _ = parseColor(layout.AccentColor)
+
+	switch {
+	case fonts.Regular == nil:
+		d.fonts = []font{
+			&standardFont{name: "Helvetica", widths: &helveticaWidths},
+			&standardFont{name: "Helvetica-Bold", widths: &helveticaBoldWidths},
+		}
+		d.bold = 1
+	case fonts.Bold == nil || fonts.Bold == fonts.Regular:
+		d.fonts = []font{newEmbeddedFont(fonts.Regular)}
+		d.fakeBold = true
+	default:
+		d.fonts = []font{newEmbeddedFont(fonts.Regular), newEmbeddedFont(fonts.Bold)}
+		d.bold = 1
+	}
+	return d
+}
+
+// SetInfo sets the title, author and creation time of the document. The title and the
+// date are what the {title} and {date} placeholders of the header and footer show.
+func (d *Document) SetInfo(title, author string, created time.Time) {
+	d.title, d.author, d.created = title, author, created
+}
+
+// top and bottom bound the content of a page
+func (d *Document) top() float64    { return d.margin + d.fontSize*2 }
+func (d *Document) bottom() float64 { return d.height - d.margin - d.fontSize*2 }
+
+// contentWidth is the width between the margins
+func (d *Document) contentWidth() float64 { return d.width - 2*d.margin }
+
+func (d *Document) newPage() {
+	d.page = &page{}
+	d.pages = append(d.pages, d.page)
+	d.y = d.top()
+}
+
+// ensure starts a new page unless height fits below the cursor
+func (d *Document) ensure(height float64) {
+	if d.page == nil || d.page.title || (d.y+height > d.bottom() && d.y > d.top()) {
+		d.newPage()
+	}
+}
+
+// TitlePage writes the title, a subtitle and a list of label and value details. With the
+// layout's title page on they fill a page of their own.
+func (d *Document) TitlePage(title, subtitle string, details [][2]string) {
+	titleSize := d.fontSize * 2.4
+	if d.layout.TitlePage == nil || *d.layout.TitlePage {
+		d.newPage()
+		d.page.title = true
+		d.y = d.height / 4
+	} else {
+		d.ensure(titleSize * lineSpacing * 3)
+	}
+
+	for _, line := range d.wrap(true, titleSize, title, d.contentWidth()) {
+		d.text(d.margin, d.y, line, true, titleSize, d.accent)
+		d.y += titleSize * lineSpacing
+	}
+	if subtitle != "" {
+		size := d.fontSize * 1.2
+		for _, line := range d.wrap(false, size, subtitle, d.contentWidth()) {
+			d.text(d.margin, d.y, line, false, size, muted)
+			d.y += size * lineSpacing
+		}
+	}
+	d.y += d.fontSize
+	d.fill(d.margin, d.y, d.contentWidth(), 1.5, d.accent)
+	d.y += d.fontSize * 2
+
+	labelWidth := 0.0
+	for _, detail := range details {
+		labelWidth = max(labelWidth, d.measure(true, d.fontSize, detail[0]))
+	}
+	labelWidth = min(labelWidth+d.fontSize*2, d.contentWidth()/3)
+	for _, detail := range details {
+		lines := d.wrap(false, d.fontSize, detail[1], d.contentWidth()-labelWidth)
+		height := float64(len(lines)) * d.fontSize * lineSpacing
+		if d.y+height > d.bottom() {
+			d.newPage()
+		}
+		d.text(d.margin, d.y, detail[0], true, d.fontSize, black)
+		for _, line := range lines {
+			d.text(d.margin+labelWidth, d.y, line, false, d.fontSize, black)
+			d.y += d.fontSize * lineSpacing
+		}
+	}
+	d.y += d.fontSize
+}
+
+// Heading writes a section heading, keeping it on the page of what follows
+func (d *Document) Heading(text string) {
+	size := d.fontSize * 1.5
+	lines := d.wrap(true, size, text, d.contentWidth())
+	d.ensure(float64(len(lines))*size*lineSpacing + d.fontSize*lineSpacing*4)
+	if d.y > d.top() {
+		d.y += size * 0.6
+	}
+	for _, line := range lines {
+		d.text(d.margin, d.y, line, true, size, d.accent)
+		d.y += size * lineSpacing
+	}
+	d.y += size * 0.3
+}
+
+// Paragraph writes wrapped body text
+func (d *Document) Paragraph(text string) {
+	d.paragraph(text, black)
+}
+
+// Note writes wrapped text in a muted color
+func (d *Document) Note(text string) {
+	d.paragraph(text, muted)
+}
+
+func (d *Document) paragraph(text string, c color) {
+	leading := d.fontSize * lineSpacing
+	for _, line := range d.wrap(false, d.fontSize, text, d.contentWidth()) {
+		d.ensure(leading)
+		d.text(d.margin, d.y, line, false, d.fontSize, c)
+		d.y += leading
+	}
+	d.y += d.fontSize * 0.5
+}
+
+// Table writes a table, starting new pages as needed with the header row repeated
+func (d *Document) Table(t Table) {
+	if len(t.Columns) == 0 {
+		return
+	}
+	size := d.fontSize
+	leading := size * lineSpacing
+	widths := d.columnWidths(t, size)
+
+	header := make([][]string, len(t.Columns))
+	headerLines := 1
+	for i, column := range t.Columns {
+		header[i] = d.wrap(true, size, column, widths[i]-2*cellPadding)
+		headerLines = max(headerLines, len(header[i]))
+	}
+	headerHeight := float64(headerLines)*leading + 2*cellPadding
+	drawHeader := func() {
+		d.fill(d.margin, d.y, d.contentWidth(), headerHeight, d.accent.tint(0.85))
+		d.cells(header, t.Align, widths, true, leading)
+		d.y += headerHeight
+		d.fill(d.margin, d.y-0.75, d.contentWidth(), 0.75, d.accent)
+	}
+
+	d.ensure(headerHeight + leading + 2*cellPadding)
+	drawHeader()
+	for r, row := range t.Rows {
+		cells := make([][]string, len(t.Columns))
+		lines := 1
+		for i := range t.Columns {
+			value := ""
+			if i < len(row) {
+				value = row[i]
+			}
+			cells[i] = d.wrap(false, size, value, widths[i]-2*cellPadding)
+			lines = max(lines, len(cells[i]))
+		}
+		// A row never spans pages; cut the lines of a row taller than a page
+		maxLines := max(1, int((d.bottom()-d.top()-headerHeight-2*cellPadding)/leading))
+		if lines > maxLines {
+			for i := range cells {
+				if len(cells[i]) > maxLines {
+					cells[i] = append(cells[i][:maxLines-1], cells[i][maxLines-1]+" ...")
+				}
+			}
+			lines = maxLines
+		}
+		height := float64(lines)*leading + 2*cellPadding
+		if d.y+height > d.bottom() {
+			d.newPage()
+			drawHeader()
+		}
+		if r%2 == 1 {
+			d.fill(d.margin, d.y, d.contentWidth(), height, zebra)
+		}
+		d.cells(cells, t.Align, widths, false, leading)
+		d.y += height
+		d.fill(d.margin, d.y-0.5, d.contentWidth(), 0.5, rule)
+	}
+	d.y += d.fontSize
+}
+
+// cells writes the wrapped lines of a row of cells at the cursor
+func (d *Document) cells(cells [][]string, align []Align, widths []float64, bold bool, leading float64) {
+	x := d.margin
+	for i, lines := range cells {
+		a := AlignLeft
+		if i < len(align) {
+			a = align[i]
+		}
+		for j, line := range lines {
+			lineX := x + cellPadding
+			switch a {
+			case AlignRight:
+				lineX = x + widths[i] - cellPadding - d.measure(bold, d.fontSize, line)
+			case AlignCenter:
+				lineX = x + (widths[i]-d.measure(bold, d.fontSize, line))/2
+			}
+			d.text(lineX, d.y+cellPadding+float64(j)*leading, line, bold, d.fontSize, black)
+		}
+		x += widths[i]
+	}
+}
+
+// columnWidths fits the columns of a table to the content width. Every column gets its
+// natural width when they all fit; otherwise narrow columns keep theirs and the wide ones
+// share the rest.
+func (d *Document) columnWidths(t Table, size float64) []float64 {
+	available := d.contentWidth()
+	natural := make([]float64, len(t.Columns))
+	for i, column := range t.Columns {
+		natural[i] = d.measure(true, size, column)
+	}
+	for _, row := range t.Rows {
+		for i := range t.Columns {
+			if i < len(row) {
+				natural[i] = max(natural[i], d.measure(false, size, row[i]))
+			}
+		}
+	}
+	total := 0.0
+	for i := range natural {
+		natural[i] = min(natural[i]+2*cellPadding, available/2)
+		total += natural[i]
+	}
+
+	widths := make([]float64, len(natural))
+	if total <= available {
+		for i, w := range natural {
+			widths[i] = w * available / total
+		}
+		return widths
+	}
+	fixed := make([]bool, len(natural))
+	remaining, open := available, len(natural)
+	for changed := true; changed && open > 0; {
+		changed = false
+		share := remaining / float64(open)
+		for i, w := range natural {
+			if !fixed[i] && w <= share {
+				widths[i], fixed[i] = w, true
+				remaining -= w
+				open--
+				changed = true
+			}
+		}
+	}
+	for i := range widths {
+		if !fixed[i] {
+			widths[i] = remaining / float64(open)
+		}
+	}
+	return widths
+}
+
+// Image writes a PNG or JPEG image scaled to the content width, or smaller to keep its
+// own size, with an optional caption
+func (d *Document) Image(data []byte, caption string) error {
+	img, err := newImage(data)
+	if err != nil {
+		return err
+	}
+	d.images = append(d.images, img)
+	name := fmt.Sprintf("Im%d", len(d.images))
+
+	width := min(float64(img.width)*0.75, d.contentWidth())
+	height := width * float64(img.height) / float64(img.width)
+	if maxHeight := d.bottom() - d.top() - d.fontSize*3; height > maxHeight {
+		height, width = maxHeight, maxHeight*float64(img.width)/float64(img.height)
+	}
+	d.ensure(height + d.fontSize*lineSpacing)
+	x := d.margin + (d.contentWidth()-width)/2
+	fmt.Fprintf(&d.page.content, "q %.2f 0 0 %.2f %.2f %.2f cm /%s Do Q\n", width, height, x, d.height-d.y-height, name)
+	d.y += height + d.fontSize*0.5
+	if caption != "" {
+		w := d.measure(false, d.fontSize, caption)
+		d.text(d.margin+max(0, (d.contentWidth()-w)/2), d.y, caption, false, d.fontSize, muted)
+		d.y += d.fontSize * lineSpacing
+	}
+	d.y += d.fontSize
+	return nil
+}
+
+// font returns the index of the regular or bold font
+func (d *Document) font(bold bool) int {
+	if bold {
+		return d.bold
+	}
+	return d.regular
+}
+
+// measure returns the width of text in points
+func (d *Document) measure(bold bool, size float64, text string) float64 {
+	return d.fonts[d.font(bold)].width(text) * size / 1000
+}
+
+// text draws a line of text with its top at y
+func (d *Document) text(x, y float64, text string, bold bool, size float64, c color) {
+	if text == "" {
+		return
+	}
+	baseline := d.height - y - size*0.8
+	f := d.font(bold)
+	fmt.Fprintf(&d.page.content, "BT %.3f %.3f %.3f rg ", c[0], c[1], c[2])
+	if bold && d.fakeBold {
+		fmt.Fprintf(&d.page.content, "%.3f %.3f %.3f RG %.2f w 2 Tr ", c[0], c[1], c[2], size*0.03)
+	}
+	fmt.Fprintf(&d.page.content, "/F%d %.2f Tf %.2f %.2f Td %s Tj ", f+1, size, x, baseline, d.fonts[f].show(text))
+	if bold && d.fakeBold {
+		d.page.content.WriteString("0 Tr ")
+	}
+	d.page.content.WriteString("ET\n")
+}
+
+// fill paints a rectangle with its top left corner at x, y
+func (d *Document) fill(x, y, width, height float64, c color) {
+	fmt.Fprintf(&d.page.content, "%.3f %.3f %.3f rg %.2f %.2f %.2f %.2f re f\n", c[0], c[1], c[2], x, d.height-y-height, width, height)
+}
+
+// wrap breaks text into lines no wider than width. Lines break at spaces and around CJK
+// characters; words wider than a line are broken anywhere.
+func (d *Document) wrap(bold bool, size float64, text string, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		runes := []rune(strings.TrimRight(paragraph, " "))
+		if len(runes) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		for start := 0; start < len(runes); {
+			lineWidth, end, breakAt := 0.0, start, -1
+			for end < len(runes) {
+				w := d.measure(bold, size, string(runes[end]))
+				if lineWidth+w > width && end > start {
+					break
+				}
+				lineWidth += w
+				if runes[end] == ' ' || wide(runes[end]) {
+					breakAt = end + 1
+				}
+				if end+1 < len(runes) && wide(runes[end+1]) {
+					breakAt = end + 1
+				}
+				end++
+			}
+			if end < len(runes) && runes[end] != ' ' && breakAt > start {
+				end = breakAt
+			}
+			lines = append(lines, strings.TrimRight(string(runes[start:end]), " "))
+			for start = end; start < len(runes) && runes[start] == ' '; start++ {
+			}
+		}
+	}
+	return lines
+}
+
+// wide reports whether a line may break before and after r
+func wide(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		(r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF)
+}
+
+// decorate draws the header and footer of every page but the title page
+func (d *Document) decorate() {
+	size := d.fontSize * 0.85
+	for i, p := range d.pages {
+		if p.title {
+			continue
+		}
+		d.page = p
+		replacer := strings.NewReplacer(
+			"{title}", d.title,
+			"{date}", d.created.Format("2006-01-02"),
+			"{page}", fmt.Sprint(i+1),
+			"{pages}", fmt.Sprint(len(d.pages)),
+		)
+		if header := replacer.Replace(d.layout.Header); header != "" {
+			header = d.fit(header, size)
+			d.text(d.margin, d.margin, header, false, size, muted)
+			d.fill(d.margin, d.margin+size*lineSpacing, d.contentWidth(), 0.5, rule)
+		}
+		if footer := replacer.Replace(d.layout.Footer); footer != "" {
+			footer = d.fit(footer, size)
+			w := d.measure(false, size, footer)
+			d.text(d.margin+(d.contentWidth()-w)/2, d.height-d.margin-size, footer, false, size, muted)
+		}
+	}
+}
+
+// fit cuts text to the content width
+func (d *Document) fit(text string, size float64) string {
+	if lines := d.wrap(false, size, text, d.contentWidth()); len(lines) > 1 {
+		return lines[0] + " ..."
+	}
+	return text
+}
@@ -0,0 +1,166 @@
+package pdf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// helveticaWidths are the widths of printable ASCII in Helvetica, from the space on
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// helveticaBoldWidths are the widths of printable ASCII in Helvetica-Bold
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// font is a font used by a document
+type font interface {
+	// width returns the width of text in 1/1000 em
+	width(text string) float64
+	// show returns the text as a string operand of the Tj operator
+	show(text string) string
+}
+
+// standardFont is a standard Type 1 font. Text outside printable ASCII is shown as '?'.
+type standardFont struct {
+	name   string
+	widths *[95]int
+}
+
+func standardRune(r rune) rune {
+	if r < ' ' || r > '~' {
+		return '?'
+	}
+	return r
+}
+
+func (f *standardFont) width(text string) float64 {
+	w := 0
+	for _, r := range text {
+		w += f.widths[standardRune(r)-' ']
+	}
+	return float64(w)
+}
+
+func (f *standardFont) show(text string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range text {
+		r = standardRune(r)
+		if r == '(' || r == ')' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// embeddedFont is a TrueType font embedded with the glyphs a document uses
+type embeddedFont struct {
+	face *Face
+	used map[uint16]rune // glyph to the character it shows
+}
+
+func newEmbeddedFont(face *Face) *embeddedFont {
+	return &embeddedFont{face: face, used: map[uint16]rune{}}
+}
+
+func (f *embeddedFont) width(text string) float64 {
+	w := 0.0
+	for _, r := range text {
+		w += f.face.advance(f.face.glyph(r))
+	}
+	return w
+}
+
+func (f *embeddedFont) show(text string) string {
+	var b strings.Builder
+	b.WriteByte('<')
+	for _, r := range text {
+		gid := f.face.glyph(r)
+		if _, ok := f.used[gid]; !ok {
+			f.used[gid] = r
+		}
+		fmt.Fprintf(&b, "%04X", gid)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// glyphs returns the used glyphs in order
+func (f *embeddedFont) glyphs() []uint16 {
+	gids := make([]uint16, 0, len(f.used))
+	for gid := range f.used {
+		gids = append(gids, gid)
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i] < gids[j] })
+	return gids
+}
+
+// subsetTag returns the six letter tag naming the glyph subset of the font
+func (f *embeddedFont) subsetTag() string {
+	hash := uint32(2166136261)
+	for _, gid := range f.glyphs() {
+		hash = (hash ^ uint32(gid)) * 16777619
+	}
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = byte('A' + hash%26)
+		hash /= 26
+	}
+	return string(tag)
+}
+
+// widthsArray returns the W array of the used glyphs
+func (f *embeddedFont) widthsArray() string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for _, gid := range f.glyphs() {
+		fmt.Fprintf(&b, "%d [%d] ", gid, int(f.face.advance(gid)+0.5))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// toUnicode returns the CMap mapping the used glyphs back to text
+func (f *embeddedFont) toUnicode() string {
+	var b strings.Builder
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	b.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	// The missing glyph stands for any character the font lacks
+	gids := f.glyphs()
+	if len(gids) > 0 && gids[0] == 0 {
+		gids = gids[1:]
+	}
+	for start := 0; start < len(gids); start += 100 {
+		end := min(start+100, len(gids))
+		fmt.Fprintf(&b, "%d beginbfchar\n", end-start)
+		for _, gid := range gids[start:end] {
+			fmt.Fprintf(&b, "<%04X> <", gid)
+			for _, unit := range utf16.Encode([]rune{f.used[gid]}) {
+				fmt.Fprintf(&b, "%04X", unit)
+			}
+			b.WriteString(">\n")
+		}
+		b.WriteString("endbfchar\n")
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return b.String()
+}
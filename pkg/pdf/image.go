@@ -0,0 +1,80 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	goimage "image"
+	imagecolor "image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+// image is an image XObject
+type image struct {
+	width, height int
+	colorSpace    string
+	filter        string
+	data          []byte
+	alpha         []byte // deflated soft mask, nil when opaque
+}
+
+// newImage decodes a PNG or JPEG image. RGB and grayscale JPEG data is embedded as is;
+// other images are embedded as deflated RGB with their transparency as a soft mask.
+func newImage(data []byte) (*image, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xFF\xD8")):
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid JPEG image: %w", err)
+		}
+		switch config.ColorModel {
+		case imagecolor.YCbCrModel:
+			return &image{width: config.Width, height: config.Height, colorSpace: "DeviceRGB", filter: "DCTDecode", data: data}, nil
+		case imagecolor.GrayModel:
+			return &image{width: config.Width, height: config.Height, colorSpace: "DeviceGray", filter: "DCTDecode", data: data}, nil
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid JPEG image: %w", err)
+		}
+		return newRaster(img), nil
+	case bytes.HasPrefix(data, []byte("\x89PNG")):
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid PNG image: %w", err)
+		}
+		return newRaster(img), nil
+	}
+	return nil, fmt.Errorf("image must be a PNG or JPEG")
+}
+
+// newRaster embeds a decoded image as deflated RGB samples
+func newRaster(img goimage.Image) *image {
+	bounds := img.Bounds()
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	alpha := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := imagecolor.NRGBAModel.Convert(img.At(x, y)).(imagecolor.NRGBA)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			opaque = opaque && c.A == 0xFF
+		}
+	}
+	result := &image{width: bounds.Dx(), height: bounds.Dy(), colorSpace: "DeviceRGB", filter: "FlateDecode", data: deflate(rgb)}
+	if !opaque {
+		result.alpha = deflate(alpha)
+	}
+	return result
+}
+
+// deflate compresses data with zlib, as the FlateDecode filter expects
+func deflate(data []byte) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
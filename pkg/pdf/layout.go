@@ -0,0 +1,137 @@
+package pdf
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pageSizes are the supported page sizes in points, portrait
+var pageSizes = map[string][2]float64{
+	"A3":     {841.89, 1190.55},
+	"A4":     {595.28, 841.89},
+	"A5":     {419.53, 595.28},
+	"Letter": {612, 792},
+	"Legal":  {612, 1008},
+}
+
+// Layout is the layout template of a document. Header and footer may use the {title},
+// {date}, {page} and {pages} placeholders.
+type Layout struct {
+	PageSize    string  `json:"page_size,omitempty"`    // A3, A4, A5, Letter or Legal
+	Orientation string  `json:"orientation,omitempty"`  // portrait or landscape
+	Margin      float64 `json:"margin,omitempty"`       // millimetres
+	FontSize    float64 `json:"font_size,omitempty"`    // points of body text and tables
+	TitlePage   *bool   `json:"title_page,omitempty"`   // a separate first page with the title; default true
+	Header      string  `json:"header,omitempty"`       // text on top of every page but the title page
+	Footer      string  `json:"footer,omitempty"`       // text at the bottom of every page but the title page
+	AccentColor string  `json:"accent_color,omitempty"` // #RRGGBB of headings and table headers
+}
+
+// DefaultLayout returns the layout used when a template sets nothing
+func DefaultLayout() Layout {
+	titlePage := true
+	return Layout{
+		PageSize:    "A4",
+		Orientation: "portrait",
+		Margin:      15,
+		FontSize:    9,
+		TitlePage:   &titlePage,
+		Header:      "{title}",
+		Footer:      "{page} / {pages}",
+		AccentColor: "#1F4E79",
+	}
+}
+
+// ParseLayout decodes a JSON layout template, filling what it leaves out from the default
+// layout. An empty template is the default layout.
+func ParseLayout(data []byte) (Layout, error) {
+	layout := DefaultLayout()
+	if strings.TrimSpace(string(data)) == "" {
+		return layout, nil
+	}
+	var t Layout
+	if err := json.Unmarshal(data, &t); err != nil {
+		return layout, fmt.Errorf("layout must be a JSON object: %w", err)
+	}
+	if t.PageSize != "" {
+		layout.PageSize = t.PageSize
+	}
+	if t.Orientation != "" {
+		layout.Orientation = t.Orientation
+	}
+	if t.Margin != 0 {
+		layout.Margin = t.Margin
+	}
+	if t.FontSize != 0 {
+		layout.FontSize = t.FontSize
+	}
+	if t.TitlePage != nil {
+		layout.TitlePage = t.TitlePage
+	}
+	if t.Header != "" {
+		layout.Header = t.Header
+	}
+	if t.Footer != "" {
+		layout.Footer = t.Footer
+	}
+	if t.AccentColor != "" {
+		layout.AccentColor = t.AccentColor
+	}
+	return layout, layout.validate()
+}
+
+func (l Layout) validate() error {
+	if _, ok := pageSizes[l.PageSize]; !ok {
+		return fmt.Errorf("page_size must be one of A3, A4, A5, Letter or Legal")
+	}
+	if l.Orientation != "portrait" && l.Orientation != "landscape" {
+		return fmt.Errorf("orientation must be portrait or landscape")
+	}
+	if l.Margin < 5 || l.Margin > 50 {
+		return fmt.Errorf("margin must be between 5 and 50 millimetres")
+	}
+	if l.FontSize < 6 || l.FontSize > 24 {
+		return fmt.Errorf("font_size must be between 6 and 24 points")
+	}
+	if _, ok := parseColor(l.AccentColor); !ok {
+		return fmt.Errorf("accent_color must be a #RRGGBB color")
+	}
+	return nil
+}
+
+// size returns the page width and height in points
+func (l Layout) size() (float64, float64) {
+	size := pageSizes[l.PageSize]
+	if l.Orientation == "landscape" {
+		return size[1], size[0]
+	}
+	return size[0], size[1]
+}
+
+// color is an RGB color with components from 0 to 1
+type color [3]float64
+
+var (
+	black = color{0, 0, 0}
+	muted = color{0.4, 0.4, 0.4}
+	rule  = color{0.8, 0.8, 0.8}
+	zebra = color{0.96, 0.96, 0.96}
+)
+
+func parseColor(s string) (color, bool) {
+	if len(s) != 7 || s[0] != '#' {
+		return color{}, false
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color{}, false
+	}
+	return color{float64(v>>16&0xFF) / 255, float64(v>>8&0xFF) / 255, float64(v&0xFF) / 255}, true
+}
+
+// tint mixes a color with white; amount 1 is white
+func (c color) tint(amount float64) color {
+	return color{c[0] + (1-c[0])*amount, c[1] + (1-c[1])*amount, c[2] + (1-c[2])*amount}
+}
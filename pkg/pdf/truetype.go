@@ -0,0 +1,437 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// Face is a parsed TrueType font. It is immutable and shared by the documents using it.
+type Face struct {
+	name       string // PostScript name
+	tables     map[string][]byte
+	unitsPerEm float64
+	bbox       [4]float64 // in 1/1000 em
+	ascent     float64
+	descent    float64
+	capHeight  float64
+	italic     float64
+	fixedPitch bool
+	advances   []uint16 // advance width of every glyph, in font units
+	cmap       map[rune]uint16
+}
+
+var (
+	facesMu sync.Mutex
+	faces   = map[string]*Face{}
+)
+
+// LoadFace reads a TrueType font (.ttf) or a font of a TrueType collection (.ttc).
+// Faces are cached by path and index.
+func LoadFace(path string, index int) (*Face, error) {
+	key := fmt.Sprintf("%s#%d", path, index)
+	facesMu.Lock()
+	defer facesMu.Unlock()
+	if face, ok := faces[key]; ok {
+		return face, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	face, err := ParseFace(data, index)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	faces[key] = face
+	return face, nil
+}
+
+// ParseFace parses a TrueType font, or the font at index of a TrueType collection
+func ParseFace(data []byte, index int) (*Face, error) {
+	offset := 0
+	if len(data) >= 12 && string(data[:4]) == "ttcf" {
+		count := int(binary.BigEndian.Uint32(data[8:]))
+		if index < 0 || index >= count || len(data) < 12+4*count {
+			return nil, fmt.Errorf("font collection has no font %d", index)
+		}
+		offset = int(binary.BigEndian.Uint32(data[12+4*index:]))
+	}
+	if len(data) < offset+12 {
+		return nil, fmt.Errorf("not a TrueType font")
+	}
+	switch string(data[offset : offset+4]) {
+	case "\x00\x01\x00\x00", "true":
+	case "OTTO":
+		return nil, fmt.Errorf("CFF-based OpenType fonts are not supported; use a TrueType font")
+	default:
+		return nil, fmt.Errorf("not a TrueType font")
+	}
+
+	f := &Face{tables: map[string][]byte{}}
+	numTables := int(binary.BigEndian.Uint16(data[offset+4:]))
+	for i := 0; i < numTables; i++ {
+		record := offset + 12 + 16*i
+		if len(data) < record+16 {
+			return nil, fmt.Errorf("truncated table directory")
+		}
+		tag := string(data[record : record+4])
+		start := int(binary.BigEndian.Uint32(data[record+8:]))
+		length := int(binary.BigEndian.Uint32(data[record+12:]))
+		if start < 0 || length < 0 || start+length > len(data) {
+			return nil, fmt.Errorf("table %q lies outside the file", tag)
+		}
+		f.tables[tag] = data[start : start+length]
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "maxp", "cmap", "loca", "glyf"} {
+		if f.tables[tag] == nil {
+			return nil, fmt.Errorf("missing %s table", tag)
+		}
+	}
+	if err := f.parse(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *Face) parse() error {
+	head, hhea, maxp := f.tables["head"], f.tables["hhea"], f.tables["maxp"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 {
+		return fmt.Errorf("truncated head, hhea or maxp table")
+	}
+	f.unitsPerEm = float64(binary.BigEndian.Uint16(head[18:]))
+	if f.unitsPerEm == 0 {
+		return fmt.Errorf("invalid unitsPerEm")
+	}
+	for i := range f.bbox {
+		f.bbox[i] = f.scale(int16(binary.BigEndian.Uint16(head[36+2*i:])))
+	}
+	f.ascent = f.scale(int16(binary.BigEndian.Uint16(hhea[4:])))
+	f.descent = f.scale(int16(binary.BigEndian.Uint16(hhea[6:])))
+	f.capHeight = f.ascent
+	if os2 := f.tables["OS/2"]; len(os2) >= 8 {
+		if fsType := binary.BigEndian.Uint16(os2[8:]); fsType&0x000F == 0x0002 {
+			return fmt.Errorf("the font license does not permit embedding")
+		}
+		if version := binary.BigEndian.Uint16(os2); version >= 2 && len(os2) >= 90 {
+			f.capHeight = f.scale(int16(binary.BigEndian.Uint16(os2[88:])))
+		}
+	}
+	if post := f.tables["post"]; len(post) >= 16 {
+		f.italic = float64(int32(binary.BigEndian.Uint32(post[4:]))) / 65536
+		f.fixedPitch = binary.BigEndian.Uint32(post[12:]) != 0
+	}
+
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	numMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	hmtx := f.tables["hmtx"]
+	if numMetrics == 0 || numMetrics > numGlyphs || len(hmtx) < 4*numMetrics {
+		return fmt.Errorf("invalid hmtx table")
+	}
+	f.advances = make([]uint16, numGlyphs)
+	for gid := range f.advances {
+		if gid < numMetrics {
+			f.advances[gid] = binary.BigEndian.Uint16(hmtx[4*gid:])
+		} else {
+			f.advances[gid] = f.advances[numMetrics-1]
+		}
+	}
+
+	if err := f.parseCmap(); err != nil {
+		return err
+	}
+	f.name = f.postScriptName()
+	return nil
+}
+
+// scale converts font units to 1/1000 em
+func (f *Face) scale(v int16) float64 {
+	return float64(v) * 1000 / f.unitsPerEm
+}
+
+// parseCmap reads the Unicode character map: a format 12 subtable when there is one,
+// and a format 4 subtable otherwise
+func (f *Face) parseCmap() error {
+	cmap := f.tables["cmap"]
+	if len(cmap) < 4 {
+		return fmt.Errorf("truncated cmap table")
+	}
+	var format4, format12 []byte
+	count := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < count && len(cmap) >= 4+8*i+8; i++ {
+		platform := binary.BigEndian.Uint16(cmap[4+8*i:])
+		encoding := binary.BigEndian.Uint16(cmap[4+8*i+2:])
+		offset := int(binary.BigEndian.Uint32(cmap[4+8*i+4:]))
+		if offset+4 > len(cmap) || !(platform == 0 || (platform == 3 && (encoding == 1 || encoding == 10))) {
+			continue
+		}
+		sub := cmap[offset:]
+		switch binary.BigEndian.Uint16(sub) {
+		case 4:
+			format4 = sub
+		case 12:
+			format12 = sub
+		}
+	}
+
+	f.cmap = map[rune]uint16{}
+	switch {
+	case format12 != nil:
+		if len(format12) < 16 {
+			return fmt.Errorf("truncated cmap subtable")
+		}
+		groups := int(binary.BigEndian.Uint32(format12[12:]))
+		if len(format12) < 16+12*groups {
+			return fmt.Errorf("truncated cmap subtable")
+		}
+		for i := 0; i < groups; i++ {
+			g := format12[16+12*i:]
+			start, end, gid := binary.BigEndian.Uint32(g), binary.BigEndian.Uint32(g[4:]), binary.BigEndian.Uint32(g[8:])
+			for c := start; c <= end && c <= 0x10FFFF && gid+c-start < uint32(len(f.advances)); c++ {
+				f.cmap[rune(c)] = uint16(gid + c - start)
+			}
+		}
+	case format4 != nil:
+		if len(format4) < 14 {
+			return fmt.Errorf("truncated cmap subtable")
+		}
+		segments := int(binary.BigEndian.Uint16(format4[6:])) / 2
+		if len(format4) < 16+8*segments {
+			return fmt.Errorf("truncated cmap subtable")
+		}
+		ends, starts := format4[14:], format4[16+2*segments:]
+		deltas, rangeOffsets := format4[16+4*segments:], format4[16+6*segments:]
+		for i := 0; i < segments; i++ {
+			end, start := binary.BigEndian.Uint16(ends[2*i:]), binary.BigEndian.Uint16(starts[2*i:])
+			delta, rangeOffset := binary.BigEndian.Uint16(deltas[2*i:]), int(binary.BigEndian.Uint16(rangeOffsets[2*i:]))
+			for c := uint32(start); c <= uint32(end) && c != 0xFFFF; c++ {
+				var gid uint16
+				if rangeOffset == 0 {
+					gid = uint16(c) + delta
+				} else {
+					at := 16 + 6*segments + 2*i + rangeOffset + 2*int(c-uint32(start))
+					if at+2 > len(format4) {
+						continue
+					}
+					if gid = binary.BigEndian.Uint16(format4[at:]); gid != 0 {
+						gid += delta
+					}
+				}
+				if gid != 0 && int(gid) < len(f.advances) {
+					f.cmap[rune(c)] = gid
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("no Unicode character map")
+	}
+	return nil
+}
+
+// postScriptName returns the PostScript name of the font from its name table
+func (f *Face) postScriptName() string {
+	name := f.tables["name"]
+	if len(name) >= 6 {
+		count := int(binary.BigEndian.Uint16(name[2:]))
+		storage := int(binary.BigEndian.Uint16(name[4:]))
+		for i := 0; i < count && len(name) >= 6+12*i+12; i++ {
+			record := name[6+12*i:]
+			platform, nameID := binary.BigEndian.Uint16(record), binary.BigEndian.Uint16(record[6:])
+			length, offset := int(binary.BigEndian.Uint16(record[8:])), int(binary.BigEndian.Uint16(record[10:]))
+			if nameID != 6 || storage+offset+length > len(name) {
+				continue
+			}
+			raw := name[storage+offset : storage+offset+length]
+			value := string(raw)
+			if platform == 0 || platform == 3 {
+				units := make([]uint16, len(raw)/2)
+				for j := range units {
+					units[j] = binary.BigEndian.Uint16(raw[2*j:])
+				}
+				value = string(utf16.Decode(units))
+			}
+			value = strings.Map(func(r rune) rune {
+				if r <= ' ' || r > '~' || strings.ContainsRune("[](){}<>/%", r) {
+					return -1
+				}
+				return r
+			}, value)
+			if value != "" {
+				return value
+			}
+		}
+	}
+	return "EmbeddedFont"
+}
+
+// glyph returns the glyph of a rune; 0 is the missing glyph
+func (f *Face) glyph(r rune) uint16 {
+	return f.cmap[r]
+}
+
+// advance returns the advance width of a glyph in 1/1000 em
+func (f *Face) advance(gid uint16) float64 {
+	if int(gid) >= len(f.advances) {
+		return 0
+	}
+	return float64(f.advances[gid]) * 1000 / f.unitsPerEm
+}
+
+// glyphData returns the glyf data of a glyph
+func (f *Face) glyphData(gid uint16) []byte {
+	loca, glyf := f.tables["loca"], f.tables["glyf"]
+	longOffsets := binary.BigEndian.Uint16(f.tables["head"][50:]) == 1
+	var start, end int
+	if longOffsets {
+		if len(loca) < 4*int(gid)+8 {
+			return nil
+		}
+		start, end = int(binary.BigEndian.Uint32(loca[4*int(gid):])), int(binary.BigEndian.Uint32(loca[4*int(gid)+4:]))
+	} else {
+		if len(loca) < 2*int(gid)+4 {
+			return nil
+		}
+		start, end = 2*int(binary.BigEndian.Uint16(loca[2*int(gid):])), 2*int(binary.BigEndian.Uint16(loca[2*int(gid)+2:]))
+	}
+	if start >= end || end > len(glyf) {
+		return nil
+	}
+	return glyf[start:end]
+}
+
+// components returns the glyphs a composite glyph is built from
+func components(data []byte) []uint16 {
+	if len(data) < 10 || int16(binary.BigEndian.Uint16(data)) >= 0 {
+		return nil
+	}
+	const (
+		argsAreWords = 0x0001
+		haveScale    = 0x0008
+		moreFollow   = 0x0020
+		haveXYScale  = 0x0040
+		haveTwoByTwo = 0x0080
+	)
+	var gids []uint16
+	for at := 10; at+4 <= len(data); {
+		flags := binary.BigEndian.Uint16(data[at:])
+		gids = append(gids, binary.BigEndian.Uint16(data[at+2:]))
+		at += 4
+		if flags&argsAreWords != 0 {
+			at += 4
+		} else {
+			at += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			at += 2
+		case flags&haveXYScale != 0:
+			at += 4
+		case flags&haveTwoByTwo != 0:
+			at += 8
+		}
+		if flags&moreFollow == 0 {
+			break
+		}
+	}
+	return gids
+}
+
+// subset returns a font program with the outlines of the given glyphs only. Glyph ids are
+// kept so text can address glyphs by their ids.
+func (f *Face) subset(used map[uint16]bool) []byte {
+	keep := map[uint16]bool{0: true}
+	pending := make([]uint16, 0, len(used))
+	for gid := range used {
+		pending = append(pending, gid)
+	}
+	for len(pending) > 0 {
+		gid := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if keep[gid] || int(gid) >= len(f.advances) {
+			continue
+		}
+		keep[gid] = true
+		pending = append(pending, components(f.glyphData(gid))...)
+	}
+
+	numGlyphs := len(f.advances)
+	var glyf []byte
+	loca := make([]byte, 4*(numGlyphs+1))
+	for gid := 0; gid < numGlyphs; gid++ {
+		binary.BigEndian.PutUint32(loca[4*gid:], uint32(len(glyf)))
+		if keep[uint16(gid)] {
+			glyf = append(glyf, f.glyphData(uint16(gid))...)
+			for len(glyf)%4 != 0 {
+				glyf = append(glyf, 0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(loca[4*numGlyphs:], uint32(len(glyf)))
+
+	head := append([]byte(nil), f.tables["head"]...)
+	binary.BigEndian.PutUint32(head[8:], 0)  // checkSumAdjustment
+	binary.BigEndian.PutUint16(head[50:], 1) // long loca offsets
+
+	tables := map[string][]byte{"head": head, "loca": loca, "glyf": glyf}
+	for _, tag := range []string{"hhea", "hmtx", "maxp", "cvt ", "fpgm", "prep"} {
+		if table, ok := f.tables[tag]; ok {
+			tables[tag] = table
+		}
+	}
+	return writeSfnt(tables)
+}
+
+// writeSfnt writes tables as a TrueType font file
+func writeSfnt(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	n := len(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	out := make([]byte, 12+16*n)
+	binary.BigEndian.PutUint32(out, 0x00010000)
+	binary.BigEndian.PutUint16(out[4:], uint16(n))
+	binary.BigEndian.PutUint16(out[6:], uint16(16<<entrySelector))
+	binary.BigEndian.PutUint16(out[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(out[10:], uint16(16*n-16<<entrySelector))
+	head := -1
+	for i, tag := range tags {
+		table := tables[tag]
+		record := out[12+16*i:]
+		copy(record, tag)
+		binary.BigEndian.PutUint32(record[4:], tableChecksum(table))
+		binary.BigEndian.PutUint32(record[8:], uint32(len(out)))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(table)))
+		if tag == "head" {
+			head = len(out)
+		}
+		out = append(out, table...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	if head >= 0 {
+		binary.BigEndian.PutUint32(out[head+8:], 0xB1B0AFBA-tableChecksum(out))
+	}
+	return out
+}
+
+func tableChecksum(table []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(table); i += 4 {
+		var word [4]byte
+		copy(word[:], table[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
@@ -0,0 +1,155 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// writer writes the numbered objects of a PDF file and remembers where each starts
+type writer struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+// reserve returns the number of the next object without writing it
+func (w *writer) reserve() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+// object writes object n with a dictionary or other value
+func (w *writer) object(n int, value string) {
+	w.offsets[n-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", n, value)
+}
+
+// stream writes object n as a stream with the dictionary entries in dict
+func (w *writer) stream(n int, dict string, data []byte) {
+	w.offsets[n-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", n, dict, len(data))
+	w.buf.Write(data)
+	w.buf.WriteString("\nendstream\nendobj\n")
+}
+
+// textString encodes text as a UTF-16 PDF text string
+func textString(text string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(text)) {
+		fmt.Fprintf(&b, "%04X", unit)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// Bytes lays out the headers and footers and returns the document as a PDF file. A
+// document without content has one empty page.
+func (d *Document) Bytes() ([]byte, error) {
+	if len(d.pages) == 0 {
+		d.newPage()
+	}
+	d.decorate()
+
+	w := &writer{}
+	w.buf.WriteString("%PDF-1.7\n%\xE2\xE3\xCF\xD3\n")
+	catalog, pages, info := w.reserve(), w.reserve(), w.reserve()
+
+	var resources strings.Builder
+	resources.WriteString("<< /ProcSet [/PDF /Text /ImageB /ImageC] /Font <<")
+	for i, f := range d.fonts {
+		n, err := d.writeFont(w, f)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&resources, " /F%d %d 0 R", i+1, n)
+	}
+	resources.WriteString(" >> /XObject <<")
+	for i, img := range d.images {
+		fmt.Fprintf(&resources, " /Im%d %d 0 R", i+1, writeImage(w, img))
+	}
+	resources.WriteString(" >> >>")
+
+	kids := make([]string, len(d.pages))
+	for i, p := range d.pages {
+		content := w.reserve()
+		w.stream(content, "/Filter /FlateDecode", deflate(p.content.Bytes()))
+		n := w.reserve()
+		w.object(n, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources %s /Contents %d 0 R >>",
+			pages, d.width, d.height, resources.String(), content))
+		kids[i] = fmt.Sprintf("%d 0 R", n)
+	}
+	w.object(pages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	w.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pages))
+	w.object(info, fmt.Sprintf("<< /Title %s /Author %s /Producer (gobi) /CreationDate (D:%s) >>",
+		textString(d.title), textString(d.author), d.created.UTC().Format("20060102150405Z")))
+
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(w.offsets)+1, catalog, info, xref)
+	return w.buf.Bytes(), nil
+}
+
+// writeFont writes a font and returns the number of its font dictionary
+func (d *Document) writeFont(w *writer, f font) (int, error) {
+	switch f := f.(type) {
+	case *standardFont:
+		n := w.reserve()
+		w.object(n, fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", f.name))
+		return n, nil
+	case *embeddedFont:
+		used := make(map[uint16]bool, len(f.used))
+		for gid := range f.used {
+			used[gid] = true
+		}
+		program := f.face.subset(used)
+		name := f.subsetTag() + "+" + f.face.name
+
+		file := w.reserve()
+		w.stream(file, fmt.Sprintf("/Filter /FlateDecode /Length1 %d", len(program)), deflate(program))
+
+		flags := 32
+		if f.face.fixedPitch {
+			flags |= 1
+		}
+		descriptor := w.reserve()
+		w.object(descriptor, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags %d /FontBBox [%.0f %.0f %.0f %.0f] "+
+			"/ItalicAngle %.1f /Ascent %.0f /Descent %.0f /CapHeight %.0f /StemV 80 /FontFile2 %d 0 R >>",
+			name, flags, f.face.bbox[0], f.face.bbox[1], f.face.bbox[2], f.face.bbox[3],
+			f.face.italic, f.face.ascent, f.face.descent, f.face.capHeight, file))
+
+		cid := w.reserve()
+		w.object(cid, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s "+
+			"/CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> "+
+			"/FontDescriptor %d 0 R /DW 1000 /W %s /CIDToGIDMap /Identity >>", name, descriptor, f.widthsArray()))
+
+		toUnicode := w.reserve()
+		w.stream(toUnicode, "/Filter /FlateDecode", deflate([]byte(f.toUnicode())))
+
+		n := w.reserve()
+		w.object(n, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H "+
+			"/DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>", name, cid, toUnicode))
+		return n, nil
+	}
+	return 0, fmt.Errorf("unknown font %T", f)
+}
+
+// writeImage writes an image and its soft mask and returns the number of the image
+func writeImage(w *writer, img *image) int {
+	mask := ""
+	if img.alpha != nil {
+		n := w.reserve()
+		w.stream(n, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray "+
+			"/BitsPerComponent 8 /Filter /FlateDecode", img.width, img.height), img.alpha)
+		mask = fmt.Sprintf(" /SMask %d 0 R", n)
+	}
+	n := w.reserve()
+	w.stream(n, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s "+
+		"/BitsPerComponent 8 /Filter /%s%s", img.width, img.height, img.colorSpace, img.filter, mask), img.data)
+	return n
+}
@@ -0,0 +1,358 @@
+package render
+
+import (
+	"image/color"
+	"math"
+)
+
+// series is a named set of values indexed by category
+type series struct {
+	name   string
+	values []float64
+	set    []bool
+}
+
+// categorySeries groups rows by x category and series, summing duplicate points.
+// Categories and series keep the order in which they first appear.
+func categorySeries(rows []map[string]interface{}) ([]string, []*series) {
+	var categories []string
+	catIndex := map[string]int{}
+	var list []*series
+	byName := map[string]*series{}
+
+	for _, row := range rows {
+		x := label(row["x"])
+		if _, ok := catIndex[x]; !ok {
+			catIndex[x] = len(categories)
+			categories = append(categories, x)
+		}
+		name := label(row["series"])
+		if _, ok := byName[name]; !ok {
+			byName[name] = &series{name: name}
+			list = append(list, byName[name])
+		}
+	}
+	for _, sr := range list {
+		sr.values = make([]float64, len(categories))
+		sr.set = make([]bool, len(categories))
+	}
+	for _, row := range rows {
+		y, ok := toFloat(row["y"])
+		if !ok {
+			continue
+		}
+		sr := byName[label(row["series"])]
+		i := catIndex[label(row["x"])]
+		sr.values[i] += y
+		sr.set[i] = true
+	}
+	return categories, list
+}
+
+func seriesNames(list []*series) []string {
+	names := make([]string, len(list))
+	for i, sr := range list {
+		names[i] = sr.name
+	}
+	return names
+}
+
+// niceScale extends [lo, hi] to round tick values, returning the bounds and tick step
+func niceScale(lo, hi float64, ticks int) (float64, float64, float64) {
+	if lo == hi {
+		if lo == 0 {
+			hi = 1
+		} else if lo > 0 {
+			lo = 0
+		} else {
+			hi = 0
+		}
+	}
+	rough := (hi - lo) / float64(ticks)
+	mag := math.Pow(10, math.Floor(math.Log10(rough)))
+	step := mag * 10
+	for _, m := range []float64{1, 2, 2.5, 5, 10} {
+		if rough <= m*mag {
+			step = m * mag
+			break
+		}
+	}
+	return math.Floor(lo/step) * step, math.Ceil(hi/step) * step, step
+}
+
+// frame is a plot area with a linear value scale on the y axis
+type frame struct {
+	plot   rect
+	lo, hi float64
+}
+
+func (f frame) y(v float64) float64 {
+	return f.plot.y1 - (v-f.lo)/(f.hi-f.lo)*f.plot.height()
+}
+
+// newFrame draws the legend, horizontal grid lines and y axis labels, and returns the
+// remaining plot area. The bottom of the area is left free for x axis labels.
+func newFrame(s *Scene, spec *Spec, area rect, names []string, lo, hi float64) frame {
+	area.y0 += drawLegend(s, spec, names, area.y0+6)
+	lo, hi, step := niceScale(lo, hi, 5)
+
+	labelWidth := 0.0
+	for v := lo; v <= hi+step/2; v += step {
+		labelWidth = math.Max(labelWidth, textWidth(formatNumber(v), 11))
+	}
+	f := frame{plot: rect{area.x0 + labelWidth + 8, area.y0 + 8, area.x1 - 8, area.y1 - 22}, lo: lo, hi: hi}
+	for v := lo; v <= hi+step/2; v += step {
+		y := f.y(v)
+		s.Line(f.plot.x0, y, f.plot.x1, y, gridColor, 1)
+		s.Text(f.plot.x0-6, y, formatNumber(v), 11, mutedColor, AnchorEnd)
+	}
+	return f
+}
+
+// drawCategoryAxis draws the x axis line and category labels centered in equal slots,
+// skipping labels when they would overlap
+func drawCategoryAxis(s *Scene, f frame, categories []string) {
+	zero := f.y(math.Max(f.lo, math.Min(0, f.hi)))
+	s.Line(f.plot.x0, zero, f.plot.x1, zero, axisColor, 1)
+	if len(categories) == 0 {
+		return
+	}
+	slot := f.plot.width() / float64(len(categories))
+	widest := 0.0
+	for _, c := range categories {
+		widest = math.Max(widest, textWidth(c, 11))
+	}
+	every := int(math.Ceil((math.Min(widest, 120) + 8) / slot))
+	if every < 1 {
+		every = 1
+	}
+	for i, c := range categories {
+		if i%every != 0 {
+			continue
+		}
+		x := f.plot.x0 + slot*(float64(i)+0.5)
+		s.Text(x, f.plot.y1+12, truncate(c, slot*float64(every)-4, 11), 11, mutedColor, AnchorMiddle)
+	}
+}
+
+// valueRange returns the extent of the values, stacked per category when stack is set
+func valueRange(list []*series, stack bool) (float64, float64) {
+	lo, hi := 0.0, 0.0
+	if len(list) == 0 {
+		return lo, hi
+	}
+	for i := range list[0].values {
+		pos, neg := 0.0, 0.0
+		for _, sr := range list {
+			v := sr.values[i]
+			if stack {
+				if v >= 0 {
+					pos += v
+				} else {
+					neg += v
+				}
+				hi, lo = math.Max(hi, pos), math.Min(lo, neg)
+			} else {
+				hi, lo = math.Max(hi, v), math.Min(lo, v)
+			}
+		}
+	}
+	return lo, hi
+}
+
+func renderBar(s *Scene, spec *Spec, area rect) {
+	categories, list := categorySeries(spec.Rows)
+	stack := spec.optBool("stack", false)
+	lo, hi := valueRange(list, stack)
+	f := newFrame(s, spec, area, seriesNames(list), lo, hi)
+
+	slot := f.plot.width() / float64(len(categories))
+	group := slot * 0.7
+	barWidth := group / float64(len(list))
+	if stack {
+		barWidth = group
+	}
+	for i := range categories {
+		x := f.plot.x0 + slot*float64(i) + (slot-group)/2
+		pos, neg := 0.0, 0.0
+		for k, sr := range list {
+			if !sr.set[i] {
+				continue
+			}
+			v := sr.values[i]
+			base := 0.0
+			bx := x + barWidth*float64(k)
+			if stack {
+				bx = x
+				if v >= 0 {
+					base, pos = pos, pos+v
+				} else {
+					base, neg = neg, neg+v
+				}
+			}
+			top, bottom := f.y(base+v), f.y(base)
+			s.Rect(bx+0.5, top, barWidth-1, bottom-top, spec.color(k))
+		}
+	}
+	drawCategoryAxis(s, f, categories)
+}
+
+func renderLine(s *Scene, spec *Spec, area rect) {
+	categories, list := categorySeries(spec.Rows)
+	lo, hi := valueRange(list, false)
+	f := newFrame(s, spec, area, seriesNames(list), lo, hi)
+	slot := f.plot.width() / float64(len(categories))
+	step := spec.optBool("step", false)
+
+	for k, sr := range list {
+		var points []Point
+		for i := range categories {
+			if !sr.set[i] {
+				continue
+			}
+			p := Point{f.plot.x0 + slot*(float64(i)+0.5), f.y(sr.values[i])}
+			if step && len(points) > 0 {
+				points = append(points, Point{p.X, points[len(points)-1].Y})
+			}
+			points = append(points, p)
+		}
+		s.Polyline(points, spec.color(k), 2)
+		for i := range categories {
+			if sr.set[i] {
+				s.Circle(f.plot.x0+slot*(float64(i)+0.5), f.y(sr.values[i]), 3, spec.color(k))
+			}
+		}
+	}
+	drawCategoryAxis(s, f, categories)
+}
+
+func renderArea(s *Scene, spec *Spec, area rect) {
+	categories, list := categorySeries(spec.Rows)
+	stack := spec.optBool("stack", false)
+	lo, hi := valueRange(list, stack)
+	f := newFrame(s, spec, area, seriesNames(list), lo, hi)
+	slot := f.plot.width() / float64(len(categories))
+
+	base := make([]float64, len(categories))
+	for k, sr := range list {
+		top := make([]Point, len(categories))
+		bottom := make([]Point, len(categories))
+		for i := range categories {
+			x := f.plot.x0 + slot*(float64(i)+0.5)
+			b := 0.0
+			if stack {
+				b = base[i]
+			}
+			bottom[i] = Point{x, f.y(b)}
+			top[i] = Point{x, f.y(b + sr.values[i])}
+			if stack {
+				base[i] += sr.values[i]
+			}
+		}
+		outline := append([]Point{}, top...)
+		for i := len(bottom) - 1; i >= 0; i-- {
+			outline = append(outline, bottom[i])
+		}
+		s.Polygon(outline, withAlpha(spec.color(k), 0x66))
+		s.Polyline(top, spec.color(k), 2)
+	}
+	drawCategoryAxis(s, f, categories)
+}
+
+func renderWaterfall(s *Scene, spec *Spec, area rect) {
+	categories, list := categorySeries(spec.Rows)
+	steps := list[0].values
+	showTotal := spec.optBool("showTotal", true)
+
+	lo, hi, running := 0.0, 0.0, 0.0
+	for _, v := range steps {
+		running += v
+		lo, hi = math.Min(lo, running), math.Max(hi, running)
+	}
+	if showTotal {
+		categories = append(categories, "Total")
+	}
+	f := newFrame(s, spec, area, nil, lo, hi)
+
+	increase := color.NRGBA{0x91, 0xcc, 0x75, 0xff}
+	decrease := color.NRGBA{0xee, 0x66, 0x66, 0xff}
+	slot := f.plot.width() / float64(len(categories))
+	barWidth := slot * 0.6
+	running = 0
+	for i, v := range steps {
+		x := f.plot.x0 + slot*float64(i) + (slot-barWidth)/2
+		fill := increase
+		if v < 0 {
+			fill = decrease
+		}
+		s.Rect(x, f.y(running), barWidth, f.y(running+v)-f.y(running), fill)
+		if i > 0 {
+			s.Line(x-(slot-barWidth), f.y(running), x, f.y(running), axisColor, 1)
+		}
+		running += v
+	}
+	if showTotal {
+		x := f.plot.x0 + slot*float64(len(steps)) + (slot-barWidth)/2
+		s.Line(x-(slot-barWidth), f.y(running), x, f.y(running), axisColor, 1)
+		s.Rect(x, f.y(0), barWidth, f.y(running)-f.y(0), spec.color(0))
+	}
+	drawCategoryAxis(s, f, categories)
+}
+
+func renderScatter(s *Scene, spec *Spec, area rect) {
+	type point struct{ x, y, size float64 }
+	var names []string
+	groups := map[string][]point{}
+	xlo, xhi := math.Inf(1), math.Inf(-1)
+	ylo, yhi := math.Inf(1), math.Inf(-1)
+	slo, shi := math.Inf(1), math.Inf(-1)
+	for _, row := range spec.Rows {
+		x, okx := toFloat(row["x"])
+		y, oky := toFloat(row["y"])
+		if !okx || !oky {
+			continue
+		}
+		size, hasSize := toFloat(row["size"])
+		if hasSize {
+			slo, shi = math.Min(slo, size), math.Max(shi, size)
+		} else {
+			size = math.NaN()
+		}
+		name := label(row["series"])
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], point{x, y, size})
+		xlo, xhi = math.Min(xlo, x), math.Max(xhi, x)
+		ylo, yhi = math.Min(ylo, y), math.Max(yhi, y)
+	}
+	if len(names) == 0 {
+		s.Text(float64(spec.Width)/2, (area.y0+area.y1)/2, "No data", 14, mutedColor, AnchorMiddle)
+		return
+	}
+
+	// Pad both axes so points at the extremes are not cut by the plot edge
+	xpad, ypad := (xhi-xlo)*0.05, (yhi-ylo)*0.05
+	f := newFrame(s, spec, area, names, ylo-ypad, yhi+ypad)
+	xlo, xhi, xstep := niceScale(xlo-xpad, xhi+xpad, 6)
+	xpos := func(v float64) float64 { return f.plot.x0 + (v-xlo)/(xhi-xlo)*f.plot.width() }
+	for v := xlo; v <= xhi+xstep/2; v += xstep {
+		s.Line(xpos(v), f.plot.y0, xpos(v), f.plot.y1, gridColor, 1)
+		s.Text(xpos(v), f.plot.y1+12, formatNumber(v), 11, mutedColor, AnchorMiddle)
+	}
+	s.Line(f.plot.x0, f.plot.y1, f.plot.x1, f.plot.y1, axisColor, 1)
+
+	symbol := spec.optFloat("symbolSize", 10)
+	for k, name := range names {
+		for _, p := range groups[name] {
+			d := symbol
+			if !math.IsNaN(p.size) {
+				d = 6
+				if shi > slo {
+					d += (p.size - slo) / (shi - slo) * 30
+				}
+			}
+			s.Circle(xpos(p.x), f.y(p.y), d/2, withAlpha(spec.color(k), 0xcc))
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package render
+
+import (
+	"math"
+	"sort"
+)
+
+func renderFunnel(s *Scene, spec *Spec, area rect) {
+	stages := nameValues(spec.Rows)
+	switch spec.optString("sort", "descending") {
+	case "descending":
+		sort.SliceStable(stages, func(i, j int) bool { return stages[i].value > stages[j].value })
+	case "ascending":
+		sort.SliceStable(stages, func(i, j int) bool { return stages[i].value < stages[j].value })
+	}
+	if len(stages) == 0 {
+		s.Text(float64(spec.Width)/2, (area.y0+area.y1)/2, "No data", 14, mutedColor, AnchorMiddle)
+		return
+	}
+	max := 0.0
+	for _, st := range stages {
+		max = math.Max(max, st.value)
+	}
+
+	area.y0 += drawLegend(s, spec, stageNames(stages), area.y0+6)
+	cx := (area.x0 + area.x1) / 2
+	fullWidth := area.width() * 0.8
+	height := (area.height() - 8) / float64(len(stages))
+	const gap = 2
+
+	for i, st := range stages {
+		top := area.y0 + 8 + height*float64(i)
+		w0 := fullWidth * st.value / max
+		// Each stage narrows to the width of the next; the last narrows to its own width
+		w1 := w0
+		if i+1 < len(stages) {
+			w1 = fullWidth * stages[i+1].value / max
+		}
+		s.Polygon([]Point{
+			{cx - w0/2, top},
+			{cx + w0/2, top},
+			{cx + w1/2, top + height - gap},
+			{cx - w1/2, top + height - gap},
+		}, spec.color(i))
+		s.Text(cx, top+(height-gap)/2, truncate(st.name+" "+formatNumber(st.value), math.Max(w0, 80), 12), 12, textColor, AnchorMiddle)
+	}
+}
+
+func stageNames(stages []slice) []string {
+	names := make([]string, len(stages))
+	for i, st := range stages {
+		names[i] = st.name
+	}
+	return names
+}
@@ -0,0 +1,95 @@
+package render
+
+import (
+	"image/color"
+	"math"
+)
+
+// heatmapScale is the blue to yellow to red color scale used by ECharts visual maps
+var heatmapScale = []color.NRGBA{
+	{0x31, 0x36, 0x95, 0xff},
+	{0xfe, 0xe0, 0x90, 0xff},
+	{0xa5, 0x00, 0x26, 0xff},
+}
+
+// scaleColor maps t in [0, 1] onto the heatmap color scale
+func scaleColor(t float64) color.NRGBA {
+	t = math.Max(0, math.Min(1, t)) * float64(len(heatmapScale)-1)
+	i := int(math.Min(math.Floor(t), float64(len(heatmapScale)-2)))
+	return mix(heatmapScale[i], heatmapScale[i+1], t-float64(i))
+}
+
+func renderHeatmap(s *Scene, spec *Spec, area rect) {
+	var xs, ys []string
+	xIndex, yIndex := map[string]int{}, map[string]int{}
+	type cell struct {
+		x, y  int
+		value float64
+	}
+	var cells []cell
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, row := range spec.Rows {
+		v, ok := toFloat(row["value"])
+		if !ok {
+			continue
+		}
+		x, y := label(row["x"]), label(row["y"])
+		if _, ok := xIndex[x]; !ok {
+			xIndex[x] = len(xs)
+			xs = append(xs, x)
+		}
+		if _, ok := yIndex[y]; !ok {
+			yIndex[y] = len(ys)
+			ys = append(ys, y)
+		}
+		cells = append(cells, cell{xIndex[x], yIndex[y], v})
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	if len(cells) == 0 {
+		s.Text(float64(spec.Width)/2, (area.y0+area.y1)/2, "No data", 14, mutedColor, AnchorMiddle)
+		return
+	}
+	lo = spec.optFloat("visualMin", lo)
+	hi = spec.optFloat("visualMax", hi)
+
+	labelWidth := 0.0
+	for _, y := range ys {
+		labelWidth = math.Max(labelWidth, math.Min(textWidth(y, 11), 120))
+	}
+	// Leave room at the bottom for the x labels and the color scale
+	plot := rect{area.x0 + labelWidth + 8, area.y0 + 8, area.x1 - 8, area.y1 - 48}
+	cw := plot.width() / float64(len(xs))
+	ch := plot.height() / float64(len(ys))
+
+	for _, c := range cells {
+		t := 0.5
+		if hi > lo {
+			t = (c.value - lo) / (hi - lo)
+		}
+		x := plot.x0 + cw*float64(c.x)
+		y := plot.y0 + ch*float64(c.y)
+		s.Rect(x+0.5, y+0.5, cw-1, ch-1, scaleColor(t))
+		if cw >= textWidth(formatNumber(c.value), 10)+4 && ch >= 14 {
+			fg := color.NRGBA{0xff, 0xff, 0xff, 0xff}
+			if t > 0.25 && t < 0.75 {
+				fg = textColor
+			}
+			s.Text(x+cw/2, y+ch/2, formatNumber(c.value), 10, fg, AnchorMiddle)
+		}
+	}
+	for i, y := range ys {
+		s.Text(plot.x0-6, plot.y0+ch*(float64(i)+0.5), truncate(y, 120, 11), 11, mutedColor, AnchorEnd)
+	}
+	drawCategoryAxis(s, frame{plot: plot, lo: 0, hi: 1}, xs)
+
+	// Color scale legend
+	const steps = 40
+	legendWidth := math.Min(240, plot.width())
+	lx := (plot.x0+plot.x1)/2 - legendWidth/2
+	ly := area.y1 - 12
+	for i := 0; i < steps; i++ {
+		s.Rect(lx+legendWidth*float64(i)/steps, ly-5, legendWidth/steps+0.5, 10, scaleColor(float64(i)/(steps-1)))
+	}
+	s.Text(lx-6, ly, formatNumber(lo), 11, mutedColor, AnchorEnd)
+	s.Text(lx+legendWidth+6, ly, formatNumber(hi), 11, mutedColor, AnchorStart)
+}
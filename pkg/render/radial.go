@@ -0,0 +1,121 @@
+package render
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// slice is a named positive value of a pie or funnel
+type slice struct {
+	name  string
+	value float64
+}
+
+// nameValues collects the rows with a positive value, summing duplicate names
+func nameValues(rows []map[string]interface{}) []slice {
+	var slices []slice
+	index := map[string]int{}
+	for _, row := range rows {
+		v, ok := toFloat(row["value"])
+		if !ok || v <= 0 {
+			continue
+		}
+		name := label(row["name"])
+		if i, ok := index[name]; ok {
+			slices[i].value += v
+			continue
+		}
+		index[name] = len(slices)
+		slices = append(slices, slice{name, v})
+	}
+	return slices
+}
+
+func renderPie(s *Scene, spec *Spec, area rect) {
+	slices := nameValues(spec.Rows)
+	total := 0.0
+	for _, sl := range slices {
+		total += sl.value
+	}
+	if total == 0 {
+		s.Text(float64(spec.Width)/2, (area.y0+area.y1)/2, "No data", 14, mutedColor, AnchorMiddle)
+		return
+	}
+
+	// The legend sits on the right when there is room for it
+	if spec.optBool("legend", true) && area.width() > 360 {
+		const size = 12
+		legendWidth := 0.0
+		for _, sl := range slices {
+			legendWidth = math.Max(legendWidth, 18+textWidth(fmt.Sprintf("%s %.1f%%", sl.name, 100*sl.value/total), size))
+		}
+		legendWidth = math.Min(legendWidth, area.width()/3)
+		y := (area.y0+area.y1)/2 - float64(len(slices))*10
+		for i, sl := range slices {
+			if y > area.y1-10 {
+				break
+			}
+			x := area.x1 - legendWidth
+			s.Rect(x, y-5, 12, 10, spec.color(i))
+			s.Text(x+18, y, truncate(fmt.Sprintf("%s %.1f%%", sl.name, 100*sl.value/total), legendWidth-18, size), size, textColor, AnchorStart)
+			y += 20
+		}
+		area.x1 -= legendWidth + 16
+	}
+
+	cx, cy := (area.x0+area.x1)/2, (area.y0+area.y1)/2
+	r := math.Min(area.width(), area.height())/2 - 24
+	r0 := r * math.Max(0, math.Min(99, spec.optFloat("innerRadius", 0))) / 100
+	angle := 0.0
+	for i, sl := range slices {
+		sweep := 360 * sl.value / total
+		s.Wedge(cx, cy, r0, r, angle, angle+sweep, spec.color(i))
+		if spec.optBool("showLabel", true) && sweep >= 12 {
+			p := polar(cx, cy, r+12, angle+sweep/2)
+			anchor := AnchorStart
+			if p.X < cx {
+				anchor = AnchorEnd
+			}
+			s.Text(p.X, p.Y, truncate(sl.name, 100, 11), 11, textColor, anchor)
+		}
+		angle += sweep
+	}
+}
+
+func renderGauge(s *Scene, spec *Spec, area rect) {
+	value, _ := toFloat(spec.Rows[0]["value"])
+	name := label(spec.Rows[0]["name"])
+	lo := spec.optFloat("min", 0)
+	hi := spec.optFloat("max", 100)
+	if hi <= lo {
+		hi = lo + 1
+	}
+	ratio := math.Max(0, math.Min(1, (value-lo)/(hi-lo)))
+
+	const start, sweep = -135.0, 270.0
+	cx, cy := (area.x0+area.x1)/2, area.y0+area.height()*0.55
+	r := math.Min(area.width()/2, area.height()*0.5) - 8
+	thickness := r * 0.15
+
+	s.Wedge(cx, cy, r-thickness, r, start, start+sweep, color.NRGBA{0xe6, 0xeb, 0xf8, 0xff})
+	s.Wedge(cx, cy, r-thickness, r, start, start+sweep*ratio, spec.color(0))
+	if target, ok := toFloat(spec.Options["target"]); ok {
+		a := start + sweep*math.Max(0, math.Min(1, (target-lo)/(hi-lo)))
+		p1, p2 := polar(cx, cy, r-thickness-4, a), polar(cx, cy, r+4, a)
+		s.Line(p1.X, p1.Y, p2.X, p2.Y, textColor, 2)
+	}
+	for i := 0; i <= 5; i++ {
+		a := start + sweep*float64(i)/5
+		p := polar(cx, cy, r-thickness-14, a)
+		s.Text(p.X, p.Y, formatNumber(lo+(hi-lo)*float64(i)/5), 11, mutedColor, AnchorMiddle)
+	}
+
+	needle := polar(cx, cy, r-thickness-4, start+sweep*ratio)
+	s.Line(cx, cy, needle.X, needle.Y, textColor, 3)
+	s.Circle(cx, cy, 6, textColor)
+
+	valueText := formatNumber(value) + spec.optString("unit", "")
+	s.Text(cx, cy+r*0.45, valueText, math.Max(14, r/6), textColor, AnchorMiddle)
+	s.Text(cx, cy+r*0.45+math.Max(14, r/6)+4, name, 12, mutedColor, AnchorMiddle)
+}
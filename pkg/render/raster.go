@@ -0,0 +1,189 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"sort"
+)
+
+// supersample is the oversampling factor used for anti-aliasing
+const supersample = 2
+
+// PNG rasterizes the scene and encodes it as PNG
+func (s *Scene) PNG() ([]byte, error) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, s.Rasterize()); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Rasterize draws the scene into an image. Shapes are drawn at twice the resolution
+// and box filtered down, which smooths edges without a full coverage rasterizer.
+func (s *Scene) Rasterize() *image.RGBA {
+	big := image.NewRGBA(image.Rect(0, 0, s.Width*supersample, s.Height*supersample))
+	bg := premultiply(s.Background)
+	for i := 0; i < len(big.Pix); i += 4 {
+		big.Pix[i], big.Pix[i+1], big.Pix[i+2], big.Pix[i+3] = bg.R, bg.G, bg.B, bg.A
+	}
+
+	for _, el := range s.elements {
+		switch e := el.(type) {
+		case polygon:
+			fillPolygon(big, scalePoints(e.points), e.fill)
+		case polyline:
+			strokePolyline(big, scalePoints(e.points), e.width*supersample, e.stroke)
+		case circle:
+			fillPolygon(big, circlePoints(e.center.X*supersample, e.center.Y*supersample, e.radius*supersample), e.fill)
+		case text:
+			drawText(big, e)
+		}
+	}
+
+	return downsample(big, s.Width, s.Height)
+}
+
+func scalePoints(points []Point) []Point {
+	scaled := make([]Point, len(points))
+	for i, p := range points {
+		scaled[i] = Point{p.X * supersample, p.Y * supersample}
+	}
+	return scaled
+}
+
+func circlePoints(cx, cy, r float64) []Point {
+	n := int(math.Max(16, r))
+	points := make([]Point, n)
+	for i := range points {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		points[i] = Point{cx + r*math.Cos(a), cy + r*math.Sin(a)}
+	}
+	return points
+}
+
+// fillPolygon fills a polygon with the even-odd rule, sampling pixel centers
+func fillPolygon(img *image.RGBA, points []Point, c color.NRGBA) {
+	if len(points) < 3 || c.A == 0 {
+		return
+	}
+	minY, maxY := points[0].Y, points[0].Y
+	for _, p := range points {
+		minY = math.Min(minY, p.Y)
+		maxY = math.Max(maxY, p.Y)
+	}
+	bounds := img.Bounds()
+	y0 := int(math.Max(math.Floor(minY), float64(bounds.Min.Y)))
+	y1 := int(math.Min(math.Ceil(maxY), float64(bounds.Max.Y-1)))
+
+	xs := make([]float64, 0, 8)
+	for y := y0; y <= y1; y++ {
+		cy := float64(y) + 0.5
+		xs = xs[:0]
+		for i := range points {
+			p1, p2 := points[i], points[(i+1)%len(points)]
+			if (p1.Y <= cy && p2.Y > cy) || (p2.Y <= cy && p1.Y > cy) {
+				xs = append(xs, p1.X+(cy-p1.Y)*(p2.X-p1.X)/(p2.Y-p1.Y))
+			}
+		}
+		sort.Float64s(xs)
+		for k := 0; k+1 < len(xs); k += 2 {
+			x0 := int(math.Ceil(xs[k] - 0.5))
+			x1 := int(math.Ceil(xs[k+1] - 0.5))
+			for x := x0; x < x1; x++ {
+				blend(img, x, y, c)
+			}
+		}
+	}
+}
+
+// strokePolyline draws each segment as a quad and rounds the joints
+func strokePolyline(img *image.RGBA, points []Point, width float64, c color.NRGBA) {
+	half := math.Max(width, 1) / 2
+	for i := 0; i+1 < len(points); i++ {
+		p1, p2 := points[i], points[i+1]
+		dx, dy := p2.X-p1.X, p2.Y-p1.Y
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -dy/length*half, dx/length*half
+		fillPolygon(img, []Point{{p1.X + nx, p1.Y + ny}, {p2.X + nx, p2.Y + ny}, {p2.X - nx, p2.Y - ny}, {p1.X - nx, p1.Y - ny}}, c)
+		if half >= 1.5 && i > 0 {
+			fillPolygon(img, circlePoints(p1.X, p1.Y, half), c)
+		}
+	}
+}
+
+// drawText draws text with the built-in bitmap font, centering each glyph in a slot of
+// the width textWidth allots to a character
+func drawText(img *image.RGBA, t text) {
+	scale := int(math.Max(1, math.Round(t.size*supersample/9)))
+	slot := t.size * charWidth * supersample
+	width := textWidth(t.value, t.size) * supersample
+	x := t.at.X*supersample - [...]float64{0, width / 2, width}[t.anchor] + (slot-float64(glyphWidth*scale))/2
+	y := t.at.Y*supersample - float64(glyphHeight*scale)/2
+
+	for _, r := range t.value {
+		rows := glyph(r)
+		for row := 0; row < glyphHeight; row++ {
+			for col := 0; col < glyphWidth; col++ {
+				if rows[row]&(1<<(glyphWidth-1-col)) == 0 {
+					continue
+				}
+				px, py := int(math.Round(x))+col*scale, int(math.Round(y))+row*scale
+				for dy := 0; dy < scale; dy++ {
+					for dx := 0; dx < scale; dx++ {
+						blend(img, px+dx, py+dy, t.fill)
+					}
+				}
+			}
+		}
+		x += slot
+	}
+}
+
+// blend composites a non-premultiplied color over a pixel
+func blend(img *image.RGBA, x, y int, c color.NRGBA) {
+	if !(image.Point{x, y}.In(img.Rect)) {
+		return
+	}
+	i := img.PixOffset(x, y)
+	src := premultiply(c)
+	inv := 255 - uint32(src.A)
+	img.Pix[i] = uint8(uint32(src.R) + uint32(img.Pix[i])*inv/255)
+	img.Pix[i+1] = uint8(uint32(src.G) + uint32(img.Pix[i+1])*inv/255)
+	img.Pix[i+2] = uint8(uint32(src.B) + uint32(img.Pix[i+2])*inv/255)
+	img.Pix[i+3] = uint8(uint32(src.A) + uint32(img.Pix[i+3])*inv/255)
+}
+
+func premultiply(c color.NRGBA) color.RGBA {
+	a := uint32(c.A)
+	return color.RGBA{uint8(uint32(c.R) * a / 255), uint8(uint32(c.G) * a / 255), uint8(uint32(c.B) * a / 255), c.A}
+}
+
+// downsample box filters the supersampled image to the output size
+func downsample(big *image.RGBA, width, height int) *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	const n = supersample * supersample
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sum [4]uint32
+			for dy := 0; dy < supersample; dy++ {
+				i := big.PixOffset(x*supersample, y*supersample+dy)
+				for dx := 0; dx < supersample; dx++ {
+					for k := 0; k < 4; k++ {
+						sum[k] += uint32(big.Pix[i+dx*4+k])
+					}
+				}
+			}
+			o := out.PixOffset(x, y)
+			for k := 0; k < 4; k++ {
+				out.Pix[o+k] = uint8(sum[k] / n)
+			}
+		}
+	}
+	return out
+}
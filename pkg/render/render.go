@@ -0,0 +1,339 @@
+// Package render draws charts server side as SVG or PNG without external dependencies.
+package render
+
+import (
+	"fmt"
+	"gobi/internal/models"
+	"gobi/pkg/charts"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is an output image format
+type Format string
+
+const (
+	FormatSVG Format = "svg"
+	FormatPNG Format = "png"
+)
+
+// Size limits of a rendered image
+const (
+	DefaultWidth  = 800
+	DefaultHeight = 500
+	MinSize       = 100
+	MaxSize       = 4000
+	// MaxPixels bounds width×height: PNGs are drawn at supersample times both sides first,
+	// so a 4 MP image takes a 64 MB buffer
+	MaxPixels = 4_000_000
+)
+
+// maxConcurrentRenders bounds how many images are drawn at once, and so the memory
+// rasterization buffers can take
+const maxConcurrentRenders = 2
+
+var renderSlots = make(chan struct{}, maxConcurrentRenders)
+
+// ValidSize reports whether an image size is within the limits
+func ValidSize(width, height int) bool {
+	return width >= MinSize && width <= MaxSize && height >= MinSize && height <= MaxSize &&
+		width*height <= MaxPixels
+}
+
+// Spec is everything needed to draw a chart
+type Spec struct {
+	Type    string
+	Title   string
+	Width   int
+	Height  int
+	Rows    []map[string]interface{} // rows keyed by chart field name, as shaped by pkg/charts
+	Options map[string]interface{}   // chart configuration
+	Palette []color.NRGBA
+}
+
+// renderers draws the plot of each supported chart type inside the given area
+var renderers = map[string]func(s *Scene, spec *Spec, area rect){
+	"bar":       renderBar,
+	"line":      renderLine,
+	"area":      renderArea,
+	"scatter":   renderScatter,
+	"waterfall": renderWaterfall,
+	"pie":       renderPie,
+	"gauge":     renderGauge,
+	"funnel":    renderFunnel,
+	"heatmap":   renderHeatmap,
+}
+
+// defaultPalette matches the ECharts default series colors
+var defaultPalette = []color.NRGBA{
+	{0x54, 0x70, 0xc6, 0xff}, {0x91, 0xcc, 0x75, 0xff}, {0xfa, 0xc8, 0x58, 0xff},
+	{0xee, 0x66, 0x66, 0xff}, {0x73, 0xc0, 0xde, 0xff}, {0x3b, 0xa2, 0x72, 0xff},
+	{0xfc, 0x84, 0x52, 0xff}, {0x9a, 0x60, 0xb4, 0xff}, {0xea, 0x7c, 0xcc, 0xff},
+}
+
+var (
+	textColor  = color.NRGBA{0x33, 0x33, 0x33, 0xff}
+	mutedColor = color.NRGBA{0x6e, 0x70, 0x79, 0xff}
+	gridColor  = color.NRGBA{0xe0, 0xe6, 0xf1, 0xff}
+	axisColor  = color.NRGBA{0x6e, 0x70, 0x79, 0xff}
+)
+
+// rect is a plot area
+type rect struct {
+	x0, y0, x1, y1 float64
+}
+
+func (r rect) width() float64  { return r.x1 - r.x0 }
+func (r rect) height() float64 { return r.y1 - r.y0 }
+
+// Supported reports whether a chart type can be rendered
+func Supported(chartType string) bool {
+	_, ok := renderers[chartType]
+	return ok
+}
+
+// ParseFormat validates an output format; an empty format means SVG
+func ParseFormat(format string) (Format, error) {
+	switch Format(strings.ToLower(format)) {
+	case "", FormatSVG:
+		return FormatSVG, nil
+	case FormatPNG:
+		return FormatPNG, nil
+	}
+	return "", fmt.Errorf("unsupported image format: %s", format)
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format Format) string {
+	if format == FormatPNG {
+		return "image/png"
+	}
+	return "image/svg+xml"
+}
+
+// NewSpec builds a render spec from a chart and its shaped data rows
+func NewSpec(chart *models.Chart, rows []map[string]interface{}, width, height int) (*Spec, error) {
+	if !Supported(chart.Type) {
+		return nil, fmt.Errorf("rendering is not supported for chart type: %s", chart.Type)
+	}
+	if !ValidSize(width, height) {
+		return nil, fmt.Errorf("image size must be between %d and %d pixels and at most %d pixels in total", MinSize, MaxSize, MaxPixels)
+	}
+	cfg, err := charts.ParseConfig(chart.Config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chart configuration: %w", err)
+	}
+	if t, ok := charts.Lookup(chart.Type); ok {
+		cfg = t.Options(cfg)
+	}
+
+	spec := &Spec{
+		Type:    chart.Type,
+		Title:   chart.Name,
+		Width:   width,
+		Height:  height,
+		Rows:    rows,
+		Options: cfg,
+		Palette: defaultPalette,
+	}
+	if title, ok := cfg["title"].(string); ok && title != "" {
+		spec.Title = title
+	}
+	if colors, ok := cfg["color"].([]interface{}); ok {
+		var palette []color.NRGBA
+		for _, c := range colors {
+			if s, ok := c.(string); ok {
+				if parsed, ok := parseHexColor(s); ok {
+					palette = append(palette, parsed)
+				}
+			}
+		}
+		if len(palette) > 0 {
+			spec.Palette = palette
+		}
+	}
+	return spec, nil
+}
+
+// Render draws a chart in the requested format. At most maxConcurrentRenders images are
+// drawn at once; other calls wait for a slot.
+func Render(spec *Spec, format Format) ([]byte, error) {
+	draw, ok := renderers[spec.Type]
+	if !ok {
+		return nil, fmt.Errorf("rendering is not supported for chart type: %s", spec.Type)
+	}
+	if !ValidSize(spec.Width, spec.Height) {
+		return nil, fmt.Errorf("image size %dx%d is out of range", spec.Width, spec.Height)
+	}
+	renderSlots <- struct{}{}
+	defer func() { <-renderSlots }()
+
+	scene := NewScene(spec.Width, spec.Height)
+	area := rect{16, 16, float64(spec.Width) - 16, float64(spec.Height) - 16}
+	if spec.Title != "" {
+		scene.Text(float64(spec.Width)/2, 22, spec.Title, 16, textColor, AnchorMiddle)
+		area.y0 = 44
+	}
+	if len(spec.Rows) == 0 {
+		scene.Text(float64(spec.Width)/2, (area.y0+area.y1)/2, "No data", 14, mutedColor, AnchorMiddle)
+	} else {
+		draw(scene, spec, area)
+	}
+
+	if format == FormatPNG {
+		return scene.PNG()
+	}
+	return scene.SVG(), nil
+}
+
+// color returns the palette color of series i
+func (spec *Spec) color(i int) color.NRGBA {
+	return spec.Palette[i%len(spec.Palette)]
+}
+
+func (spec *Spec) optBool(key string, def bool) bool {
+	if v, ok := spec.Options[key].(bool); ok {
+		return v
+	}
+	return def
+}
+
+func (spec *Spec) optFloat(key string, def float64) float64 {
+	if v, ok := toFloat(spec.Options[key]); ok {
+		return v
+	}
+	return def
+}
+
+func (spec *Spec) optString(key string, def string) string {
+	if v, ok := spec.Options[key].(string); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// toFloat converts numeric row values to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	case []byte:
+		f, err := strconv.ParseFloat(string(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// label formats a row value for display
+func label(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return n
+	case []byte:
+		return string(n)
+	case time.Time:
+		if n.Hour() == 0 && n.Minute() == 0 && n.Second() == 0 {
+			return n.Format("2006-01-02")
+		}
+		return n.Format("2006-01-02 15:04")
+	}
+	if f, ok := toFloat(v); ok {
+		return formatNumber(f)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// formatNumber prints a number compactly, abbreviating thousands and millions
+func formatNumber(f float64) string {
+	abs := math.Abs(f)
+	switch {
+	case abs >= 1e9:
+		return trimFloat(f/1e9) + "B"
+	case abs >= 1e6:
+		return trimFloat(f/1e6) + "M"
+	case abs >= 1e4:
+		return trimFloat(f/1e3) + "K"
+	}
+	return trimFloat(f)
+}
+
+func trimFloat(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
+
+// truncate shortens a label to fit width at the given font size
+func truncate(s string, width, size float64) string {
+	if textWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	max := int(width/(size*charWidth)) - 2
+	if max < 1 {
+		return ""
+	}
+	if max > len(runes) {
+		max = len(runes)
+	}
+	return string(runes[:max]) + ".."
+}
+
+func parseHexColor(s string) (color.NRGBA, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return color.NRGBA{}, false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, true
+}
+
+// withAlpha returns c with alpha a
+func withAlpha(c color.NRGBA, a uint8) color.NRGBA {
+	c.A = a
+	return c
+}
+
+// mix interpolates between two colors
+func mix(a, b color.NRGBA, t float64) color.NRGBA {
+	t = math.Max(0, math.Min(1, t))
+	lerp := func(x, y uint8) uint8 { return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t)) }
+	return color.NRGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), lerp(a.A, b.A)}
+}
+
+// drawLegend draws series names in a centered row at y and returns the height used
+func drawLegend(s *Scene, spec *Spec, names []string, y float64) float64 {
+	if len(names) < 2 || !spec.optBool("legend", true) {
+		return 0
+	}
+	const size = 12
+	total := 0.0
+	for _, name := range names {
+		total += 18 + textWidth(name, size) + 14
+	}
+	x := (float64(spec.Width) - total) / 2
+	for i, name := range names {
+		s.Rect(x, y-5, 12, 10, spec.color(i))
+		s.Text(x+18, y, name, size, textColor, AnchorStart)
+		x += 18 + textWidth(name, size) + 14
+	}
+	return 24
+}
@@ -0,0 +1,189 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+)
+
+// Point is a position in scene coordinates, with the origin at the top left
+type Point struct {
+	X, Y float64
+}
+
+// Anchor is the horizontal alignment of text relative to its position
+type Anchor int
+
+const (
+	AnchorStart Anchor = iota
+	AnchorMiddle
+	AnchorEnd
+)
+
+type polygon struct {
+	points []Point
+	fill   color.NRGBA
+}
+
+type polyline struct {
+	points []Point
+	stroke color.NRGBA
+	width  float64
+}
+
+type circle struct {
+	center Point
+	radius float64
+	fill   color.NRGBA
+}
+
+type text struct {
+	at     Point // vertical middle of the text
+	value  string
+	size   float64
+	fill   color.NRGBA
+	anchor Anchor
+}
+
+// Scene is a list of vector primitives shared by the SVG and PNG backends, so both
+// formats render the same picture
+type Scene struct {
+	Width      int
+	Height     int
+	Background color.NRGBA
+	elements   []interface{}
+}
+
+// NewScene creates an empty scene with a white background
+func NewScene(width, height int) *Scene {
+	return &Scene{Width: width, Height: height, Background: color.NRGBA{255, 255, 255, 255}}
+}
+
+// Polygon adds a filled polygon
+func (s *Scene) Polygon(points []Point, fill color.NRGBA) {
+	if len(points) >= 3 {
+		s.elements = append(s.elements, polygon{points: points, fill: fill})
+	}
+}
+
+// Rect adds a filled rectangle; negative sizes are normalized
+func (s *Scene) Rect(x, y, w, h float64, fill color.NRGBA) {
+	if w < 0 {
+		x, w = x+w, -w
+	}
+	if h < 0 {
+		y, h = y+h, -h
+	}
+	s.Polygon([]Point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}, fill)
+}
+
+// Line adds a straight line
+func (s *Scene) Line(x1, y1, x2, y2 float64, stroke color.NRGBA, width float64) {
+	s.Polyline([]Point{{x1, y1}, {x2, y2}}, stroke, width)
+}
+
+// Polyline adds an open line through the points
+func (s *Scene) Polyline(points []Point, stroke color.NRGBA, width float64) {
+	if len(points) >= 2 {
+		s.elements = append(s.elements, polyline{points: points, stroke: stroke, width: width})
+	}
+}
+
+// Circle adds a filled circle
+func (s *Scene) Circle(x, y, r float64, fill color.NRGBA) {
+	s.elements = append(s.elements, circle{center: Point{x, y}, radius: r, fill: fill})
+}
+
+// Text adds a single line of text vertically centered on y
+func (s *Scene) Text(x, y float64, value string, size float64, fill color.NRGBA, anchor Anchor) {
+	if value != "" {
+		s.elements = append(s.elements, text{at: Point{x, y}, value: value, size: size, fill: fill, anchor: anchor})
+	}
+}
+
+// Wedge adds an annular sector between radii r0 and r1. Angles are in degrees,
+// clockwise from twelve o'clock.
+func (s *Scene) Wedge(cx, cy, r0, r1, a0, a1 float64, fill color.NRGBA) {
+	steps := int(math.Ceil(math.Abs(a1-a0)/2)) + 1
+	points := make([]Point, 0, 2*steps+2)
+	for i := 0; i <= steps; i++ {
+		points = append(points, polar(cx, cy, r1, a0+(a1-a0)*float64(i)/float64(steps)))
+	}
+	if r0 <= 0 {
+		points = append(points, Point{cx, cy})
+	} else {
+		for i := steps; i >= 0; i-- {
+			points = append(points, polar(cx, cy, r0, a0+(a1-a0)*float64(i)/float64(steps)))
+		}
+	}
+	s.Polygon(points, fill)
+}
+
+// polar returns the point at radius r and angle a (degrees clockwise from twelve o'clock)
+func polar(cx, cy, r, a float64) Point {
+	rad := a * math.Pi / 180
+	return Point{cx + r*math.Sin(rad), cy - r*math.Cos(rad)}
+}
+
+// charWidth is the advance of one character relative to the font size. It is generous
+// for proportional fonts so that text measured with it is never wider than estimated.
+const charWidth = 0.7
+
+// textWidth estimates the rendered width of text; both backends lay text out to this estimate
+func textWidth(value string, size float64) float64 {
+	return float64(len([]rune(value))) * size * charWidth
+}
+
+// SVG serializes the scene as an SVG document
+func (s *Scene) SVG() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, s.Width, s.Height, s.Width, s.Height)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" %s/>`, svgPaint("fill", s.Background))
+	for _, el := range s.elements {
+		switch e := el.(type) {
+		case polygon:
+			fmt.Fprintf(&b, `<polygon points="%s" %s/>`, svgPoints(e.points), svgPaint("fill", e.fill))
+		case polyline:
+			fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke-width="%s" stroke-linejoin="round" %s/>`,
+				svgPoints(e.points), svgNum(e.width), svgPaint("stroke", e.stroke))
+		case circle:
+			fmt.Fprintf(&b, `<circle cx="%s" cy="%s" r="%s" %s/>`, svgNum(e.center.X), svgNum(e.center.Y), svgNum(e.radius), svgPaint("fill", e.fill))
+		case text:
+			anchor := [...]string{"start", "middle", "end"}[e.anchor]
+			fmt.Fprintf(&b, `<text x="%s" y="%s" font-family="sans-serif" font-size="%s" text-anchor="%s" dominant-baseline="middle" %s>`,
+				svgNum(e.at.X), svgNum(e.at.Y), svgNum(e.size), anchor, svgPaint("fill", e.fill))
+			xml.EscapeText(&b, []byte(e.value))
+			b.WriteString(`</text>`)
+		}
+	}
+	b.WriteString(`</svg>`)
+	return b.Bytes()
+}
+
+func svgPoints(points []Point) string {
+	var b bytes.Buffer
+	for i, p := range points {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(svgNum(p.X))
+		b.WriteByte(',')
+		b.WriteString(svgNum(p.Y))
+	}
+	return b.String()
+}
+
+func svgNum(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
+
+func svgPaint(attr string, c color.NRGBA) string {
+	paint := fmt.Sprintf(`%s="#%02x%02x%02x"`, attr, c.R, c.G, c.B)
+	if c.A < 255 {
+		paint += fmt.Sprintf(` %s-opacity="%s"`, attr, svgNum(float64(c.A)/255))
+	}
+	return paint
+}
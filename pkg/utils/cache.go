@@ -61,6 +61,31 @@ var (
 	appConfig            *config.Config
 )
 
+// QueryCacheListener is called with the key and value of every query result stored in the
+// cache. Listeners run on the storing goroutine and must not block.
+type QueryCacheListener func(key string, value interface{})
+
+var (
+	cacheListenersMu sync.RWMutex
+	cacheListeners   = make(map[int]QueryCacheListener)
+	nextListenerID   int
+)
+
+// AddQueryCacheListener registers a listener for stored query results and returns the
+// function that removes it
+func AddQueryCacheListener(listener QueryCacheListener) func() {
+	cacheListenersMu.Lock()
+	defer cacheListenersMu.Unlock()
+	id := nextListenerID
+	nextListenerID++
+	cacheListeners[id] = listener
+	return func() {
+		cacheListenersMu.Lock()
+		defer cacheListenersMu.Unlock()
+		delete(cacheListeners, id)
+	}
+}
+
 // InitQueryCache initializes the intelligent cache system
 func InitQueryCache(cfg *config.Config) {
 	appConfig = cfg
@@ -97,12 +122,18 @@ func GetQueryCache(key string) (interface{}, bool) {
 	return CacheManagerInstance.Get(key)
 }
 
-// SetQueryCache sets a value in cache with intelligent TTL and priority
+// SetQueryCache sets a value in cache with intelligent TTL and priority. Listeners are
+// notified of the fresh result even when caching is disabled.
 func SetQueryCache(key string, value interface{}, sql string) {
-	if CacheManagerInstance == nil {
-		return
+	if CacheManagerInstance != nil {
+		CacheManagerInstance.Set(key, value, sql)
+	}
+
+	cacheListenersMu.RLock()
+	defer cacheListenersMu.RUnlock()
+	for _, listener := range cacheListeners {
+		listener(key, value)
 	}
-	CacheManagerInstance.Set(key, value, sql)
 }
 
 // DeleteQueryCache deletes a value from cache
@@ -519,12 +550,7 @@ func GenerateCacheKey(datasourceID uint, sql string) string {
 func ExecuteSQL(ds models.DataSource, sqlStr string) ([]map[string]interface{}, error) {
 	sqlStr = SanitizeSQL(sqlStr)
 
-	db, err := database.GetConnection(&ds)
-	if err != nil {
-		return nil, errors.WrapError(err, "could not get database connection")
-	}
-
-	rows, err := db.Query(sqlStr)
+	rows, _, err := database.QueryWithFailover(context.Background(), &ds, sqlStr)
 	if err != nil {
 		return nil, errors.WrapError(err, "query execution failed")
 	}
@@ -600,7 +626,7 @@ func ExecuteSQLWithTimeout(ds models.DataSource, sqlStr string, timeout time.Dur
 // ExecuteSQLWithLimit executes SQL with a limit clause
 func ExecuteSQLWithLimit(ds models.DataSource, sqlStr string, limit int) ([]map[string]interface{}, error) {
 	if !containsLimit(sqlStr) {
-		sqlStr = addLimitClause(sqlStr, limit)
+		sqlStr = addLimitClause(ds.Type, sqlStr, limit)
 	}
 	return ExecuteSQL(ds, sqlStr)
 }
@@ -610,8 +636,11 @@ func containsLimit(sql string) bool {
 	return strings.Contains(strings.ToUpper(sql), "LIMIT")
 }
 
-// addLimitClause adds a LIMIT clause to SQL
-func addLimitClause(sql string, limit int) string {
+// addLimitClause limits SQL in the dialect of the data source type
+func addLimitClause(dsType, sql string, limit int) string {
+	if driver, err := database.GetDriver(dsType); err == nil {
+		return driver.LimitQuery(sql, limit)
+	}
 	return fmt.Sprintf("%s LIMIT %d", sql, limit)
 }
 
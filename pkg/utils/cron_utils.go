@@ -1,11 +1,26 @@
 package utils
 
 import (
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/robfig/cron/v3"
 )
 
+const (
+	// cronStarBit marks a day of month or day of week field given as * in a parsed pattern
+	cronStarBit = 1 << 63
+	// cronEveryHour is the hour field of a pattern that runs every hour
+	cronEveryHour = 1<<24 - 1
+	// cronSearchDays bounds the search for the next run, long enough for patterns such as
+	// 29 February on a Monday
+	cronSearchDays = 366 * 30
+)
+
+// cronParser parses the five field cron patterns of report schedules
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+
 // CronUtils provides common cron-related utilities
 type CronUtils struct{}
 
@@ -17,21 +32,179 @@ func NewCronUtils() *CronUtils {
 // CalculateNextRunFromCron calculates the next run time based on cron pattern
 // with customizable default time for error cases
 func (c *CronUtils) CalculateNextRunFromCron(cronPattern string, defaultTime time.Time) time.Time {
-	parser := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
-	schedule, err := parser.Parse(cronPattern)
+	return c.CalculateNextRunInZone(cronPattern, "", defaultTime)
+}
+
+// CalculateNextRunInZone calculates the next run time of a cron pattern evaluated in an
+// IANA time zone, the server's local zone when empty, with a default time for error cases
+func (c *CronUtils) CalculateNextRunInZone(cronPattern, zone string, defaultTime time.Time) time.Time {
+	loc, err := LoadScheduleLocation(zone)
 	if err != nil {
 		return defaultTime
 	}
-	return schedule.Next(time.Now())
+	runs, err := c.NextRuns(cronPattern, loc, time.Now(), 1)
+	if err != nil || len(runs) == 0 {
+		return defaultTime
+	}
+	return runs[0]
+}
+
+// NextRuns returns the next n run times of a cron pattern after a time, with the wall
+// clock of the pattern in loc. Across daylight saving changes, a time skipped by a
+// forward change runs when the clock jumps, and a time repeated by a backward change runs
+// once, at its first occurrence; patterns that run every hour run at both.
+func (c *CronUtils) NextRuns(cronPattern string, loc *time.Location, after time.Time, n int) ([]time.Time, error) {
+	schedule, err := cronParser.Parse(cronPattern)
+	if err != nil {
+		return nil, err
+	}
+	spec, ok := schedule.(*cron.SpecSchedule)
+	if !ok {
+		runs := make([]time.Time, 0, n)
+		for t := after; len(runs) < n; {
+			t = schedule.Next(t)
+			runs = append(runs, t.In(loc))
+		}
+		return runs, nil
+	}
+
+	runs := make([]time.Time, 0, n)
+	for len(runs) < n {
+		next, ok := nextSpecRun(spec, loc, after)
+		if !ok {
+			break
+		}
+		runs = append(runs, next)
+		after = next
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("cron pattern %q never runs", cronPattern)
+	}
+	return runs, nil
 }
 
 // ValidateCronPattern validates if a cron pattern is valid
 func (c *CronUtils) ValidateCronPattern(cronPattern string) error {
-	parser := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
-	_, err := parser.Parse(cronPattern)
+	_, err := cronParser.Parse(cronPattern)
 	return err
 }
 
+// LoadScheduleLocation returns the IANA time zone of a schedule; empty is the server's
+// local zone
+func LoadScheduleLocation(zone string) (*time.Location, error) {
+	if zone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil || zone == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", zone)
+	}
+	return loc, nil
+}
+
+// nextSpecRun returns the first run of a parsed pattern after a time, searching day by day
+// in loc. On a day the clock goes back, its runs are not in wall clock order, so the
+// earliest of the day is taken.
+func nextSpecRun(spec *cron.SpecSchedule, loc *time.Location, after time.Time) (time.Time, bool) {
+	local := after.In(loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+	everyHour := spec.Hour&cronEveryHour == cronEveryHour
+
+	for i := 0; i < cronSearchDays; i, day = i+1, day.AddDate(0, 0, 1) {
+		if !cronDayMatches(spec, day) {
+			continue
+		}
+		// Without a change of offset around the day, wall clock order is time order
+		steady := utcOffset(day.Add(-24*time.Hour), loc) == utcOffset(day.Add(48*time.Hour), loc)
+
+		var best time.Time
+		for hour := 0; hour < 24; hour++ {
+			if spec.Hour&(1<<uint(hour)) == 0 {
+				continue
+			}
+			for minute := 0; minute < 60; minute++ {
+				if spec.Minute&(1<<uint(minute)) == 0 {
+					continue
+				}
+				if steady {
+					t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+					if t.After(after) {
+						return t, true
+					}
+					continue
+				}
+				occurrences := wallClockInstants(day.Year(), day.Month(), day.Day(), hour, minute, loc)
+				if !everyHour && len(occurrences) > 1 {
+					occurrences = occurrences[:1]
+				}
+				for _, t := range occurrences {
+					if t.After(after) && (best.IsZero() || t.Before(best)) {
+						best = t
+					}
+				}
+			}
+		}
+		if !best.IsZero() {
+			return best, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// utcOffset returns the offset of loc in seconds at an instant
+func utcOffset(t time.Time, loc *time.Location) int {
+	_, offset := t.In(loc).Zone()
+	return offset
+}
+
+// cronDayMatches reports whether a pattern runs on a day. As in cron, a day matches
+// either the day of month or the day of week when both are restricted.
+func cronDayMatches(spec *cron.SpecSchedule, day time.Time) bool {
+	if spec.Month&(1<<uint(day.Month())) == 0 {
+		return false
+	}
+	domMatch := spec.Dom&(1<<uint(day.Day())) > 0
+	dowMatch := spec.Dow&(1<<uint(day.Weekday())) > 0
+	if spec.Dom&cronStarBit > 0 || spec.Dow&cronStarBit > 0 {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// wallClockInstants returns the instants at which the clock of loc shows a date and time,
+// in order: two when the clock goes back over it, and when it is skipped by the clock
+// going forward, the instant the clock jumps
+func wallClockInstants(year int, month time.Month, day, hour, minute int, loc *time.Location) []time.Time {
+	wall := time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+
+	// Try the offsets in force around the wall time; zones change at most once a day
+	var instants []time.Time
+	var latest time.Time
+	tried := map[int]bool{}
+	for _, probe := range []time.Time{wall.Add(-24 * time.Hour), wall, wall.Add(24 * time.Hour)} {
+		offset := utcOffset(probe, loc)
+		if tried[offset] {
+			continue
+		}
+		tried[offset] = true
+		t := wall.Add(-time.Duration(offset) * time.Second).In(loc)
+		if t.After(latest) {
+			latest = t
+		}
+		if t.Year() == year && t.Month() == month && t.Day() == day && t.Hour() == hour && t.Minute() == minute {
+			instants = append(instants, t)
+		}
+	}
+	if len(instants) == 0 {
+		// Skipped: read with the offset before the change, the wall time falls after it,
+		// in the zone that started when the clock jumped
+		start, _ := latest.ZoneBounds()
+		return []time.Time{start.In(loc)}
+	}
+	sort.Slice(instants, func(i, j int) bool { return instants[i].Before(instants[j]) })
+	return instants
+}
+
 // Global convenience functions
 var cronUtils = NewCronUtils()
 
@@ -40,6 +213,16 @@ func CalculateNextRunFromCron(cronPattern string, defaultTime time.Time) time.Ti
 	return cronUtils.CalculateNextRunFromCron(cronPattern, defaultTime)
 }
 
+// CalculateNextRunInZone is a convenience function using the global utils
+func CalculateNextRunInZone(cronPattern, zone string, defaultTime time.Time) time.Time {
+	return cronUtils.CalculateNextRunInZone(cronPattern, zone, defaultTime)
+}
+
+// NextCronRuns is a convenience function using the global utils
+func NextCronRuns(cronPattern string, loc *time.Location, after time.Time, n int) ([]time.Time, error) {
+	return cronUtils.NextRuns(cronPattern, loc, after, n)
+}
+
 // ValidateCronPattern is a convenience function using the global utils
 func ValidateCronPattern(cronPattern string) error {
 	return cronUtils.ValidateCronPattern(cronPattern)
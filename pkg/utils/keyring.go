@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"gobi/config"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvelopePrefix 信封加密密文前缀，格式为 enc:v1:<key id>:<base64 payload>
+const EnvelopePrefix = "enc:v1:"
+
+const (
+	dekSize        = 32
+	gcmNonceSize   = 12
+	gcmTagSize     = 16
+	wrappedDEKSize = gcmNonceSize + dekSize + gcmTagSize
+)
+
+// Keyring 加密密钥环，保存所有可用于解密的主密钥以及当前用于加密的主密钥
+type Keyring struct {
+	mu       sync.RWMutex
+	activeID string
+	keys     map[string][]byte
+}
+
+// keyFile 密钥文件格式
+type keyFile struct {
+	ActiveKeyID string            `yaml:"active_key_id" json:"active_key_id"`
+	Keys        map[string]string `yaml:"keys" json:"keys"`
+}
+
+var (
+	keyring   *Keyring
+	keyringMu sync.RWMutex
+)
+
+// InitKeyring 根据配置初始化全局密钥环；未配置任何密钥时保持旧的 DATA_SOURCE_SECRET 加密方式
+func InitKeyring(cfg *config.Config) error {
+	kr, err := NewKeyring(cfg.Encryption)
+	if err != nil {
+		return err
+	}
+	keyringMu.Lock()
+	keyring = kr
+	keyringMu.Unlock()
+	return nil
+}
+
+// GetKeyring 获取全局密钥环，未配置时返回 nil
+func GetKeyring() *Keyring {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	return keyring
+}
+
+// NewKeyring 从配置和密钥文件加载密钥环
+func NewKeyring(cfg config.EncryptionConfig) (*Keyring, error) {
+	encoded := make(map[string]string)
+	activeID := cfg.ActiveKeyID
+
+	if cfg.KeyFile != "" {
+		content, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		var kf keyFile
+		// YAML 是 JSON 的超集，两种格式的密钥文件都可以解析
+		if err := yaml.Unmarshal(content, &kf); err != nil {
+			return nil, fmt.Errorf("failed to parse key file: %w", err)
+		}
+		for id, key := range kf.Keys {
+			encoded[id] = key
+		}
+		if activeID == "" {
+			activeID = kf.ActiveKeyID
+		}
+	}
+	for id, key := range cfg.Keys {
+		encoded[id] = key
+	}
+
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	if activeID == "" {
+		return nil, fmt.Errorf("encryption active key id is not set")
+	}
+
+	kr := &Keyring{activeID: activeID, keys: make(map[string][]byte, len(encoded))}
+	for id, value := range encoded {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid encryption key id %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s is not valid base64: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %s must be 32 bytes (256 bit), but got %d bytes", id, len(key))
+		}
+		kr.keys[id] = key
+	}
+	if _, ok := kr.keys[activeID]; !ok {
+		return nil, fmt.Errorf("active encryption key %s not found in keyring", activeID)
+	}
+	return kr, nil
+}
+
+// ActiveKeyID 返回当前用于加密的密钥ID
+func (k *Keyring) ActiveKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.activeID
+}
+
+// Encrypt 使用随机数据密钥加密明文，并用当前主密钥包裹数据密钥
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	k.mu.RLock()
+	kid, kek := k.activeID, k.keys[k.activeID]
+	k.mu.RUnlock()
+
+	dek := make([]byte, dekSize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", err
+	}
+	wrapped, err := sealGCM(kek, dek, []byte(kid))
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealGCM(dek, []byte(plaintext), []byte(kid))
+	if err != nil {
+		return "", err
+	}
+	payload := append(wrapped, sealed...)
+	return EnvelopePrefix + kid + ":" + base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// Decrypt 解密信封密文，任何仍在密钥环中的旧密钥都可用于解密
+func (k *Keyring) Decrypt(ciphertext string) (string, error) {
+	kid, payload, err := parseEnvelope(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	k.mu.RLock()
+	kek, ok := k.keys[kid]
+	k.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("encryption key %s not found in keyring", kid)
+	}
+	if len(payload) < wrappedDEKSize+gcmNonceSize+gcmTagSize {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+	dek, err := openGCM(kek, payload[:wrappedDEKSize], []byte(kid))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	plaintext, err := openGCM(dek, payload[wrappedDEKSize:], []byte(kid))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsEnvelopeCiphertext 判断字符串是否为信封加密密文
+func IsEnvelopeCiphertext(s string) bool {
+	return strings.HasPrefix(s, EnvelopePrefix)
+}
+
+// EnvelopeKeyID 返回信封密文使用的密钥ID，非信封密文返回空字符串
+func EnvelopeKeyID(ciphertext string) string {
+	kid, _, err := parseEnvelope(ciphertext)
+	if err != nil {
+		return ""
+	}
+	return kid
+}
+
+// EncryptSecret 加密敏感数据；配置了密钥环时使用信封加密，否则回退到 EncryptAES
+func EncryptSecret(plaintext string) (string, error) {
+	if kr := GetKeyring(); kr != nil {
+		return kr.Encrypt(plaintext)
+	}
+	return EncryptAES(plaintext)
+}
+
+// DecryptSecret 解密敏感数据，兼容信封密文和旧的 EncryptAES 密文
+func DecryptSecret(ciphertext string) (string, error) {
+	if !IsEnvelopeCiphertext(ciphertext) {
+		return DecryptAES(ciphertext)
+	}
+	kr := GetKeyring()
+	if kr == nil {
+		return "", fmt.Errorf("encryption keyring is not configured")
+	}
+	return kr.Decrypt(ciphertext)
+}
+
+// SecretNeedsRotation 判断密文是否需要使用当前主密钥重新加密
+func SecretNeedsRotation(ciphertext string) bool {
+	kr := GetKeyring()
+	if kr == nil || ciphertext == "" || IsSecretReference(ciphertext) {
+		return false
+	}
+	return EnvelopeKeyID(ciphertext) != kr.ActiveKeyID()
+}
+
+func parseEnvelope(ciphertext string) (string, []byte, error) {
+	if !IsEnvelopeCiphertext(ciphertext) {
+		return "", nil, fmt.Errorf("not an envelope ciphertext")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(ciphertext, EnvelopePrefix), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", nil, fmt.Errorf("malformed envelope ciphertext")
+	}
+	payload, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, err
+	}
+	return parts[0], payload, nil
+}
+
+func sealGCM(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openGCM(key, data, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], aad)
+}
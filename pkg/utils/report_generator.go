@@ -4,33 +4,16 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"gobi/internal/models"
-	"gobi/pkg/database"
-	"time"
+	"gobi/pkg/exceltemplate"
+	"sort"
 
-	"github.com/robfig/cron/v3"
 	"github.com/xuri/excelize/v2"
 )
 
-var reportCron *cron.Cron
-
-// InitReportGenerator initializes the report generator cron jobs
-func InitReportGenerator() {
-	reportCron = cron.New()
-	reportCron.Start()
-
-	// Schedule report generation check every minute
-	reportCron.AddFunc("* * * * *", checkAndGenerateReports)
-}
-
-// StopReportGenerator stops the report generator cron jobs
-func StopReportGenerator() {
-	if reportCron != nil {
-		reportCron.Stop()
-	}
-}
-
-// GenerateExcelFromTemplate populates an Excel template with chart data.
+// GenerateExcelFromTemplate populates an Excel template with chart data. The data is
+// bound to the template as the chart result, so {{#table chart}}, {{query.chart.<column>}}
+// and a query.chart defined name show it. A template without bindings keeps its sheets and
+// gets the data on a sheet of its own.
 func GenerateExcelFromTemplate(chartData string, templateData []byte, chartID string) ([]byte, error) {
 	// Unmarshal chart data
 	var data []map[string]interface{}
@@ -38,175 +21,73 @@ func GenerateExcelFromTemplate(chartData string, templateData []byte, chartID st
 		return nil, fmt.Errorf("failed to unmarshal chart data: %w", err)
 	}
 
-	// Create a reader from the template data
-	r := bytes.NewReader(templateData)
-
 	// Open the template
-	f, err := excelize.OpenReader(r)
+	f, err := excelize.OpenReader(bytes.NewReader(templateData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open excel template: %w", err)
 	}
 	defer f.Close()
 
-	// Get the first sheet name
-	sheetName := f.GetSheetName(0)
-	if sheetName == "" {
-		return nil, fmt.Errorf("no sheets found in the template")
+	bindings, err := exceltemplate.Scan(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid excel template: %w", err)
 	}
 
-	// Write headers if there's data
-	if len(data) > 0 {
-		col := 1
-		for key := range data[0] {
-			cell, _ := excelize.CoordinatesToCellName(col, 1)
-			f.SetCellValue(sheetName, cell, key)
-			col++
+	// Columns in name order, as the rows do not keep theirs
+	seen := map[string]bool{}
+	table := &exceltemplate.Table{}
+	for _, row := range data {
+		for column := range row {
+			if !seen[column] {
+				seen[column] = true
+				table.Columns = append(table.Columns, column)
+			}
 		}
 	}
-
-	// Write data rows
-	for row, rowData := range data {
-		col := 1
-		// This assumes consistent key order, which is not guaranteed for maps.
-		// For production, it's better to have a fixed order of columns.
-		for _, value := range rowData {
-			cell, _ := excelize.CoordinatesToCellName(col, row+2)
-			f.SetCellValue(sheetName, cell, value)
-			col++
+	sort.Strings(table.Columns)
+	for _, row := range data {
+		values := make([]interface{}, len(table.Columns))
+		for i, column := range table.Columns {
+			values[i] = row[column]
 		}
+		table.Rows = append(table.Rows, values)
 	}
 
-	// Write to buffer
-	buf, err := f.WriteToBuffer()
-	if err != nil {
-		return nil, fmt.Errorf("failed to write excel to buffer: %w", err)
-	}
-
-	return buf.Bytes(), nil
-}
-
-// checkAndGenerateReports checks for reports that need to be generated
-func checkAndGenerateReports() {
-	now := time.Now()
-	var schedules []models.ReportSchedule
-
-	// Find all active schedules that are due
-	if err := database.DB.Where("active = ? AND next_run <= ?", true, now).Find(&schedules).Error; err != nil {
-		Logger.WithFields(map[string]interface{}{
-			"action": "check_reports",
-			"error":  err.Error(),
-		}).Error("Failed to fetch report schedules")
-		return
-	}
-
-	for _, schedule := range schedules {
-		go generateReport(&schedule)
-	}
-}
-
-// generateReport generates a report based on the schedule
-func generateReport(schedule *models.ReportSchedule) {
-	// Create a new report record
-	report := models.Report{
-		UserID:      schedule.UserID,
-		Name:        schedule.Name,
-		Type:        schedule.Type,
-		Status:      "pending",
-		GeneratedAt: time.Now(),
-	}
-
-	if err := database.DB.Create(&report).Error; err != nil {
-		Logger.WithFields(map[string]interface{}{
-			"action":     "generate_report",
-			"scheduleID": schedule.ID,
-			"error":      err.Error(),
-		}).Error("Failed to create report record")
-		return
-	}
-
-	// Create Excel file
-	f := excelize.NewFile()
-	defer f.Close()
-
-	// Process queries
-	var queryIDs []uint
-	if err := json.Unmarshal([]byte(schedule.Queries), &queryIDs); err == nil {
-		for i, queryID := range queryIDs {
-			var query models.Query
-			if err := database.DB.First(&query, queryID).Error; err != nil {
-				continue
-			}
-
-			// Execute query
-			var ds models.DataSource
-			if err := database.DB.First(&ds, query.DataSourceID).Error; err != nil {
-				continue
-			}
-
-			results, err := ExecuteSQL(ds, query.SQL)
-			if err != nil {
-				continue
-			}
-
-			// Create sheet for query results
-			sheetName := fmt.Sprintf("Query_%d", i+1)
-			f.NewSheet(sheetName)
-
-			// Write headers
-			if len(results) > 0 {
-				col := 1
-				for key := range results[0] {
-					cell, _ := excelize.CoordinatesToCellName(col, 1)
-					f.SetCellValue(sheetName, cell, key)
-					col++
-				}
+	if len(bindings) > 0 {
+		if _, err := exceltemplate.Fill(f, exceltemplate.Data{Tables: map[string]*exceltemplate.Table{"chart": table}}); err != nil {
+			return nil, fmt.Errorf("failed to fill excel template: %w", err)
+		}
+	} else {
+		sheetName := "Chart " + chartID
+		for n := 2; ; n++ {
+			if index, _ := f.GetSheetIndex(sheetName); index < 0 {
+				break
 			}
-
-			// Write data
-			for row, result := range results {
-				col := 1
-				for _, value := range result {
-					cell, _ := excelize.CoordinatesToCellName(col, row+2)
-					f.SetCellValue(sheetName, cell, value)
-					col++
-				}
+			sheetName = fmt.Sprintf("Chart %s (%d)", chartID, n)
+		}
+		if _, err := f.NewSheet(sheetName); err != nil {
+			return nil, fmt.Errorf("failed to add data sheet: %w", err)
+		}
+		header := make([]interface{}, len(table.Columns))
+		for i, column := range table.Columns {
+			header[i] = column
+		}
+		if err := f.SetSheetRow(sheetName, "A1", &header); err != nil {
+			return nil, fmt.Errorf("failed to write data sheet: %w", err)
+		}
+		for i, row := range table.Rows {
+			cell, _ := excelize.CoordinatesToCellName(1, i+2)
+			if err := f.SetSheetRow(sheetName, cell, &row); err != nil {
+				return nil, fmt.Errorf("failed to write data sheet: %w", err)
 			}
 		}
 	}
 
-	// Save the report
-	content, err := f.WriteToBuffer()
+	// Write to buffer
+	buf, err := f.WriteToBuffer()
 	if err != nil {
-		report.Status = "failed"
-		report.Error = "Failed to generate Excel file: " + err.Error()
-	} else {
-		report.Status = "success"
-		report.Content = content.Bytes()
-	}
-
-	// Update report status
-	if err := database.DB.Save(&report).Error; err != nil {
-		Logger.WithFields(map[string]interface{}{
-			"action":     "generate_report",
-			"scheduleID": schedule.ID,
-			"reportID":   report.ID,
-			"error":      err.Error(),
-		}).Error("Failed to update report status")
-	}
-
-	// Update schedule next run time using cron pattern
-	schedule.LastRun = time.Now()
-	schedule.NextRun = calculateNextRunFromCron(schedule.CronPattern)
-	if err := database.DB.Save(&schedule).Error; err != nil {
-		Logger.WithFields(map[string]interface{}{
-			"action":     "generate_report",
-			"scheduleID": schedule.ID,
-			"error":      err.Error(),
-		}).Error("Failed to update schedule next run time")
+		return nil, fmt.Errorf("failed to write excel to buffer: %w", err)
 	}
-}
 
-// calculateNextRunFromCron calculates the next run time based on cron pattern
-func calculateNextRunFromCron(cronPattern string) time.Time {
-	return CalculateNextRunFromCron(cronPattern, time.Now())
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,318 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"gobi/config"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretProvider 外部密钥提供者，解析形如 <scheme>:<reference> 的密钥引用
+type SecretProvider interface {
+	Scheme() string
+	Resolve(ref string) (string, error)
+}
+
+// cachedSecret 已解析的密钥缓存项
+type cachedSecret struct {
+	value     string
+	expiresAt time.Time
+}
+
+// SecretResolver 按 scheme 分发密钥引用，并按 TTL 缓存解析结果
+type SecretResolver struct {
+	mu        sync.RWMutex
+	providers map[string]SecretProvider
+	cache     map[string]cachedSecret
+	ttl       time.Duration
+	allowed   SecretAllowlist
+}
+
+// SecretAllowlist 用户提交的密钥引用（如数据源密码）可访问的范围，配置本身的引用不受限制
+type SecretAllowlist struct {
+	EnvPrefixes []string // 环境变量名前缀
+	FileDirs    []string // 文件所在目录
+	VaultPaths  []string // Vault 路径前缀
+}
+
+var secretResolver = NewSecretResolver(5*time.Minute, &EnvSecretProvider{}, &FileSecretProvider{}, &VaultSecretProvider{})
+
+// NewSecretResolver 创建密钥解析器
+func NewSecretResolver(ttl time.Duration, providers ...SecretProvider) *SecretResolver {
+	r := &SecretResolver{
+		providers: make(map[string]SecretProvider),
+		cache:     make(map[string]cachedSecret),
+		ttl:       ttl,
+	}
+	for _, p := range providers {
+		r.providers[p.Scheme()] = p
+	}
+	return r
+}
+
+// InitSecretProviders 根据配置初始化内置密钥提供者
+func InitSecretProviders(cfg *config.Config) {
+	secretResolver.mu.Lock()
+	defer secretResolver.mu.Unlock()
+	if cfg.Secrets.CacheTTL > 0 {
+		secretResolver.ttl = cfg.Secrets.CacheTTL
+	}
+	secretResolver.providers["vault"] = NewVaultSecretProvider(cfg.Secrets.Vault)
+	secretResolver.cache = make(map[string]cachedSecret)
+	secretResolver.allowed = SecretAllowlist{
+		EnvPrefixes: cfg.Secrets.AllowedEnvPrefixes,
+		FileDirs:    cfg.Secrets.AllowedFileDirs,
+		VaultPaths:  cfg.Secrets.AllowedVaultPaths,
+	}
+}
+
+// RegisterSecretProvider 注册自定义密钥提供者，相同 scheme 会被覆盖
+func RegisterSecretProvider(p SecretProvider) {
+	secretResolver.Register(p)
+}
+
+// IsSecretReference 判断值是否为已注册提供者的密钥引用
+func IsSecretReference(value string) bool {
+	return secretResolver.IsReference(value)
+}
+
+// ResolveSecret 解析密钥引用
+func ResolveSecret(value string) (string, error) {
+	return secretResolver.Resolve(value)
+}
+
+// CheckSecretReference 检查密钥引用是否在白名单内
+func CheckSecretReference(value string) error {
+	return secretResolver.Check(value)
+}
+
+// ResolveAllowedSecret 解析白名单内的密钥引用，用于用户提交的引用
+func ResolveAllowedSecret(value string) (string, error) {
+	if err := secretResolver.Check(value); err != nil {
+		return "", err
+	}
+	return secretResolver.Resolve(value)
+}
+
+// SetAllowlist 设置用户提交的密钥引用白名单
+func (r *SecretResolver) SetAllowlist(allowed SecretAllowlist) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.allowed = allowed
+}
+
+// Check 检查密钥引用是否在白名单内：env: 须以允许的前缀开头，file: 须位于允许的目录下
+// （解析符号链接后），vault: 须为规范路径且位于允许的路径下；其他 scheme 一律拒绝
+func (r *SecretResolver) Check(value string) error {
+	scheme, ref, ok := strings.Cut(value, ":")
+	if !ok || ref == "" {
+		return fmt.Errorf("invalid secret reference")
+	}
+	r.mu.RLock()
+	allowed := r.allowed
+	r.mu.RUnlock()
+
+	switch scheme {
+	case "env":
+		for _, prefix := range allowed.EnvPrefixes {
+			if strings.HasPrefix(ref, prefix) {
+				return nil
+			}
+		}
+	case "file":
+		if !filepath.IsAbs(ref) {
+			return fmt.Errorf("file secret reference must be an absolute path")
+		}
+		path := filepath.Clean(ref)
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+		for _, dir := range allowed.FileDirs {
+			dir = filepath.Clean(dir)
+			if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+				dir = resolved
+			}
+			if rel, err := filepath.Rel(dir, path); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+				return nil
+			}
+		}
+	case "vault":
+		secretPath, _, _ := strings.Cut(ref, "#")
+		secretPath = "/" + strings.TrimLeft(secretPath, "/")
+		if pathpkg.Clean(secretPath) != secretPath || strings.ContainsAny(secretPath, "%?") {
+			return fmt.Errorf("vault secret reference must be a plain path")
+		}
+		for _, prefix := range allowed.VaultPaths {
+			prefix = pathpkg.Clean("/" + prefix)
+			if secretPath == prefix || strings.HasPrefix(secretPath, strings.TrimSuffix(prefix, "/")+"/") {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("secret reference %s:%s is not allowed", scheme, ref)
+}
+
+// Register 注册密钥提供者
+func (r *SecretResolver) Register(p SecretProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[p.Scheme()] = p
+}
+
+// IsReference 判断值是否为密钥引用
+func (r *SecretResolver) IsReference(value string) bool {
+	scheme, ref, ok := strings.Cut(value, ":")
+	if !ok || ref == "" {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, exists := r.providers[scheme]
+	return exists
+}
+
+// Resolve 解析密钥引用，命中缓存时直接返回
+func (r *SecretResolver) Resolve(value string) (string, error) {
+	scheme, ref, ok := strings.Cut(value, ":")
+	if !ok || ref == "" {
+		return "", fmt.Errorf("invalid secret reference")
+	}
+
+	r.mu.RLock()
+	provider, exists := r.providers[scheme]
+	cached, hit := r.cache[value]
+	ttl := r.ttl
+	r.mu.RUnlock()
+
+	if !exists {
+		return "", fmt.Errorf("no secret provider registered for scheme %q", scheme)
+	}
+	if hit && time.Now().Before(cached.expiresAt) {
+		return cached.value, nil
+	}
+
+	secret, err := provider.Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s secret: %w", scheme, err)
+	}
+
+	if ttl > 0 {
+		r.mu.Lock()
+		r.cache[value] = cachedSecret{value: secret, expiresAt: time.Now().Add(ttl)}
+		r.mu.Unlock()
+	}
+	return secret, nil
+}
+
+// EnvSecretProvider 从环境变量读取密钥，引用格式 env:NAME
+type EnvSecretProvider struct{}
+
+// Scheme 返回 env
+func (p *EnvSecretProvider) Scheme() string { return "env" }
+
+// Resolve 读取环境变量
+func (p *EnvSecretProvider) Resolve(ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s not set", ref)
+	}
+	return value, nil
+}
+
+// FileSecretProvider 从文件读取密钥，引用格式 file:/path/to/secret
+type FileSecretProvider struct{}
+
+// Scheme 返回 file
+func (p *FileSecretProvider) Scheme() string { return "file" }
+
+// Resolve 读取文件内容，去掉结尾换行
+func (p *FileSecretProvider) Resolve(ref string) (string, error) {
+	content, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// VaultSecretProvider 从 Vault KV 读取密钥，引用格式 vault:secret/data/pg#password
+type VaultSecretProvider struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+// NewVaultSecretProvider 创建 Vault 密钥提供者，地址和令牌缺省时读取 VAULT_ADDR / VAULT_TOKEN
+func NewVaultSecretProvider(cfg config.VaultConfig) *VaultSecretProvider {
+	address := cfg.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	token := cfg.Token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return &VaultSecretProvider{
+		address: strings.TrimRight(address, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Scheme 返回 vault
+func (p *VaultSecretProvider) Scheme() string { return "vault" }
+
+// Resolve 读取 Vault 路径并返回指定字段，同时兼容 KV v1 和 v2 的响应格式
+func (p *VaultSecretProvider) Resolve(ref string) (string, error) {
+	if p.address == "" {
+		return "", fmt.Errorf("vault address is not configured")
+	}
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("vault reference must be in the form path#field")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.address+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	if p.token != "" {
+		req.Header.Set("X-Vault-Token", p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned status %d for %s", resp.StatusCode, path)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("field %s not found at %s", field, path)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprintf("%v", value), nil
+}
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"encoding/json"
+	"gobi/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeVault is a stand-in Vault KV v2 server holding one secret per path
+type fakeVault struct {
+	mu       sync.Mutex
+	token    string
+	secrets  map[string]map[string]interface{}
+	requests int32
+}
+
+func (v *fakeVault) set(path, field, value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.secrets[path] = map[string]interface{}{field: value}
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&v.requests, 1)
+	if r.Header.Get("X-Vault-Token") != v.token {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	v.mu.Lock()
+	data, ok := v.secrets[r.URL.Path]
+	v.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]interface{}{"data": data, "metadata": map[string]interface{}{"version": 1}},
+	})
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *VaultSecretProvider) {
+	vault := &fakeVault{token: "test-token", secrets: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(vault)
+	t.Cleanup(server.Close)
+	return vault, NewVaultSecretProvider(config.VaultConfig{Address: server.URL + "/", Token: vault.token, Timeout: time.Second})
+}
+
+func TestVaultSecretProviderResolve(t *testing.T) {
+	vault, provider := newFakeVault(t)
+	vault.set("/v1/secret/data/pg", "password", "s3cret")
+
+	got, err := provider.Resolve("secret/data/pg#password")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got != "s3cret" {
+		t.Errorf("Resolve = %q, want %q", got, "s3cret")
+	}
+
+	for _, ref := range []string{"secret/data/pg#missing", "secret/data/other#password", "secret/data/pg"} {
+		if _, err := provider.Resolve(ref); err == nil {
+			t.Errorf("Resolve(%q) succeeded, want an error", ref)
+		}
+	}
+
+	unauthorized := NewVaultSecretProvider(config.VaultConfig{Address: provider.address, Token: "wrong", Timeout: time.Second})
+	if _, err := unauthorized.Resolve("secret/data/pg#password"); err == nil {
+		t.Error("Resolve with a wrong token succeeded, want an error")
+	}
+}
+
+func TestSecretResolverCachesVaultSecretsAndPicksUpRotation(t *testing.T) {
+	vault, provider := newFakeVault(t)
+	vault.set("/v1/secret/data/pg", "password", "first")
+	ttl := 100 * time.Millisecond
+	resolver := NewSecretResolver(ttl, provider)
+
+	for i := 0; i < 3; i++ {
+		got, err := resolver.Resolve("vault:secret/data/pg#password")
+		if err != nil {
+			t.Fatalf("Resolve: %v", err)
+		}
+		if got != "first" {
+			t.Fatalf("Resolve = %q, want %q", got, "first")
+		}
+	}
+	if n := atomic.LoadInt32(&vault.requests); n != 1 {
+		t.Errorf("vault got %d requests within the TTL, want 1", n)
+	}
+
+	// Rotated in Vault: the cached value is served until the TTL runs out
+	vault.set("/v1/secret/data/pg", "password", "second")
+	if got, _ := resolver.Resolve("vault:secret/data/pg#password"); got != "first" {
+		t.Errorf("Resolve before the TTL = %q, want the cached %q", got, "first")
+	}
+	time.Sleep(ttl + 20*time.Millisecond)
+	got, err := resolver.Resolve("vault:secret/data/pg#password")
+	if err != nil {
+		t.Fatalf("Resolve after the TTL: %v", err)
+	}
+	if got != "second" {
+		t.Errorf("Resolve after the TTL = %q, want the rotated %q", got, "second")
+	}
+	if n := atomic.LoadInt32(&vault.requests); n != 2 {
+		t.Errorf("vault got %d requests, want 2", n)
+	}
+}
+
+func TestSecretResolverCheck(t *testing.T) {
+	dir := t.TempDir()
+	allowedDir := filepath.Join(dir, "ds")
+	if err := os.Mkdir(allowedDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(dir, "outside")
+	if err := os.WriteFile(outside, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(allowedDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := NewSecretResolver(0, &EnvSecretProvider{}, &FileSecretProvider{}, &VaultSecretProvider{})
+	resolver.SetAllowlist(SecretAllowlist{
+		EnvPrefixes: []string{"GOBI_DS_"},
+		FileDirs:    []string{allowedDir},
+		VaultPaths:  []string{"secret/data/datasources"},
+	})
+
+	tests := []struct {
+		ref     string
+		allowed bool
+	}{
+		{"env:GOBI_DS_PG", true},
+		{"env:GOBI_JWT_SECRET", false},
+		{"file:" + filepath.Join(allowedDir, "pg"), true},
+		{"file:" + filepath.Join(allowedDir, "..", "outside"), false},
+		{"file:" + filepath.Join(allowedDir, "link"), false},
+		{"file:" + allowedDir, false},
+		{"file:relative/pg", false},
+		{"file:/etc/passwd", false},
+		{"vault:secret/data/datasources/pg#password", true},
+		{"vault:/secret/data/datasources#password", true},
+		{"vault:secret/data/datasources-other/pg#password", false},
+		{"vault:secret/data/datasources/../jwt#secret", false},
+		{"vault:secret/data/datasources/%2e%2e/jwt#secret", false},
+		{"vault:sys/config#token", false},
+		{"plain:password", false},
+	}
+	for _, tt := range tests {
+		err := resolver.Check(tt.ref)
+		if tt.allowed && err != nil {
+			t.Errorf("Check(%q) = %v, want allowed", tt.ref, err)
+		}
+		if !tt.allowed && err == nil {
+			t.Errorf("Check(%q) allowed, want rejected", tt.ref)
+		}
+	}
+
+	empty := NewSecretResolver(0, &EnvSecretProvider{})
+	if err := empty.Check("env:GOBI_DS_PG"); err == nil {
+		t.Error("Check with an empty allowlist allowed a reference")
+	}
+}